@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -50,6 +51,9 @@ var (
 
 	// CSR attribute requesting extensions
 	oidExtensionRequest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 14}
+
+	// id-pkix-ocsp-nocheck, RFC 6960 section 4.2.2.2.1
+	oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
 )
 
 // OID and fixed value for the "must staple" variant of the TLS Feature
@@ -104,6 +108,9 @@ type certificateStorage interface {
 type CertificateAuthorityImpl struct {
 	rsaProfile   string
 	ecdsaProfile string
+	// ecdsaP521Profile is used for P-521 keys. If it's empty they get
+	// ecdsaProfile.
+	ecdsaP521Profile string
 	// A map from issuer cert common name to an internalIssuer struct
 	issuers map[string]*internalIssuer
 	// The common name of the default issuer cert
@@ -117,6 +124,7 @@ type CertificateAuthorityImpl struct {
 	stats            metrics.Scope
 	prefix           int // Prepended to the serial number
 	validityPeriod   time.Duration
+	lifespanOCSP     time.Duration
 	maxNames         int
 	forceCNFromSAN   bool
 	enableMustStaple bool
@@ -126,6 +134,12 @@ type CertificateAuthorityImpl struct {
 type Issuer struct {
 	Signer crypto.Signer
 	Cert   *x509.Certificate
+	// OCSPSigner and OCSPCert optionally hold a delegated OCSP responder key
+	// and certificate, issued by Cert. If present they are used to sign OCSP
+	// responses instead of Signer, so that the issuer key is only needed to
+	// sign certificates.
+	OCSPSigner crypto.Signer
+	OCSPCert   *x509.Certificate
 }
 
 // internalIssuer represents the fully initialized internal state for a single
@@ -134,12 +148,78 @@ type internalIssuer struct {
 	cert       *x509.Certificate
 	eeSigner   signer.Signer
 	ocspSigner ocsp.Signer
+	// ocspDelegate is the delegated OCSP responder certificate, if any
+	ocspDelegate *x509.Certificate
+}
+
+// checkOCSPDelegate checks that delegate is a certificate the issuer has
+// authorized to sign OCSP responses on its behalf, per RFC 6960 section 4.2.2.2,
+// that key is its key, and that it is valid for as long as responses signed
+// now.
+func checkOCSPDelegate(issuer, delegate *x509.Certificate, key crypto.Signer, now time.Time, lifespanOCSP time.Duration) error {
+	if err := delegate.CheckSignatureFrom(issuer); err != nil {
+		return fmt.Errorf("OCSP delegate %q was not issued by %q: %s",
+			delegate.Subject.CommonName, issuer.Subject.CommonName, err)
+	}
+	certKey, err := x509.MarshalPKIXPublicKey(delegate.PublicKey)
+	if err != nil {
+		return err
+	}
+	signerKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(certKey, signerKey) {
+		return fmt.Errorf("OCSP delegate key doesn't match the public key of %q",
+			delegate.Subject.CommonName)
+	}
+	if err := checkOCSPDelegateValidity(delegate, now, lifespanOCSP); err != nil {
+		return err
+	}
+	ocspSigning := false
+	for _, eku := range delegate.ExtKeyUsage {
+		if eku == x509.ExtKeyUsageOCSPSigning {
+			ocspSigning = true
+		}
+	}
+	if !ocspSigning {
+		return fmt.Errorf("OCSP delegate %q lacks the OCSPSigning extended key usage",
+			delegate.Subject.CommonName)
+	}
+	noCheck := false
+	for _, ext := range delegate.Extensions {
+		if ext.Id.Equal(oidOCSPNoCheck) {
+			noCheck = true
+		}
+	}
+	if !noCheck {
+		return fmt.Errorf("OCSP delegate %q lacks the id-pkix-ocsp-nocheck extension",
+			delegate.Subject.CommonName)
+	}
+	return nil
+}
+
+// checkOCSPDelegateValidity checks that an OCSP response signed by delegate at
+// now would expire before delegate does. Clients reject responses whose
+// responder certificate has expired, so a delegate must be replaced at least
+// lifespanOCSP before its NotAfter.
+func checkOCSPDelegateValidity(delegate *x509.Certificate, now time.Time, lifespanOCSP time.Duration) error {
+	if now.Before(delegate.NotBefore) {
+		return fmt.Errorf("OCSP delegate %q is not valid until %s",
+			delegate.Subject.CommonName, delegate.NotBefore)
+	}
+	if now.Add(lifespanOCSP).After(delegate.NotAfter) {
+		return fmt.Errorf("OCSP delegate %q expires at %s, before responses signed now would",
+			delegate.Subject.CommonName, delegate.NotAfter)
+	}
+	return nil
 }
 
 func makeInternalIssuers(
 	issuers []Issuer,
 	policy *cfsslConfig.Signing,
 	lifespanOCSP time.Duration,
+	clk clock.Clock,
 ) (map[string]*internalIssuer, error) {
 	if len(issuers) == 0 {
 		return nil, errors.New("No issuers specified.")
@@ -155,8 +235,18 @@ func makeInternalIssuers(
 		}
 
 		// Set up our OCSP signer. Note this calls for both the issuer cert and the
-		// OCSP signing cert, which are the same in our case.
-		ocspSigner, err := ocsp.NewSigner(iss.Cert, iss.Cert, iss.Signer, lifespanOCSP)
+		// OCSP signing cert, which are the same unless a delegate is configured.
+		ocspCert, ocspKey := iss.Cert, iss.Signer
+		if iss.OCSPCert != nil || iss.OCSPSigner != nil {
+			if iss.OCSPCert == nil || iss.OCSPSigner == nil {
+				return nil, errors.New("Issuer with OCSP delegate cert but no key, or key but no cert, specified.")
+			}
+			if err := checkOCSPDelegate(iss.Cert, iss.OCSPCert, iss.OCSPSigner, clk.Now(), lifespanOCSP); err != nil {
+				return nil, err
+			}
+			ocspCert, ocspKey = iss.OCSPCert, iss.OCSPSigner
+		}
+		ocspSigner, err := ocsp.NewSigner(iss.Cert, ocspCert, ocspKey, lifespanOCSP)
 		if err != nil {
 			return nil, err
 		}
@@ -165,9 +255,10 @@ func makeInternalIssuers(
 			return nil, errors.New("Multiple issuer certs with the same CommonName are not supported")
 		}
 		internalIssuers[cn] = &internalIssuer{
-			cert:       iss.Cert,
-			eeSigner:   eeSigner,
-			ocspSigner: ocspSigner,
+			cert:         iss.Cert,
+			eeSigner:     eeSigner,
+			ocspSigner:   ocspSigner,
+			ocspDelegate: iss.OCSPCert,
 		}
 	}
 	return internalIssuers, nil
@@ -210,7 +301,8 @@ func NewCertificateAuthorityImpl(
 	internalIssuers, err := makeInternalIssuers(
 		issuers,
 		cfsslConfigObj.Signing,
-		config.LifespanOCSP.Duration)
+		config.LifespanOCSP.Duration,
+		clk)
 	if err != nil {
 		return nil, err
 	}
@@ -228,7 +320,9 @@ func NewCertificateAuthorityImpl(
 		defaultIssuer:    defaultIssuer,
 		rsaProfile:       rsaProfile,
 		ecdsaProfile:     ecdsaProfile,
+		ecdsaP521Profile: config.ECDSAP521Profile,
 		prefix:           config.SerialPrefix,
+		lifespanOCSP:     config.LifespanOCSP.Duration,
 		clk:              clk,
 		log:              logger,
 		stats:            stats,
@@ -362,6 +456,16 @@ func (ca *CertificateAuthorityImpl) GenerateOCSP(ctx context.Context, xferObj co
 			core.SerialToString(cert.SerialNumber), cn, err)
 	}
 
+	// The delegate is checked when the CA starts, but may since have come
+	// too close to expiring
+	if issuer.ocspDelegate != nil {
+		err = checkOCSPDelegateValidity(issuer.ocspDelegate, ca.clk.Now(), ca.lifespanOCSP)
+		if err != nil {
+			ca.log.AuditErr(err.Error())
+			return nil, err
+		}
+	}
+
 	ocspResponse, err := issuer.ocspSigner.Sign(signRequest)
 	ca.noteSignError(err)
 	return ocspResponse, err
@@ -375,6 +479,7 @@ func (ca *CertificateAuthorityImpl) IssueCertificate(ctx context.Context, csr x5
 	emptyCert := core.Certificate{}
 
 	if err := csrlib.VerifyCSR(
+		ctx,
 		&csr,
 		ca.maxNames,
 		&ca.keyPolicy,
@@ -383,6 +488,9 @@ func (ca *CertificateAuthorityImpl) IssueCertificate(ctx context.Context, csr x5
 		regID,
 	); err != nil {
 		ca.log.AuditErr(err.Error())
+		if _, ok := err.(core.InternalServerError); ok {
+			return emptyCert, err
+		}
 		return emptyCert, core.MalformedRequestError(err.Error())
 	}
 
@@ -421,11 +529,14 @@ func (ca *CertificateAuthorityImpl) IssueCertificate(ctx context.Context, csr x5
 	serialHex := core.SerialToString(serialBigInt)
 
 	var profile string
-	switch csr.PublicKey.(type) {
+	switch key := csr.PublicKey.(type) {
 	case *rsa.PublicKey:
 		profile = ca.rsaProfile
 	case *ecdsa.PublicKey:
 		profile = ca.ecdsaProfile
+		if key.Curve == elliptic.P521() && ca.ecdsaP521Profile != "" {
+			profile = ca.ecdsaP521Profile
+		}
 	default:
 		err = core.InternalServerError(fmt.Sprintf("unsupported key type %T", csr.PublicKey))
 		ca.log.AuditErr(err.Error())
@@ -3,10 +3,15 @@ package ca
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/asn1"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"sort"
 	"testing"
 	"time"
@@ -238,7 +243,7 @@ func setup(t *testing.T) *testCtx {
 		},
 	}
 
-	issuers := []Issuer{{caKey, caCert}}
+	issuers := []Issuer{{Signer: caKey, Cert: caCert}}
 
 	keyPolicy := goodkey.KeyPolicy{
 		AllowRSA:           true,
@@ -456,6 +461,127 @@ func TestOCSP(t *testing.T) {
 	test.AssertEquals(t, parsedNewCertOcspResp.SerialNumber.Cmp(parsedNewCert.SerialNumber), 0)
 }
 
+// makeOCSPDelegate returns a key and a certificate for it issued by caCert,
+// built from template.
+func makeOCSPDelegate(t *testing.T, template *x509.Certificate) (crypto.Signer, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "Failed to generate delegate key")
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), caKey)
+	test.AssertNotError(t, err, "Failed to create delegate cert")
+	cert, err := x509.ParseCertificate(der)
+	test.AssertNotError(t, err, "Failed to parse delegate cert")
+	return key, cert
+}
+
+func ocspDelegateTemplate(now time.Time) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(1337),
+		Subject:      pkix.Name{CommonName: "OCSP delegate"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidOCSPNoCheck, Value: []byte{0x05, 0x00}},
+		},
+	}
+}
+
+func TestOCSPDelegate(t *testing.T) {
+	testCtx := setup(t)
+	delegateKey, delegateCert := makeOCSPDelegate(t, ocspDelegateTemplate(testCtx.fc.Now()))
+	issuers := []Issuer{{
+		Signer:     caKey,
+		Cert:       caCert,
+		OCSPSigner: delegateKey,
+		OCSPCert:   delegateCert,
+	}}
+	ca, err := NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		issuers,
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertNotError(t, err, "Failed to create CA")
+	ca.Publisher = &mocks.Publisher{}
+	ca.PA = testCtx.pa
+	ca.SA = &mockSA{}
+
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	cert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	ocspResp, err := ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+		CertDER: cert.DER,
+		Status:  string(core.OCSPStatusGood),
+	})
+	test.AssertNotError(t, err, "Failed to generate OCSP")
+	parsed, err := ocsp.ParseResponse(ocspResp, caCert)
+	test.AssertNotError(t, err, "Failed to parse / validate delegated OCSP response")
+	test.Assert(t, parsed.Certificate != nil, "Delegated OCSP response has no responder cert")
+	test.AssertByteEquals(t, parsed.Certificate.Raw, delegateCert.Raw)
+	test.AssertNotError(t, parsed.CheckSignatureFrom(delegateCert), "Response not signed by delegate")
+
+	// Responses that would outlive the delegate aren't signed
+	testCtx.fc.Add(24*time.Hour - 30*time.Minute)
+	_, err = ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+		CertDER: cert.DER,
+		Status:  string(core.OCSPStatusGood),
+	})
+	test.AssertError(t, err, "Signed OCSP response that outlives the delegate")
+
+	// A delegate cert without a key is a configuration error
+	issuers[0].OCSPSigner = nil
+	_, err = NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		issuers,
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "Created CA with OCSP delegate cert but no key")
+}
+
+func TestCheckOCSPDelegate(t *testing.T) {
+	now := time.Now()
+	lifespan := time.Hour
+
+	goodKey, good := makeOCSPDelegate(t, ocspDelegateTemplate(now))
+	test.AssertNotError(t, checkOCSPDelegate(caCert, good, goodKey, now, lifespan), "Rejected good delegate")
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "Failed to generate key")
+	test.AssertError(t, checkOCSPDelegate(caCert, good, otherKey, now, lifespan), "Accepted delegate with the wrong key")
+
+	expired := ocspDelegateTemplate(now)
+	expired.NotAfter = now.Add(-time.Minute)
+	key, cert := makeOCSPDelegate(t, expired)
+	test.AssertError(t, checkOCSPDelegate(caCert, cert, key, now, lifespan), "Accepted expired delegate")
+
+	// Responses signed now would outlive the delegate
+	expiring := ocspDelegateTemplate(now)
+	expiring.NotAfter = now.Add(30 * time.Minute)
+	key, cert = makeOCSPDelegate(t, expiring)
+	test.AssertError(t, checkOCSPDelegate(caCert, cert, key, now, lifespan), "Accepted delegate expiring within the OCSP lifespan")
+
+	noEKU := ocspDelegateTemplate(now)
+	noEKU.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	key, cert = makeOCSPDelegate(t, noEKU)
+	test.AssertError(t, checkOCSPDelegate(caCert, cert, key, now, lifespan), "Accepted delegate without OCSPSigning")
+
+	noCheck := ocspDelegateTemplate(now)
+	noCheck.ExtraExtensions = nil
+	key, cert = makeOCSPDelegate(t, noCheck)
+	test.AssertError(t, checkOCSPDelegate(caCert, cert, key, now, lifespan), "Accepted delegate without id-pkix-ocsp-nocheck")
+
+	// A delegate issued by some other CA
+	template := ocspDelegateTemplate(now)
+	der, err := x509.CreateCertificate(rand.Reader, template, template, otherKey.Public(), otherKey)
+	test.AssertNotError(t, err, "Failed to create self-signed delegate")
+	cert, err = x509.ParseCertificate(der)
+	test.AssertNotError(t, err, "Failed to parse self-signed delegate")
+	test.AssertError(t, checkOCSPDelegate(caCert, cert, otherKey, now, lifespan), "Accepted delegate from another issuer")
+}
+
 func TestNoHostnames(t *testing.T) {
 	testCtx := setup(t)
 	ca, err := NewCertificateAuthorityImpl(
@@ -671,6 +797,47 @@ func TestProfileSelection(t *testing.T) {
 	}
 }
 
+func TestP521ProfileSelection(t *testing.T) {
+	testCtx := setup(t)
+	p521Profile := *testCtx.caConfig.CFSSL.Signing.Profiles[ecdsaProfileName]
+	p521Profile.Usage = []string{"digital signature", "server auth", "client auth"}
+	testCtx.caConfig.CFSSL.Signing.Profiles["ecdsaP521EE"] = &p521Profile
+	testCtx.caConfig.ECDSAP521Profile = "ecdsaP521EE"
+	testCtx.keyPolicy.AllowECDSANISTP521 = true
+	ca, err := NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		testCtx.issuers,
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertNotError(t, err, "Failed to create CA")
+	ca.Publisher = &mocks.Publisher{}
+	ca.PA = testCtx.pa
+	ca.SA = &mockSA{}
+
+	issue := func(curve elliptic.Curve) *x509.Certificate {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		test.AssertNotError(t, err, "Failed to generate key")
+		csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			DNSNames: []string{"example.com"},
+		}, key)
+		test.AssertNotError(t, err, "Failed to create CSR")
+		csr, err := x509.ParseCertificateRequest(csrDER)
+		test.AssertNotError(t, err, "Cannot parse CSR")
+		issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+		test.AssertNotError(t, err, "Failed to sign certificate")
+		cert, err := x509.ParseCertificate(issuedCert.DER)
+		test.AssertNotError(t, err, "Certificate failed to parse")
+		return cert
+	}
+
+	cert := issue(elliptic.P521())
+	test.AssertDeepEquals(t, cert.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth})
+	cert = issue(elliptic.P256())
+	test.AssertDeepEquals(t, cert.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth})
+}
+
 func countMustStaple(t *testing.T, cert *x509.Certificate) (count int) {
 	oidTLSFeature := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
 	for _, ext := range cert.Extensions {
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"bufio"
+	"crypto/sha256"
 	"crypto/x509"
 	"database/sql"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"os"
 	"os/user"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -28,18 +34,44 @@ const clientName = "AdminRevoker"
 const usageString = `
 usage:
 admin-revoker serial-revoke --config <path> <serial> <reason-code>
+admin-revoker batched-serial-revoke --config <path> [batch flags] <serials-file> <reason-code>
 admin-revoker reg-revoke --config <path> <registration-id> <reason-code>
+admin-revoker key-revoke --config <path> [batch flags] <spki-sha256> <reason-code>
+admin-revoker backfill-key-hashes --config <path> [--batch-size <n>] [--dry-run]
 admin-revoker list-reasons --config <path>
 admin-revoker auth-revoke --config <path> <domain>
 
 command descriptions:
-  serial-revoke   Revoke a single certificate by the hex serial number
-  reg-revoke      Revoke all certificates associated with a registration ID
-  list-reasons    List all revocation reason codes
-  auth-revoke     Revoke all pending/valid authorizations for a domain
+  serial-revoke          Revoke a single certificate by the hex serial number
+  batched-serial-revoke  Revoke every certificate whose hex serial number is
+                         listed in a file, one per line
+  reg-revoke             Revoke all certificates associated with a registration ID
+  key-revoke             Revoke all unexpired certificates for a public key, given
+                         the hex SHA-256 hash of its SubjectPublicKeyInfo. Only
+                         certificates in the keyHashToSerial table are found:
+                         those issued while the StoreKeyHashes feature was
+                         enabled, and those added by backfill-key-hashes
+  backfill-key-hashes    Add every unexpired certificate to the keyHashToSerial
+                         table, so that key-revoke finds certificates issued
+                         before StoreKeyHashes was enabled. Certificates already
+                         in the table are left alone, so it can be rerun safely
+  list-reasons           List all revocation reason codes
+  auth-revoke            Revoke all pending/valid authorizations for a domain
 
 args:
   config    File path to the configuration file for this service
+
+batch flags:
+  parallelism  Number of certificates to revoke at once (default 1)
+  checkpoint   File path to record revoked serials in. Serials already
+               recorded there are skipped, so an interrupted run can be
+               resumed by running the same command again.
+  dry-run      Log the certificates that would be revoked, without revoking them
+
+backfill flags:
+  batch-size   Number of certificates read from the database at a time
+               (default 1000)
+  dry-run      Count the certificates that would be added, without adding them
 `
 
 type config struct {
@@ -75,20 +107,40 @@ func setupContext(c config) (rpc.RegistrationAuthorityClient, blog.Logger, *gorp
 	return *rac, logger, dbMap, *sac, scope
 }
 
-func revokeBySerial(ctx context.Context, serial string, reasonCode revocation.Reason, rac rpc.RegistrationAuthorityClient, logger blog.Logger, tx *gorp.Transaction) (err error) {
-	if reasonCode < 0 || reasonCode == 7 || reasonCode > 10 {
-		panic(fmt.Sprintf("Invalid reason code: %d", reasonCode))
-	}
+// certSelector is the part of gorp.SqlExecutor the revoker uses to look up
+// certificates, satisfied by both *gorp.DbMap and *gorp.Transaction.
+type certSelector interface {
+	Select(i interface{}, query string, args ...interface{}) ([]interface{}, error)
+	SelectOne(holder interface{}, query string, args ...interface{}) error
+}
+
+// certRevoker is the part of the RA the revoker uses
+type certRevoker interface {
+	AdministrativelyRevokeCertificate(ctx context.Context, cert x509.Certificate, reason revocation.Reason, user string) error
+}
 
+func validReasonCode(reasonCode revocation.Reason) bool {
+	return reasonCode >= 0 && reasonCode != 7 && reasonCode <= 10
+}
+
+func getCertificate(dbMap certSelector, serial string) (*x509.Certificate, error) {
 	var certObj core.Certificate
-	err = tx.SelectOne(&certObj, fmt.Sprintf("SELECT %s FROM certificates WHERE serial = ?", sa.CertificateFields), serial)
+	err := dbMap.SelectOne(&certObj, fmt.Sprintf("SELECT %s FROM certificates WHERE serial = ?", sa.CertificateFields), serial)
 	if err == sql.ErrNoRows {
-		return core.NotFoundError(fmt.Sprintf("No certificate found for %s", serial))
+		return nil, core.NotFoundError(fmt.Sprintf("No certificate found for %s", serial))
 	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return x509.ParseCertificate(certObj.DER)
+}
+
+func revokeBySerial(ctx context.Context, serial string, reasonCode revocation.Reason, rac certRevoker, logger blog.Logger, tx certSelector) (err error) {
+	if !validReasonCode(reasonCode) {
+		panic(fmt.Sprintf("Invalid reason code: %d", reasonCode))
 	}
-	cert, err := x509.ParseCertificate(certObj.DER)
+
+	cert, err := getCertificate(tx, serial)
 	if err != nil {
 		return
 	}
@@ -103,7 +155,7 @@ func revokeBySerial(ctx context.Context, serial string, reasonCode revocation.Re
 	return
 }
 
-func revokeByReg(ctx context.Context, regID int64, reasonCode revocation.Reason, rac rpc.RegistrationAuthorityClient, logger blog.Logger, tx *gorp.Transaction) (err error) {
+func revokeByReg(ctx context.Context, regID int64, reasonCode revocation.Reason, rac certRevoker, logger blog.Logger, tx certSelector) (err error) {
 	var certs []core.Certificate
 	_, err = tx.Select(&certs, "SELECT serial FROM certificates WHERE registrationID = :regID", map[string]interface{}{"regID": regID})
 	if err != nil {
@@ -120,6 +172,226 @@ func revokeByReg(ctx context.Context, regID int64, reasonCode revocation.Reason,
 	return
 }
 
+// serialsForKeyHash returns the serials of the unexpired certificates for the
+// public key whose SubjectPublicKeyInfo has the given SHA-256 hash.
+func serialsForKeyHash(dbMap certSelector, keyHash []byte, now time.Time) ([]string, error) {
+	var serials []string
+	_, err := dbMap.Select(
+		&serials,
+		"SELECT certSerial FROM keyHashToSerial WHERE keyHash = ? AND certNotAfter > ?",
+		keyHash,
+		now,
+	)
+	return serials, err
+}
+
+// keyHashDB is the part of gorp.SqlExecutor the key hash backfill uses
+type keyHashDB interface {
+	Select(i interface{}, query string, args ...interface{}) ([]interface{}, error)
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// keyHashBackfiller adds unexpired certificates to the keyHashToSerial table,
+// which the SA only fills in for certificates issued while the StoreKeyHashes
+// feature is enabled.
+type keyHashBackfiller struct {
+	dbMap     keyHashDB
+	log       blog.Logger
+	batchSize int
+	dryRun    bool
+}
+
+// backfill reads the certificates unexpired at now in batches, in serial
+// order, and records the key hash of each. It returns the number of
+// certificates read and the number of rows added; certificates that are
+// already in the table are skipped by the table's unique key.
+func (kb *keyHashBackfiller) backfill(now time.Time) (checked, added int, err error) {
+	after := ""
+	for {
+		var certs []core.Certificate
+		_, err = kb.dbMap.Select(
+			&certs,
+			"SELECT serial, der FROM certificates WHERE serial > ? AND expires > ? ORDER BY serial LIMIT ?",
+			after,
+			now,
+			kb.batchSize,
+		)
+		if err != nil {
+			return checked, added, err
+		}
+		for _, cert := range certs {
+			checked++
+			parsed, err := x509.ParseCertificate(cert.DER)
+			if err != nil {
+				kb.log.AuditErr(fmt.Sprintf("Couldn't parse certificate %s, not adding its key hash: %s", cert.Serial, err))
+				continue
+			}
+			if kb.dryRun {
+				continue
+			}
+			keyHash := sha256.Sum256(parsed.RawSubjectPublicKeyInfo)
+			result, err := kb.dbMap.Exec(
+				"INSERT IGNORE INTO keyHashToSerial (keyHash, certNotAfter, certSerial) VALUES (?, ?, ?)",
+				keyHash[:],
+				parsed.NotAfter,
+				cert.Serial,
+			)
+			if err != nil {
+				return checked, added, err
+			}
+			rows, err := result.RowsAffected()
+			if err != nil {
+				return checked, added, err
+			}
+			added += int(rows)
+		}
+		if len(certs) < kb.batchSize {
+			return checked, added, nil
+		}
+		after = certs[len(certs)-1].Serial
+		kb.log.Info(fmt.Sprintf("Checked %d certificates, up to serial %s", checked, after))
+	}
+}
+
+// readSerials reads hex serial numbers from a file, one per line. Blank lines
+// and lines starting with # are ignored.
+func readSerials(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var serials []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		serials = append(serials, line)
+	}
+	return serials, scanner.Err()
+}
+
+// checkpoint records the serials a batch has revoked in a file, one per line,
+// so that a later run of the same batch can skip them.
+type checkpoint struct {
+	mu   sync.Mutex
+	f    *os.File
+	done map[string]bool
+}
+
+func openCheckpoint(filename string) (*checkpoint, error) {
+	done := make(map[string]bool)
+	if _, err := os.Stat(filename); err == nil {
+		serials, err := readSerials(filename)
+		if err != nil {
+			return nil, err
+		}
+		for _, serial := range serials {
+			done[serial] = true
+		}
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &checkpoint{f: f, done: done}, nil
+}
+
+func (c *checkpoint) contains(serial string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done[serial]
+}
+
+func (c *checkpoint) record(serial string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := fmt.Fprintln(c.f, serial); err != nil {
+		return err
+	}
+	c.done[serial] = true
+	return c.f.Sync()
+}
+
+func (c *checkpoint) Close() error {
+	return c.f.Close()
+}
+
+// batchRevoker revokes a list of certificates by serial, up to parallelism at
+// a time. A failure to revoke one certificate is logged and doesn't stop the
+// rest of the batch.
+type batchRevoker struct {
+	rac         certRevoker
+	dbMap       certSelector
+	log         blog.Logger
+	stats       metrics.Scope
+	user        string
+	parallelism int
+	dryRun      bool
+	// checkpoint may be nil
+	checkpoint *checkpoint
+}
+
+// revoke revokes the certificates with the given serials, returning the
+// number that couldn't be revoked.
+func (br *batchRevoker) revoke(ctx context.Context, serials []string, reasonCode revocation.Reason) int {
+	work := make(chan string)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	for i := 0; i < br.parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for serial := range work {
+				if err := br.revokeOne(ctx, serial, reasonCode); err != nil {
+					br.log.AuditErr(fmt.Sprintf("Failed to revoke certificate %s: %s", serial, err))
+					br.stats.Inc("Errors.Revoke", 1)
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, serial := range serials {
+		if br.checkpoint != nil && br.checkpoint.contains(serial) {
+			br.log.Info(fmt.Sprintf("Skipping certificate %s, already revoked according to checkpoint", serial))
+			continue
+		}
+		work <- serial
+	}
+	close(work)
+	wg.Wait()
+	return failed
+}
+
+func (br *batchRevoker) revokeOne(ctx context.Context, serial string, reasonCode revocation.Reason) error {
+	cert, err := getCertificate(br.dbMap, serial)
+	if err != nil {
+		return err
+	}
+	if br.dryRun {
+		br.log.Info(fmt.Sprintf("Would revoke certificate %s with reason '%s'", serial, revocation.ReasonToString[reasonCode]))
+		return nil
+	}
+	err = br.rac.AdministrativelyRevokeCertificate(ctx, *cert, reasonCode, br.user)
+	if err != nil {
+		return err
+	}
+	br.stats.Inc("RevokedCertificates", 1)
+	br.log.Info(fmt.Sprintf("Revoked certificate %s with reason '%s'", serial, revocation.ReasonToString[reasonCode]))
+	if br.checkpoint != nil {
+		if err := br.checkpoint.record(serial); err != nil {
+			br.log.AuditErr(fmt.Sprintf("Failed to record certificate %s in checkpoint: %s", serial, err))
+		}
+	}
+	return nil
+}
+
 // This abstraction is needed so that we can use sort.Sort below
 type revocationCodes []revocation.Reason
 
@@ -139,6 +411,10 @@ func main() {
 	command := os.Args[1]
 	flagSet := flag.NewFlagSet(command, flag.ContinueOnError)
 	configFile := flagSet.String("config", "", "File path to the configuration file for this service")
+	parallelism := flagSet.Int("parallelism", 1, "Number of certificates to revoke at once")
+	checkpointFile := flagSet.String("checkpoint", "", "File path to record revoked serials in")
+	dryRun := flagSet.Bool("dry-run", false, "Log the certificates that would be revoked, without revoking them")
+	batchSize := flagSet.Int("batch-size", 1000, "Number of certificates read from the database at a time")
 	err := flagSet.Parse(os.Args[2:])
 	cmd.FailOnError(err, "Error parsing flagset")
 
@@ -152,6 +428,39 @@ func main() {
 
 	ctx := context.Background()
 	args := flagSet.Args()
+
+	// runBatch revokes serials with the batch flags applied, exiting
+	// unsuccessfully if any couldn't be revoked.
+	runBatch := func(serials []string, reasonCode revocation.Reason, rac certRevoker, logger blog.Logger, dbMap certSelector, stats metrics.Scope) {
+		if !validReasonCode(reasonCode) {
+			cmd.FailOnError(fmt.Errorf("invalid reason code %d", reasonCode), "Can't revoke certificates")
+		}
+		if *parallelism < 1 {
+			cmd.FailOnError(fmt.Errorf("parallelism must be at least 1"), "Can't revoke certificates")
+		}
+		u, err := user.Current()
+		cmd.FailOnError(err, "Couldn't determine current user")
+		br := &batchRevoker{
+			rac:         rac,
+			dbMap:       dbMap,
+			log:         logger,
+			stats:       stats,
+			user:        u.Username,
+			parallelism: *parallelism,
+			dryRun:      *dryRun,
+		}
+		if *checkpointFile != "" {
+			br.checkpoint, err = openCheckpoint(*checkpointFile)
+			cmd.FailOnError(err, "Couldn't open checkpoint file")
+			defer br.checkpoint.Close()
+		}
+		logger.Info(fmt.Sprintf("Revoking %d certificates", len(serials)))
+		failed := br.revoke(ctx, serials, reasonCode)
+		if failed > 0 {
+			cmd.FailOnError(fmt.Errorf("%d of %d certificates couldn't be revoked", failed, len(serials)), "Batch revocation incomplete")
+		}
+	}
+
 	switch {
 	case command == "serial-revoke" && len(args) == 2:
 		// 1: serial,  2: reasonCode
@@ -202,6 +511,59 @@ func main() {
 		err = tx.Commit()
 		cmd.FailOnError(err, "Couldn't cleanly close transaction")
 
+	case command == "batched-serial-revoke" && len(args) == 2:
+		// 1: serials file,  2: reasonCode
+		serials, err := readSerials(args[0])
+		cmd.FailOnError(err, "Couldn't read serials file")
+		reasonCode, err := strconv.Atoi(args[1])
+		cmd.FailOnError(err, "Reason code argument must be an integer")
+
+		cac, logger, dbMap, _, stats := setupContext(c)
+		defer logger.AuditPanic()
+
+		runBatch(serials, revocation.Reason(reasonCode), cac, logger, dbMap, stats)
+
+	case command == "key-revoke" && len(args) == 2:
+		// 1: hex SPKI hash,  2: reasonCode
+		keyHash, err := hex.DecodeString(args[0])
+		cmd.FailOnError(err, "SPKI hash argument must be hex")
+		if len(keyHash) != sha256.Size {
+			cmd.FailOnError(fmt.Errorf("got %d bytes", len(keyHash)), "SPKI hash argument must be a SHA-256 hash")
+		}
+		reasonCode, err := strconv.Atoi(args[1])
+		cmd.FailOnError(err, "Reason code argument must be an integer")
+
+		cac, logger, dbMap, _, stats := setupContext(c)
+		defer logger.AuditPanic()
+
+		logger.Warning("key-revoke only finds certificates in the keyHashToSerial table. Certificates issued " +
+			"before the StoreKeyHashes feature was enabled are missed unless backfill-key-hashes has been run")
+		serials, err := serialsForKeyHash(dbMap, keyHash, time.Now())
+		cmd.FailOnError(err, "Couldn't look up certificates for key")
+		runBatch(serials, revocation.Reason(reasonCode), cac, logger, dbMap, stats)
+
+	case command == "backfill-key-hashes" && len(args) == 0:
+		if *batchSize < 1 {
+			cmd.FailOnError(fmt.Errorf("batch-size must be at least 1"), "Can't backfill key hashes")
+		}
+		_, logger, dbMap, _, stats := setupContext(c)
+		defer logger.AuditPanic()
+
+		kb := &keyHashBackfiller{
+			dbMap:     dbMap,
+			log:       logger,
+			batchSize: *batchSize,
+			dryRun:    *dryRun,
+		}
+		checked, added, err := kb.backfill(time.Now())
+		cmd.FailOnError(err, "Couldn't backfill key hashes")
+		if *dryRun {
+			logger.Info(fmt.Sprintf("Would add key hashes for up to %d unexpired certificates", checked))
+		} else {
+			logger.Info(fmt.Sprintf("Checked %d unexpired certificates, added %d key hashes", checked, added))
+		}
+		stats.Inc("BackfilledKeyHashes", int64(added))
+
 	case command == "list-reasons":
 		var codes revocationCodes
 		for k := range revocation.ReasonToString {
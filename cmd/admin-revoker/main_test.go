@@ -1 +1,211 @@
 package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/letsencrypt/boulder/core"
+	blog "github.com/letsencrypt/boulder/log"
+	"github.com/letsencrypt/boulder/metrics"
+	"github.com/letsencrypt/boulder/revocation"
+	"github.com/letsencrypt/boulder/test"
+)
+
+// mockSelector finds the same certificate for every serial except those in
+// missing
+type mockSelector struct {
+	der     []byte
+	missing map[string]bool
+}
+
+func (ms mockSelector) Select(i interface{}, query string, args ...interface{}) ([]interface{}, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (ms mockSelector) SelectOne(holder interface{}, query string, args ...interface{}) error {
+	if ms.missing[args[0].(string)] {
+		return errors.New("no rows")
+	}
+	holder.(*core.Certificate).DER = ms.der
+	return nil
+}
+
+// mockRevoker records the serials it's asked to revoke
+type mockRevoker struct {
+	mu      sync.Mutex
+	revoked []string
+	serial  string
+}
+
+func (mr *mockRevoker) AdministrativelyRevokeCertificate(_ context.Context, cert x509.Certificate, _ revocation.Reason, _ string) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	mr.revoked = append(mr.revoked, mr.serial)
+	return nil
+}
+
+func setupBatch(t *testing.T, missing map[string]bool) (*batchRevoker, *mockRevoker, *blog.Mock) {
+	cert, err := core.LoadCert("../../test/test-ca.pem")
+	test.AssertNotError(t, err, "Failed to load test certificate")
+	log := blog.NewMock()
+	rac := &mockRevoker{serial: core.SerialToString(cert.SerialNumber)}
+	br := &batchRevoker{
+		rac:         rac,
+		dbMap:       mockSelector{der: cert.Raw, missing: missing},
+		log:         log,
+		stats:       metrics.NewNoopScope(),
+		user:        "tester",
+		parallelism: 3,
+	}
+	return br, rac, log
+}
+
+func writeFile(t *testing.T, dir, name, contents string) string {
+	path := filepath.Join(dir, name)
+	test.AssertNotError(t, ioutil.WriteFile(path, []byte(contents), 0600), "Failed to write file")
+	return path
+}
+
+func TestReadSerials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admin-revoker")
+	test.AssertNotError(t, err, "Failed to create temp dir")
+	defer os.RemoveAll(dir)
+
+	path := writeFile(t, dir, "serials", "# compromised\naa\n\n  bb  \n#cc\ndd")
+	serials, err := readSerials(path)
+	test.AssertNotError(t, err, "readSerials failed")
+	test.AssertDeepEquals(t, serials, []string{"aa", "bb", "dd"})
+
+	_, err = readSerials(filepath.Join(dir, "missing"))
+	test.AssertError(t, err, "readSerials didn't fail on a missing file")
+}
+
+func TestBatchRevoke(t *testing.T) {
+	br, rac, log := setupBatch(t, map[string]bool{"bb": true})
+
+	failed := br.revoke(context.Background(), []string{"aa", "bb", "cc", "dd"}, revocation.Reason(1))
+	test.AssertEquals(t, failed, 1)
+	test.AssertEquals(t, len(rac.revoked), 3)
+	test.AssertEquals(t, len(log.GetAllMatching("Failed to revoke certificate bb")), 1)
+}
+
+func TestBatchRevokeDryRun(t *testing.T) {
+	br, rac, log := setupBatch(t, nil)
+	br.dryRun = true
+
+	failed := br.revoke(context.Background(), []string{"aa", "bb"}, revocation.Reason(1))
+	test.AssertEquals(t, failed, 0)
+	test.AssertEquals(t, len(rac.revoked), 0)
+	test.AssertEquals(t, len(log.GetAllMatching("Would revoke certificate")), 2)
+}
+
+func TestBatchRevokeCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admin-revoker")
+	test.AssertNotError(t, err, "Failed to create temp dir")
+	defer os.RemoveAll(dir)
+	path := writeFile(t, dir, "checkpoint", "aa\n")
+
+	br, rac, _ := setupBatch(t, map[string]bool{"cc": true})
+	br.checkpoint, err = openCheckpoint(path)
+	test.AssertNotError(t, err, "openCheckpoint failed")
+	failed := br.revoke(context.Background(), []string{"aa", "bb", "cc"}, revocation.Reason(1))
+	test.AssertNotError(t, br.checkpoint.Close(), "Failed to close checkpoint")
+	test.AssertEquals(t, failed, 1)
+	test.AssertEquals(t, len(rac.revoked), 1)
+
+	// A resumed run only retries the certificate that failed
+	br, rac, _ = setupBatch(t, nil)
+	br.checkpoint, err = openCheckpoint(path)
+	test.AssertNotError(t, err, "openCheckpoint failed")
+	defer br.checkpoint.Close()
+	failed = br.revoke(context.Background(), []string{"aa", "bb", "cc"}, revocation.Reason(1))
+	test.AssertEquals(t, failed, 0)
+	test.AssertEquals(t, len(rac.revoked), 1)
+
+	serials, err := readSerials(path)
+	test.AssertNotError(t, err, "readSerials failed")
+	sort.Strings(serials)
+	test.AssertDeepEquals(t, serials, []string{"aa", "bb", "cc"})
+}
+
+// backfillDB serves certificates in serial order and records the key hashes
+// inserted, ignoring ones already present as INSERT IGNORE does
+type backfillDB struct {
+	certs    []core.Certificate
+	selects  int
+	inserted map[string][]byte
+}
+
+type rowsResult int64
+
+func (r rowsResult) LastInsertId() (int64, error) { return 0, nil }
+func (r rowsResult) RowsAffected() (int64, error) { return int64(r), nil }
+
+func (db *backfillDB) Select(i interface{}, query string, args ...interface{}) ([]interface{}, error) {
+	db.selects++
+	after := args[0].(string)
+	limit := args[2].(int)
+	output := i.(*[]core.Certificate)
+	for _, cert := range db.certs {
+		if cert.Serial > after && len(*output) < limit {
+			*output = append(*output, cert)
+		}
+	}
+	return nil, nil
+}
+
+func (db *backfillDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	serial := args[2].(string)
+	if _, present := db.inserted[serial]; present {
+		return rowsResult(0), nil
+	}
+	db.inserted[serial] = args[0].([]byte)
+	return rowsResult(1), nil
+}
+
+func TestBackfillKeyHashes(t *testing.T) {
+	cert, err := core.LoadCert("../../test/test-ca.pem")
+	test.AssertNotError(t, err, "Failed to load test certificate")
+	keyHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	db := &backfillDB{
+		certs: []core.Certificate{
+			{Serial: "aa", DER: cert.Raw},
+			{Serial: "bb", DER: []byte("not a certificate")},
+			{Serial: "cc", DER: cert.Raw},
+		},
+		inserted: map[string][]byte{"cc": keyHash[:]},
+	}
+	log := blog.NewMock()
+	kb := &keyHashBackfiller{dbMap: db, log: log, batchSize: 2}
+
+	// A dry run reads every certificate without adding anything
+	kb.dryRun = true
+	checked, added, err := kb.backfill(time.Now())
+	test.AssertNotError(t, err, "backfill failed")
+	test.AssertEquals(t, checked, 3)
+	test.AssertEquals(t, added, 0)
+	test.AssertEquals(t, len(db.inserted), 1)
+
+	// Certificates are read in batches, ones already in the table are skipped
+	// and ones that can't be parsed are logged
+	kb.dryRun = false
+	db.selects = 0
+	checked, added, err = kb.backfill(time.Now())
+	test.AssertNotError(t, err, "backfill failed")
+	test.AssertEquals(t, checked, 3)
+	test.AssertEquals(t, added, 1)
+	test.AssertEquals(t, db.selects, 2)
+	test.AssertByteEquals(t, db.inserted["aa"], keyHash[:])
+	test.AssertEquals(t, len(log.GetAllMatching("Couldn't parse certificate bb")), 2)
+}
@@ -46,24 +46,38 @@ func loadIssuers(c config) ([]ca.Issuer, error) {
 	if c.CA.Key != nil {
 		issuerConfig := *c.CA.Key
 		issuerConfig.CertFile = c.Common.IssuerCert
-		priv, cert, err := loadIssuer(issuerConfig)
-		return []ca.Issuer{{
-			Signer: priv,
-			Cert:   cert,
-		}}, err
+		issuer, err := loadIssuerWithDelegate(issuerConfig)
+		return []ca.Issuer{issuer}, err
 	}
 	var issuers []ca.Issuer
 	for _, issuerConfig := range c.CA.Issuers {
-		priv, cert, err := loadIssuer(issuerConfig)
+		issuer, err := loadIssuerWithDelegate(issuerConfig)
 		cmd.FailOnError(err, "Couldn't load private key")
-		issuers = append(issuers, ca.Issuer{
-			Signer: priv,
-			Cert:   cert,
-		})
+		issuers = append(issuers, issuer)
 	}
 	return issuers, nil
 }
 
+// loadIssuerWithDelegate loads an issuer along with its delegated OCSP
+// responder, if one is configured.
+func loadIssuerWithDelegate(issuerConfig cmd.IssuerConfig) (ca.Issuer, error) {
+	priv, cert, err := loadIssuer(issuerConfig)
+	if err != nil {
+		return ca.Issuer{}, err
+	}
+	issuer := ca.Issuer{
+		Signer: priv,
+		Cert:   cert,
+	}
+	if issuerConfig.OCSPDelegate != nil {
+		issuer.OCSPSigner, issuer.OCSPCert, err = loadIssuer(*issuerConfig.OCSPDelegate)
+		if err != nil {
+			return ca.Issuer{}, fmt.Errorf("Couldn't load OCSP delegate: %s", err)
+		}
+	}
+	return issuer, nil
+}
+
 func loadIssuer(issuerConfig cmd.IssuerConfig) (crypto.Signer, *x509.Certificate, error) {
 	cert, err := core.LoadCert(issuerConfig.CertFile)
 	if err != nil {
@@ -152,12 +166,18 @@ func main() {
 	issuers, err := loadIssuers(c)
 	cmd.FailOnError(err, "Couldn't load issuers")
 
+	keyPolicy := c.CA.KeyPolicy.KeyPolicy()
+	if c.CA.WeakKeyFile != "" {
+		keyPolicy.WeakRSAKeys, err = goodkey.LoadWeakRSAKeys(c.CA.WeakKeyFile)
+		cmd.FailOnError(err, "Couldn't load weak key file")
+	}
+
 	cai, err := ca.NewCertificateAuthorityImpl(
 		c.CA,
 		clock.Default(),
 		scope,
 		issuers,
-		goodkey.NewKeyPolicy(),
+		keyPolicy,
 		logger)
 	cmd.FailOnError(err, "Failed to create CA impl")
 	cai.PA = pa
@@ -12,12 +12,14 @@ import (
 	caPB "github.com/letsencrypt/boulder/ca/proto"
 	"github.com/letsencrypt/boulder/cmd"
 	"github.com/letsencrypt/boulder/core"
+	"github.com/letsencrypt/boulder/features"
 	"github.com/letsencrypt/boulder/goodkey"
 	bgrpc "github.com/letsencrypt/boulder/grpc"
 	"github.com/letsencrypt/boulder/metrics"
 	"github.com/letsencrypt/boulder/policy"
 	"github.com/letsencrypt/boulder/ra"
 	"github.com/letsencrypt/boulder/rpc"
+	"github.com/letsencrypt/boulder/webhook"
 )
 
 const clientName = "RA"
@@ -64,6 +66,36 @@ type config struct {
 		// the pending state. If you can't respond to a challenge this quickly, then
 		// you need to request a new challenge.
 		PendingAuthorizationLifetimeDays int
+
+		KeyPolicy cmd.KeyPolicyConfig
+
+		// WeakKeyFile, if set, is a Debian weak key blocklist in
+		// openssl-blacklist format. RSA keys on it are rejected.
+		WeakKeyFile string
+
+		// EmailValidation controls the DNS checks of contact email domains.
+		// Results are cached for CacheTTL when the domain is valid and
+		// NegativeCacheTTL when it isn't, for up to CacheSize domains. With
+		// Async, contacts whose domain isn't cached are accepted straight
+		// away and checked in the background, for up to MaxPendingRechecks
+		// domains at once (default 100); beyond that contacts are checked
+		// before they're accepted. With the UndeliverableEmails feature
+		// enabled, contacts that fail are recorded as undeliverable, so the
+		// mailers skip them, until they are submitted again and pass. Checks
+		// still pending when the RA stops are lost.
+		EmailValidation struct {
+			CacheTTL           cmd.ConfigDuration
+			NegativeCacheTTL   cmd.ConfigDuration
+			CacheSize          int
+			Async              bool
+			MaxPendingRechecks int
+		}
+
+		// Webhooks, if its key file is set, sends signed notifications of
+		// account events to the https: contacts of registrations
+		Webhooks cmd.WebhookConfig
+
+		Features map[string]bool
 	}
 
 	PA cmd.PAConfig
@@ -91,6 +123,9 @@ func main() {
 	err := cmd.ReadConfigFile(*configFile, &c)
 	cmd.FailOnError(err, "Reading JSON config file into config structure")
 
+	err = features.Set(c.RA.Features)
+	cmd.FailOnError(err, "Failed to set feature flags")
+
 	go cmd.DebugServer(c.RA.DebugAddr)
 
 	stats, logger := cmd.StatsAndLogging(c.Statsd, c.Syslog)
@@ -136,6 +171,15 @@ func main() {
 	sac, err := rpc.NewStorageAuthorityClient(clientName, amqpConf, scope)
 	cmd.FailOnError(err, "Unable to create SA client")
 
+	keyPolicy := c.RA.KeyPolicy.KeyPolicy()
+	if c.RA.WeakKeyFile != "" {
+		keyPolicy.WeakRSAKeys, err = goodkey.LoadWeakRSAKeys(c.RA.WeakKeyFile)
+		cmd.FailOnError(err, "Couldn't load weak key file")
+	}
+	if features.Enabled(features.BlockedKeyTable) {
+		keyPolicy.BlockedKeyCheck = sac.KeyBlocked
+	}
+
 	// TODO(patf): remove once RA.authorizationLifetimeDays is deployed
 	authorizationLifetime := 300 * 24 * time.Hour
 	if c.RA.AuthorizationLifetimeDays != 0 {
@@ -153,7 +197,7 @@ func main() {
 		logger,
 		scope,
 		c.RA.MaxContactsPerRegistration,
-		goodkey.NewKeyPolicy(),
+		keyPolicy,
 		c.RA.MaxNames,
 		c.RA.DoNotForceCN,
 		c.RA.ReuseValidAuthz,
@@ -163,6 +207,12 @@ func main() {
 	policyErr := rai.SetRateLimitPoliciesFile(c.RA.RateLimitPoliciesFilename)
 	cmd.FailOnError(policyErr, "Couldn't load rate limit policies file")
 	rai.PA = pa
+	rai.SetEmailValidation(
+		c.RA.EmailValidation.CacheTTL.Duration,
+		c.RA.EmailValidation.NegativeCacheTTL.Duration,
+		c.RA.EmailValidation.CacheSize,
+		c.RA.EmailValidation.Async,
+		c.RA.EmailValidation.MaxPendingRechecks)
 
 	raDNSTimeout, err := time.ParseDuration(c.Common.DNSTimeout)
 	cmd.FailOnError(err, "Couldn't parse RA DNS timeout")
@@ -191,6 +241,15 @@ func main() {
 	rai.CA = cac
 	rai.SA = sac
 
+	if wc := c.RA.Webhooks; wc.KeyFile != "" {
+		alg, key, err := webhook.LoadKey(wc.KeyFile)
+		cmd.FailOnError(err, "Couldn't load webhook key")
+		notifier, err := webhook.New(alg, key, sac, wc.Timeout.Duration, wc.MaxAttempts,
+			wc.RetryBase.Duration, wc.RetryMax.Duration, logger, scope)
+		cmd.FailOnError(err, "Couldn't create webhook notifier")
+		rai.Webhooks = notifier
+	}
+
 	ras, err := rpc.NewAmqpRPCServer(amqpConf, c.RA.MaxConcurrentRPCServerRequests, scope, logger)
 	cmd.FailOnError(err, "Unable to create RA RPC server")
 	err = rpc.NewRegistrationAuthorityServer(ras, rai, logger)
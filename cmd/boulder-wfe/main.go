@@ -10,10 +10,14 @@ import (
 	"github.com/jmhodges/clock"
 
 	"github.com/letsencrypt/boulder/cmd"
+	"github.com/letsencrypt/boulder/core"
 	"github.com/letsencrypt/boulder/features"
 	"github.com/letsencrypt/boulder/goodkey"
+	bgrpc "github.com/letsencrypt/boulder/grpc"
 	blog "github.com/letsencrypt/boulder/log"
 	"github.com/letsencrypt/boulder/metrics"
+	noncePB "github.com/letsencrypt/boulder/nonce/proto"
+	rlPB "github.com/letsencrypt/boulder/ratelimit/proto"
 	"github.com/letsencrypt/boulder/rpc"
 	"github.com/letsencrypt/boulder/wfe"
 )
@@ -42,6 +46,32 @@ type config struct {
 		AcceptRevocationReason bool
 		AllowAuthzDeactivation bool
 
+		// GetNonceService is used to request new nonces. If it is not set the
+		// WFE issues and redeems nonces in process.
+		GetNonceService *cmd.GRPCClientConfig
+		// RedeemNonceServices maps the prefix of each nonce service to the
+		// configuration used to send it nonces for redemption.
+		RedeemNonceServices map[string]cmd.GRPCClientConfig
+
+		// RequestLimitPoliciesFilename is a YAML file of per-endpoint request
+		// rate limits. If it is not set no request limits are enforced.
+		RequestLimitPoliciesFilename string
+		// RequestLimiterService holds the token buckets for request limits so
+		// they are shared with other WFEs. If it is not set the buckets are
+		// kept in process.
+		RequestLimiterService *cmd.GRPCClientConfig
+
+		KeyPolicy cmd.KeyPolicyConfig
+
+		// WeakKeyFile, if set, is a Debian weak key blocklist that account
+		// keys and CSR keys are checked against. See goodkey.LoadWeakRSAKeys.
+		WeakKeyFile string
+
+		// Unsubscribe configures the endpoint that the unsubscribe links in
+		// notification emails point to. If its key file is not set the
+		// endpoint is not served.
+		Unsubscribe cmd.UnsubscribeConfig
+
 		Features map[string]bool
 	}
 
@@ -68,6 +98,21 @@ func setupWFE(c config, logger blog.Logger, stats metrics.Scope) (*rpc.Registrat
 	return rac, sac
 }
 
+func setupNonceService(c config, stats metrics.Scope) core.NonceService {
+	conn, err := bgrpc.ClientSetup(c.WFE.GetNonceService, stats)
+	cmd.FailOnError(err, "Failed to load credentials and create connection to nonce service")
+	getter := noncePB.NewNonceServiceClient(conn)
+
+	redeemers := make(map[string]noncePB.NonceServiceClient, len(c.WFE.RedeemNonceServices))
+	for prefix, rnsConfig := range c.WFE.RedeemNonceServices {
+		rnsConfig := rnsConfig
+		conn, err := bgrpc.ClientSetup(&rnsConfig, stats)
+		cmd.FailOnError(err, fmt.Sprintf("Failed to load credentials and create connection to nonce service %q", prefix))
+		redeemers[prefix] = noncePB.NewNonceServiceClient(conn)
+	}
+	return bgrpc.NewNonceServiceClient(getter, redeemers, c.WFE.GetNonceService.Timeout.Duration)
+}
+
 func main() {
 	configFile := flag.String("config", "", "File path to the configuration file for this service")
 	flag.Parse()
@@ -90,11 +135,34 @@ func main() {
 	defer logger.AuditPanic()
 	logger.Info(cmd.VersionString(clientName))
 
-	wfe, err := wfe.NewWebFrontEndImpl(scope, clock.Default(), goodkey.NewKeyPolicy(), logger)
-	cmd.FailOnError(err, "Unable to create WFE")
 	rac, sac := setupWFE(c, logger, scope)
+	keyPolicy := c.WFE.KeyPolicy.KeyPolicy()
+	if c.WFE.WeakKeyFile != "" {
+		keyPolicy.WeakRSAKeys, err = goodkey.LoadWeakRSAKeys(c.WFE.WeakKeyFile)
+		cmd.FailOnError(err, "Couldn't load weak key file")
+	}
+	if features.Enabled(features.BlockedKeyTable) {
+		keyPolicy.BlockedKeyCheck = sac.KeyBlocked
+	}
+	wfe, err := wfe.NewWebFrontEndImpl(scope, clock.Default(), keyPolicy, logger)
+	cmd.FailOnError(err, "Unable to create WFE")
 	wfe.RA = rac
 	wfe.SA = sac
+	wfe.UnsubscribeSigner, err = c.WFE.Unsubscribe.Signer()
+	cmd.FailOnError(err, "Couldn't load unsubscribe key")
+	wfe.EmailOptOuts = sac
+	if c.WFE.GetNonceService != nil {
+		wfe.NonceService = setupNonceService(c, scope)
+	}
+	if c.WFE.RequestLimitPoliciesFilename != "" {
+		err = wfe.SetRequestLimitPoliciesFile(c.WFE.RequestLimitPoliciesFilename)
+		cmd.FailOnError(err, "Couldn't load request limit policies file")
+	}
+	if c.WFE.RequestLimiterService != nil {
+		conn, err := bgrpc.ClientSetup(c.WFE.RequestLimiterService, scope)
+		cmd.FailOnError(err, "Failed to load credentials and create connection to request limiter service")
+		wfe.RequestBuckets = bgrpc.NewBucketStoreClient(rlPB.NewBucketStoreClient(conn), c.WFE.RequestLimiterService.Timeout.Duration)
+	}
 
 	// TODO: remove this check once the production config uses the SubscriberAgreementURL in the wfe section
 	if c.WFE.SubscriberAgreementURL != "" {
@@ -116,7 +184,7 @@ func main() {
 	wfe.IssuerCert, err = cmd.LoadCert(c.Common.IssuerCert)
 	cmd.FailOnError(err, fmt.Sprintf("Couldn't read issuer cert [%s]", c.Common.IssuerCert))
 
-	logger.Info(fmt.Sprintf("WFE using key policy: %#v", goodkey.NewKeyPolicy()))
+	logger.Info(fmt.Sprintf("WFE using key policy: %#v", keyPolicy))
 
 	go cmd.ProfileCmd(scope)
 
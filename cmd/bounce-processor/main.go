@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/net/context"
+
+	"github.com/letsencrypt/boulder/cmd"
+	blog "github.com/letsencrypt/boulder/log"
+	bmail "github.com/letsencrypt/boulder/mail"
+	"github.com/letsencrypt/boulder/metrics"
+	"github.com/letsencrypt/boulder/rpc"
+)
+
+const clientName = "BounceProcessor"
+
+const defaultPollInterval = time.Minute
+
+type undeliverableAdder interface {
+	AddUndeliverableEmail(ctx context.Context, email string, reason string, added time.Time) error
+}
+
+type bounceProcessor struct {
+	sa    undeliverableAdder
+	stats metrics.Scope
+	log   blog.Logger
+	clk   clock.Clock
+	// unsubscribe verifies the unsubscribe links in the headers that DSNs
+	// return, so that only bounces of mail we sent are believed
+	unsubscribe *bmail.UnsubscribeSigner
+	// webhookPassword is the HTTP basic auth password webhook requests must
+	// carry
+	webhookPassword string
+}
+
+// recordBounces marks each bounced recipient as undeliverable
+func (bp *bounceProcessor) recordBounces(ctx context.Context, bounces []bmail.Bounce) error {
+	for _, b := range bounces {
+		err := bp.sa.AddUndeliverableEmail(ctx, b.Recipient, b.Status, bp.clk.Now())
+		if err != nil {
+			bp.stats.Inc("Errors.AddUndeliverableEmail", 1)
+			return err
+		}
+		bp.log.Info(fmt.Sprintf("Marked %q undeliverable (status %s)", b.Recipient, b.Status))
+		bp.stats.Inc("Bounces", 1)
+	}
+	return nil
+}
+
+// verifiedBounces returns the bounces from a DSN that return the headers of a
+// message we sent to the bounced recipient. Anyone can send a DSN to the
+// bounce mailbox, but only we can sign the unsubscribe link that our mail to
+// an address carries.
+func (bp *bounceProcessor) verifiedBounces(name string, bounces []bmail.Bounce) []bmail.Bounce {
+	var verified []bmail.Bounce
+	for _, b := range bounces {
+		_, email, err := bp.unsubscribe.VerifyListUnsubscribe(b.ListUnsubscribe)
+		if err != nil || !strings.EqualFold(email, b.Recipient) {
+			bp.log.Info(fmt.Sprintf("Ignoring unverified bounce of %q in message %s", b.Recipient, name))
+			bp.stats.Inc("Errors.UnverifiedBounce", 1)
+			continue
+		}
+		verified = append(verified, b)
+	}
+	return verified
+}
+
+// processMaildir reads every message in the new/ directory of the maildir at
+// dir as a delivery status notification. Messages are moved to cur/ once they
+// are handled, including ones that aren't DSNs. Only bounces that
+// verifiedBounces accepts are recorded. Messages whose bounces couldn't be
+// recorded are left in new/ to be retried.
+func (bp *bounceProcessor) processMaildir(ctx context.Context, dir string) error {
+	newDir := filepath.Join(dir, "new")
+	entries, err := ioutil.ReadDir(newDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(newDir, entry.Name())
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		bounces, err := bmail.ParseDSN(f)
+		_ = f.Close()
+		if err != nil {
+			bp.log.Info(fmt.Sprintf("Skipping message %s: %s", entry.Name(), err))
+			bp.stats.Inc("Errors.ParseDSN", 1)
+		} else if err := bp.recordBounces(ctx, bp.verifiedBounces(entry.Name(), bounces)); err != nil {
+			bp.log.AuditErr(fmt.Sprintf("Failed to record bounces from message %s: %s", entry.Name(), err))
+			continue
+		}
+		// The maildir convention for messages that have been seen
+		err = os.Rename(path, filepath.Join(dir, "cur", entry.Name()+":2,S"))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webhookBounce is one entry of the JSON array that the bounce webhook
+// accepts
+type webhookBounce struct {
+	Email string `json:"email"`
+	// Status is the RFC 3463 status code of the bounce. Only permanent
+	// failures, with 5.X.X codes, mark the address undeliverable.
+	Status string `json:"status"`
+}
+
+// ServeHTTP handles bounce webhook requests. The mail provider should retry
+// requests that fail.
+func (bp *bounceProcessor) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		response.Header().Set("Allow", "POST")
+		http.Error(response, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_, password, ok := request.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(bp.webhookPassword)) != 1 {
+		http.Error(response, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var payload []webhookBounce
+	if err := json.NewDecoder(request.Body).Decode(&payload); err != nil {
+		http.Error(response, "Malformed bounce payload", http.StatusBadRequest)
+		return
+	}
+	var bounces []bmail.Bounce
+	for _, wb := range payload {
+		if wb.Email == "" || !strings.HasPrefix(wb.Status, "5.") {
+			continue
+		}
+		bounces = append(bounces, bmail.Bounce{Recipient: wb.Email, Status: wb.Status})
+	}
+	if err := bp.recordBounces(context.Background(), bounces); err != nil {
+		bp.log.AuditErr(fmt.Sprintf("Failed to record webhook bounces: %s", err))
+		http.Error(response, "Failed to record bounces", http.StatusInternalServerError)
+		return
+	}
+	response.WriteHeader(http.StatusOK)
+}
+
+type config struct {
+	BounceProcessor struct {
+		cmd.ServiceConfig
+
+		// Maildir is a maildir that bounce notifications are delivered to.
+		// Messages in its new/ directory are processed every PollInterval.
+		Maildir      string
+		PollInterval cmd.ConfigDuration
+
+		// Unsubscribe must have the mailers' unsubscribe key file when Maildir
+		// is set. A bounce is only recorded if the DSN returns the headers of
+		// the bounced message, with an unsubscribe link signed for the
+		// recipient, so mail sent without unsubscribe links can't be marked
+		// undeliverable from the maildir.
+		Unsubscribe cmd.UnsubscribeConfig
+
+		// WebhookAddress, if set, is the address to serve the bounce webhook
+		// on. Requests must use HTTP basic auth with the configured password.
+		WebhookAddress string
+		cmd.PasswordConfig
+	}
+
+	Statsd cmd.StatsdConfig
+
+	Syslog cmd.SyslogConfig
+}
+
+func main() {
+	configFile := flag.String("config", "", "File path to the configuration file for this service")
+	once := flag.Bool("once", false, "Process the maildir once and exit")
+	flag.Parse()
+	if *configFile == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var c config
+	err := cmd.ReadConfigFile(*configFile, &c)
+	cmd.FailOnError(err, "Reading JSON config file into config structure")
+	conf := c.BounceProcessor
+	if conf.Maildir == "" && conf.WebhookAddress == "" {
+		cmd.FailOnError(errors.New("neither a maildir nor a webhook address is configured"), "Invalid config")
+	}
+
+	go cmd.DebugServer(conf.DebugAddr)
+
+	stats, logger := cmd.StatsAndLogging(c.Statsd, c.Syslog)
+	scope := metrics.NewStatsdScope(stats, "BounceProcessor")
+	defer logger.AuditPanic()
+	logger.Info(cmd.VersionString(clientName))
+
+	sac, err := rpc.NewStorageAuthorityClient(clientName, conf.AMQP, scope)
+	cmd.FailOnError(err, "Failed to create SA client")
+
+	webhookPassword, err := conf.PasswordConfig.Pass()
+	cmd.FailOnError(err, "Failed to load webhook password")
+
+	unsubscribe, err := conf.Unsubscribe.Signer()
+	cmd.FailOnError(err, "Couldn't load unsubscribe key")
+	if conf.Maildir != "" && unsubscribe == nil {
+		cmd.FailOnError(errors.New("an unsubscribe key is required to verify bounces in the maildir"), "Invalid config")
+	}
+
+	bp := &bounceProcessor{
+		sa:              sac,
+		stats:           scope,
+		log:             logger,
+		clk:             cmd.Clock(),
+		unsubscribe:     unsubscribe,
+		webhookPassword: webhookPassword,
+	}
+
+	if conf.WebhookAddress != "" {
+		if webhookPassword == "" {
+			cmd.FailOnError(errors.New("a webhook password is required to serve the bounce webhook"), "Invalid config")
+		}
+		go func() {
+			logger.Info(fmt.Sprintf("Serving bounce webhook on %s", conf.WebhookAddress))
+			err := http.ListenAndServe(conf.WebhookAddress, bp)
+			cmd.FailOnError(err, "Bounce webhook server failed")
+		}()
+	}
+
+	if conf.Maildir == "" {
+		select {}
+	}
+	pollInterval := conf.PollInterval.Duration
+	if pollInterval == 0 {
+		pollInterval = defaultPollInterval
+	}
+	for {
+		err = bp.processMaildir(context.Background(), conf.Maildir)
+		if err != nil {
+			logger.AuditErr(fmt.Sprintf("Failed to process maildir %s: %s", conf.Maildir, err))
+		}
+		if *once {
+			return
+		}
+		bp.clk.Sleep(pollInterval)
+	}
+}
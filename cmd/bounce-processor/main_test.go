@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/net/context"
+
+	blog "github.com/letsencrypt/boulder/log"
+	bmail "github.com/letsencrypt/boulder/mail"
+	"github.com/letsencrypt/boulder/metrics"
+	"github.com/letsencrypt/boulder/test"
+)
+
+const dsnTemplate = "From: MAILER-DAEMON@mx.example.com\r\n" +
+	"Content-Type: multipart/report; report-type=delivery-status; boundary=\"BOUNDARY\"\r\n" +
+	"\r\n" +
+	"--BOUNDARY\r\n" +
+	"Content-Type: message/delivery-status\r\n" +
+	"\r\n" +
+	"Reporting-MTA: dns; mx.example.com\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; %s\r\n" +
+	"Action: failed\r\n" +
+	"Status: 5.1.1\r\n" +
+	"--BOUNDARY\r\n" +
+	"Content-Type: text/rfc822-headers\r\n" +
+	"\r\n" +
+	"Subject: Certificate expiration notice\r\n" +
+	"List-Unsubscribe: <%s>\r\n" +
+	"--BOUNDARY--\r\n"
+
+var unsubscribe, _ = bmail.NewUnsubscribeSigner([]byte("0123456789abcdef0123456789abcdef"), "https://example.com/unsubscribe")
+
+// makeDSN returns a DSN reporting that mail to recipient bounced, which
+// returns the headers of a message sent to unsubscribeFor
+func makeDSN(recipient, unsubscribeFor string) []byte {
+	return []byte(fmt.Sprintf(dsnTemplate, recipient, unsubscribe.URL(1, unsubscribeFor)))
+}
+
+type fakeAdder struct {
+	undeliverable map[string]string
+	err           error
+}
+
+func (f *fakeAdder) AddUndeliverableEmail(ctx context.Context, email string, reason string, added time.Time) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.undeliverable[email] = reason
+	return nil
+}
+
+func newProcessor() (*bounceProcessor, *fakeAdder) {
+	sa := &fakeAdder{undeliverable: make(map[string]string)}
+	return &bounceProcessor{
+		sa:              sa,
+		stats:           metrics.NewNoopScope(),
+		log:             blog.NewMock(),
+		clk:             clock.NewFake(),
+		unsubscribe:     unsubscribe,
+		webhookPassword: "hunter2",
+	}, sa
+}
+
+func makeMaildir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "bounce-processor")
+	test.AssertNotError(t, err, "Failed to create temp dir")
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		err = os.Mkdir(filepath.Join(dir, sub), 0700)
+		test.AssertNotError(t, err, "Failed to create maildir")
+	}
+	return dir
+}
+
+func TestProcessMaildir(t *testing.T) {
+	bp, sa := newProcessor()
+	dir := makeMaildir(t)
+	defer os.RemoveAll(dir)
+
+	err := ioutil.WriteFile(filepath.Join(dir, "new", "1.dsn"), makeDSN("gone@example.com", "gone@example.com"), 0600)
+	test.AssertNotError(t, err, "Failed to write DSN")
+	err = ioutil.WriteFile(filepath.Join(dir, "new", "2.spam"), []byte("Subject: hi\r\n\r\nhello\r\n"), 0600)
+	test.AssertNotError(t, err, "Failed to write message")
+	// DSNs that don't return a message we sent to the recipient are forgeries
+	err = ioutil.WriteFile(filepath.Join(dir, "new", "3.dsn"), makeDSN("victim@example.com", "gone@example.com"), 0600)
+	test.AssertNotError(t, err, "Failed to write DSN")
+	err = ioutil.WriteFile(filepath.Join(dir, "new", "4.dsn"), []byte(strings.Replace(string(makeDSN("victim@example.com", "victim@example.com")), "List-Unsubscribe", "X-Unsubscribe", 1)), 0600)
+	test.AssertNotError(t, err, "Failed to write DSN")
+
+	// A failure to record bounces leaves the message to be retried
+	sa.err = errors.New("oops")
+	err = bp.processMaildir(context.Background(), dir)
+	test.AssertNotError(t, err, "processMaildir failed")
+	_, err = os.Stat(filepath.Join(dir, "new", "1.dsn"))
+	test.AssertNotError(t, err, "DSN was moved despite SA failure")
+
+	sa.err = nil
+	err = bp.processMaildir(context.Background(), dir)
+	test.AssertNotError(t, err, "processMaildir failed")
+	test.AssertDeepEquals(t, sa.undeliverable, map[string]string{"gone@example.com": "5.1.1"})
+
+	// All the messages have been handled
+	remaining, err := ioutil.ReadDir(filepath.Join(dir, "new"))
+	test.AssertNotError(t, err, "Failed to read maildir")
+	test.AssertEquals(t, len(remaining), 0)
+	_, err = os.Stat(filepath.Join(dir, "cur", "1.dsn:2,S"))
+	test.AssertNotError(t, err, "DSN wasn't moved to cur")
+}
+
+func TestWebhook(t *testing.T) {
+	bp, sa := newProcessor()
+
+	post := func(password, body string) int {
+		req, err := http.NewRequest("POST", "/", strings.NewReader(body))
+		test.AssertNotError(t, err, "Failed to create request")
+		req.SetBasicAuth("bounces", password)
+		resp := httptest.NewRecorder()
+		bp.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	payload := `[{"email":"gone@example.com","status":"5.1.1"},{"email":"full@example.com","status":"4.2.2"}]`
+
+	test.AssertEquals(t, post("wrong", payload), http.StatusUnauthorized)
+	test.AssertEquals(t, len(sa.undeliverable), 0)
+
+	test.AssertEquals(t, post("hunter2", "{"), http.StatusBadRequest)
+
+	// Only permanent failures are recorded
+	test.AssertEquals(t, post("hunter2", payload), http.StatusOK)
+	test.AssertDeepEquals(t, sa.undeliverable, map[string]string{"gone@example.com": "5.1.1"})
+
+	sa.err = errors.New("oops")
+	test.AssertEquals(t, post("hunter2", payload), http.StatusInternalServerError)
+
+	req, err := http.NewRequest("GET", "/", nil)
+	test.AssertNotError(t, err, "Failed to create request")
+	resp := httptest.NewRecorder()
+	bp.ServeHTTP(resp, req)
+	test.AssertEquals(t, resp.Code, http.StatusMethodNotAllowed)
+}
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,6 +14,8 @@ import (
 	"github.com/letsencrypt/pkcs11key"
 
 	"github.com/letsencrypt/boulder/core"
+	"github.com/letsencrypt/boulder/goodkey"
+	"github.com/letsencrypt/boulder/mail"
 )
 
 // PasswordConfig either contains a password or the path to a file
@@ -69,6 +73,94 @@ type SMTPConfig struct {
 	Server   string
 	Port     string
 	Username string
+	// TLS is "implicit" for TLS from the start of the connection, "starttls"
+	// to require upgrading the connection with STARTTLS before authenticating,
+	// or "none". Defaults to "implicit" on port 465 and "starttls" otherwise.
+	TLS string
+	// CACertFile is an optional PEM bundle of roots to verify the server's
+	// certificate against instead of the system roots
+	CACertFile string
+	// ServerName is the name the server's certificate must be valid for.
+	// Defaults to Server.
+	ServerName string
+	// Connections is the number of SMTP connections to send over in
+	// parallel. Defaults to 1.
+	Connections int
+	// SendsPerSecond, if greater than zero, limits the rate of sends across
+	// all connections
+	SendsPerSecond float64
+}
+
+// TLSConfig returns the TLS mode to connect to the mail server with and the
+// TLS config to verify it with
+func (sc *SMTPConfig) TLSConfig() (mail.TLSMode, *tls.Config, error) {
+	mode := mail.TLSMode(sc.TLS)
+	switch mode {
+	case "":
+		// By convention, port 465 is TLS-wrapped SMTP
+		mode = mail.TLSStartTLS
+		if sc.Port == "465" {
+			mode = mail.TLSImplicit
+		}
+	case mail.TLSImplicit, mail.TLSStartTLS, mail.TLSNone:
+	default:
+		return "", nil, fmt.Errorf("unknown SMTP TLS mode %q", sc.TLS)
+	}
+
+	tlsConfig := &tls.Config{ServerName: sc.ServerName}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = sc.Server
+	}
+	if sc.CACertFile != "" {
+		pem, err := ioutil.ReadFile(sc.CACertFile)
+		if err != nil {
+			return "", nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return "", nil, fmt.Errorf("no certificates found in %q", sc.CACertFile)
+		}
+	}
+	return mode, tlsConfig, nil
+}
+
+// UnsubscribeConfig configures the signed unsubscribe links in notification
+// emails. The mailers and the WFE must use the same key.
+type UnsubscribeConfig struct {
+	// BaseURL is the URL of the WFE's unsubscribe endpoint
+	BaseURL string
+	// KeyFile is the path to a file containing the HMAC key that unsubscribe
+	// tokens are signed with. It must be at least 32 bytes long.
+	KeyFile string
+}
+
+// Signer returns an UnsubscribeSigner for the configured key and base URL, or
+// nil if no key file is configured.
+func (uc *UnsubscribeConfig) Signer() (*mail.UnsubscribeSigner, error) {
+	if uc.KeyFile == "" {
+		return nil, nil
+	}
+	key, err := ioutil.ReadFile(uc.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return mail.NewUnsubscribeSigner([]byte(strings.TrimRight(string(key), "\n")), uc.BaseURL)
+}
+
+// WebhookConfig configures the delivery of signed notifications to the
+// https: webhook contacts of registrations
+type WebhookConfig struct {
+	// KeyFile is the path to a PEM file containing the ECDSA (P-256 or P-384)
+	// or RSA private key that notifications are signed with
+	KeyFile string
+	// Timeout bounds each delivery attempt. Defaults to 10 seconds.
+	Timeout ConfigDuration
+	// MaxAttempts is the number of times a delivery is tried before giving
+	// up. Defaults to 1.
+	MaxAttempts int
+	// RetryBase and RetryMax bound the backoff between attempts
+	RetryBase ConfigDuration
+	RetryMax  ConfigDuration
 }
 
 // AMQPConfig describes how to connect to AMQP, and how to speak to each of the
@@ -118,6 +210,10 @@ type CAConfig struct {
 
 	RSAProfile   string
 	ECDSAProfile string
+	// ECDSAP521Profile is the profile used for P-521 keys, if it's different
+	// from ECDSAProfile
+	ECDSAP521Profile string
+
 	TestMode     bool
 	SerialPrefix int
 	// TODO(jsha): Remove Key field once we've migrated to Issuers
@@ -147,6 +243,30 @@ type CAConfig struct {
 	EnableMustStaple bool
 
 	PublisherService *GRPCClientConfig
+
+	KeyPolicy KeyPolicyConfig
+
+	// WeakKeyFile is the path to a blocklist of Debian weak RSA keys, in the
+	// format of the openssl-blacklist package. Keys on it are rejected. If
+	// empty, no blocklist is checked.
+	WeakKeyFile string
+}
+
+// KeyPolicyConfig allows key types beyond the RSA, P-256 and P-384 keys that
+// goodkey.NewKeyPolicy allows.
+type KeyPolicyConfig struct {
+	AllowECDSANISTP521 bool
+	// AllowEd25519 only affects account keys; certificate keys must be RSA
+	// or ECDSA.
+	AllowEd25519 bool
+}
+
+// KeyPolicy returns the key policy with the configured key types allowed.
+func (kpc KeyPolicyConfig) KeyPolicy() goodkey.KeyPolicy {
+	policy := goodkey.NewKeyPolicy()
+	policy.AllowECDSANISTP521 = kpc.AllowECDSANISTP521
+	policy.AllowEd25519 = kpc.AllowEd25519
+	return policy
 }
 
 // PAConfig specifies how a policy authority should connect to its
@@ -187,6 +307,9 @@ type IssuerConfig struct {
 	File       string
 	PKCS11     *pkcs11key.Config
 	CertFile   string
+	// OCSPDelegate optionally configures a delegated OCSP responder key and
+	// certificate, issued by this issuer, to sign OCSP responses with.
+	OCSPDelegate *IssuerConfig
 }
 
 // TLSConfig reprents certificates and a key for authenticated TLS.
@@ -232,6 +355,24 @@ type OCSPUpdaterConfig struct {
 	SignFailureBackoffFactor float64
 	SignFailureBackoffMax    ConfigDuration
 
+	// The number of OCSP responses to sign concurrently. Defaults to 1.
+	ParallelGenerateOCSPRequests int
+
+	// If non-empty, this updater only handles certificates whose serials
+	// (in hex) start with one of these prefixes. Giving each of several
+	// updaters a disjoint set of prefixes lets them split the work. Serials
+	// begin with the CA's SerialPrefix byte, so prefixes must include it:
+	// with a SerialPrefix of 255, use prefixes such as "ff0" and "ff1", not
+	// "0" and "1". No prefix may be a prefix of another.
+	//
+	// Each updater only checks its own prefixes, so it is up to the operator
+	// to make the prefixes of all the updaters disjoint, or certificates will
+	// be handled by more than one of them, and to make them cover every
+	// serial the CAs issue, or some certificates won't be handled at all. A
+	// single updater with SerialPrefixes of ["ff"] handles every certificate
+	// from a CA with a SerialPrefix of 255.
+	SerialPrefixes []string
+
 	Publisher *GRPCClientConfig
 }
 
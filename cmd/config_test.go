@@ -3,6 +3,7 @@ package cmd
 import (
 	"testing"
 
+	"github.com/letsencrypt/boulder/mail"
 	"github.com/letsencrypt/boulder/test"
 )
 
@@ -47,3 +48,53 @@ func TestPasswordConfig(t *testing.T) {
 		test.AssertEquals(t, password, tc.expected)
 	}
 }
+
+func TestSMTPTLSConfig(t *testing.T) {
+	tests := []struct {
+		conf         SMTPConfig
+		expectedMode mail.TLSMode
+		expectedName string
+	}{
+		{
+			conf:         SMTPConfig{Server: "smtp.example.com", Port: "465"},
+			expectedMode: mail.TLSImplicit,
+			expectedName: "smtp.example.com",
+		},
+		{
+			// Other ports require STARTTLS unless configured otherwise
+			conf:         SMTPConfig{Server: "smtp.example.com", Port: "587"},
+			expectedMode: mail.TLSStartTLS,
+			expectedName: "smtp.example.com",
+		},
+		{
+			conf:         SMTPConfig{Server: "10.0.0.1", Port: "465", TLS: "starttls", ServerName: "smtp.example.com"},
+			expectedMode: mail.TLSStartTLS,
+			expectedName: "smtp.example.com",
+		},
+		{
+			conf:         SMTPConfig{Server: "localhost", Port: "25", TLS: "none"},
+			expectedMode: mail.TLSNone,
+			expectedName: "localhost",
+		},
+	}
+	for _, tc := range tests {
+		mode, tlsConfig, err := tc.conf.TLSConfig()
+		test.AssertNotError(t, err, "Failed to build SMTP TLS config")
+		test.AssertEquals(t, mode, tc.expectedMode)
+		test.AssertEquals(t, tlsConfig.ServerName, tc.expectedName)
+		test.Assert(t, tlsConfig.RootCAs == nil, "Expected system roots")
+	}
+
+	conf := SMTPConfig{Server: "smtp.example.com", Port: "587", CACertFile: "../test/test-root.pem"}
+	_, tlsConfig, err := conf.TLSConfig()
+	test.AssertNotError(t, err, "Failed to build SMTP TLS config")
+	test.AssertEquals(t, len(tlsConfig.RootCAs.Subjects()), 1)
+
+	conf = SMTPConfig{Server: "smtp.example.com", Port: "587", CACertFile: "testdata/test_secret"}
+	_, _, err = conf.TLSConfig()
+	test.AssertError(t, err, "Accepted a CA file without certificates")
+
+	conf = SMTPConfig{Server: "smtp.example.com", Port: "587", TLS: "opportunistic"}
+	_, _, err = conf.TLSConfig()
+	test.AssertError(t, err, "Accepted an unknown TLS mode")
+}
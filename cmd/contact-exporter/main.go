@@ -1,71 +1,251 @@
 package main
 
 import (
+	"bufio"
+	"encoding/csv"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
-	"gopkg.in/gorp.v1"
-
 	"github.com/jmhodges/clock"
 	"github.com/letsencrypt/boulder/cmd"
+	"github.com/letsencrypt/boulder/core"
 	blog "github.com/letsencrypt/boulder/log"
 	"github.com/letsencrypt/boulder/sa"
 )
 
 type contactExporter struct {
 	log   blog.Logger
-	dbMap *gorp.DbMap
+	dbMap dbSelector
 	clk   clock.Clock
 	grace time.Duration
+
+	// Optional filters. nameSuffixes matches certificates with a name equal
+	// to or under one of the suffixes, issuedAfter and issuedBefore bound
+	// when a certificate was issued, and regIDs limits the export to the
+	// given registrations.
+	nameSuffixes []string
+	issuedAfter  time.Time
+	issuedBefore time.Time
+	regIDs       []int64
+
+	// batchSize is the number of registrations fetched from the database at a
+	// time
+	batchSize int
+}
+
+// dbSelector is the part of gorp.DbMap that the exporter uses
+type dbSelector interface {
+	Select(i interface{}, query string, args ...interface{}) ([]interface{}, error)
 }
 
 type contact struct {
 	ID int64 `json:"id"`
 }
 
-// Find all registration contacts with unexpired certificates.
-func (c contactExporter) findContacts() ([]contact, error) {
-	var contactsList []contact
-	_, err := c.dbMap.Select(
-		&contactsList,
-		`SELECT id
+const defaultBatchSize = 1000
+
+// Find all registration contacts with unexpired certificates that match the
+// exporter's filters, calling found with each in order of registration ID.
+// Registrations are fetched in batches, so that they never all need to be
+// held in memory.
+func (c contactExporter) findContacts(found func(contact) error) error {
+	query, params := c.query()
+	batchSize := c.batchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	params["limit"] = batchSize
+	params["after"] = int64(0)
+	for {
+		var contactsList []contact
+		_, err := c.dbMap.Select(&contactsList, query, params)
+		if err != nil {
+			c.log.AuditErr(fmt.Sprintf("Error finding contacts: %s", err))
+			return err
+		}
+		for _, ct := range contactsList {
+			if err := found(ct); err != nil {
+				return err
+			}
+		}
+		if len(contactsList) < batchSize {
+			return nil
+		}
+		params["after"] = contactsList[len(contactsList)-1].ID
+	}
+}
+
+// query builds the query for a batch of contacts, which takes the registration
+// ID to start after and the batch size as the "after" and "limit" parameters
+func (c contactExporter) query() (string, map[string]interface{}) {
+	params := map[string]interface{}{
+		"expireCutoff": c.clk.Now().Add(-c.grace),
+	}
+	certConditions := []string{"expires >= :expireCutoff"}
+	if !c.issuedAfter.IsZero() {
+		certConditions = append(certConditions, "issued >= :issuedAfter")
+		params["issuedAfter"] = c.issuedAfter
+	}
+	if !c.issuedBefore.IsZero() {
+		certConditions = append(certConditions, "issued < :issuedBefore")
+		params["issuedBefore"] = c.issuedBefore
+	}
+	if len(c.nameSuffixes) > 0 {
+		// issuedNames holds names reversed, so that a suffix of a name is a
+		// prefix of its reversed form
+		var nameConditions []string
+		for i, suffix := range c.nameSuffixes {
+			reversed := core.ReverseName(suffix)
+			exact := fmt.Sprintf("name%d", i)
+			under := fmt.Sprintf("nameLike%d", i)
+			nameConditions = append(nameConditions,
+				fmt.Sprintf("reversedName = :%s OR reversedName LIKE :%s", exact, under))
+			params[exact] = reversed
+			params[under] = likeEscaper.Replace(reversed) + ".%"
+		}
+		certConditions = append(certConditions, fmt.Sprintf(
+			"serial IN (SELECT serial FROM issuedNames WHERE %s)",
+			strings.Join(nameConditions, " OR ")))
+	}
+
+	regConditions := []string{"contact != 'null'", "id > :after"}
+	if len(c.regIDs) > 0 {
+		var placeholders []string
+		for i, id := range c.regIDs {
+			name := fmt.Sprintf("reg%d", i)
+			placeholders = append(placeholders, ":"+name)
+			params[name] = id
+		}
+		regConditions = append(regConditions, fmt.Sprintf("id IN (%s)", strings.Join(placeholders, ", ")))
+	}
+
+	query := fmt.Sprintf(`SELECT id
 		FROM registrations
-		WHERE contact != 'null' AND
+		WHERE %s AND
 			id IN (
 				SELECT registrationID
 				FROM certificates
-				WHERE expires >= :expireCutoff
-			);`,
-		map[string]interface{}{
-			"expireCutoff": c.clk.Now().Add(-c.grace),
-		})
-	if err != nil {
-		c.log.AuditErr(fmt.Sprintf("Error finding contacts: %s", err))
-		return nil, err
+				WHERE %s
+			)
+		ORDER BY id
+		LIMIT :limit;`,
+		strings.Join(regConditions, " AND "),
+		strings.Join(certConditions, " AND "))
+	return query, params
+}
+
+// likeEscaper escapes the characters that are special in a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// contactWriter writes contacts in one of the output formats
+type contactWriter interface {
+	write(contact) error
+	// close finishes the output. It doesn't close the underlying writer.
+	close() error
+}
+
+// Output formats
+const (
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+)
+
+func newContactWriter(w io.Writer, format string) (contactWriter, error) {
+	switch format {
+	case formatJSON:
+		return &jsonWriter{w: w}, nil
+	case formatNDJSON:
+		return &ndjsonWriter{enc: json.NewEncoder(w)}, nil
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"id"}); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: cw}, nil
 	}
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
 
-	return contactsList, nil
+// jsonWriter writes a single JSON array of contact objects
+type jsonWriter struct {
+	w       io.Writer
+	started bool
 }
 
-// The `writeContacts` function produces a file containing JSON serialized
-// contact objects
-func writeContacts(contactsList []contact, outfile string) error {
-	data, err := json.Marshal(contactsList)
+func (jw *jsonWriter) write(ct contact) error {
+	data, err := json.Marshal(ct)
 	if err != nil {
 		return err
 	}
-	data = append(data, '\n')
+	sep := ","
+	if !jw.started {
+		sep = "["
+		jw.started = true
+	}
+	_, err = fmt.Fprintf(jw.w, "%s%s", sep, data)
+	return err
+}
+
+func (jw *jsonWriter) close() error {
+	if !jw.started {
+		_, err := fmt.Fprint(jw.w, "[]\n")
+		return err
+	}
+	_, err := fmt.Fprint(jw.w, "]\n")
+	return err
+}
+
+// ndjsonWriter writes one JSON contact object per line
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (nw *ndjsonWriter) write(ct contact) error {
+	return nw.enc.Encode(ct)
+}
 
-	if outfile != "" {
-		return ioutil.WriteFile(outfile, data, 0644)
-	} else {
-		fmt.Printf("%s", data)
-		return nil
+func (nw *ndjsonWriter) close() error {
+	return nil
+}
+
+// csvWriter writes an "id" column of registration IDs
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (cw *csvWriter) write(ct contact) error {
+	return cw.w.Write([]string{strconv.FormatInt(ct.ID, 10)})
+}
+
+func (cw *csvWriter) close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// exportContacts streams the contacts found by c to w in the given format,
+// returning how many were written
+func exportContacts(c contactExporter, w io.Writer, format string) (int, error) {
+	cw, err := newContactWriter(w, format)
+	if err != nil {
+		return 0, err
 	}
+	count := 0
+	err = c.findContacts(func(ct contact) error {
+		count++
+		return cw.write(ct)
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, cw.close()
 }
 
 const usageIntro = `
@@ -86,13 +266,28 @@ mailing is underway, ensuring we use the correct address if a user has updated
 their contact information between the time of export and the time of
 notification.
 
-The contact exporter's registration ID output will be JSON of the form:
+The contact exporter's registration ID output will by default be JSON of the
+form:
   [
    { "id": 1 },
    ...
    { "id": n }
   ]
 
+The -format parameter selects another output format: "ndjson" writes one
+{"id": n} object per line, and "csv" writes an "id" column with a header row.
+Results are written as they are read from the database, so large exports
+don't need to fit in memory.
+
+The export can be narrowed to the registrations affected by an incident:
+- -names takes a comma separated list of domains, and includes only
+  registrations with a certificate for one of the domains or a name under it.
+- -issuedAfter and -issuedBefore include only registrations with a
+  certificate issued in that window. Each takes a date (2006-01-02) or an
+  RFC 3339 timestamp.
+- -regIDs takes a comma separated list of registration IDs to consider.
+When several filters are given a certificate must match all of them.
+
 Examples:
   Export all registration IDs with unexpired certificates to "regs.json":
 
@@ -104,6 +299,13 @@ Examples:
   contact-exporter -config test/config/contact-exporter.json -grace 48h -outfile
     "regs.json"
 
+  Export the registration IDs with unexpired certificates for example.com or
+  its subdomains that were issued in September 2016, as CSV:
+
+  contact-exporter -config test/config/contact-exporter.json -names example.com
+    -issuedAfter 2016-09-01 -issuedBefore 2016-10-01 -format csv
+    -outfile "regs.csv"
+
 Required arguments:
 - config
 - outfile`
@@ -111,6 +313,11 @@ Required arguments:
 func main() {
 	outFile := flag.String("outfile", "", "File to write contacts to (defaults to stdout).")
 	grace := flag.Duration("grace", 2*24*time.Hour, "Include contacts with certificates that expired in < grace ago")
+	format := flag.String("format", formatJSON, "Output format: json, ndjson or csv")
+	names := flag.String("names", "", "Comma separated domains; only include contacts with certificates for these domains or their subdomains")
+	issuedAfter := flag.String("issuedAfter", "", "Only include contacts with certificates issued at or after this date or RFC 3339 time")
+	issuedBefore := flag.String("issuedBefore", "", "Only include contacts with certificates issued before this date or RFC 3339 time")
+	regIDs := flag.String("regIDs", "", "Comma separated registration IDs; only include these registrations")
 	type config struct {
 		ContactExporter struct {
 			cmd.DBConfig
@@ -150,10 +357,66 @@ func main() {
 		clk:   cmd.Clock(),
 		grace: *grace,
 	}
+	if *names != "" {
+		exporter.nameSuffixes = parseNames(*names)
+	}
+	exporter.issuedAfter, err = parseTime(*issuedAfter)
+	cmd.FailOnError(err, "Invalid -issuedAfter")
+	exporter.issuedBefore, err = parseTime(*issuedBefore)
+	cmd.FailOnError(err, "Invalid -issuedBefore")
+	exporter.regIDs, err = parseRegIDs(*regIDs)
+	cmd.FailOnError(err, "Invalid -regIDs")
 
-	contacts, err := exporter.findContacts()
-	cmd.FailOnError(err, "Could not find contacts")
-
-	err = writeContacts(contacts, *outFile)
+	f, err := os.Create(*outFile)
+	cmd.FailOnError(err, fmt.Sprintf("Could not create outfile %q", *outFile))
+	w := bufio.NewWriter(f)
+	count, err := exportContacts(exporter, w, *format)
+	cmd.FailOnError(err, fmt.Sprintf("Could not write contacts to outfile %q", *outFile))
+	err = w.Flush()
+	cmd.FailOnError(err, fmt.Sprintf("Could not write contacts to outfile %q", *outFile))
+	err = f.Close()
 	cmd.FailOnError(err, fmt.Sprintf("Could not write contacts to outfile %q", *outFile))
+	log.Info(fmt.Sprintf("Exported %d contacts to %q", count, *outFile))
+}
+
+// parseNames splits a comma separated list of domains, normalizing each to
+// a lowercase name without a wildcard label
+func parseNames(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		name = strings.TrimPrefix(strings.TrimPrefix(name, "*"), ".")
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// parseTime parses a date or an RFC 3339 timestamp. An empty string is the
+// zero time.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseRegIDs parses a comma separated list of registration IDs
+func parseRegIDs(list string) ([]int64, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var ids []int64
+	for _, field := range strings.Split(list, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid registration ID %q", field)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
 }
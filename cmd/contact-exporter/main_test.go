@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -8,14 +9,14 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"math/big"
 	"net"
-	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"golang.org/x/net/context"
+	"gopkg.in/gorp.v1"
 
 	"github.com/jmhodges/clock"
 	"github.com/letsencrypt/boulder/core"
@@ -40,6 +41,16 @@ const (
 	telNum    = "666-666-7777"
 )
 
+// collectContacts runs findContacts and returns everything it finds
+func collectContacts(c contactExporter) ([]contact, error) {
+	var contacts []contact
+	err := c.findContacts(func(ct contact) error {
+		contacts = append(contacts, ct)
+		return nil
+	})
+	return contacts, err
+}
+
 func TestFindContacts(t *testing.T) {
 	testCtx := setup(t)
 	defer testCtx.cleanUp()
@@ -49,7 +60,7 @@ func TestFindContacts(t *testing.T) {
 
 	// Run findContacts - since no certificates have been added corresponding to
 	// the above registrations, no contacts should be found.
-	contacts, err := testCtx.c.findContacts()
+	contacts, err := collectContacts(testCtx.c)
 	test.AssertNotError(t, err, "findContacts() produced error")
 	test.AssertEquals(t, len(contacts), 0)
 
@@ -61,7 +72,7 @@ func TestFindContacts(t *testing.T) {
 	// *not* be present since their certificate has already expired. Unlike
 	// previous versions of this test RegD is not filtered out for having a `tel:`
 	// contact field anymore - this is the duty of the notify-mailer.
-	contacts, err = testCtx.c.findContacts()
+	contacts, err = collectContacts(testCtx.c)
 	test.AssertNotError(t, err, "findContacts() produced error")
 	test.AssertEquals(t, len(contacts), 3)
 	test.AssertEquals(t, contacts[0].ID, regA.ID)
@@ -70,7 +81,7 @@ func TestFindContacts(t *testing.T) {
 
 	// Allow a 1 year grace period
 	testCtx.c.grace = 360 * 24 * time.Hour
-	contacts, err = testCtx.c.findContacts()
+	contacts, err = collectContacts(testCtx.c)
 	test.AssertNotError(t, err, "findContacts() produced error")
 	// Now all four registration should be returned, including RegB since its
 	// certificate expired within the grace period
@@ -81,44 +92,142 @@ func TestFindContacts(t *testing.T) {
 	test.AssertEquals(t, contacts[3].ID, regD.ID)
 }
 
-func exampleContacts() []contact {
-	return []contact{
-		contact{
-			ID: 1,
-		},
-		contact{
-			ID: 2,
-		},
-		contact{
-			ID: 3,
-		},
+func TestFindContactsFilters(t *testing.T) {
+	testCtx := setup(t)
+	defer testCtx.cleanUp()
+	testCtx.addRegistrations(t)
+	testCtx.addCertificates(t)
+
+	// Fetching one registration at a time finds the same contacts
+	testCtx.c.batchSize = 1
+	contacts, err := collectContacts(testCtx.c)
+	test.AssertNotError(t, err, "findContacts() produced error")
+	test.AssertDeepEquals(t, contacts, []contact{{regA.ID}, {regC.ID}, {regD.ID}})
+
+	// Names match themselves and their subdomains, but not other names that
+	// share a suffix
+	testCtx.c.nameSuffixes = []string{"example-a.com", "example-c.com"}
+	contacts, err = collectContacts(testCtx.c)
+	test.AssertNotError(t, err, "findContacts() produced error")
+	test.AssertDeepEquals(t, contacts, []contact{{regA.ID}, {regC.ID}})
+	testCtx.c.nameSuffixes = []string{"c.com"}
+	contacts, err = collectContacts(testCtx.c)
+	test.AssertNotError(t, err, "findContacts() produced error")
+	test.AssertEquals(t, len(contacts), 0)
+	testCtx.c.nameSuffixes = nil
+
+	// Certificates A, C and D were issued one, ten and twenty days ago
+	fc := newFakeClock(t)
+	testCtx.c.issuedAfter = fc.Now().Add(-15 * 24 * time.Hour)
+	contacts, err = collectContacts(testCtx.c)
+	test.AssertNotError(t, err, "findContacts() produced error")
+	test.AssertDeepEquals(t, contacts, []contact{{regA.ID}, {regC.ID}})
+	testCtx.c.issuedAfter = time.Time{}
+	testCtx.c.issuedBefore = fc.Now().Add(-15 * 24 * time.Hour)
+	contacts, err = collectContacts(testCtx.c)
+	test.AssertNotError(t, err, "findContacts() produced error")
+	test.AssertDeepEquals(t, contacts, []contact{{regD.ID}})
+	testCtx.c.issuedBefore = time.Time{}
+
+	testCtx.c.regIDs = []int64{regB.ID, regD.ID}
+	contacts, err = collectContacts(testCtx.c)
+	test.AssertNotError(t, err, "findContacts() produced error")
+	test.AssertDeepEquals(t, contacts, []contact{{regD.ID}})
+}
+
+// fakeSelector serves pages of registration IDs to findContacts
+type fakeSelector struct {
+	ids     []int64
+	queries int
+}
+
+func (fs *fakeSelector) Select(i interface{}, query string, args ...interface{}) ([]interface{}, error) {
+	fs.queries++
+	params := args[0].(map[string]interface{})
+	after := params["after"].(int64)
+	limit := params["limit"].(int)
+	output := i.(*[]contact)
+	for _, id := range fs.ids {
+		if id > after && len(*output) < limit {
+			*output = append(*output, contact{ID: id})
+		}
 	}
+	return nil, nil
 }
 
-func TestWriteOutput(t *testing.T) {
-	expected := `[{"id":1},{"id":2},{"id":3}]`
+func TestExportContacts(t *testing.T) {
+	db := &fakeSelector{ids: []int64{1, 2, 3}}
+	exporter := contactExporter{
+		log:       blog.UseMock(),
+		dbMap:     db,
+		clk:       newFakeClock(t),
+		batchSize: 2,
+	}
 
-	contacts := exampleContacts()
-	dir := os.TempDir()
-	f, err := ioutil.TempFile(dir, "contacts_test")
-	test.AssertNotError(t, err, "ioutil.TempFile produced an error")
+	for format, expected := range map[string]string{
+		formatJSON:   `[{"id":1},{"id":2},{"id":3}]` + "\n",
+		formatNDJSON: "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n",
+		formatCSV:    "id\n1\n2\n3\n",
+	} {
+		db.queries = 0
+		var out bytes.Buffer
+		count, err := exportContacts(exporter, &out, format)
+		test.AssertNotError(t, err, fmt.Sprintf("exportContacts(%s) produced error", format))
+		test.AssertEquals(t, count, 3)
+		test.AssertEquals(t, out.String(), expected)
+		// One full batch, then a partial one that ends the export
+		test.AssertEquals(t, db.queries, 2)
+	}
 
-	// Writing the contacts with no outFile should print to stdout
-	err = writeContacts(contacts, "")
-	test.AssertNotError(t, err, "writeContacts with no outfile produced error")
+	// An empty export is still valid JSON
+	var out bytes.Buffer
+	_, err := exportContacts(contactExporter{dbMap: &fakeSelector{}, clk: newFakeClock(t)}, &out, formatJSON)
+	test.AssertNotError(t, err, "exportContacts produced error")
+	test.AssertEquals(t, out.String(), "[]\n")
 
-	// Writing the contacts to an outFile should produce the correct results
-	err = writeContacts(contacts, f.Name())
-	test.AssertNotError(t, err, fmt.Sprintf("writeContacts produced an error writing to %s", f.Name()))
+	_, err = exportContacts(exporter, &out, "xml")
+	test.AssertError(t, err, "exportContacts accepted an unknown format")
+}
 
-	contents, err := ioutil.ReadFile(f.Name())
-	test.AssertNotError(t, err, fmt.Sprintf("ioutil.ReadFile produced an error reading from %s", f.Name()))
+func TestQueryFilters(t *testing.T) {
+	exporter := contactExporter{
+		clk:          newFakeClock(t),
+		nameSuffixes: []string{"example.com", "under_score.net"},
+		issuedAfter:  time.Date(2016, 9, 1, 0, 0, 0, 0, time.UTC),
+		regIDs:       []int64{7, 9},
+	}
+	query, params := exporter.query()
+	test.Assert(t, strings.Contains(query, "issued >= :issuedAfter"), "query lacks issuedAfter condition")
+	test.Assert(t, !strings.Contains(query, "issuedBefore"), "query has unexpected issuedBefore condition")
+	test.Assert(t, strings.Contains(query, "id IN (:reg0, :reg1)"), "query lacks regIDs condition")
+	test.AssertEquals(t, params["name0"], "com.example")
+	test.AssertEquals(t, params["nameLike0"], "com.example.%")
+	test.AssertEquals(t, params["nameLike1"], `net.under\_score.%`)
+	test.AssertEquals(t, params["reg1"], int64(9))
+}
 
-	test.AssertEquals(t, string(contents), expected+"\n")
+func TestParseFlags(t *testing.T) {
+	test.AssertDeepEquals(t, parseNames(" Example.com,*.example.net,,"), []string{"example.com", "example.net"})
+
+	ids, err := parseRegIDs("1, 2,3")
+	test.AssertNotError(t, err, "parseRegIDs failed")
+	test.AssertDeepEquals(t, ids, []int64{1, 2, 3})
+	_, err = parseRegIDs("1,two")
+	test.AssertError(t, err, "parseRegIDs accepted an invalid ID")
+
+	when, err := parseTime("2016-09-01")
+	test.AssertNotError(t, err, "parseTime failed")
+	test.AssertEquals(t, when, time.Date(2016, 9, 1, 0, 0, 0, 0, time.UTC))
+	when, err = parseTime("2016-09-01T12:00:00Z")
+	test.AssertNotError(t, err, "parseTime failed")
+	test.AssertEquals(t, when, time.Date(2016, 9, 1, 12, 0, 0, 0, time.UTC))
+	_, err = parseTime("September")
+	test.AssertError(t, err, "parseTime accepted an invalid time")
 }
 
 type testCtx struct {
 	c       contactExporter
+	dbMap   *gorp.DbMap
 	ssa     core.StorageAdder
 	cleanUp func()
 }
@@ -256,9 +365,10 @@ func (ctx testCtx) addCertificates(t *testing.T) {
 		RegistrationID: regA.ID,
 		Serial:         serial1String,
 		Expires:        rawCertA.NotAfter,
+		Issued:         fc.Now().Add(-1 * 24 * time.Hour),
 		DER:            certDerA,
 	}
-	err := ctx.c.dbMap.Insert(certA)
+	err := ctx.dbMap.Insert(certA)
 	test.AssertNotError(t, err, "Couldn't add certA")
 
 	// Add one cert for RegB that already expired 30 days ago
@@ -275,9 +385,10 @@ func (ctx testCtx) addCertificates(t *testing.T) {
 		RegistrationID: regB.ID,
 		Serial:         serial2String,
 		Expires:        rawCertB.NotAfter,
+		Issued:         fc.Now().Add(-60 * 24 * time.Hour),
 		DER:            certDerB,
 	}
-	err = ctx.c.dbMap.Insert(certB)
+	err = ctx.dbMap.Insert(certB)
 	test.AssertNotError(t, err, "Couldn't add certB")
 
 	// Add one cert for RegC that expires in 30 days
@@ -294,9 +405,10 @@ func (ctx testCtx) addCertificates(t *testing.T) {
 		RegistrationID: regC.ID,
 		Serial:         serial3String,
 		Expires:        rawCertC.NotAfter,
+		Issued:         fc.Now().Add(-10 * 24 * time.Hour),
 		DER:            certDerC,
 	}
-	err = ctx.c.dbMap.Insert(certC)
+	err = ctx.dbMap.Insert(certC)
 	test.AssertNotError(t, err, "Couldn't add certC")
 
 	// Add one cert for RegD that expires in 30 days
@@ -313,10 +425,22 @@ func (ctx testCtx) addCertificates(t *testing.T) {
 		RegistrationID: regD.ID,
 		Serial:         serial4String,
 		Expires:        rawCertD.NotAfter,
+		Issued:         fc.Now().Add(-20 * 24 * time.Hour),
 		DER:            certDerD,
 	}
-	err = ctx.c.dbMap.Insert(certD)
+	err = ctx.dbMap.Insert(certD)
 	test.AssertNotError(t, err, "Couldn't add certD")
+
+	for _, cert := range []*core.Certificate{certA, certB, certC, certD} {
+		parsed, err := x509.ParseCertificate(cert.DER)
+		test.AssertNotError(t, err, "Couldn't parse certificate")
+		for _, name := range parsed.DNSNames {
+			_, err = ctx.dbMap.Exec(
+				"INSERT INTO issuedNames (reversedName, serial, notBefore) VALUES (?, ?, ?)",
+				core.ReverseName(name), cert.Serial, cert.Issued)
+			test.AssertNotError(t, err, "Couldn't add issued name")
+		}
+	}
 }
 
 func setup(t *testing.T) testCtx {
@@ -341,6 +465,7 @@ func setup(t *testing.T) testCtx {
 			log:   log,
 			clk:   fc,
 		},
+		dbMap:   dbMap,
 		ssa:     ssa,
 		cleanUp: cleanUp,
 	}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	htmltemplate "html/template"
 	"io/ioutil"
 	"math"
 	netmail "net/mail"
@@ -13,6 +14,7 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -23,11 +25,13 @@ import (
 
 	"github.com/letsencrypt/boulder/cmd"
 	"github.com/letsencrypt/boulder/core"
+	"github.com/letsencrypt/boulder/features"
 	blog "github.com/letsencrypt/boulder/log"
 	"github.com/letsencrypt/boulder/mail"
 	"github.com/letsencrypt/boulder/metrics"
 	"github.com/letsencrypt/boulder/rpc"
 	"github.com/letsencrypt/boulder/sa"
+	"github.com/letsencrypt/boulder/webhook"
 )
 
 const defaultNagCheckInterval = 24 * time.Hour
@@ -36,10 +40,29 @@ type emailContent struct {
 	ExpirationDate   string
 	DaysToExpiration int
 	DNSNames         string
+	// Certificates lists every certificate covered by the email, soonest to
+	// expire first, so that digest templates can describe each of them
+	Certificates []certContent
+	// UnsubscribeURL is the recipient's unsubscribe link, if unsubscribe
+	// links are configured
+	UnsubscribeURL string
+}
+
+type certContent struct {
+	Serial           string
+	ExpirationDate   string
+	DaysToExpiration int
+	DNSNames         []string
 }
 
 type regStore interface {
 	GetRegistration(context.Context, int64) (core.Registration, error)
+	EmailOptedOut(context.Context, string) (bool, error)
+	EmailUndeliverable(context.Context, string) (bool, error)
+}
+
+type webhookNotifier interface {
+	Notify(ctx context.Context, hooks []string, event webhook.Event)
 }
 
 type mailer struct {
@@ -49,13 +72,68 @@ type mailer struct {
 	rs            regStore
 	mailer        mail.Mailer
 	emailTemplate *template.Template
-	subject       string
-	nagTimes      []time.Duration
-	limit         int
-	clk           clock.Clock
+	// htmlTemplate is optional; when set, nags are sent as
+	// multipart/alternative messages with an HTML body as well
+	htmlTemplate *htmltemplate.Template
+	// digest makes each run send at most one email per registration, covering
+	// every expiring certificate regardless of which nag window it is in
+	digest bool
+	// unsubscribe is optional; when set, opted out addresses are skipped and
+	// each recipient gets their own message with an unsubscribe link
+	unsubscribe *mail.UnsubscribeSigner
+	subject     string
+	nagTimes    []time.Duration
+	limit       int
+	clk         clock.Clock
+	// parallelism is the number of registrations processed concurrently. It
+	// should match the number of connections in the mailer's pool.
+	parallelism int
+	// webhooks is optional; when set, the https: contacts of registrations
+	// are sent a certificate.expiring event once the nag emails are sent
+	webhooks webhookNotifier
+	// webhooksPending tracks webhook deliveries still running in the
+	// background
+	webhooksPending sync.WaitGroup
+}
+
+// notifyWebhooks sends a certificate.expiring event for certs to the webhook
+// contacts among contacts. Delivery runs in the background, so that a slow
+// webhook doesn't hold up the emails of other registrations; see
+// waitForWebhooks.
+func (m *mailer) notifyWebhooks(regID int64, contacts []string, certs []*x509.Certificate) {
+	if m.webhooks == nil || !features.Enabled(features.WebhookContacts) {
+		return
+	}
+	hooks := webhook.Contacts(contacts)
+	if len(hooks) == 0 {
+		return
+	}
+	event := webhook.Event{
+		Type:         webhook.CertificateExpiring,
+		Registration: regID,
+		Created:      m.clk.Now(),
+	}
+	for _, cert := range certs {
+		event.Certificates = append(event.Certificates, webhook.Certificate{
+			Serial:   core.SerialToString(cert.SerialNumber),
+			Expires:  cert.NotAfter,
+			DNSNames: cert.DNSNames,
+		})
+	}
+	m.webhooksPending.Add(1)
+	go func() {
+		defer m.webhooksPending.Done()
+		m.webhooks.Notify(context.Background(), hooks, event)
+	}()
 }
 
-func (m *mailer) sendNags(contacts []string, certs []*x509.Certificate) error {
+// waitForWebhooks waits for the webhook deliveries started by notifyWebhooks
+// to finish
+func (m *mailer) waitForWebhooks() {
+	m.webhooksPending.Wait()
+}
+
+func (m *mailer) sendNags(regID int64, contacts []string, certs []*x509.Certificate) error {
 	if len(contacts) == 0 {
 		return nil
 	}
@@ -70,11 +148,38 @@ func (m *mailer) sendNags(contacts []string, certs []*x509.Certificate) error {
 				contact, err))
 			continue
 		}
-		if parsed.Scheme == "mailto" {
-			emails = append(emails, parsed.Opaque)
+		if parsed.Scheme != "mailto" {
+			continue
+		}
+		if features.Enabled(features.UndeliverableEmails) {
+			undeliverable, err := m.rs.EmailUndeliverable(context.Background(), parsed.Opaque)
+			if err != nil {
+				m.stats.Inc("Errors.EmailUndeliverable", 1)
+				return err
+			}
+			if undeliverable {
+				m.stats.Inc("Undeliverable", 1)
+				continue
+			}
+		}
+		// Opt-outs are honoured even without an unsubscribe signer, since
+		// they may have been recorded while one was configured
+		optedOut, err := m.rs.EmailOptedOut(context.Background(), parsed.Opaque)
+		if err != nil {
+			m.stats.Inc("Errors.EmailOptedOut", 1)
+			return err
 		}
+		if optedOut {
+			m.stats.Inc("OptedOut", 1)
+			continue
+		}
+		emails = append(emails, parsed.Opaque)
 	}
+	// Webhooks are only notified once the nag emails have been sent. If
+	// sending fails the certificates' status isn't updated and they are
+	// retried on the next run, which notifies the webhooks then.
 	if len(emails) == 0 {
+		m.notifyWebhooks(regID, contacts, certs)
 		return nil
 	}
 
@@ -82,16 +187,30 @@ func (m *mailer) sendNags(contacts []string, certs []*x509.Certificate) error {
 	expDate := m.clk.Now()
 	domains := []string{}
 	serials := []string{}
+	certContents := []certContent{}
+
+	sorted := make(certsByExpiry, len(certs))
+	copy(sorted, certs)
+	sort.Stable(sorted)
 
 	// Pick out the expiration date that is closest to being hit.
-	for _, cert := range certs {
+	for _, cert := range sorted {
 		domains = append(domains, cert.DNSNames...)
-		serials = append(serials, core.SerialToString(cert.SerialNumber))
+		serial := core.SerialToString(cert.SerialNumber)
+		serials = append(serials, serial)
 		possible := cert.NotAfter.Sub(m.clk.Now())
 		if possible < expiresIn {
 			expiresIn = possible
 			expDate = cert.NotAfter
 		}
+		certDomains := core.UniqueLowerNames(cert.DNSNames)
+		sort.Strings(certDomains)
+		certContents = append(certContents, certContent{
+			Serial:           serial,
+			ExpirationDate:   cert.NotAfter.UTC().Format(time.RFC822Z),
+			DaysToExpiration: int(possible.Hours() / 24),
+			DNSNames:         certDomains,
+		})
 	}
 	domains = core.UniqueLowerNames(domains)
 	sort.Strings(domains)
@@ -102,24 +221,76 @@ func (m *mailer) sendNags(contacts []string, certs []*x509.Certificate) error {
 		ExpirationDate:   expDate.UTC().Format(time.RFC822Z),
 		DaysToExpiration: int(expiresIn.Hours() / 24),
 		DNSNames:         strings.Join(domains, "\n"),
-	}
-	msgBuf := new(bytes.Buffer)
-	err := m.emailTemplate.Execute(msgBuf, email)
-	if err != nil {
-		m.stats.Inc("Errors.SendingNag.TemplateFailure", 1)
-		return err
+		Certificates:     certContents,
 	}
 	startSending := m.clk.Now()
-	err = m.mailer.SendMail(emails, m.subject, msgBuf.String())
-	if err != nil {
-		return err
+	if m.unsubscribe == nil {
+		text, html, err := m.renderNag(email)
+		if err != nil {
+			return err
+		}
+		if m.htmlTemplate != nil {
+			err = m.mailer.SendMultipartMail(emails, m.subject, text, html)
+		} else {
+			err = m.mailer.SendMail(emails, m.subject, text)
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		// Each address gets its own message, with its own unsubscribe link.
+		// Once any of them has been sent the nag counts as sent, so that a
+		// failure for one address doesn't have the others mailed again on
+		// the next run.
+		var sent int
+		var sendErr error
+		for _, address := range emails {
+			email.UnsubscribeURL = m.unsubscribe.URL(regID, address)
+			text, html, err := m.renderNag(email)
+			if err != nil {
+				return err
+			}
+			err = m.mailer.SendUnsubscribableMail(address, m.subject, text, html, email.UnsubscribeURL)
+			if err != nil {
+				m.stats.Inc("Errors.SendingNag.SendFailure", 1)
+				m.log.AuditErr(fmt.Sprintf("Error sending nag to %s for registration %d: %s", address, regID, err))
+				sendErr = err
+				continue
+			}
+			sent++
+		}
+		if sent == 0 {
+			return sendErr
+		}
 	}
 	finishSending := m.clk.Now()
 	elapsed := finishSending.Sub(startSending)
 	m.stats.TimingDuration("SendLatency", elapsed)
+	m.notifyWebhooks(regID, contacts, certs)
 	return nil
 }
 
+// renderNag executes the email templates. html is empty if there is no HTML
+// template.
+func (m *mailer) renderNag(email emailContent) (text, html string, err error) {
+	msgBuf := new(bytes.Buffer)
+	err = m.emailTemplate.Execute(msgBuf, email)
+	if err != nil {
+		m.stats.Inc("Errors.SendingNag.TemplateFailure", 1)
+		return "", "", err
+	}
+	if m.htmlTemplate == nil {
+		return msgBuf.String(), "", nil
+	}
+	htmlBuf := new(bytes.Buffer)
+	err = m.htmlTemplate.Execute(htmlBuf, email)
+	if err != nil {
+		m.stats.Inc("Errors.SendingNag.TemplateFailure", 1)
+		return "", "", err
+	}
+	return msgBuf.String(), htmlBuf.String(), nil
+}
+
 func (m *mailer) updateCertStatus(serial string) error {
 	_, err := m.dbMap.Exec(
 		"UPDATE certificateStatus SET lastExpirationNagSent = ?  WHERE serial = ?",
@@ -155,69 +326,95 @@ func (m *mailer) processCerts(allCerts []core.Certificate) {
 		regIDToCerts[cert.RegistrationID] = cs
 	}
 
-	for regID, certs := range regIDToCerts {
-		reg, err := m.rs.GetRegistration(ctx, regID)
-		if err != nil {
-			m.log.AuditErr(fmt.Sprintf("Error fetching registration %d: %s", regID, err))
-			m.stats.Inc("Errors.GetRegistration", 1)
-			continue
-		}
-
-		parsedCerts := []*x509.Certificate{}
-		for _, cert := range certs {
-			parsedCert, err := x509.ParseCertificate(cert.DER)
-			if err != nil {
-				// TODO(#1420): tell registration about this error
-				m.log.AuditErr(fmt.Sprintf("Error parsing certificate %s: %s", cert.Serial, err))
-				m.stats.Inc("Errors.ParseCertificate", 1)
-				continue
-			}
-
-			renewed, err := m.certIsRenewed(cert.Serial)
-			if err != nil {
-				m.log.AuditErr(fmt.Sprintf("expiration-mailer: error fetching renewal state: %v", err))
-				// assume not renewed
-			} else if renewed {
-				m.stats.Inc("Renewed", 1)
-				if err := m.updateCertStatus(cert.Serial); err != nil {
-					m.log.AuditErr(fmt.Sprintf("Error updating certificate status for %s: %s", cert.Serial, err))
-					m.stats.Inc("Errors.UpdateCertificateStatus", 1)
-				}
-				continue
+	parallelism := m.parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	work := make(chan int64)
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for regID := range work {
+				m.processRegistration(ctx, regID, regIDToCerts[regID])
 			}
+		}()
+	}
+	for regID := range regIDToCerts {
+		work <- regID
+	}
+	close(work)
+	wg.Wait()
+	return
+}
 
-			parsedCerts = append(parsedCerts, parsedCert)
-		}
+// processRegistration sends nags for the expiring certificates of a single
+// registration and records that they were sent
+func (m *mailer) processRegistration(ctx context.Context, regID int64, certs []core.Certificate) {
+	reg, err := m.rs.GetRegistration(ctx, regID)
+	if err != nil {
+		m.log.AuditErr(fmt.Sprintf("Error fetching registration %d: %s", regID, err))
+		m.stats.Inc("Errors.GetRegistration", 1)
+		return
+	}
 
-		if len(parsedCerts) == 0 {
-			// all certificates are renewed
+	parsedCerts := []*x509.Certificate{}
+	for _, cert := range certs {
+		parsedCert, err := x509.ParseCertificate(cert.DER)
+		if err != nil {
+			// TODO(#1420): tell registration about this error
+			m.log.AuditErr(fmt.Sprintf("Error parsing certificate %s: %s", cert.Serial, err))
+			m.stats.Inc("Errors.ParseCertificate", 1)
 			continue
 		}
 
-		if reg.Contact == nil {
+		renewed, err := m.certIsRenewed(cert.Serial)
+		if err != nil {
+			m.log.AuditErr(fmt.Sprintf("expiration-mailer: error fetching renewal state: %v", err))
+			// assume not renewed
+		} else if renewed {
+			m.stats.Inc("Renewed", 1)
+			if err := m.updateCertStatus(cert.Serial); err != nil {
+				m.log.AuditErr(fmt.Sprintf("Error updating certificate status for %s: %s", cert.Serial, err))
+				m.stats.Inc("Errors.UpdateCertificateStatus", 1)
+			}
 			continue
 		}
 
-		err = m.sendNags(*reg.Contact, parsedCerts)
+		parsedCerts = append(parsedCerts, parsedCert)
+	}
+
+	if len(parsedCerts) == 0 {
+		// all certificates are renewed
+		return
+	}
+
+	if reg.Contact == nil {
+		return
+	}
+
+	err = m.sendNags(reg.ID, *reg.Contact, parsedCerts)
+	if err != nil {
+		m.log.AuditErr(fmt.Sprintf("Error sending nag emails: %s", err))
+		return
+	}
+	for _, cert := range parsedCerts {
+		serial := core.SerialToString(cert.SerialNumber)
+		err = m.updateCertStatus(serial)
 		if err != nil {
-			m.log.AuditErr(fmt.Sprintf("Error sending nag emails: %s", err))
+			m.log.AuditErr(fmt.Sprintf("Error updating certificate status for %s: %s", serial, err))
+			m.stats.Inc("Errors.UpdateCertificateStatus", 1)
 			continue
 		}
-		for _, cert := range parsedCerts {
-			serial := core.SerialToString(cert.SerialNumber)
-			err = m.updateCertStatus(serial)
-			if err != nil {
-				m.log.AuditErr(fmt.Sprintf("Error updating certificate status for %s: %s", serial, err))
-				m.stats.Inc("Errors.UpdateCertificateStatus", 1)
-				continue
-			}
-		}
 	}
-	return
 }
 
 func (m *mailer) findExpiringCertificates() error {
 	now := m.clk.Now()
+	// In digest mode certificates from every nag window are collected and
+	// processed together, so that each registration gets a single email
+	var digestCerts []core.Certificate
 	// E.g. m.nagTimes = [2, 4, 8, 15] days from expiration
 	for i, expiresIn := range m.nagTimes {
 		left := now
@@ -274,16 +471,42 @@ func (m *mailer) findExpiringCertificates() error {
 			m.stats.Inc(statName, 1)
 		}
 
-		processingStarted := m.clk.Now()
-		m.processCerts(certs)
-		processingEnded := m.clk.Now()
-		elapsed := processingEnded.Sub(processingStarted)
-		m.stats.TimingDuration("ProcessingCertificatesLatency", elapsed)
+		if m.digest {
+			digestCerts = append(digestCerts, certs...)
+			continue
+		}
+		m.timedProcessCerts(certs)
+	}
+
+	if len(digestCerts) > 0 {
+		m.timedProcessCerts(digestCerts)
 	}
 
 	return nil
 }
 
+func (m *mailer) timedProcessCerts(certs []core.Certificate) {
+	processingStarted := m.clk.Now()
+	m.processCerts(certs)
+	processingEnded := m.clk.Now()
+	elapsed := processingEnded.Sub(processingStarted)
+	m.stats.TimingDuration("ProcessingCertificatesLatency", elapsed)
+}
+
+type certsByExpiry []*x509.Certificate
+
+func (cs certsByExpiry) Len() int {
+	return len(cs)
+}
+
+func (cs certsByExpiry) Less(a, b int) bool {
+	return cs[a].NotAfter.Before(cs[b].NotAfter)
+}
+
+func (cs certsByExpiry) Swap(a, b int) {
+	cs[a], cs[b] = cs[b], cs[a]
+}
+
 type durationSlice []time.Duration
 
 func (ds durationSlice) Len() int {
@@ -317,6 +540,21 @@ type config struct {
 		NagCheckInterval string
 		// Path to a text/template email template
 		EmailTemplate string
+		// Optional path to an html/template email template. When set, nags
+		// are sent as multipart/alternative messages with both bodies.
+		HTMLEmailTemplate string
+		// When Digest is true each run sends a single email per registration
+		// covering all of its expiring certificates, instead of one email per
+		// registration per nag window.
+		Digest bool
+		// Unsubscribe, if its key file is set, adds unsubscribe links to nags
+		// and skips addresses that have unsubscribed
+		Unsubscribe cmd.UnsubscribeConfig
+		// Webhooks, if its key file is set, sends certificate.expiring events
+		// to the https: contacts of registrations
+		Webhooks cmd.WebhookConfig
+
+		Features map[string]bool
 	}
 
 	Statsd cmd.StatsdConfig
@@ -341,6 +579,9 @@ func main() {
 	err := cmd.ReadConfigFile(*configFile, &c)
 	cmd.FailOnError(err, "Reading JSON config file into config structure")
 
+	err = features.Set(c.Mailer.Features)
+	cmd.FailOnError(err, "Failed to set feature flags")
+
 	go cmd.DebugServer(c.Mailer.DebugAddr)
 
 	stats, logger := cmd.StatsAndLogging(c.Statsd, c.Syslog)
@@ -373,17 +614,33 @@ func main() {
 	cmd.FailOnError(err, fmt.Sprintf("Could not read email template file [%s]", c.Mailer.EmailTemplate))
 	tmpl, err := template.New("expiry-email").Parse(string(emailTmpl))
 	cmd.FailOnError(err, "Could not parse email template")
+	var htmlTmpl *htmltemplate.Template
+	if c.Mailer.HTMLEmailTemplate != "" {
+		htmlEmailTmpl, err := ioutil.ReadFile(c.Mailer.HTMLEmailTemplate)
+		cmd.FailOnError(err, fmt.Sprintf("Could not read HTML email template file [%s]", c.Mailer.HTMLEmailTemplate))
+		htmlTmpl, err = htmltemplate.New("expiry-email-html").Parse(string(htmlEmailTmpl))
+		cmd.FailOnError(err, "Could not parse HTML email template")
+	}
+
+	unsubscribe, err := c.Mailer.Unsubscribe.Signer()
+	cmd.FailOnError(err, "Couldn't load unsubscribe key")
 
 	fromAddress, err := netmail.ParseAddress(c.Mailer.From)
 	cmd.FailOnError(err, fmt.Sprintf("Could not parse from address: %s", c.Mailer.From))
 
 	smtpPassword, err := c.Mailer.PasswordConfig.Pass()
 	cmd.FailOnError(err, "Failed to load SMTP password")
-	mailClient := mail.New(
+	tlsMode, tlsConfig, err := c.Mailer.SMTPConfig.TLSConfig()
+	cmd.FailOnError(err, "Failed to load SMTP TLS config")
+	mailClient := mail.NewPooled(
+		c.Mailer.Connections,
+		c.Mailer.SendsPerSecond,
 		c.Mailer.Server,
 		c.Mailer.Port,
 		c.Mailer.Username,
 		smtpPassword,
+		tlsMode,
+		tlsConfig,
 		*fromAddress,
 		logger,
 		scope,
@@ -428,11 +685,24 @@ func main() {
 		rs:            sac,
 		mailer:        mailClient,
 		emailTemplate: tmpl,
+		htmlTemplate:  htmlTmpl,
+		digest:        c.Mailer.Digest,
+		unsubscribe:   unsubscribe,
 		nagTimes:      nags,
 		limit:         c.Mailer.CertLimit,
 		clk:           cmd.Clock(),
+		parallelism:   c.Mailer.Connections,
+	}
+	if wc := c.Mailer.Webhooks; wc.KeyFile != "" {
+		alg, key, err := webhook.LoadKey(wc.KeyFile)
+		cmd.FailOnError(err, "Couldn't load webhook key")
+		notifier, err := webhook.New(alg, key, sac, wc.Timeout.Duration, wc.MaxAttempts,
+			wc.RetryBase.Duration, wc.RetryMax.Duration, logger, scope)
+		cmd.FailOnError(err, "Couldn't create webhook notifier")
+		m.webhooks = notifier
 	}
 
 	err = m.findExpiringCertificates()
+	m.waitForWebhooks()
 	cmd.FailOnError(err, "expiration-mailer has failed")
 }
@@ -44,7 +44,9 @@ func intFromB64(b64 string) int {
 }
 
 type fakeRegStore struct {
-	RegByID map[int64]core.Registration
+	RegByID       map[int64]core.Registration
+	OptedOut      map[string]bool
+	Undeliverable map[string]bool
 }
 
 func (f fakeRegStore) GetRegistration(ctx context.Context, id int64) (core.Registration, error) {
@@ -56,8 +58,20 @@ func (f fakeRegStore) GetRegistration(ctx context.Context, id int64) (core.Regis
 	return r, nil
 }
 
+func (f fakeRegStore) EmailOptedOut(ctx context.Context, email string) (bool, error) {
+	return f.OptedOut[email], nil
+}
+
+func (f fakeRegStore) EmailUndeliverable(ctx context.Context, email string) (bool, error) {
+	return f.Undeliverable[email], nil
+}
+
 func newFakeRegStore() fakeRegStore {
-	return fakeRegStore{RegByID: make(map[int64]core.Registration)}
+	return fakeRegStore{
+		RegByID:       make(map[int64]core.Registration),
+		OptedOut:      make(map[string]bool),
+		Undeliverable: make(map[string]bool),
+	}
 }
 
 func newFakeClock(t *testing.T) clock.FakeClock {
@@ -123,7 +137,7 @@ func TestSendNags(t *testing.T) {
 		DNSNames: []string{"example.com"},
 	}
 
-	err := m.sendNags([]string{emailA}, []*x509.Certificate{cert})
+	err := m.sendNags(1, []string{emailA}, []*x509.Certificate{cert})
 	test.AssertNotError(t, err, "Failed to send warning messages")
 	test.AssertEquals(t, len(mc.Messages), 1)
 	test.AssertEquals(t, mocks.MailerMessage{
@@ -133,7 +147,7 @@ func TestSendNags(t *testing.T) {
 	}, mc.Messages[0])
 
 	mc.Clear()
-	err = m.sendNags([]string{emailA, emailB}, []*x509.Certificate{cert})
+	err = m.sendNags(1, []string{emailA, emailB}, []*x509.Certificate{cert})
 	test.AssertNotError(t, err, "Failed to send warning messages")
 	test.AssertEquals(t, len(mc.Messages), 2)
 	test.AssertEquals(t, mocks.MailerMessage{
@@ -148,7 +162,7 @@ func TestSendNags(t *testing.T) {
 	}, mc.Messages[1])
 
 	mc.Clear()
-	err = m.sendNags([]string{}, []*x509.Certificate{cert})
+	err = m.sendNags(1, []string{}, []*x509.Certificate{cert})
 	test.AssertNotError(t, err, "Not an error to pass no email contacts")
 	test.AssertEquals(t, len(mc.Messages), 0)
 
@@ -156,7 +170,7 @@ func TestSendNags(t *testing.T) {
 	test.AssertNotError(t, err, "Failed to parse templates")
 	for _, template := range templates.Templates() {
 		m.emailTemplate = template
-		err = m.sendNags(nil, []*x509.Certificate{cert})
+		err = m.sendNags(1, nil, []*x509.Certificate{cert})
 		test.AssertNotError(t, err, "failed to send nag")
 	}
 }
@@ -832,6 +846,82 @@ func TestDedupOnRegistration(t *testing.T) {
 	test.AssertEquals(t, expected, testCtx.mc.Messages[0])
 }
 
+func TestFindExpiringCertificatesDigest(t *testing.T) {
+	testCtx := setup(t, []time.Duration{time.Hour * 24, time.Hour * 24 * 4})
+
+	var keyA jose.JsonWebKey
+	err := json.Unmarshal(jsonKeyA, &keyA)
+	test.AssertNotError(t, err, "Failed to unmarshal public JWK")
+
+	regA := core.Registration{
+		ID: 1,
+		Contact: &[]string{
+			emailA,
+		},
+		Key:       keyA,
+		InitialIP: net.ParseIP("6.5.5.6"),
+	}
+	regA, err = testCtx.ssa.NewRegistration(ctx, regA)
+	if err != nil {
+		t.Fatalf("Couldn't store regA: %s", err)
+	}
+
+	// certA falls in the 1d nag window and certB in the 4d nag window, so
+	// without digesting regA would get two emails
+	rawCertA := newX509Cert("happy A",
+		testCtx.fc.Now().Add(36*time.Hour),
+		[]string{"example-a.com"},
+		serial1,
+	)
+	rawCertB := newX509Cert("happy B",
+		testCtx.fc.Now().Add(72*time.Hour),
+		[]string{"example-b.com"},
+		serial2,
+	)
+	setupDBMap, err := sa.NewDbMap(vars.DBConnSAFullPerms, 0)
+	test.AssertNotError(t, err, "Couldn't connect to the database")
+	for _, c := range []struct {
+		raw    *x509.Certificate
+		serial string
+	}{
+		{rawCertA, serial1String},
+		{rawCertB, serial2String},
+	} {
+		der, _ := x509.CreateCertificate(rand.Reader, c.raw, c.raw, &testKey.PublicKey, &testKey)
+		err = setupDBMap.Insert(&core.Certificate{
+			RegistrationID: regA.ID,
+			Serial:         c.serial,
+			Expires:        c.raw.NotAfter,
+			DER:            der,
+		})
+		test.AssertNotError(t, err, "Couldn't add cert")
+		err = setupDBMap.Insert(&core.CertificateStatus{
+			Serial:                c.serial,
+			LastExpirationNagSent: time.Unix(0, 0),
+			Status:                core.OCSPStatusGood,
+		})
+		test.AssertNotError(t, err, "Couldn't add cert status")
+	}
+
+	testCtx.m.digest = true
+	err = testCtx.m.findExpiringCertificates()
+	test.AssertNotError(t, err, "error calling findExpiringCertificates")
+	test.AssertEquals(t, len(testCtx.mc.Messages), 1)
+	test.AssertEquals(t, mocks.MailerMessage{
+		To:      emailARaw,
+		Subject: "",
+		Body: fmt.Sprintf("hi, cert for DNS names example-a.com\nexample-b.com is going to expire in 1 days (%s)",
+			rawCertA.NotAfter.Format(time.RFC822Z)),
+	}, testCtx.mc.Messages[0])
+
+	// Both certificates were nagged, so a consecutive run shouldn't send
+	// anything
+	testCtx.mc.Clear()
+	err = testCtx.m.findExpiringCertificates()
+	test.AssertNotError(t, err, "error calling findExpiringCertificates")
+	test.AssertEquals(t, len(testCtx.mc.Messages), 0)
+}
+
 type testCtx struct {
 	dbMap   *gorp.DbMap
 	ssa     core.StorageAdder
@@ -3,13 +3,24 @@ package main
 import (
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"errors"
 	"fmt"
+	htmltemplate "html/template"
 	"math/big"
+	"sync"
 	"testing"
+	"text/template"
 	"time"
 
+	"golang.org/x/net/context"
+
+	"github.com/letsencrypt/boulder/core"
+	"github.com/letsencrypt/boulder/features"
+	"github.com/letsencrypt/boulder/mail"
+	"github.com/letsencrypt/boulder/metrics"
 	"github.com/letsencrypt/boulder/mocks"
 	"github.com/letsencrypt/boulder/test"
+	"github.com/letsencrypt/boulder/webhook"
 )
 
 var (
@@ -33,7 +44,7 @@ func TestSendEarliestCertInfo(t *testing.T) {
 		serial2,
 	)
 
-	err := ctx.m.sendNags([]string{email1, email2}, []*x509.Certificate{rawCertA, rawCertB})
+	err := ctx.m.sendNags(1, []string{email1, email2}, []*x509.Certificate{rawCertA, rawCertB})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -56,6 +67,314 @@ func TestSendEarliestCertInfo(t *testing.T) {
 	test.AssertEquals(t, expected, ctx.mc.Messages[1])
 }
 
+func TestSendNagsHTML(t *testing.T) {
+	mc := mocks.Mailer{}
+	fc := newFakeClock(t)
+	m := mailer{
+		stats:         metrics.NewNoopScope(),
+		log:           log,
+		mailer:        &mc,
+		emailTemplate: tmpl,
+		htmlTemplate: htmltemplate.Must(htmltemplate.New("expiry-email-html").Parse(
+			`<ul>{{range .Certificates}}<li>{{.Serial}}: {{range .DNSNames}}{{.}} {{end}}in {{.DaysToExpiration}} days</li>{{end}}</ul>`)),
+		subject: testEmailSubject,
+		rs:      newFakeRegStore(),
+		clk:     fc,
+	}
+
+	rawCertA := newX509Cert("happy A",
+		fc.Now().AddDate(0, 0, 5),
+		[]string{"example-a.com", "<script>.example.com"},
+		serial1,
+	)
+	rawCertB := newX509Cert("happy B",
+		fc.Now().AddDate(0, 0, 2),
+		[]string{"example-b.com"},
+		serial2,
+	)
+
+	err := m.sendNags(1, []string{email1}, []*x509.Certificate{rawCertA, rawCertB})
+	test.AssertNotError(t, err, "Failed to send warning messages")
+	test.AssertEquals(t, len(mc.Messages), 1)
+	test.AssertEquals(t, mc.Messages[0].Body, fmt.Sprintf(
+		"hi, cert for DNS names <script>.example.com\nexample-a.com\nexample-b.com is going to expire in 2 days (%s)",
+		rawCertB.NotAfter.Format(time.RFC822Z)))
+	// Certificates are listed soonest to expire first, and the HTML template
+	// escapes names
+	test.AssertEquals(t, mc.Messages[0].HTML, fmt.Sprintf(
+		`<ul><li>%s: example-b.com in 2 days</li><li>%s: &lt;script&gt;.example.com example-a.com in 5 days</li></ul>`,
+		serial2String, serial1String))
+}
+
+func TestSendNagsUnsubscribe(t *testing.T) {
+	mc := mocks.Mailer{}
+	fc := newFakeClock(t)
+	rs := newFakeRegStore()
+	rs.OptedOut["two@example.com"] = true
+	unsubscribe, err := mail.NewUnsubscribeSigner([]byte("0123456789abcdef0123456789abcdef"), "https://example.com/unsubscribe")
+	test.AssertNotError(t, err, "Failed to create UnsubscribeSigner")
+	m := mailer{
+		stats:         metrics.NewNoopScope(),
+		log:           log,
+		mailer:        &mc,
+		emailTemplate: template.Must(template.New("expiry-email").Parse(`{{.DNSNames}}: {{.UnsubscribeURL}}`)),
+		subject:       testEmailSubject,
+		rs:            rs,
+		unsubscribe:   unsubscribe,
+		clk:           fc,
+	}
+
+	rawCert := newX509Cert("happy A",
+		fc.Now().AddDate(0, 0, 5),
+		[]string{"example-a.com"},
+		serial1,
+	)
+
+	err = m.sendNags(1337, []string{email1, email2}, []*x509.Certificate{rawCert})
+	test.AssertNotError(t, err, "Failed to send warning messages")
+	// two@example.com has opted out
+	test.AssertEquals(t, len(mc.Messages), 1)
+	unsubscribeURL := unsubscribe.URL(1337, "one@example.com")
+	test.AssertEquals(t, mocks.MailerMessage{
+		To:             "one@example.com",
+		Subject:        testEmailSubject,
+		Body:           "example-a.com: " + unsubscribeURL,
+		UnsubscribeURL: unsubscribeURL,
+	}, mc.Messages[0])
+}
+
+func TestSendNagsOptedOutWithoutSigner(t *testing.T) {
+	mc := mocks.Mailer{}
+	fc := newFakeClock(t)
+	rs := newFakeRegStore()
+	rs.OptedOut["two@example.com"] = true
+	m := mailer{
+		stats:         metrics.NewNoopScope(),
+		log:           log,
+		mailer:        &mc,
+		emailTemplate: tmpl,
+		subject:       testEmailSubject,
+		rs:            rs,
+		clk:           fc,
+	}
+
+	rawCert := newX509Cert("happy A",
+		fc.Now().AddDate(0, 0, 5),
+		[]string{"example-a.com"},
+		serial1,
+	)
+
+	// Opt-outs are honoured without an unsubscribe signer too
+	err := m.sendNags(1, []string{email1, email2}, []*x509.Certificate{rawCert})
+	test.AssertNotError(t, err, "Failed to send warning messages")
+	test.AssertEquals(t, len(mc.Messages), 1)
+	test.AssertEquals(t, mc.Messages[0].To, "one@example.com")
+}
+
+// flakyMailer fails to send mail to one address
+type flakyMailer struct {
+	mocks.Mailer
+	fail string
+}
+
+func (f *flakyMailer) SendUnsubscribableMail(to, subject, text, html, unsubscribeURL string) error {
+	if to == f.fail {
+		return errors.New("mailbox unavailable")
+	}
+	return f.Mailer.SendUnsubscribableMail(to, subject, text, html, unsubscribeURL)
+}
+
+func TestSendNagsPartialFailure(t *testing.T) {
+	fc := newFakeClock(t)
+	unsubscribe, err := mail.NewUnsubscribeSigner([]byte("0123456789abcdef0123456789abcdef"), "https://example.com/unsubscribe")
+	test.AssertNotError(t, err, "Failed to create UnsubscribeSigner")
+	mc := &flakyMailer{fail: "one@example.com"}
+	m := mailer{
+		stats:         metrics.NewNoopScope(),
+		log:           log,
+		mailer:        mc,
+		emailTemplate: tmpl,
+		subject:       testEmailSubject,
+		rs:            newFakeRegStore(),
+		unsubscribe:   unsubscribe,
+		clk:           fc,
+	}
+
+	rawCert := newX509Cert("happy A",
+		fc.Now().AddDate(0, 0, 5),
+		[]string{"example-a.com"},
+		serial1,
+	)
+
+	// Once one address has been mailed the nag counts as sent, so that the
+	// certificate isn't nagged about again to the same address
+	err = m.sendNags(1, []string{email1, email2}, []*x509.Certificate{rawCert})
+	test.AssertNotError(t, err, "Partial send was reported as a failure")
+	test.AssertEquals(t, len(mc.Messages), 1)
+	test.AssertEquals(t, mc.Messages[0].To, "two@example.com")
+
+	// If nothing could be sent the error is returned, to retry next time
+	mc.Clear()
+	err = m.sendNags(1, []string{email1}, []*x509.Certificate{rawCert})
+	test.AssertError(t, err, "Failed send was reported as a success")
+	test.AssertEquals(t, len(mc.Messages), 0)
+}
+
+func TestSendNagsUndeliverable(t *testing.T) {
+	_ = features.Set(map[string]bool{"UndeliverableEmails": true})
+	defer features.Reset()
+
+	mc := mocks.Mailer{}
+	fc := newFakeClock(t)
+	rs := newFakeRegStore()
+	rs.Undeliverable["one@example.com"] = true
+	m := mailer{
+		stats:         metrics.NewNoopScope(),
+		log:           log,
+		mailer:        &mc,
+		emailTemplate: tmpl,
+		subject:       testEmailSubject,
+		rs:            rs,
+		clk:           fc,
+	}
+
+	rawCert := newX509Cert("happy A",
+		fc.Now().AddDate(0, 0, 5),
+		[]string{"example-a.com"},
+		serial1,
+	)
+
+	err := m.sendNags(1, []string{email1, email2}, []*x509.Certificate{rawCert})
+	test.AssertNotError(t, err, "Failed to send warning messages")
+	// one@example.com has bounced
+	test.AssertEquals(t, len(mc.Messages), 1)
+	test.AssertEquals(t, mc.Messages[0].To, "two@example.com")
+
+	// Once every contact has bounced nothing is sent
+	mc.Clear()
+	rs.Undeliverable["two@example.com"] = true
+	err = m.sendNags(1, []string{email1, email2}, []*x509.Certificate{rawCert})
+	test.AssertNotError(t, err, "Failed to send warning messages")
+	test.AssertEquals(t, len(mc.Messages), 0)
+}
+
+type fakeWebhooks struct {
+	mu     sync.Mutex
+	hooks  []string
+	events []webhook.Event
+}
+
+func (f *fakeWebhooks) Notify(ctx context.Context, hooks []string, event webhook.Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hooks = hooks
+	f.events = append(f.events, event)
+}
+
+// failingMailer fails to send any mail
+type failingMailer struct {
+	mocks.Mailer
+}
+
+func (f *failingMailer) SendMail(to []string, subject, msg string) error {
+	return errors.New("connection refused")
+}
+
+func TestSendNagsWebhooks(t *testing.T) {
+	_ = features.Set(map[string]bool{"WebhookContacts": true})
+	defer features.Reset()
+
+	mc := mocks.Mailer{}
+	fc := newFakeClock(t)
+	hooks := &fakeWebhooks{}
+	m := mailer{
+		stats:         metrics.NewNoopScope(),
+		log:           log,
+		mailer:        &mc,
+		emailTemplate: tmpl,
+		subject:       testEmailSubject,
+		rs:            newFakeRegStore(),
+		clk:           fc,
+		webhooks:      hooks,
+	}
+
+	rawCert := newX509Cert("happy A",
+		fc.Now().AddDate(0, 0, 5),
+		[]string{"example-a.com"},
+		serial1,
+	)
+
+	// A registration with only a webhook contact is notified without email
+	err := m.sendNags(1, []string{"https://hooks.example.com/acme"}, []*x509.Certificate{rawCert})
+	test.AssertNotError(t, err, "Failed to send warning messages")
+	m.waitForWebhooks()
+	test.AssertEquals(t, len(mc.Messages), 0)
+	test.AssertEquals(t, len(hooks.events), 1)
+	test.AssertDeepEquals(t, hooks.hooks, []string{"https://hooks.example.com/acme"})
+	event := hooks.events[0]
+	test.AssertEquals(t, event.Type, webhook.CertificateExpiring)
+	test.AssertEquals(t, event.Registration, int64(1))
+	test.AssertEquals(t, event.Certificates[0].Serial, core.SerialToString(serial1))
+	test.AssertDeepEquals(t, event.Certificates[0].DNSNames, []string{"example-a.com"})
+
+	// Email contacts still get nags
+	err = m.sendNags(1, []string{email1, "https://hooks.example.com/acme"}, []*x509.Certificate{rawCert})
+	test.AssertNotError(t, err, "Failed to send warning messages")
+	m.waitForWebhooks()
+	test.AssertEquals(t, len(mc.Messages), 1)
+	test.AssertEquals(t, len(hooks.events), 2)
+
+	// If the emails can't be sent the webhooks aren't notified either, since
+	// the certificates will be retried on the next run
+	m.mailer = &failingMailer{}
+	err = m.sendNags(1, []string{email1, "https://hooks.example.com/acme"}, []*x509.Certificate{rawCert})
+	test.AssertError(t, err, "Sending to a failing mailer succeeded")
+	m.waitForWebhooks()
+	test.AssertEquals(t, len(hooks.events), 2)
+}
+
+// blockingWebhooks doesn't return from Notify until it is released
+type blockingWebhooks struct {
+	release chan struct{}
+}
+
+func (b *blockingWebhooks) Notify(ctx context.Context, hooks []string, event webhook.Event) {
+	<-b.release
+}
+
+func TestSendNagsWebhooksDontBlock(t *testing.T) {
+	_ = features.Set(map[string]bool{"WebhookContacts": true})
+	defer features.Reset()
+
+	mc := mocks.Mailer{}
+	fc := newFakeClock(t)
+	hooks := &blockingWebhooks{release: make(chan struct{})}
+	m := mailer{
+		stats:         metrics.NewNoopScope(),
+		log:           log,
+		mailer:        &mc,
+		emailTemplate: tmpl,
+		subject:       testEmailSubject,
+		rs:            newFakeRegStore(),
+		clk:           fc,
+		webhooks:      hooks,
+	}
+	rawCert := newX509Cert("happy A",
+		fc.Now().AddDate(0, 0, 5),
+		[]string{"example-a.com"},
+		serial1,
+	)
+
+	// A webhook that doesn't respond doesn't hold up sending nags
+	for i := 0; i < 2; i++ {
+		err := m.sendNags(1, []string{email1, "https://hooks.example.com/acme"}, []*x509.Certificate{rawCert})
+		test.AssertNotError(t, err, "Failed to send warning messages")
+	}
+	test.AssertEquals(t, len(mc.Messages), 2)
+	close(hooks.release)
+	m.waitForWebhooks()
+}
+
 func newX509Cert(commonName string, notAfter time.Time, dnsNames []string, serial *big.Int) *x509.Certificate {
 	return &x509.Certificate{
 		Subject: pkix.Name{
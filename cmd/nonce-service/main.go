@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/letsencrypt/boulder/cmd"
+	bgrpc "github.com/letsencrypt/boulder/grpc"
+	"github.com/letsencrypt/boulder/metrics"
+	"github.com/letsencrypt/boulder/nonce"
+	noncePB "github.com/letsencrypt/boulder/nonce/proto"
+)
+
+const clientName = "NonceService"
+
+type config struct {
+	NonceService struct {
+		cmd.ServiceConfig
+
+		// Prefix is prepended to every nonce issued by this instance so that
+		// WFEs can route redemption requests back to it. It must be
+		// nonce.PrefixLen characters of the base64url alphabet and unique
+		// among the nonce services the WFEs are configured with.
+		Prefix string
+	}
+
+	Statsd cmd.StatsdConfig
+
+	Syslog cmd.SyslogConfig
+}
+
+func main() {
+	configFile := flag.String("config", "", "File path to the configuration file for this service")
+	flag.Parse()
+	if *configFile == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var c config
+	err := cmd.ReadConfigFile(*configFile, &c)
+	cmd.FailOnError(err, "Reading JSON config file into config structure")
+
+	go cmd.DebugServer(c.NonceService.DebugAddr)
+
+	stats, logger := cmd.StatsAndLogging(c.Statsd, c.Syslog)
+	scope := metrics.NewStatsdScope(stats, "NonceService")
+	defer logger.AuditPanic()
+	logger.Info(cmd.VersionString(clientName))
+
+	if c.NonceService.GRPC == nil {
+		logger.AuditErr("No gRPC server configuration provided")
+		os.Exit(1)
+	}
+
+	ns, err := nonce.NewNonceService(scope, c.NonceService.Prefix)
+	cmd.FailOnError(err, "Failed to create nonce service")
+
+	go cmd.ProfileCmd(scope)
+
+	s, l, err := bgrpc.NewServer(c.NonceService.GRPC, scope)
+	cmd.FailOnError(err, "Failed to setup gRPC server")
+	gw := bgrpc.NewNonceServiceServer(nonce.NewLocal(ns))
+	noncePB.RegisterNonceServiceServer(s, gw)
+	err = s.Serve(l)
+	cmd.FailOnError(err, "gRPC service failed")
+}
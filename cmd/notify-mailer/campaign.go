@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Recipient statuses recorded in a campaign's state file
+const (
+	statusSent    = "sent"
+	statusSkipped = "skipped"
+	statusFailed  = "failed"
+)
+
+// campaignRecord is one line of a campaign state file. The first line of the
+// file has only Campaign set; every other line records the outcome of one
+// recipient.
+type campaignRecord struct {
+	Campaign string    `json:"campaign,omitempty"`
+	ID       int       `json:"id,omitempty"`
+	Email    string    `json:"email,omitempty"`
+	Status   string    `json:"status,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time,omitempty"`
+}
+
+// recipientKey identifies a recipient within a campaign. The same address can
+// be a contact for several registrations, and is sent to once for each.
+type recipientKey struct {
+	id    int
+	email string
+}
+
+// campaign persists the progress of a mailing to a state file, one JSON line
+// per recipient as it is handled, so that a run that dies partway through can
+// be restarted without sending to anyone twice.
+type campaign struct {
+	id string
+
+	mu   sync.Mutex
+	file *os.File
+	// done holds the recipients that a previous run sent to or skipped
+	done map[recipientKey]string
+}
+
+// campaignID derives a campaign ID from the content of a mailing, so that
+// rerunning the same mailing resumes it by default
+func campaignID(subject, body string, destinations []byte) string {
+	h := sha256.New()
+	for _, part := range [][]byte{[]byte(subject), []byte(body), destinations} {
+		fmt.Fprintf(h, "%d:", len(part))
+		_, _ = h.Write(part)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// openCampaign opens the state file at path, creating it if it doesn't exist.
+// An existing file must belong to the campaign with the given ID.
+func openCampaign(path, id string) (*campaign, error) {
+	c := &campaign{id: id, done: make(map[recipientKey]string)}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	c.file = f
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if info.Size() == 0 {
+		if err := c.write(campaignRecord{Campaign: id}); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+	if err := c.load(f); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("reading campaign state file %q: %s", path, err)
+	}
+	// Terminate a line left incomplete by a killed run, so that it doesn't
+	// swallow the next record
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if last[0] != '\n' {
+		if _, err := f.Write([]byte("\n")); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// load reads the records of a previous run
+func (c *campaign) load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	first := true
+	for scanner.Scan() {
+		var record campaignRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// The last line may be incomplete if the previous run was killed
+			// while writing it
+			continue
+		}
+		if first {
+			if record.Campaign != c.id {
+				return fmt.Errorf("state file is for campaign %q, not %q", record.Campaign, c.id)
+			}
+			first = false
+			continue
+		}
+		key := recipientKey{record.ID, record.Email}
+		switch record.Status {
+		case statusSent, statusSkipped:
+			c.done[key] = record.Status
+		case statusFailed:
+			delete(c.done, key)
+		}
+	}
+	if first {
+		return fmt.Errorf("state file has no campaign header")
+	}
+	return scanner.Err()
+}
+
+// previously returns the status a previous run recorded for dest, if it was
+// sent to or skipped
+func (c *campaign) previously(dest recipient) (string, bool) {
+	status, present := c.done[recipientKey{dest.id, dest.email}]
+	return status, present
+}
+
+// record appends the outcome of sending to dest to the state file
+func (c *campaign) record(dest recipient, status string, sendErr error, now time.Time) error {
+	record := campaignRecord{
+		ID:     dest.id,
+		Email:  dest.email,
+		Status: status,
+		Time:   now,
+	}
+	if sendErr != nil {
+		record.Error = sendErr.Error()
+	}
+	return c.write(record)
+}
+
+func (c *campaign) write(record campaignRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = c.file.Write(append(line, '\n'))
+	return err
+}
+
+func (c *campaign) Close() error {
+	return c.file.Close()
+}
+
+// report tallies the outcome of a run for the final summary
+type report struct {
+	mu       sync.Mutex
+	counts   map[string]int
+	resumed  int
+	failures []string
+}
+
+func newReport() *report {
+	return &report{counts: make(map[string]int)}
+}
+
+func (r *report) add(dest recipient, status string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counts[status]++
+	if err != nil {
+		r.failures = append(r.failures, fmt.Sprintf("%q (registration %d): %s", dest.email, dest.id, err))
+	}
+}
+
+func (r *report) addResumed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resumed++
+}
+
+// print writes the summary of the run to w
+func (r *report) print(w io.Writer, campaignID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if campaignID != "" {
+		fmt.Fprintf(w, "Campaign %s\n", campaignID)
+	}
+	fmt.Fprintf(w, "Sent: %d\n", r.counts[statusSent])
+	fmt.Fprintf(w, "Skipped (unsubscribed or undeliverable): %d\n", r.counts[statusSkipped])
+	fmt.Fprintf(w, "Already handled by a previous run: %d\n", r.resumed)
+	fmt.Fprintf(w, "Failed: %d\n", r.counts[statusFailed])
+	failures := make([]string, len(r.failures))
+	copy(failures, r.failures)
+	sort.Strings(failures)
+	for _, f := range failures {
+		fmt.Fprintf(w, "  %s\n", f)
+	}
+}
@@ -3,16 +3,20 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/mail"
 	"os"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/jmhodges/clock"
 	"github.com/letsencrypt/boulder/cmd"
+	"github.com/letsencrypt/boulder/features"
 	blog "github.com/letsencrypt/boulder/log"
 	bmail "github.com/letsencrypt/boulder/mail"
 	"github.com/letsencrypt/boulder/metrics"
@@ -29,6 +33,23 @@ type mailer struct {
 	destinations  []byte
 	checkpoint    interval
 	sleepInterval time.Duration
+	// parallelism is the number of messages sent concurrently, each worker
+	// sleeping for sleepInterval after its sends. It should match the number
+	// of connections in mailer's pool.
+	parallelism int
+	// unsubscribe is optional; when set, opted out addresses are skipped and
+	// messages carry an unsubscribe link
+	unsubscribe *bmail.UnsubscribeSigner
+	// campaign is optional; when set, the outcome of each recipient is
+	// persisted and recipients handled by a previous run are skipped
+	campaign *campaign
+	// report tallies the outcome of the run. It is created by run if nil.
+	report *report
+	// bodyTemplate is set in template mode, when emailTemplate is executed
+	// as a text/template for each recipient with their recipientData.
+	// recipientFields holds the extra fields from the -dataFile CSV.
+	bodyTemplate    *template.Template
+	recipientFields map[int]map[string]string
 }
 
 type interval struct {
@@ -40,6 +61,12 @@ type regID struct {
 	ID int
 }
 
+// recipient is an email address and the registration it is a contact for
+type recipient struct {
+	id    int
+	email string
+}
+
 type contactJSON struct {
 	ID      int
 	Contact []byte
@@ -100,23 +127,121 @@ func (m *mailer) run() error {
 	}
 
 	startTime := m.clk.Now()
+	if m.report == nil {
+		m.report = newReport()
+	}
+
+	parallelism := m.parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	work := make(chan recipient)
+	// Each worker stops at its first error, so this never blocks
+	errs := make(chan error, parallelism)
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dest := range work {
+				sent, err := m.sendOne(dest)
+				if recordErr := m.recordResult(dest, sent, err); recordErr != nil {
+					errs <- recordErr
+					return
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				if sent {
+					m.clk.Sleep(m.sleepInterval)
+				}
+			}
+		}()
+	}
 
+sendLoop:
 	for i, dest := range destinations {
-		m.printStatus(dest, i, len(destinations), startTime)
-		if strings.TrimSpace(dest) == "" {
+		if m.campaign != nil {
+			if status, done := m.campaign.previously(dest); done {
+				m.log.Info(fmt.Sprintf("Skipping %q, which was %s by a previous run\n", dest.email, status))
+				m.report.addResumed()
+				continue
+			}
+		}
+		m.printStatus(dest.email, i, len(destinations), startTime)
+		if strings.TrimSpace(dest.email) == "" {
 			continue
 		}
-		err := m.mailer.SendMail([]string{dest}, m.subject, m.emailTemplate)
+		select {
+		case work <- dest:
+		case err = <-errs:
+			break sendLoop
+		}
+	}
+	close(work)
+	wg.Wait()
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+	return err
+}
+
+// recordResult adds the outcome of sending to dest to the run's report and,
+// if there is one, the campaign state file
+func (m *mailer) recordResult(dest recipient, sent bool, sendErr error) error {
+	status := statusSkipped
+	if sendErr != nil {
+		status = statusFailed
+	} else if sent {
+		status = statusSent
+	}
+	m.report.add(dest, status, sendErr)
+	if m.campaign == nil {
+		return nil
+	}
+	return m.campaign.record(dest, status, sendErr, m.clk.Now())
+}
+
+// sendOne sends the message to dest unless it has unsubscribed or bounced,
+// and returns whether a message was sent
+func (m *mailer) sendOne(dest recipient) (bool, error) {
+	if features.Enabled(features.UndeliverableEmails) {
+		undeliverable, err := emailUndeliverable(dest.email, m.dbMap)
 		if err != nil {
-			return err
+			return false, err
+		}
+		if undeliverable {
+			m.log.Info(fmt.Sprintf("Skipping %q, which is undeliverable\n", dest.email))
+			return false, nil
 		}
-		m.clk.Sleep(m.sleepInterval)
 	}
-	return nil
+	// Opt-outs are honoured even without an unsubscribe signer, since they
+	// may have been recorded while one was configured
+	optedOut, err := emailOptedOut(dest.email, m.dbMap)
+	if err != nil {
+		return false, err
+	}
+	if optedOut {
+		m.log.Info(fmt.Sprintf("Skipping %q, which has unsubscribed\n", dest.email))
+		return false, nil
+	}
+	body, err := m.body(dest)
+	if err != nil {
+		return false, err
+	}
+	if m.unsubscribe == nil {
+		return true, m.mailer.SendMail([]string{dest.email}, m.subject, body)
+	}
+	return true, m.mailer.SendUnsubscribableMail(dest.email, m.subject, body, "",
+		m.unsubscribe.URL(int64(dest.id), dest.email))
 }
 
 // Resolves each reg ID to the most up-to-date contact email.
-func (m *mailer) resolveDestinations() ([]string, error) {
+func (m *mailer) resolveDestinations() ([]recipient, error) {
 	var regs []regID
 	err := json.Unmarshal(m.destinations, &regs)
 	if err != nil {
@@ -136,7 +261,7 @@ func (m *mailer) resolveDestinations() ([]string, error) {
 			len(regs))
 	}
 
-	var contactsList []string
+	var contactsList []recipient
 	for _, c := range regs[m.checkpoint.start:m.checkpoint.end] {
 		// Get the email address for the reg ID
 		emails, err := emailsForReg(c.ID, m.dbMap)
@@ -148,18 +273,19 @@ func (m *mailer) resolveDestinations() ([]string, error) {
 			if strings.TrimSpace(email) == "" {
 				continue
 			}
-			contactsList = append(contactsList, email)
+			contactsList = append(contactsList, recipient{id: c.ID, email: email})
 		}
 	}
 	return contactsList, nil
 }
 
-// Since the only thing we use from gorp is the SelectOne method on the
-// gorp.DbMap object, we just define an interface with that method
+// Since the only things we use from gorp are the SelectOne and Select methods
+// on the gorp.DbMap object, we just define an interface with those methods
 // instead of importing all of gorp. This facilitates mock implementations for
 // unit tests
 type dbSelector interface {
 	SelectOne(holder interface{}, query string, args ...interface{}) error
+	Select(i interface{}, query string, args ...interface{}) ([]interface{}, error)
 }
 
 // Finds the email addresses associated with a reg ID
@@ -193,6 +319,34 @@ func emailsForReg(id int, dbMap dbSelector) ([]string, error) {
 	return addresses, nil
 }
 
+// Checks whether an email address has unsubscribed from notification emails
+func emailOptedOut(email string, dbMap dbSelector) (bool, error) {
+	var count int64
+	err := dbMap.SelectOne(&count,
+		`SELECT COUNT(1)
+		FROM emailOptOuts
+		WHERE email = :email
+		LIMIT 1;`,
+		map[string]interface{}{
+			"email": email,
+		})
+	return count > 0, err
+}
+
+// Checks whether an email address has been marked undeliverable after bouncing
+func emailUndeliverable(email string, dbMap dbSelector) (bool, error) {
+	var count int64
+	err := dbMap.SelectOne(&count,
+		`SELECT COUNT(1)
+		FROM undeliverableEmails
+		WHERE email = :email
+		LIMIT 1;`,
+		map[string]interface{}{
+			"email": email,
+		})
+	return count > 0, err
+}
+
 const usageIntro = `
 Introduction:
 
@@ -230,6 +384,39 @@ opportunity for the operator to terminate early in the event of error. The
 -sleep flag honours durations with a unit suffix (e.g. 1m for 1 minute, 10s for
 10 seconds, etc). Using -sleep=0 will disable the sleep and send at full speed.
 
+Setting "connections" in the config sends over that many SMTP connections in
+parallel, each one sleeping between its own messages, and "sendsPerSecond"
+caps the combined rate. When sending in parallel a failed run may have sent
+some messages after the one it stopped at, so resume from the earliest
+reported position.
+
+For large mailings the -stateFile argument is safer than checkpointing by
+hand. The outcome of every recipient is appended to the state file as it is
+sent, and when the tool is rerun with the same state file it skips everyone
+already sent to or skipped, retrying only failures and recipients that hadn't
+been reached. The state file belongs to a single campaign, identified by the
+-campaign argument or, by default, by a hash of the subject, body and
+recipients, so it can't be reused for a different message by accident. A
+report of the run is printed when it finishes.
+
+With -template=true the body is a Go text/template, executed separately for
+each recipient so that a notice can be targeted at them. The template is
+given:
+
+  .RegistrationID  the recipient's registration ID
+  .Email           the recipient's email address
+  .Certificates    the registration's unexpired, unrevoked certificates,
+                   soonest expiring first, each with .Serial, .Names and
+                   .Expires
+  .Fields          the registration's row of the -dataFile CSV, if given,
+                   keyed by column header. The CSV must have an "id" column
+                   of registration IDs.
+
+The join function joins a list with a separator, e.g. {{join .Names ", "}}.
+
+Referring to a field that a recipient doesn't have, e.g. because they have no
+row in the CSV, stops the run rather than sending them an incomplete message.
+
 Examples:
   Send an email with subject "Hello!" from the email "hello@goodbye.com" with
   the contents read from "test_msg_body.txt" to every email associated with the
@@ -256,6 +443,23 @@ Examples:
     -toFile cmd/notify-mailer/testdata/test_msg_recipients.json -subject "Hello!"
     -sleep 10s -start 200 -end 300 -dryRun=true
 
+  Send the message to every recipient, recording progress so that the same
+  command can be rerun to resume if it is interrupted:
+
+  notify-mailer -config test/config/notify-mailer.json
+    -body cmd/notify-mailer/testdata/test_msg_body.txt -from hello@goodbye.com
+    -toFile cmd/notify-mailer/testdata/test_msg_recipients.json -subject "Hello!"
+    -sleep 10s -stateFile /var/lib/notify-mailer/hello.state -dryRun=false
+
+  Send each recipient a list of their certificates along with the reason
+  column for their registration from "incident.csv", as a dry run:
+
+  notify-mailer -config test/config/notify-mailer.json
+    -body cmd/notify-mailer/testdata/test_msg_template.txt -template
+    -dataFile incident.csv -from hello@goodbye.com
+    -toFile cmd/notify-mailer/testdata/test_msg_recipients.json
+    -subject "Your certificates" -sleep 10s -dryRun=true
+
 Required arguments:
 - body
 - config
@@ -272,6 +476,10 @@ func main() {
 	sleep := flag.Duration("sleep", 60*time.Second, "How long to sleep between emails.")
 	start := flag.Int("start", 0, "Line of input file to start from.")
 	end := flag.Int("end", 99999999, "Line of input file to end before.")
+	templateMode := flag.Bool("template", false, "Whether to execute the body as a text/template for each recipient.")
+	dataFile := flag.String("dataFile", "", "CSV file of extra template fields per registration ID. Requires -template.")
+	stateFile := flag.String("stateFile", "", "File to record progress in, and to resume from if it exists.")
+	campaignFlag := flag.String("campaign", "", "ID of the campaign recorded in -stateFile. Defaults to a hash of the message and recipients.")
 	reconnBase := flag.Duration("reconnectBase", 1*time.Second, "Base sleep duration between reconnect attempts")
 	reconnMax := flag.Duration("reconnectMax", 5*60*time.Second, "Max sleep duration between reconnect attempts after exponential backoff")
 	type config struct {
@@ -279,6 +487,11 @@ func main() {
 			cmd.DBConfig
 			cmd.PasswordConfig
 			cmd.SMTPConfig
+			// Unsubscribe, if its key file is set, adds unsubscribe links to
+			// messages and skips addresses that have unsubscribed
+			Unsubscribe cmd.UnsubscribeConfig
+
+			Features map[string]bool
 		}
 		Statsd cmd.StatsdConfig
 		Syslog cmd.SyslogConfig
@@ -303,6 +516,9 @@ func main() {
 	err = json.Unmarshal(configData, &cfg)
 	cmd.FailOnError(err, "Unmarshaling config")
 
+	err = features.Set(cfg.NotifyMailer.Features)
+	cmd.FailOnError(err, "Failed to set feature flags")
+
 	stats, log := cmd.StatsAndLogging(cfg.Statsd, cfg.Syslog)
 	scope := metrics.NewStatsdScope(stats, "NotificationMailer")
 	defer log.AuditPanic()
@@ -323,6 +539,25 @@ func main() {
 	toBody, err := ioutil.ReadFile(*toFile)
 	cmd.FailOnError(err, fmt.Sprintf("Reading %q", *toFile))
 
+	var bodyTemplate *template.Template
+	var recipientFields map[int]map[string]string
+	if *templateMode {
+		bodyTemplate, err = parseBodyTemplate(string(body))
+		cmd.FailOnError(err, fmt.Sprintf("Parsing template %q", *bodyFile))
+		if *dataFile != "" {
+			f, err := os.Open(*dataFile)
+			cmd.FailOnError(err, fmt.Sprintf("Opening %q", *dataFile))
+			recipientFields, err = readRecipientFields(f)
+			_ = f.Close()
+			cmd.FailOnError(err, fmt.Sprintf("Reading %q", *dataFile))
+		}
+	} else if *dataFile != "" {
+		cmd.FailOnError(errors.New("-dataFile requires -template"), "Invalid arguments")
+	}
+
+	unsubscribe, err := cfg.NotifyMailer.Unsubscribe.Signer()
+	cmd.FailOnError(err, "Couldn't load unsubscribe key")
+
 	checkpointRange := interval{
 		start: *start,
 		end:   *end,
@@ -330,15 +565,23 @@ func main() {
 
 	var mailClient bmail.Mailer
 	if *dryRun {
-		mailClient = bmail.NewDryRun(*address, log)
+		// The dry run mailer isn't safe for concurrent access, so workers
+		// share it through a pool of one
+		mailClient = bmail.NewPool([]bmail.Mailer{bmail.NewDryRun(*address, log)}, 0)
 	} else {
 		smtpPassword, err := cfg.NotifyMailer.PasswordConfig.Pass()
 		cmd.FailOnError(err, "Failed to load SMTP password")
-		mailClient = bmail.New(
+		tlsMode, tlsConfig, err := cfg.NotifyMailer.SMTPConfig.TLSConfig()
+		cmd.FailOnError(err, "Failed to load SMTP TLS config")
+		mailClient = bmail.NewPooled(
+			cfg.NotifyMailer.Connections,
+			cfg.NotifyMailer.SendsPerSecond,
 			cfg.NotifyMailer.Server,
 			cfg.NotifyMailer.Port,
 			cfg.NotifyMailer.Username,
 			smtpPassword,
+			tlsMode,
+			tlsConfig,
 			*address,
 			log,
 			scope,
@@ -363,8 +606,28 @@ func main() {
 		emailTemplate: string(body),
 		checkpoint:    checkpointRange,
 		sleepInterval: *sleep,
+		unsubscribe:   unsubscribe,
+		parallelism:   cfg.NotifyMailer.Connections,
+		report:        newReport(),
+
+		bodyTemplate:    bodyTemplate,
+		recipientFields: recipientFields,
+	}
+
+	var id string
+	if *stateFile != "" {
+		id = *campaignFlag
+		if id == "" {
+			id = campaignID(*subject, string(body), toBody)
+		}
+		m.campaign, err = openCampaign(*stateFile, id)
+		cmd.FailOnError(err, "Couldn't open campaign state file")
+		defer func() {
+			_ = m.campaign.Close()
+		}()
 	}
 
 	err = m.run()
+	m.report.print(os.Stdout, id)
 	cmd.FailOnError(err, "mailer.send returned error")
 }
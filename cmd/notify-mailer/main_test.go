@@ -1,16 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/jmhodges/clock"
 
+	"github.com/letsencrypt/boulder/features"
 	blog "github.com/letsencrypt/boulder/log"
+	bmail "github.com/letsencrypt/boulder/mail"
 	"github.com/letsencrypt/boulder/mocks"
 	"github.com/letsencrypt/boulder/test"
 )
@@ -294,14 +305,164 @@ func TestMessageContent(t *testing.T) {
 	}, mc.Messages[0])
 }
 
+func TestUnsubscribe(t *testing.T) {
+	testDestinationsBody, err := ioutil.ReadFile("testdata/test_msg_recipients.txt")
+	test.AssertNotError(t, err, "failed to read testdata/test_msg_recipients.txt")
+	unsubscribe, err := bmail.NewUnsubscribeSigner([]byte("0123456789abcdef0123456789abcdef"), "https://example.com/unsubscribe")
+	test.AssertNotError(t, err, "failed to create UnsubscribeSigner")
+
+	mc := &mocks.Mailer{}
+	m := &mailer{
+		log:           blog.UseMock(),
+		mailer:        mc,
+		dbMap:         mockEmailResolver{optedOut: "test-example-updated@example.com"},
+		subject:       "Test",
+		destinations:  testDestinationsBody,
+		emailTemplate: "Hi",
+		checkpoint:    interval{start: 0, end: 3},
+		sleepInterval: 0,
+		clk:           newFakeClock(t),
+		unsubscribe:   unsubscribe,
+	}
+
+	// Run the mailer. test-example-updated@example.com (ID 2) has unsubscribed,
+	// so only two messages should have been produced, each with its own
+	// unsubscribe link
+	err = m.run()
+	test.AssertNotError(t, err, "error calling mailer run()")
+	test.AssertEquals(t, len(mc.Messages), 2)
+	test.AssertEquals(t, mocks.MailerMessage{
+		To:             "example@example.com",
+		Subject:        "Test",
+		Body:           "Hi",
+		UnsubscribeURL: unsubscribe.URL(1, "example@example.com"),
+	}, mc.Messages[0])
+	test.AssertEquals(t, mocks.MailerMessage{
+		To:             "test-test-test@example.com",
+		Subject:        "Test",
+		Body:           "Hi",
+		UnsubscribeURL: unsubscribe.URL(3, "test-test-test@example.com"),
+	}, mc.Messages[1])
+}
+
+func TestOptedOutWithoutSigner(t *testing.T) {
+	testDestinationsBody, err := ioutil.ReadFile("testdata/test_msg_recipients.txt")
+	test.AssertNotError(t, err, "failed to read testdata/test_msg_recipients.txt")
+
+	mc := &mocks.Mailer{}
+	m := &mailer{
+		log:           blog.UseMock(),
+		mailer:        mc,
+		dbMap:         mockEmailResolver{optedOut: "test-example-updated@example.com"},
+		subject:       "Test",
+		destinations:  testDestinationsBody,
+		emailTemplate: "Hi",
+		checkpoint:    interval{start: 0, end: 3},
+		sleepInterval: 0,
+		clk:           newFakeClock(t),
+	}
+
+	// Opt-outs are honoured without an unsubscribe signer too
+	err = m.run()
+	test.AssertNotError(t, err, "error calling mailer run()")
+	test.AssertEquals(t, len(mc.Messages), 2)
+	test.AssertEquals(t, mc.Messages[0].To, "example@example.com")
+	test.AssertEquals(t, mc.Messages[1].To, "test-test-test@example.com")
+}
+
+func TestUndeliverable(t *testing.T) {
+	_ = features.Set(map[string]bool{"UndeliverableEmails": true})
+	defer features.Reset()
+
+	testDestinationsBody, err := ioutil.ReadFile("testdata/test_msg_recipients.txt")
+	test.AssertNotError(t, err, "failed to read testdata/test_msg_recipients.txt")
+
+	mc := &mocks.Mailer{}
+	m := &mailer{
+		log:           blog.UseMock(),
+		mailer:        mc,
+		dbMap:         mockEmailResolver{},
+		subject:       "Test",
+		destinations:  testDestinationsBody,
+		emailTemplate: "Hi",
+		checkpoint:    interval{start: 0, end: 3},
+		sleepInterval: 0,
+		clk:           newFakeClock(t),
+	}
+
+	// Run the mailer. test-test-test@example.com (ID 3) has bounced, so only
+	// two messages should have been produced
+	err = m.run()
+	test.AssertNotError(t, err, "error calling mailer run()")
+	test.AssertEquals(t, len(mc.Messages), 2)
+	test.AssertEquals(t, mc.Messages[0].To, "example@example.com")
+	test.AssertEquals(t, mc.Messages[1].To, "test-example-updated@example.com")
+}
+
+func TestParallelRun(t *testing.T) {
+	testDestinationsBody, err := ioutil.ReadFile("testdata/test_msg_recipients.txt")
+	test.AssertNotError(t, err, "failed to read testdata/test_msg_recipients.txt")
+
+	mc := &mocks.Mailer{}
+	m := &mailer{
+		log: blog.UseMock(),
+		// The mock mailer isn't safe for concurrent access
+		mailer:        bmail.NewPool([]bmail.Mailer{mc}, 0),
+		dbMap:         mockEmailResolver{},
+		subject:       "Test",
+		destinations:  testDestinationsBody,
+		emailTemplate: "Hi",
+		checkpoint:    interval{start: 0},
+		sleepInterval: 0,
+		clk:           newFakeClock(t),
+		parallelism:   3,
+	}
+
+	err = m.run()
+	test.AssertNotError(t, err, "error calling mailer run()")
+	// Every line of the destinations is sent to once, in no particular order.
+	// Registration ID 4 is listed twice.
+	sent := make(map[string]int)
+	for _, msg := range mc.Messages {
+		sent[msg.To]++
+	}
+	test.AssertDeepEquals(t, sent, map[string]int{
+		"example@example.com":                 1,
+		"test-example-updated@example.com":    1,
+		"test-test-test@example.com":          1,
+		"example-example-example@example.com": 2,
+		"youve.got.mail@example.com":          1,
+		"mail@example.com":                    1,
+	})
+}
+
 // the `mockEmailResolver` implements the `dbSelector` interface from
 // `notify-mailer/main.go` to allow unit testing without using a backing
 // database
-type mockEmailResolver struct{}
+type mockEmailResolver struct {
+	// optedOut is the only address opt out lookups find
+	optedOut string
+}
 
 // the `mockEmailResolver` select method treats the requested reg ID as an index
-// into a list of anonymous structs
-func (bs mockEmailResolver) SelectOne(output interface{}, _ string, args ...interface{}) error {
+// into a list of anonymous structs. Opt out lookups find only bs.optedOut and
+// undeliverable lookups find only "test-test-test@example.com".
+func (bs mockEmailResolver) SelectOne(output interface{}, query string, args ...interface{}) error {
+	if count, ok := output.(*int64); ok {
+		argsMap, ok := args[0].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("incorrect args type %T", args)
+		}
+		listed := bs.optedOut
+		if strings.Contains(query, "undeliverableEmails") {
+			listed = "test-test-test@example.com"
+		}
+		if argsMap["email"] == listed {
+			*count = 1
+		}
+		return nil
+	}
+
 	// The "db" is just a list in memory
 	db := []contactJSON{
 		contactJSON{
@@ -361,6 +522,114 @@ func (bs mockEmailResolver) SelectOne(output interface{}, _ string, args ...inte
 	return nil
 }
 
+// Select returns a single certificate, for example.com and www.example.com,
+// for reg ID 1 and none for other reg IDs
+func (bs mockEmailResolver) Select(output interface{}, query string, args ...interface{}) ([]interface{}, error) {
+	rows, ok := output.(*[]certificateRow)
+	if !ok {
+		return nil, fmt.Errorf("incorrect output type %T", output)
+	}
+	argsMap, ok := args[0].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("incorrect args type %T", args)
+	}
+	if argsMap["id"] != 1 {
+		return nil, nil
+	}
+	*rows = append(*rows, certificateRow{
+		Serial:  "000000000000000000000000000000000001",
+		DER:     testCertDER([]string{"www.example.com", "Example.com"}),
+		Expires: time.Date(2016, 12, 25, 0, 0, 0, 0, time.UTC),
+	})
+	return nil, nil
+}
+
+func testCertDER(names []string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     names,
+		NotBefore:    time.Date(2016, 9, 25, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2016, 12, 25, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		panic(err)
+	}
+	return der
+}
+
+func TestTemplateMode(t *testing.T) {
+	testDestinationsBody, err := ioutil.ReadFile("testdata/test_msg_recipients.txt")
+	test.AssertNotError(t, err, "failed to read testdata/test_msg_recipients.txt")
+	body, err := ioutil.ReadFile("testdata/test_msg_template.txt")
+	test.AssertNotError(t, err, "failed to read testdata/test_msg_template.txt")
+	tmpl, err := parseBodyTemplate(string(body))
+	test.AssertNotError(t, err, "failed to parse template")
+	fields, err := readRecipientFields(strings.NewReader("id,reason\n1,an incident\n2,another incident\n"))
+	test.AssertNotError(t, err, "failed to read fields")
+
+	mc := &mocks.Mailer{}
+	m := &mailer{
+		log:             blog.UseMock(),
+		mailer:          mc,
+		dbMap:           mockEmailResolver{},
+		subject:         "Test",
+		destinations:    testDestinationsBody,
+		emailTemplate:   string(body),
+		checkpoint:      interval{start: 0, end: 3},
+		sleepInterval:   0,
+		clk:             newFakeClock(t),
+		bodyTemplate:    tmpl,
+		recipientFields: fields,
+	}
+
+	// Reg ID 3 has no row in the CSV, so the run stops there
+	err = m.run()
+	test.AssertError(t, err, "run succeeded with a missing template field")
+	test.AssertEquals(t, len(mc.Messages), 2)
+	test.AssertEquals(t, mc.Messages[0].Body, `Hello,
+
+The following certificates issued to your account (ID 1)
+will be revoked because of an incident:
+
+  000000000000000000000000000000000001: example.com, www.example.com
+
+Please replace them as soon as possible.
+`)
+	test.AssertEquals(t, mc.Messages[1].Body, `Hello,
+
+The following certificates issued to your account (ID 2)
+will be revoked because of another incident:
+
+
+Please replace them as soon as possible.
+`)
+}
+
+func TestReadRecipientFields(t *testing.T) {
+	fields, err := readRecipientFields(strings.NewReader("reason,id\nfoo,1\n\"bar, baz\",2\n"))
+	test.AssertNotError(t, err, "failed to read fields")
+	test.AssertDeepEquals(t, fields, map[int]map[string]string{
+		1: {"id": "1", "reason": "foo"},
+		2: {"id": "2", "reason": "bar, baz"},
+	})
+
+	for _, csv := range []string{
+		"",
+		"reason\nfoo\n",
+		"id,reason\nx,foo\n",
+		"id,reason\n1,foo\n1,bar\n",
+		"id,reason\n1\n",
+	} {
+		_, err := readRecipientFields(strings.NewReader(csv))
+		test.AssertError(t, err, fmt.Sprintf("invalid CSV %q accepted", csv))
+	}
+}
+
 func TestResolveEmails(t *testing.T) {
 	// Start with three reg. IDs. Note: the IDs have been matched with fake
 	// results in the `db` slice in `mockEmailResolver`'s `SelectOne`. If you add
@@ -402,10 +671,10 @@ func TestResolveEmails(t *testing.T) {
 	destinations, err := m.resolveDestinations()
 	test.AssertNotError(t, err, "failed to resolveDestinations")
 
-	expected := []string{
-		"example@example.com",
-		"test-example-updated@example.com",
-		"test-test-test@example.com",
+	expected := []recipient{
+		{1, "example@example.com"},
+		{2, "test-example-updated@example.com"},
+		{3, "test-test-test@example.com"},
 	}
 
 	test.AssertEquals(t, len(destinations), len(expected))
@@ -424,3 +693,75 @@ func newFakeClock(t *testing.T) clock.FakeClock {
 	fc.Set(ft.UTC())
 	return fc
 }
+
+func TestCampaignResume(t *testing.T) {
+	testDestinationsBody, err := ioutil.ReadFile("testdata/test_msg_recipients.txt")
+	test.AssertNotError(t, err, "failed to read testdata/test_msg_recipients.txt")
+	dir, err := ioutil.TempDir("", "notify-mailer")
+	test.AssertNotError(t, err, "failed to create temp dir")
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "state")
+
+	// A previous run sent to ID 1, failed to send to ID 2 and was killed
+	// while recording ID 3
+	state := `{"campaign":"hello"}
+{"id":1,"email":"example@example.com","status":"sent"}
+{"id":2,"email":"test-example-updated@example.com","status":"failed","error":"oops"}
+{"id":3,"email":"test-te`
+	err = ioutil.WriteFile(stateFile, []byte(state), 0600)
+	test.AssertNotError(t, err, "failed to write state file")
+
+	_, err = openCampaign(stateFile, "goodbye")
+	test.AssertError(t, err, "state file for another campaign was accepted")
+
+	c, err := openCampaign(stateFile, "hello")
+	test.AssertNotError(t, err, "failed to open campaign")
+	mc := &mocks.Mailer{}
+	m := &mailer{
+		log:           blog.UseMock(),
+		mailer:        mc,
+		dbMap:         mockEmailResolver{},
+		subject:       "Test",
+		destinations:  testDestinationsBody,
+		emailTemplate: "Hi",
+		checkpoint:    interval{start: 0, end: 3},
+		sleepInterval: 0,
+		clk:           newFakeClock(t),
+		campaign:      c,
+	}
+	err = m.run()
+	test.AssertNotError(t, err, "error calling mailer run()")
+	test.AssertNotError(t, c.Close(), "failed to close campaign")
+
+	// Only the recipients without a recorded success are sent to
+	test.AssertEquals(t, len(mc.Messages), 2)
+	test.AssertEquals(t, mc.Messages[0].To, "test-example-updated@example.com")
+	test.AssertEquals(t, mc.Messages[1].To, "test-test-test@example.com")
+
+	var out bytes.Buffer
+	m.report.print(&out, "hello")
+	test.AssertEquals(t, out.String(), "Campaign hello\n"+
+		"Sent: 2\n"+
+		"Skipped (unsubscribed or undeliverable): 0\n"+
+		"Already handled by a previous run: 1\n"+
+		"Failed: 0\n")
+
+	// A further run has nothing left to send
+	c, err = openCampaign(stateFile, "hello")
+	test.AssertNotError(t, err, "failed to reopen campaign")
+	defer c.Close()
+	mc.Clear()
+	m.campaign = c
+	m.report = nil
+	err = m.run()
+	test.AssertNotError(t, err, "error calling mailer run()")
+	test.AssertEquals(t, len(mc.Messages), 0)
+	test.AssertEquals(t, m.report.resumed, 3)
+}
+
+func TestCampaignID(t *testing.T) {
+	id := campaignID("Hello", "Hi", []byte(`[{"id": 1}]`))
+	test.AssertEquals(t, id, campaignID("Hello", "Hi", []byte(`[{"id": 1}]`)))
+	test.AssertNotEquals(t, id, campaignID("Hello", "Hi", []byte(`[{"id": 2}]`)))
+	test.AssertNotEquals(t, id, campaignID("HelloHi", "", []byte(`[{"id": 1}]`)))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+// recipientData is what the body template is executed with in template mode
+type recipientData struct {
+	RegistrationID int
+	Email          string
+	// Certificates are the registration's unexpired, unrevoked certificates,
+	// soonest expiring first
+	Certificates []certificateData
+	// Fields holds the columns of the registration's row in the -dataFile
+	// CSV, keyed by column header
+	Fields map[string]string
+}
+
+type certificateData struct {
+	Serial  string
+	Names   []string
+	Expires time.Time
+}
+
+// certificateRow is a row of the certificates table
+type certificateRow struct {
+	Serial  string
+	DER     []byte
+	Expires time.Time
+}
+
+// parseBodyTemplate parses a message body for template mode. Referring to a
+// field that a recipient's data doesn't have is an error, so that a notice is
+// never sent with part of it missing. Templates can use strings.Join as join.
+func parseBodyTemplate(body string) (*template.Template, error) {
+	return template.New("body").
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"join": strings.Join}).
+		Parse(body)
+}
+
+// readRecipientFields reads a CSV file whose first row is a header including
+// an "id" column of registration IDs, and returns each row keyed by its
+// registration ID
+func readRecipientFields(r io.Reader) (map[int]map[string]string, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("data file is empty")
+	}
+	header := records[0]
+	idColumn := -1
+	for i, name := range header {
+		if name == "id" {
+			idColumn = i
+		}
+	}
+	if idColumn == -1 {
+		return nil, fmt.Errorf("data file has no \"id\" column")
+	}
+	fields := make(map[int]map[string]string, len(records)-1)
+	for line, record := range records[1:] {
+		id, err := strconv.Atoi(record[idColumn])
+		if err != nil {
+			return nil, fmt.Errorf("data file line %d: invalid registration ID %q", line+2, record[idColumn])
+		}
+		if _, present := fields[id]; present {
+			return nil, fmt.Errorf("data file line %d: registration ID %d is repeated", line+2, id)
+		}
+		row := make(map[string]string, len(header))
+		for i, name := range header {
+			row[name] = record[i]
+		}
+		fields[id] = row
+	}
+	return fields, nil
+}
+
+// body returns the message to send to dest. In template mode this executes
+// the body template with dest's data.
+func (m *mailer) body(dest recipient) (string, error) {
+	if m.bodyTemplate == nil {
+		return m.emailTemplate, nil
+	}
+	certs, err := certificatesForReg(dest.id, m.clk.Now(), m.dbMap)
+	if err != nil {
+		return "", err
+	}
+	data := recipientData{
+		RegistrationID: dest.id,
+		Email:          dest.email,
+		Certificates:   certs,
+		Fields:         m.recipientFields[dest.id],
+	}
+	var buf bytes.Buffer
+	if err := m.bodyTemplate.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("executing template for %q (registration %d): %s", dest.email, dest.id, err)
+	}
+	return buf.String(), nil
+}
+
+// Finds the unexpired, unrevoked certificates of a reg ID
+func certificatesForReg(id int, now time.Time, dbMap dbSelector) ([]certificateData, error) {
+	var rows []certificateRow
+	_, err := dbMap.Select(&rows,
+		`SELECT c.serial, c.der, c.expires
+		FROM certificates AS c
+		JOIN certificateStatus AS cs ON cs.serial = c.serial
+		WHERE c.registrationID = :id
+		AND c.expires > :now
+		AND cs.status != :revoked
+		ORDER BY c.expires;`,
+		map[string]interface{}{
+			"id":      id,
+			"now":     now,
+			"revoked": string(core.OCSPStatusRevoked),
+		})
+	if err != nil {
+		return nil, err
+	}
+	certs := make([]certificateData, 0, len(rows))
+	for _, row := range rows {
+		cert, err := x509.ParseCertificate(row.DER)
+		if err != nil {
+			return nil, fmt.Errorf("parsing certificate %s: %s", row.Serial, err)
+		}
+		names := core.UniqueLowerNames(cert.DNSNames)
+		sort.Strings(names)
+		certs = append(certs, certificateData{
+			Serial:  row.Serial,
+			Names:   names,
+			Expires: row.Expires,
+		})
+	}
+	return certs, nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/letsencrypt/boulder/core"
+	blog "github.com/letsencrypt/boulder/log"
+	"github.com/letsencrypt/boulder/metrics"
+)
+
+// cacheEntry is a stored OCSP response along with what's needed to decide
+// whether it can still be served.
+type cacheEntry struct {
+	Response []byte
+	// LastUpdated is the ocspLastUpdated of the certificateStatus row the
+	// response was read from.
+	LastUpdated time.Time
+	// NextUpdate is copied from the response. The entry is never served
+	// after this time.
+	NextUpdate time.Time
+	// Checked is when LastUpdated was last confirmed against the database.
+	Checked time.Time
+}
+
+// SharedCache is a key-value store shared between OCSP responders, so that a
+// response fetched from the database by one responder can be served by all
+// of them. memcacheSharedCache is the implementation used in production.
+type SharedCache interface {
+	// Get returns the value stored under key. It returns false if there is
+	// no such value.
+	Get(key string) ([]byte, bool, error)
+	// Set stores value under key, to be dropped after ttl.
+	Set(key string, value []byte, ttl time.Duration) error
+}
+
+// memorySharedCache is an in-process SharedCache. It stands in for memcached
+// in tests.
+type memorySharedCache struct {
+	mu      sync.Mutex
+	clk     clock.Clock
+	entries map[string]memorySharedEntry
+}
+
+type memorySharedEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+func newMemorySharedCache(clk clock.Clock) *memorySharedCache {
+	return &memorySharedCache{clk: clk, entries: make(map[string]memorySharedEntry)}
+}
+
+func (m *memorySharedCache) Get(key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !m.clk.Now().Before(e.expires) {
+		delete(m.entries, key)
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+func (m *memorySharedCache) Set(key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = memorySharedEntry{value: value, expires: m.clk.Now().Add(ttl)}
+	return nil
+}
+
+// lruCache is a bounded, in-memory cache of OCSP responses that evicts the
+// least recently used entry when full.
+type lruCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruItem struct {
+	key   string
+	entry cacheEntry
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *lruCache) get(key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruItem).entry, true
+}
+
+func (c *lruCache) set(key string, entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*lruItem).entry = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruItem{key: key, entry: entry})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruItem).key)
+	}
+}
+
+func (c *lruCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// CachingSource is a Source that serves responses from a DBSource
+// through an in-memory LRU cache and, optionally, a SharedCache. Cached
+// entries are served until the response's NextUpdate. Once an entry is older
+// than revalidateAfter its ocspLastUpdated is checked against the database,
+// which is cheaper than fetching the response, and the entry is dropped if
+// the response has since been updated.
+type CachingSource struct {
+	src             *DBSource
+	local           *lruCache
+	shared          SharedCache
+	revalidateAfter time.Duration
+	clk             clock.Clock
+	stats           metrics.Scope
+	log             blog.Logger
+}
+
+// NewCachingSource wraps src in a cache holding up to size responses in
+// memory. shared may be nil.
+func NewCachingSource(src *DBSource, size int, shared SharedCache, revalidateAfter time.Duration, clk clock.Clock, stats metrics.Scope, log blog.Logger) *CachingSource {
+	return &CachingSource{
+		src:             src,
+		local:           newLRUCache(size),
+		shared:          shared,
+		revalidateAfter: revalidateAfter,
+		clk:             clk,
+		stats:           stats.NewScope("Cache"),
+		log:             log,
+	}
+}
+
+// Response is called by the HTTP server to handle a new OCSP request.
+func (cs *CachingSource) Response(req *ocsp.Request) ([]byte, error) {
+	// Don't let requests for other issuers hit the cache.
+	iss, ok := cs.src.findIssuer(req)
+	if !ok {
+		return cs.src.Response(req)
+	}
+	serial := core.SerialToString(req.SerialNumber)
+	// Entries are keyed by issuer as well as serial, so that a response
+	// looked up (and checked) for one issuer is never served for a request
+	// naming another.
+	key := cacheKey(iss, serial)
+
+	if entry, ok := cs.cached(key, serial); ok {
+		return entry.Response, nil
+	}
+	cs.stats.Inc("Miss", 1)
+
+	response, err := cs.src.lookup(req)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := ocsp.ParseResponse(response.OCSPResponse, nil)
+	if err != nil {
+		// Serve it, but don't cache what we can't read a NextUpdate from.
+		cs.log.Warning(fmt.Sprintf("Not caching unparseable OCSP response for serial %s: %s", serial, err))
+		return response.OCSPResponse, nil
+	}
+	now := cs.clk.Now()
+	entry := cacheEntry{
+		Response:    response.OCSPResponse,
+		LastUpdated: response.OCSPLastUpdated,
+		NextUpdate:  parsed.NextUpdate,
+		Checked:     now,
+	}
+	if entry.NextUpdate.After(now) {
+		cs.store(key, entry)
+	}
+	return response.OCSPResponse, nil
+}
+
+// cacheKey is the key of a response in the local and shared caches. It
+// includes both the issuer's key and name hashes, since several issuer
+// certificates can share a key.
+func cacheKey(iss issuer, serial string) string {
+	return iss.id + ":" + iss.nameID + ":" + serial
+}
+
+// cached returns a servable cache entry for key, the cache key of serial,
+// checking the local cache and then the shared cache.
+func (cs *CachingSource) cached(key, serial string) (cacheEntry, bool) {
+	entry, ok := cs.local.get(key)
+	if ok {
+		cs.stats.Inc("Hit.Local", 1)
+	} else {
+		entry, ok = cs.getShared(key)
+		if !ok {
+			return cacheEntry{}, false
+		}
+		cs.stats.Inc("Hit.Shared", 1)
+		cs.local.set(key, entry)
+	}
+
+	now := cs.clk.Now()
+	if !now.Before(entry.NextUpdate) {
+		cs.stats.Inc("Stale.Expired", 1)
+		cs.local.remove(key)
+		return cacheEntry{}, false
+	}
+	if now.Sub(entry.Checked) < cs.revalidateAfter {
+		return entry, true
+	}
+
+	lastUpdated, err := cs.src.lastUpdated(serial)
+	if err != nil {
+		// The database is what the cache is protecting; fall back to it
+		// rather than serving something we couldn't check.
+		cs.log.Warning(fmt.Sprintf("Failed to revalidate cached OCSP response for serial %s: %s", serial, err))
+		cs.local.remove(key)
+		return cacheEntry{}, false
+	}
+	if !lastUpdated.Equal(entry.LastUpdated) {
+		cs.stats.Inc("Stale.Updated", 1)
+		cs.local.remove(key)
+		return cacheEntry{}, false
+	}
+	cs.stats.Inc("Revalidated", 1)
+	entry.Checked = now
+	cs.store(key, entry)
+	return entry, true
+}
+
+func (cs *CachingSource) getShared(key string) (cacheEntry, bool) {
+	if cs.shared == nil {
+		return cacheEntry{}, false
+	}
+	value, ok, err := cs.shared.Get(key)
+	if err != nil {
+		cs.stats.Inc("Errors.SharedGet", 1)
+		cs.log.Warning(fmt.Sprintf("Failed to read shared OCSP cache for %s: %s", key, err))
+		return cacheEntry{}, false
+	}
+	if !ok {
+		return cacheEntry{}, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(value, &entry); err != nil {
+		cs.stats.Inc("Errors.SharedGet", 1)
+		cs.log.Warning(fmt.Sprintf("Failed to decode shared OCSP cache entry for %s: %s", key, err))
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (cs *CachingSource) store(key string, entry cacheEntry) {
+	cs.local.set(key, entry)
+	if cs.shared == nil {
+		return
+	}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		cs.stats.Inc("Errors.SharedSet", 1)
+		return
+	}
+	if err := cs.shared.Set(key, value, entry.NextUpdate.Sub(cs.clk.Now())); err != nil {
+		cs.stats.Inc("Errors.SharedSet", 1)
+		cs.log.Warning(fmt.Sprintf("Failed to write shared OCSP cache for %s: %s", key, err))
+	}
+}
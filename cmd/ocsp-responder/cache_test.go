@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/letsencrypt/boulder/core"
+	blog "github.com/letsencrypt/boulder/log"
+	"github.com/letsencrypt/boulder/metrics"
+	"github.com/letsencrypt/boulder/test"
+)
+
+// countingSelector returns a fixed certificateStatus and counts how many
+// queries it has answered.
+type countingSelector struct {
+	response dbResponse
+	queries  int
+}
+
+func (cs *countingSelector) SelectOne(output interface{}, _ string, _ ...interface{}) error {
+	outputPtr, ok := output.(*dbResponse)
+	if !ok {
+		return fmt.Errorf("incorrect output type %T", output)
+	}
+	cs.queries++
+	*outputPtr = cs.response
+	return nil
+}
+
+func setupCache(t *testing.T, size int, shared SharedCache) (*CachingSource, *countingSelector, clock.FakeClock) {
+	fc := clock.NewFake()
+	fc.Set(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	selector := &countingSelector{response: dbResponse{resp.OCSPResponse, fc.Now()}}
+	src, err := makeDBSource(selector, []string{"./testdata/test-ca.der.pem"}, blog.NewMock())
+	test.AssertNotError(t, err, "makeDBSource failed")
+	cs := NewCachingSource(src, size, shared, time.Minute, fc, metrics.NewNoopScope(), blog.NewMock())
+	return cs, selector, fc
+}
+
+func TestCachingSourceHit(t *testing.T) {
+	cs, selector, _ := setupCache(t, 10, nil)
+	ocspReq, err := ocsp.ParseRequest(req)
+	test.AssertNotError(t, err, "Failed to parse OCSP request")
+
+	for i := 0; i < 3; i++ {
+		body, err := cs.Response(ocspReq)
+		test.AssertNotError(t, err, "Response not found")
+		test.AssertByteEquals(t, body, resp.OCSPResponse)
+	}
+	test.AssertEquals(t, selector.queries, 1)
+}
+
+func TestCachingSourceRevalidate(t *testing.T) {
+	cs, selector, fc := setupCache(t, 10, nil)
+	ocspReq, err := ocsp.ParseRequest(req)
+	test.AssertNotError(t, err, "Failed to parse OCSP request")
+
+	_, err = cs.Response(ocspReq)
+	test.AssertNotError(t, err, "Response not found")
+
+	// Past revalidateAfter an unchanged response is confirmed with one query
+	// and kept.
+	fc.Add(2 * time.Minute)
+	_, err = cs.Response(ocspReq)
+	test.AssertNotError(t, err, "Response not found")
+	test.AssertEquals(t, selector.queries, 2)
+	_, err = cs.Response(ocspReq)
+	test.AssertNotError(t, err, "Response not found")
+	test.AssertEquals(t, selector.queries, 2)
+
+	// Once the response has been updated the cached entry is dropped and
+	// the new response fetched.
+	fc.Add(2 * time.Minute)
+	selector.response.OCSPLastUpdated = fc.Now()
+	_, err = cs.Response(ocspReq)
+	test.AssertNotError(t, err, "Response not found")
+	test.AssertEquals(t, selector.queries, 4)
+	entry, ok := cs.local.get(testCacheKey(t, cs, ocspReq))
+	test.Assert(t, ok, "Updated response not cached")
+	test.AssertEquals(t, entry.LastUpdated, fc.Now())
+}
+
+func TestCachingSourceExpires(t *testing.T) {
+	cs, selector, fc := setupCache(t, 10, nil)
+	ocspReq, err := ocsp.ParseRequest(req)
+	test.AssertNotError(t, err, "Failed to parse OCSP request")
+
+	_, err = cs.Response(ocspReq)
+	test.AssertNotError(t, err, "Response not found")
+
+	// The test response's NextUpdate is 2030-08-26
+	fc.Set(time.Date(2030, 8, 26, 0, 0, 0, 0, time.UTC))
+	_, err = cs.Response(ocspReq)
+	test.AssertNotError(t, err, "Response not found")
+	test.AssertEquals(t, selector.queries, 2)
+	_, ok := cs.local.get(testCacheKey(t, cs, ocspReq))
+	test.Assert(t, !ok, "Response past NextUpdate was cached")
+}
+
+func TestCachingSourceShared(t *testing.T) {
+	fc := clock.NewFake()
+	fc.Set(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	shared := newMemorySharedCache(fc)
+	first, firstSelector, _ := setupCache(t, 10, shared)
+	second, secondSelector, _ := setupCache(t, 10, shared)
+	ocspReq, err := ocsp.ParseRequest(req)
+	test.AssertNotError(t, err, "Failed to parse OCSP request")
+
+	_, err = first.Response(ocspReq)
+	test.AssertNotError(t, err, "Response not found")
+	body, err := second.Response(ocspReq)
+	test.AssertNotError(t, err, "Response not found")
+	test.AssertByteEquals(t, body, resp.OCSPResponse)
+	test.AssertEquals(t, firstSelector.queries, 1)
+	test.AssertEquals(t, secondSelector.queries, 0)
+}
+
+// testCacheKey returns the key a request's response is cached under
+func testCacheKey(t *testing.T, cs *CachingSource, req *ocsp.Request) string {
+	iss, ok := cs.src.findIssuer(req)
+	test.Assert(t, ok, "Request is for an unknown issuer")
+	return cacheKey(iss, core.SerialToString(req.SerialNumber))
+}
+
+func TestCachingSourceIssuersShareSerial(t *testing.T) {
+	fc := clock.NewFake()
+	fc.Set(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	selector := &countingSelector{response: dbResponse{resp.OCSPResponse, fc.Now()}}
+	src, err := makeDBSource(selector, []string{"./testdata/test-ca.der.pem", "../../test/test-ca2.pem"}, blog.NewMock())
+	test.AssertNotError(t, err, "makeDBSource failed")
+	shared := newMemorySharedCache(fc)
+	cs := NewCachingSource(src, 10, shared, time.Minute, fc, metrics.NewNoopScope(), blog.NewMock())
+
+	ocspReq, err := ocsp.ParseRequest(req)
+	test.AssertNotError(t, err, "Failed to parse OCSP request")
+	body, err := cs.Response(ocspReq)
+	test.AssertNotError(t, err, "Response not found")
+	test.AssertByteEquals(t, body, resp.OCSPResponse)
+
+	// A request for the same serial from the other issuer must not be served
+	// the first issuer's cached response
+	other := src.issuers[1]
+	h := ocspReq.HashAlgorithm.New()
+	h.Write(other.cert.RawSubject)
+	nameHash := h.Sum(nil)
+	h.Reset()
+	h.Write(other.subjectPublicKey)
+	otherReq := &ocsp.Request{
+		HashAlgorithm:  ocspReq.HashAlgorithm,
+		IssuerNameHash: nameHash,
+		IssuerKeyHash:  h.Sum(nil),
+		SerialNumber:   ocspReq.SerialNumber,
+	}
+	test.Assert(t, testCacheKey(t, cs, otherReq) != testCacheKey(t, cs, ocspReq), "Issuers share a cache key")
+	otherResponse := []byte("other issuer's response")
+	selector.response.OCSPResponse = otherResponse
+	body, err = cs.Response(otherReq)
+	test.AssertNotError(t, err, "Response not found")
+	test.AssertByteEquals(t, body, otherResponse)
+	test.AssertEquals(t, selector.queries, 2)
+
+	// The first issuer's response is still cached
+	body, err = cs.Response(ocspReq)
+	test.AssertNotError(t, err, "Response not found")
+	test.AssertByteEquals(t, body, resp.OCSPResponse)
+	test.AssertEquals(t, selector.queries, 2)
+}
+
+func TestCachingSourceUnknownIssuer(t *testing.T) {
+	fc := clock.NewFake()
+	selector := &countingSelector{response: resp}
+	src, err := makeDBSource(selector, []string{"../../test/test-ca2.pem"}, blog.NewMock())
+	test.AssertNotError(t, err, "makeDBSource failed")
+	cs := NewCachingSource(src, 10, nil, time.Minute, fc, metrics.NewNoopScope(), blog.NewMock())
+	ocspReq, err := ocsp.ParseRequest(req)
+	test.AssertNotError(t, err, "Failed to parse OCSP request")
+
+	_, err = cs.Response(ocspReq)
+	test.AssertEquals(t, err, errUnknownIssuer)
+	test.AssertEquals(t, selector.queries, 0)
+}
+
+func TestLRUCacheEvicts(t *testing.T) {
+	c := newLRUCache(2)
+	c.set("a", cacheEntry{})
+	c.set("b", cacheEntry{})
+	// Touch "a" so that "b" is the least recently used
+	_, ok := c.get("a")
+	test.Assert(t, ok, "a missing")
+	c.set("c", cacheEntry{})
+
+	_, ok = c.get("b")
+	test.Assert(t, !ok, "Least recently used entry not evicted")
+	_, ok = c.get("a")
+	test.Assert(t, ok, "a evicted")
+	_, ok = c.get("c")
+	test.Assert(t, ok, "c evicted")
+}
+
+func TestMemorySharedCacheExpires(t *testing.T) {
+	fc := clock.NewFake()
+	m := newMemorySharedCache(fc)
+	test.AssertNotError(t, m.Set("a", []byte("value"), time.Minute), "Set failed")
+	value, ok, err := m.Get("a")
+	test.AssertNotError(t, err, "Get failed")
+	test.Assert(t, ok, "Value missing")
+	test.AssertByteEquals(t, value, []byte("value"))
+
+	fc.Add(time.Minute)
+	_, ok, err = m.Get("a")
+	test.AssertNotError(t, err, "Get failed")
+	test.Assert(t, !ok, "Value not expired")
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"database/sql"
+	"encoding/asn1"
 	"encoding/hex"
 	"flag"
 	"fmt"
@@ -26,8 +29,8 @@ import (
 )
 
 /*
-DBSource maps a given Database schema to a CA Key Hash, so we can pick
-from among them when presented with OCSP requests for different certs.
+DBSource maps a given Database schema to a set of CA certificates, so we can
+pick from among them when presented with OCSP requests for different certs.
 
 We assume that OCSP responses are stored in a very simple database table,
 with two columns: serialNumber and response
@@ -41,9 +44,62 @@ serialNumber field, since we will always query on it.
 
 */
 type DBSource struct {
-	dbMap     dbSelector
-	caKeyHash []byte
-	log       blog.Logger
+	dbMap   dbSelector
+	issuers []issuer
+	// If validate is true stored responses are parsed and checked against
+	// the request before they are served.
+	validate bool
+	clk      clock.Clock
+	log      blog.Logger
+}
+
+// issuer holds the parts of an issuer certificate that OCSP requests identify
+// it by: the hashes of its subject name and of its public key.
+type issuer struct {
+	cert *x509.Certificate
+	// subjectPublicKey is the value of the BIT STRING subjectPublicKey in the
+	// certificate's SubjectPublicKeyInfo, which is what issuerKeyHash is
+	// computed over.
+	subjectPublicKey []byte
+	// id is used to identify the issuer in logs. It is the hash of the
+	// issuer's key, which issuers sharing a key have in common.
+	id string
+	// nameID is the hash of the issuer's subject name
+	nameID string
+}
+
+func newIssuer(cert *x509.Certificate) (issuer, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return issuer{}, err
+	}
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	nameHash := sha1.Sum(cert.RawSubject)
+	return issuer{
+		cert:             cert,
+		subjectPublicKey: spki.PublicKey.RightAlign(),
+		id:               hex.EncodeToString(keyHash[:]),
+		nameID:           hex.EncodeToString(nameHash[:]),
+	}, nil
+}
+
+// matches returns true if the issuer name and key hashes of the OCSP request
+// identify this issuer.
+func (i issuer) matches(req *ocsp.Request) bool {
+	if !req.HashAlgorithm.Available() {
+		return false
+	}
+	h := req.HashAlgorithm.New()
+	h.Write(i.subjectPublicKey)
+	if !bytes.Equal(h.Sum(nil), req.IssuerKeyHash) {
+		return false
+	}
+	h.Reset()
+	h.Write(i.cert.RawSubject)
+	return bytes.Equal(h.Sum(nil), req.IssuerNameHash)
 }
 
 // Since the only thing we use from gorp is the SelectOne method on the
@@ -55,10 +111,34 @@ type dbSelector interface {
 }
 
 // NewSourceFromDatabase produces a DBSource representing the binding of a
-// given DB schema to a CA key.
-func NewSourceFromDatabase(dbMap dbSelector, caKeyHash []byte, log blog.Logger) (src *DBSource, err error) {
-	src = &DBSource{dbMap: dbMap, caKeyHash: caKeyHash, log: log}
-	return
+// given DB schema to a set of CA certificates.
+func NewSourceFromDatabase(dbMap dbSelector, issuerCerts []*x509.Certificate, log blog.Logger) (*DBSource, error) {
+	src := &DBSource{dbMap: dbMap, clk: clock.Default(), log: log}
+	for _, cert := range issuerCerts {
+		i, err := newIssuer(cert)
+		if err != nil {
+			return nil, err
+		}
+		src.issuers = append(src.issuers, i)
+	}
+	return src, nil
+}
+
+// findIssuer returns the issuer an OCSP request is for, if it is one of ours.
+func (src *DBSource) findIssuer(req *ocsp.Request) (issuer, bool) {
+	for _, i := range src.issuers {
+		if i.matches(req) {
+			return i, true
+		}
+	}
+	return issuer{}, false
+}
+
+// KnownIssuer returns true if the OCSP request is for one of the issuers this
+// DBSource serves.
+func (src *DBSource) KnownIssuer(req *ocsp.Request) bool {
+	_, ok := src.findIssuer(req)
+	return ok
 }
 
 type dbResponse struct {
@@ -67,22 +147,28 @@ type dbResponse struct {
 }
 
 // Response is called by the HTTP server to handle a new OCSP request.
-func (src *DBSource) Response(req *ocsp.Request) ([]byte, bool) {
-	// Check that this request is for the proper CA
-	if bytes.Compare(req.IssuerKeyHash, src.caKeyHash) != 0 {
+func (src *DBSource) Response(req *ocsp.Request) ([]byte, error) {
+	response, err := src.lookup(req)
+	if err != nil {
+		return nil, err
+	}
+	return response.OCSPResponse, nil
+}
+
+// lookup returns the stored OCSP response for a request, along with the time
+// it was last updated.
+func (src *DBSource) lookup(req *ocsp.Request) (dbResponse, error) {
+	// Check that this request is for one of our CAs
+	iss, ok := src.findIssuer(req)
+	if !ok {
 		src.log.Debug(fmt.Sprintf("Request intended for CA Cert ID: %s", hex.EncodeToString(req.IssuerKeyHash)))
-		return nil, false
+		return dbResponse{}, errUnknownIssuer
 	}
 
 	serialString := core.SerialToString(req.SerialNumber)
 	src.log.Debug(fmt.Sprintf("Searching for OCSP issued by us for serial %s", serialString))
 
 	var response dbResponse
-	defer func() {
-		if len(response.OCSPResponse) != 0 {
-			src.log.Debug(fmt.Sprintf("OCSP Response sent for CA=%s, Serial=%s", hex.EncodeToString(src.caKeyHash), serialString))
-		}
-	}()
 	err := src.dbMap.SelectOne(
 		&response,
 		"SELECT ocspResponse, ocspLastUpdated FROM certificateStatus WHERE serial = :serial",
@@ -92,32 +178,73 @@ func (src *DBSource) Response(req *ocsp.Request) ([]byte, bool) {
 		src.log.AuditErr(fmt.Sprintf("Failed to retrieve response from certificateStatus table: %s", err))
 	}
 	if err != nil {
-		return nil, false
+		return dbResponse{}, errNotFound
 	}
 	if response.OCSPLastUpdated.IsZero() {
-		src.log.Debug(fmt.Sprintf("OCSP Response not sent (ocspLastUpdated is zero) for CA=%s, Serial=%s", hex.EncodeToString(src.caKeyHash), serialString))
-		return nil, false
+		src.log.Debug(fmt.Sprintf("OCSP Response not sent (ocspLastUpdated is zero) for CA=%s, Serial=%s", iss.id, serialString))
+		return dbResponse{}, errNotFound
+	}
+	if src.validate {
+		if err := src.checkResponse(response.OCSPResponse, iss, req); err != nil {
+			return dbResponse{}, err
+		}
 	}
 
-	return response.OCSPResponse, true
+	src.log.Debug(fmt.Sprintf("OCSP Response sent for CA=%s, Serial=%s", iss.id, serialString))
+	return response, nil
 }
 
-func makeDBSource(dbMap dbSelector, issuerCert string, log blog.Logger) (*DBSource, error) {
-	// Load the CA's key so we can store its SubjectKey in the DB
-	caCertDER, err := cmd.LoadCert(issuerCert)
+// checkResponse checks that a stored response was signed for iss, is about
+// the requested serial, and hasn't passed its NextUpdate.
+func (src *DBSource) checkResponse(der []byte, iss issuer, req *ocsp.Request) error {
+	serialString := core.SerialToString(req.SerialNumber)
+	parsed, err := ocsp.ParseResponse(der, iss.cert)
 	if err != nil {
-		return nil, fmt.Errorf("Could not read issuer cert %s: %s", issuerCert, err)
+		src.log.AuditErr(fmt.Sprintf("Stored OCSP response for CA=%s, Serial=%s is invalid: %s", iss.id, serialString, err))
+		return errInvalidResponse
 	}
-	caCert, err := x509.ParseCertificate(caCertDER)
-	if err != nil {
-		return nil, fmt.Errorf("Could not parse issuer cert %s: %s", issuerCert, err)
+	if parsed.SerialNumber == nil || parsed.SerialNumber.Cmp(req.SerialNumber) != 0 {
+		src.log.AuditErr(fmt.Sprintf("Stored OCSP response for CA=%s, Serial=%s is for serial %s", iss.id, serialString, core.SerialToString(parsed.SerialNumber)))
+		return errInvalidResponse
 	}
-	if len(caCert.SubjectKeyId) == 0 {
-		return nil, fmt.Errorf("Empty subjectKeyID")
+	if !src.clk.Now().Before(parsed.NextUpdate) {
+		src.log.Warning(fmt.Sprintf("Stored OCSP response for CA=%s, Serial=%s expired at %s", iss.id, serialString, parsed.NextUpdate))
+		return errStaleResponse
+	}
+	return nil
+}
+
+// lastUpdated returns the ocspLastUpdated time of the stored response for a
+// serial, without fetching the response itself.
+func (src *DBSource) lastUpdated(serial string) (time.Time, error) {
+	var response dbResponse
+	err := src.dbMap.SelectOne(
+		&response,
+		"SELECT ocspLastUpdated FROM certificateStatus WHERE serial = :serial",
+		map[string]interface{}{"serial": serial},
+	)
+	return response.OCSPLastUpdated, err
+}
+
+func makeDBSource(dbMap dbSelector, issuerCerts []string, log blog.Logger) (*DBSource, error) {
+	var certs []*x509.Certificate
+	for _, issuerCert := range issuerCerts {
+		caCertDER, err := cmd.LoadCert(issuerCert)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read issuer cert %s: %s", issuerCert, err)
+		}
+		caCert, err := x509.ParseCertificate(caCertDER)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse issuer cert %s: %s", issuerCert, err)
+		}
+		certs = append(certs, caCert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("No issuer certs provided")
 	}
 
 	// Construct source from DB
-	return NewSourceFromDatabase(dbMap, caCert.SubjectKeyId, log)
+	return NewSourceFromDatabase(dbMap, certs, log)
 }
 
 type config struct {
@@ -139,6 +266,38 @@ type config struct {
 
 		ShutdownStopTimeout string
 		ShutdownKillTimeout string
+
+		// IssuerCerts lists the certificates of the issuers whose OCSP
+		// responses are served from the database. If it is empty
+		// Common.IssuerCert is used.
+		IssuerCerts []string
+
+		// If ValidateResponses is true stored responses are parsed before
+		// being served, and aren't served if they are for the wrong
+		// certificate or past their NextUpdate.
+		ValidateResponses bool
+
+		// Cache configures an in-memory cache of OCSP responses in front
+		// of the database. A Size of zero disables it.
+		Cache struct {
+			Size int
+			// How long a cached response is served before its
+			// ocspLastUpdated is rechecked against the database.
+			RevalidateAfter cmd.ConfigDuration
+
+			// Shared configures a memcached server that responders
+			// share cached responses through. If Memcached is empty
+			// each responder only has its own in-memory cache.
+			Shared struct {
+				// Memcached is the host:port of the server
+				Memcached string
+				// Timeout bounds each request to the server
+				Timeout cmd.ConfigDuration
+				// MaxIdleConns is how many connections to the
+				// server are kept open for reuse
+				MaxIdleConns int
+			}
+		}
 	}
 
 	Statsd cmd.StatsdConfig
@@ -177,7 +336,7 @@ as generated by Boulder's single-ocsp command.
 	go cmd.ProfileCmd(scope)
 
 	config := c.OCSPResponder
-	var source cfocsp.Source
+	var source Source
 
 	if strings.HasPrefix(config.Source, "file:") {
 		url, err := url.Parse(config.Source)
@@ -188,8 +347,9 @@ as generated by Boulder's single-ocsp command.
 		if filename == "" {
 			filename = url.Opaque
 		}
-		source, err = cfocsp.NewSourceFromFile(filename)
+		fileSource, err := cfocsp.NewSourceFromFile(filename)
 		cmd.FailOnError(err, fmt.Sprintf("Couldn't read file: %s", url.Path))
+		source = cfsslSource{fileSource}
 	} else {
 		// For databases, DBConfig takes precedence over Source, if present.
 		dbConnect, err := config.DBConfig.URL()
@@ -197,20 +357,34 @@ as generated by Boulder's single-ocsp command.
 		if dbConnect == "" {
 			dbConnect = config.Source
 		}
-		logger.Info(fmt.Sprintf("Loading OCSP Database for CA Cert: %s", c.Common.IssuerCert))
+		issuerCerts := config.IssuerCerts
+		if len(issuerCerts) == 0 {
+			issuerCerts = []string{c.Common.IssuerCert}
+		}
+		logger.Info(fmt.Sprintf("Loading OCSP Database for CA Certs: %s", strings.Join(issuerCerts, ", ")))
 		dbMap, err := sa.NewDbMap(dbConnect, config.DBConfig.MaxDBConns)
 		cmd.FailOnError(err, "Could not connect to database")
 		sa.SetSQLDebug(dbMap, logger)
 		go sa.ReportDbConnCount(dbMap, scope)
-		source, err = makeDBSource(dbMap, c.Common.IssuerCert, logger)
+		dbSource, err := makeDBSource(dbMap, issuerCerts, logger)
 		cmd.FailOnError(err, "Couldn't load OCSP DB")
+		dbSource.validate = config.ValidateResponses
+		source = dbSource
+		if config.Cache.Size > 0 {
+			var shared SharedCache
+			if sharedConfig := config.Cache.Shared; sharedConfig.Memcached != "" {
+				logger.Info(fmt.Sprintf("Sharing cached OCSP responses through memcached at %s", sharedConfig.Memcached))
+				shared = newMemcacheSharedCache(sharedConfig.Memcached, sharedConfig.Timeout.Duration, sharedConfig.MaxIdleConns, clock.Default())
+			}
+			source = NewCachingSource(dbSource, config.Cache.Size, shared, config.Cache.RevalidateAfter.Duration, clock.Default(), scope, logger)
+		}
 	}
 
 	stopTimeout, err := time.ParseDuration(c.OCSPResponder.ShutdownStopTimeout)
 	cmd.FailOnError(err, "Couldn't parse shutdown stop timeout")
 	killTimeout, err := time.ParseDuration(c.OCSPResponder.ShutdownKillTimeout)
 	cmd.FailOnError(err, "Couldn't parse shutdown kill timeout")
-	m := mux(scope, c.OCSPResponder.Path, source)
+	m := mux(scope, c.OCSPResponder.Path, source, logger)
 	srv := &http.Server{
 		Addr:    c.OCSPResponder.ListenAddress,
 		Handler: m,
@@ -225,8 +399,8 @@ as generated by Boulder's single-ocsp command.
 	cmd.FailOnError(err, "Error starting HTTP server")
 }
 
-func mux(scope metrics.Scope, responderPath string, source cfocsp.Source) http.Handler {
-	m := http.StripPrefix(responderPath, cfocsp.NewResponder(source))
+func mux(scope metrics.Scope, responderPath string, source Source, logger blog.Logger) http.Handler {
+	m := http.StripPrefix(responderPath, newResponder(source, clock.Default(), scope, logger))
 	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" && r.URL.Path == "/" {
 			w.Header().Set("Cache-Control", "max-age=43200") // Cache for 12 hours
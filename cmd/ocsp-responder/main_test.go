@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jmhodges/clock"
 	"golang.org/x/crypto/ocsp"
 
 	cfocsp "github.com/cloudflare/cfssl/ocsp"
@@ -31,7 +32,7 @@ func TestMux(t *testing.T) {
 	}
 	src := make(cfocsp.InMemorySource)
 	src[ocspReq.SerialNumber.String()] = resp.OCSPResponse
-	h := mux(stats, "/foobar/", src)
+	h := mux(stats, "/foobar/", cfsslSource{src}, blog.NewMock())
 	type muxTest struct {
 		method   string
 		path     string
@@ -57,12 +58,12 @@ func TestMux(t *testing.T) {
 }
 
 func TestDBHandler(t *testing.T) {
-	src, err := makeDBSource(mockSelector{}, "./testdata/test-ca.der.pem", blog.NewMock())
+	src, err := makeDBSource(mockSelector{}, []string{"./testdata/test-ca.der.pem"}, blog.NewMock())
 	if err != nil {
 		t.Fatalf("makeDBSource: %s", err)
 	}
 
-	h := cfocsp.NewResponder(src)
+	h := newResponder(src, clock.NewFake(), stats, blog.NewMock())
 	w := httptest.NewRecorder()
 	r, err := http.NewRequest("POST", "/", bytes.NewReader(req))
 	if err != nil {
@@ -91,6 +92,49 @@ func TestDBHandler(t *testing.T) {
 	}
 }
 
+func TestMultipleIssuers(t *testing.T) {
+	src, err := makeDBSource(mockSelector{}, []string{"../../test/test-ca2.pem", "./testdata/test-ca.der.pem"}, blog.NewMock())
+	test.AssertNotError(t, err, "makeDBSource failed")
+	test.AssertEquals(t, len(src.issuers), 2)
+
+	ocspReq, err := ocsp.ParseRequest(req)
+	test.AssertNotError(t, err, "Failed to parse OCSP request")
+	test.Assert(t, src.KnownIssuer(ocspReq), "Request for second issuer not recognized")
+	body, err := src.Response(ocspReq)
+	test.AssertNotError(t, err, "Response for second issuer not found")
+	test.AssertByteEquals(t, body, resp.OCSPResponse)
+
+	// A request with the right key hash but the wrong name hash is not ours
+	ocspReq.IssuerNameHash = []byte("wrong")
+	test.Assert(t, !src.KnownIssuer(ocspReq), "Request with mismatched name hash recognized")
+}
+
+func TestUnknownIssuer(t *testing.T) {
+	mockLog := blog.NewMock()
+	src, err := makeDBSource(mockSelector{}, []string{"../../test/test-ca2.pem"}, mockLog)
+	test.AssertNotError(t, err, "makeDBSource failed")
+
+	ocspReq, err := ocsp.ParseRequest(req)
+	test.AssertNotError(t, err, "Failed to parse OCSP request")
+	_, err = src.Response(ocspReq)
+	test.AssertEquals(t, err, errUnknownIssuer)
+	test.AssertEquals(t, len(mockLog.GetAllMatching("Request intended for CA Cert ID")), 1)
+
+	h := mux(stats, "/", src, blog.NewMock())
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("POST", "/", bytes.NewReader(req))
+	test.AssertNotError(t, err, "NewRequest failed")
+	h.ServeHTTP(w, r)
+	test.AssertEquals(t, w.Code, http.StatusOK)
+	test.AssertByteEquals(t, w.Body.Bytes(), ocsp.UnauthorizedErrorResponse)
+	test.AssertEquals(t, w.Header().Get("Content-Type"), "application/ocsp-response")
+}
+
+func TestMakeDBSourceNoIssuers(t *testing.T) {
+	_, err := makeDBSource(mockSelector{}, nil, blog.NewMock())
+	test.AssertError(t, err, "makeDBSource succeeded without issuers")
+}
+
 // mockSelector always returns the same certificateStatus
 type mockSelector struct{}
 
@@ -113,14 +157,14 @@ func (bs brokenSelector) SelectOne(_ interface{}, _ string, _ ...interface{}) er
 
 func TestErrorLog(t *testing.T) {
 	mockLog := blog.NewMock()
-	src, err := makeDBSource(brokenSelector{}, "./testdata/test-ca.der.pem", mockLog)
+	src, err := makeDBSource(brokenSelector{}, []string{"./testdata/test-ca.der.pem"}, mockLog)
 	test.AssertNotError(t, err, "Failed to create broken dbMap")
 
 	ocspReq, err := ocsp.ParseRequest(req)
 	test.AssertNotError(t, err, "Failed to parse OCSP request")
 
-	_, found := src.Response(ocspReq)
-	test.Assert(t, !found, "Somehow found OCSP response")
+	_, err = src.Response(ocspReq)
+	test.AssertEquals(t, err, errNotFound)
 
 	test.AssertEquals(t, len(mockLog.GetAllMatching("Failed to retrieve response from certificateStatus table")), 1)
 }
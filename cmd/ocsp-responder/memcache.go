@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmhodges/clock"
+)
+
+// maxRelativeExpiration is the longest expiration time memcached accepts as a
+// number of seconds. Longer ones must be given as a Unix time.
+const maxRelativeExpiration = 30 * 24 * time.Hour
+
+// memcacheSharedCache is a SharedCache backed by a memcached server, using
+// memcached's text protocol. Connections are reused, up to maxIdle of them
+// at a time.
+type memcacheSharedCache struct {
+	addr    string
+	timeout time.Duration
+	clk     clock.Clock
+	idle    chan *memcacheConn
+}
+
+type memcacheConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+}
+
+func newMemcacheSharedCache(addr string, timeout time.Duration, maxIdle int, clk clock.Clock) *memcacheSharedCache {
+	return &memcacheSharedCache{
+		addr:    addr,
+		timeout: timeout,
+		clk:     clk,
+		idle:    make(chan *memcacheConn, maxIdle),
+	}
+}
+
+// Get returns the value stored under key. It returns false if there is no
+// such value.
+func (m *memcacheSharedCache) Get(key string) ([]byte, bool, error) {
+	if err := checkMemcacheKey(key); err != nil {
+		return nil, false, err
+	}
+	var value []byte
+	var found bool
+	err := m.do(func(c *memcacheConn) error {
+		if _, err := fmt.Fprintf(c.rw, "get %s\r\n", key); err != nil {
+			return err
+		}
+		if err := c.rw.Flush(); err != nil {
+			return err
+		}
+		line, err := readMemcacheLine(c.rw)
+		if err != nil {
+			return err
+		}
+		if line == "END" {
+			return nil
+		}
+		// VALUE <key> <flags> <bytes>
+		fields := strings.Fields(line)
+		if len(fields) != 4 || fields[0] != "VALUE" || fields[1] != key {
+			return fmt.Errorf("unexpected memcached reply %q", line)
+		}
+		size, err := strconv.Atoi(fields[3])
+		if err != nil || size < 0 {
+			return fmt.Errorf("unexpected memcached reply %q", line)
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.rw, data); err != nil {
+			return err
+		}
+		if string(data[size:]) != "\r\n" {
+			return errors.New("memcached value not terminated by CRLF")
+		}
+		if line, err = readMemcacheLine(c.rw); err != nil {
+			return err
+		}
+		if line != "END" {
+			return fmt.Errorf("unexpected memcached reply %q", line)
+		}
+		value, found = data[:size], true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return value, found, nil
+}
+
+// Set stores value under key, to be dropped by memcached after ttl.
+func (m *memcacheSharedCache) Set(key string, value []byte, ttl time.Duration) error {
+	if err := checkMemcacheKey(key); err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	// An expiration of zero means "never", so round up to whole seconds
+	exptime := int64((ttl + time.Second - 1) / time.Second)
+	if ttl > maxRelativeExpiration {
+		exptime = m.clk.Now().Add(ttl).Unix()
+	}
+	return m.do(func(c *memcacheConn) error {
+		if _, err := fmt.Fprintf(c.rw, "set %s 0 %d %d\r\n", key, exptime, len(value)); err != nil {
+			return err
+		}
+		if _, err := c.rw.Write(value); err != nil {
+			return err
+		}
+		if _, err := c.rw.WriteString("\r\n"); err != nil {
+			return err
+		}
+		if err := c.rw.Flush(); err != nil {
+			return err
+		}
+		line, err := readMemcacheLine(c.rw)
+		if err != nil {
+			return err
+		}
+		if line != "STORED" {
+			return fmt.Errorf("unexpected memcached reply %q", line)
+		}
+		return nil
+	})
+}
+
+// do runs f on a connection to the server. The connection is put back for
+// reuse if f succeeds, and closed otherwise, since a failed exchange may have
+// left it part way through a reply.
+func (m *memcacheSharedCache) do(f func(*memcacheConn) error) error {
+	c, err := m.conn()
+	if err != nil {
+		return err
+	}
+	if m.timeout > 0 {
+		if err := c.conn.SetDeadline(time.Now().Add(m.timeout)); err != nil {
+			_ = c.conn.Close()
+			return err
+		}
+	}
+	if err := f(c); err != nil {
+		_ = c.conn.Close()
+		return err
+	}
+	select {
+	case m.idle <- c:
+	default:
+		_ = c.conn.Close()
+	}
+	return nil
+}
+
+func (m *memcacheSharedCache) conn() (*memcacheConn, error) {
+	select {
+	case c := <-m.idle:
+		return c, nil
+	default:
+	}
+	conn, err := net.DialTimeout("tcp", m.addr, m.timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &memcacheConn{
+		conn: conn,
+		rw:   bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)),
+	}, nil
+}
+
+// readMemcacheLine reads a reply line, turning the protocol's error replies
+// into errors
+func readMemcacheLine(r *bufio.ReadWriter) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+		return "", fmt.Errorf("memcached error: %s", line)
+	}
+	return line, nil
+}
+
+// checkMemcacheKey rejects keys that memcached's text protocol can't carry
+func checkMemcacheKey(key string) error {
+	if len(key) == 0 || len(key) > 250 {
+		return fmt.Errorf("invalid memcached key length %d", len(key))
+	}
+	for _, c := range key {
+		if c <= ' ' || c == 0x7f {
+			return fmt.Errorf("invalid memcached key %q", key)
+		}
+	}
+	return nil
+}
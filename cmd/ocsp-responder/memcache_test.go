@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+// fakeMemcached serves the get and set commands of memcached's text
+// protocol from a map, recording the expiration time of each set.
+type fakeMemcached struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	values   map[string][]byte
+	exptimes map[string]int64
+	conns    int
+}
+
+func newFakeMemcached(t *testing.T) *fakeMemcached {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	test.AssertNotError(t, err, "Failed to listen")
+	fm := &fakeMemcached{
+		listener: listener,
+		values:   make(map[string][]byte),
+		exptimes: make(map[string]int64),
+	}
+	go fm.serve()
+	return fm
+}
+
+func (fm *fakeMemcached) serve() {
+	for {
+		conn, err := fm.listener.Accept()
+		if err != nil {
+			return
+		}
+		fm.mu.Lock()
+		fm.conns++
+		fm.mu.Unlock()
+		go fm.handle(conn)
+	}
+}
+
+func (fm *fakeMemcached) handle(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 2 && fields[0] == "get":
+			fm.mu.Lock()
+			value, ok := fm.values[fields[1]]
+			fm.mu.Unlock()
+			if ok {
+				fmt.Fprintf(rw, "VALUE %s 0 %d\r\n%s\r\n", fields[1], len(value), value)
+			}
+			fmt.Fprint(rw, "END\r\n")
+		case len(fields) == 5 && fields[0] == "set":
+			exptime, _ := strconv.ParseInt(fields[3], 10, 64)
+			size, _ := strconv.Atoi(fields[4])
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(rw, data); err != nil {
+				return
+			}
+			fm.mu.Lock()
+			fm.values[fields[1]] = data[:size]
+			fm.exptimes[fields[1]] = exptime
+			fm.mu.Unlock()
+			fmt.Fprint(rw, "STORED\r\n")
+		default:
+			fmt.Fprint(rw, "ERROR\r\n")
+		}
+		if err := rw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func TestMemcacheSharedCache(t *testing.T) {
+	fm := newFakeMemcached(t)
+	defer func() { _ = fm.listener.Close() }()
+	fc := clock.NewFake()
+	fc.Set(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	m := newMemcacheSharedCache(fm.listener.Addr().String(), time.Second, 2, fc)
+
+	_, ok, err := m.Get("missing")
+	test.AssertNotError(t, err, "Get failed")
+	test.Assert(t, !ok, "Missing key found")
+
+	value := []byte("a value\r\nwith a line break")
+	test.AssertNotError(t, m.Set("key", value, 90*time.Second+time.Millisecond), "Set failed")
+	got, ok, err := m.Get("key")
+	test.AssertNotError(t, err, "Get failed")
+	test.Assert(t, ok, "Value missing")
+	test.AssertByteEquals(t, got, value)
+
+	// Expirations are rounded up to whole seconds, and long ones are sent
+	// as a Unix time
+	fm.mu.Lock()
+	test.AssertEquals(t, fm.exptimes["key"], int64(91))
+	fm.mu.Unlock()
+	test.AssertNotError(t, m.Set("long", value, 60*24*time.Hour), "Set failed")
+	fm.mu.Lock()
+	test.AssertEquals(t, fm.exptimes["long"], fc.Now().Add(60*24*time.Hour).Unix())
+	// The connection was reused for every request
+	test.AssertEquals(t, fm.conns, 1)
+	fm.mu.Unlock()
+
+	test.AssertError(t, m.Set("bad key", value, time.Minute), "Set accepted a key with a space")
+}
+
+func TestMemcacheSharedCacheUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	test.AssertNotError(t, err, "Failed to listen")
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	m := newMemcacheSharedCache(addr, time.Second, 2, clock.NewFake())
+	_, _, err = m.Get("key")
+	test.AssertError(t, err, "Get succeeded without a server")
+	test.AssertError(t, m.Set("key", []byte("value"), time.Minute), "Set succeeded without a server")
+}
+
+func TestCachingSourceMemcache(t *testing.T) {
+	fm := newFakeMemcached(t)
+	defer func() { _ = fm.listener.Close() }()
+	fc := clock.NewFake()
+	shared := newMemcacheSharedCache(fm.listener.Addr().String(), time.Second, 2, fc)
+	first, firstSelector, _ := setupCache(t, 10, shared)
+	second, secondSelector, _ := setupCache(t, 10, shared)
+	ocspReq, err := ocsp.ParseRequest(req)
+	test.AssertNotError(t, err, "Failed to parse OCSP request")
+
+	_, err = first.Response(ocspReq)
+	test.AssertNotError(t, err, "Response not found")
+	body, err := second.Response(ocspReq)
+	test.AssertNotError(t, err, "Response not found")
+	test.AssertByteEquals(t, body, resp.OCSPResponse)
+	test.AssertEquals(t, firstSelector.queries, 1)
+	test.AssertEquals(t, secondSelector.queries, 0)
+}
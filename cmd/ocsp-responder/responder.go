@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	cfocsp "github.com/cloudflare/cfssl/ocsp"
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/letsencrypt/boulder/core"
+	blog "github.com/letsencrypt/boulder/log"
+	"github.com/letsencrypt/boulder/metrics"
+)
+
+var (
+	// errNotFound is returned by a Source that has no response for the
+	// requested certificate.
+	errNotFound = errors.New("no response found")
+	// errUnknownIssuer is returned by a Source that doesn't serve responses
+	// for the requested certificate's issuer.
+	errUnknownIssuer = errors.New("unknown issuer")
+	// errInvalidResponse is returned by a Source whose stored response
+	// doesn't match the request.
+	errInvalidResponse = errors.New("stored response is invalid")
+	// errStaleResponse is returned by a Source whose stored response has
+	// passed its NextUpdate.
+	errStaleResponse = errors.New("stored response is stale")
+)
+
+// Source finds OCSP responses for requests. Unlike cfocsp.Source it says why
+// it can't provide a response, so that the responder can answer accordingly.
+type Source interface {
+	Response(*ocsp.Request) ([]byte, error)
+}
+
+// cfsslSource adapts a cfocsp.Source, such as the one read from a file of
+// responses, to a Source.
+type cfsslSource struct {
+	cfocsp.Source
+}
+
+func (s cfsslSource) Response(req *ocsp.Request) ([]byte, error) {
+	response, found := s.Source.Response(req)
+	if !found {
+		return nil, errNotFound
+	}
+	return response, nil
+}
+
+// Outcomes of an OCSP request, used both as the Outcome of the request's log
+// line and as stat names.
+const (
+	outcomeMalformed       = "Malformed"
+	outcomeUnknownIssuer   = "UnknownIssuer"
+	outcomeNotFound        = "NotFound"
+	outcomeStale           = "StaleResponse"
+	outcomeInvalidResponse = "InvalidResponse"
+	outcomeNotModified     = "NotModified"
+	outcomeSuccess         = "Success"
+)
+
+// ocspRequestEvent is logged, as JSON, once for every request the responder
+// handles. It plays the same role as the WFE's requestEvent.
+type ocspRequestEvent struct {
+	ID            string    `json:",omitempty"`
+	RealIP        string    `json:",omitempty"`
+	ClientAddr    string    `json:",omitempty"`
+	Method        string    `json:",omitempty"`
+	RequestTime   time.Time `json:",omitempty"`
+	ResponseTime  time.Time `json:",omitempty"`
+	UserAgent     string    `json:",omitempty"`
+	Serial        string    `json:",omitempty"`
+	IssuerKeyHash string    `json:",omitempty"`
+	Outcome       string
+	Errors        []string
+}
+
+func (e *ocspRequestEvent) AddError(msg string, args ...interface{}) {
+	e.Errors = append(e.Errors, fmt.Sprintf(msg, args...))
+}
+
+// ocspRequestList is the part of an OCSPRequest (RFC 6960 section 4.1.1)
+// needed to count the certificates it asks about. ocsp.ParseRequest silently
+// ignores all but the first.
+type ocspRequestList struct {
+	TBSRequest struct {
+		Version       int           `asn1:"explicit,tag:0,default:0,optional"`
+		RequestorName asn1.RawValue `asn1:"explicit,tag:1,optional"`
+		RequestList   []asn1.RawValue
+	}
+}
+
+// requestCount returns the number of certificates an OCSP request asks about.
+func requestCount(der []byte) (int, error) {
+	var req ocspRequestList
+	if _, err := asn1.Unmarshal(der, &req); err != nil {
+		return 0, err
+	}
+	return len(req.TBSRequest.RequestList), nil
+}
+
+// decodeGETRequest extracts a DER OCSP request from the path of a GET request.
+// RFC 6960 appendix A.1 calls for the URL encoding of the base64 encoding of
+// the request, but clients also send the base64 unescaped, in which case its
+// '+'s arrive as spaces, and some use the URL-safe alphabet or drop padding.
+func decodeGETRequest(path string) ([]byte, error) {
+	b64, err := url.QueryUnescape(path)
+	if err != nil {
+		return nil, err
+	}
+	b64 = strings.Replace(b64, " ", "+", -1)
+	b64 = strings.TrimRight(b64, "=")
+	if strings.ContainsAny(b64, "-_") {
+		return base64.RawURLEncoding.DecodeString(b64)
+	}
+	return base64.RawStdEncoding.DecodeString(b64)
+}
+
+// responder is an http.Handler that answers OCSP requests from a Source,
+// answering with the error response appropriate to each of the Source's
+// errors. It logs a line for every request, and counts requests by method
+// and by outcome.
+type responder struct {
+	source Source
+	clk    clock.Clock
+	stats  metrics.Scope
+	log    blog.Logger
+}
+
+func newResponder(source Source, clk clock.Clock, stats metrics.Scope, log blog.Logger) *responder {
+	return &responder{source: source, clk: clk, stats: stats, log: log}
+}
+
+func (rs *responder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logEvent := &ocspRequestEvent{
+		ID:          core.NewToken(),
+		RealIP:      r.Header.Get("X-Real-IP"),
+		ClientAddr:  getClientAddr(r),
+		Method:      r.Method,
+		RequestTime: rs.clk.Now(),
+		UserAgent:   r.Header.Get("User-Agent"),
+	}
+	defer rs.logEvent(logEvent)
+
+	// Error responses must not be cached. The header is replaced below once
+	// there's a response to be served.
+	w.Header().Set("Cache-Control", "max-age=0, no-cache")
+
+	var body []byte
+	var err error
+	switch r.Method {
+	case "GET":
+		rs.stats.Inc("Requests.GET", 1)
+		body, err = decodeGETRequest(r.URL.Path)
+		if err != nil {
+			logEvent.AddError("decoding GET request: %s", err)
+			rs.outcome(logEvent, outcomeMalformed)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	case "POST":
+		rs.stats.Inc("Requests.POST", 1)
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			logEvent.AddError("reading POST body: %s", err)
+			rs.outcome(logEvent, outcomeMalformed)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	default:
+		rs.stats.Inc("Requests.Other", 1)
+		logEvent.AddError("method not allowed")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	if count, err := requestCount(body); err != nil || count != 1 {
+		if err != nil {
+			logEvent.AddError("parsing request: %s", err)
+		} else {
+			logEvent.AddError("request is for %d certificates", count)
+		}
+		rs.outcome(logEvent, outcomeMalformed)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	req, err := ocsp.ParseRequest(body)
+	if err != nil {
+		logEvent.AddError("parsing request: %s", err)
+		rs.outcome(logEvent, outcomeMalformed)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	logEvent.Serial = core.SerialToString(req.SerialNumber)
+	logEvent.IssuerKeyHash = hex.EncodeToString(req.IssuerKeyHash)
+
+	response, err := rs.source.Response(req)
+	switch err {
+	case nil:
+	case errUnknownIssuer:
+		// RFC 6960 section 2.3: a responder that isn't authoritative for
+		// the requested certificate answers "unauthorized".
+		rs.outcome(logEvent, outcomeUnknownIssuer)
+		w.Write(ocsp.UnauthorizedErrorResponse)
+		return
+	case errStaleResponse:
+		rs.outcome(logEvent, outcomeStale)
+		w.Write(ocsp.TryLaterErrorResponse)
+		return
+	case errInvalidResponse:
+		rs.outcome(logEvent, outcomeInvalidResponse)
+		w.Write(ocsp.InternalErrorErrorResponse)
+		return
+	default:
+		rs.outcome(logEvent, outcomeNotFound)
+		w.Write(ocsp.UnauthorizedErrorResponse)
+		return
+	}
+
+	parsed, err := ocsp.ParseResponse(response, nil)
+	if err != nil {
+		logEvent.AddError("parsing stored response: %s", err)
+		rs.outcome(logEvent, outcomeInvalidResponse)
+		w.Write(ocsp.InternalErrorErrorResponse)
+		return
+	}
+
+	// Caching headers are derived from the response's own validity period,
+	// so caches never hold a response past its NextUpdate.
+	maxAge := parsed.NextUpdate.Sub(rs.clk.Now()) / time.Second
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	etag := fmt.Sprintf("\"%X\"", sha256.Sum256(response))
+	w.Header().Set("Last-Modified", parsed.ThisUpdate.Format(http.TimeFormat))
+	w.Header().Set("Expires", parsed.NextUpdate.Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public, no-transform, must-revalidate", maxAge))
+	w.Header().Set("ETag", etag)
+
+	// RFC 7232 requires a 304 to carry the headers a 200 would have, so
+	// this check has to come after they're set.
+	if r.Header.Get("If-None-Match") == etag {
+		rs.outcome(logEvent, outcomeNotModified)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	rs.outcome(logEvent, outcomeSuccess)
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+func (rs *responder) outcome(logEvent *ocspRequestEvent, outcome string) {
+	logEvent.Outcome = outcome
+	rs.stats.Inc("Outcome."+outcome, 1)
+}
+
+func (rs *responder) logEvent(logEvent *ocspRequestEvent) {
+	logEvent.ResponseTime = rs.clk.Now()
+	var msg string
+	if len(logEvent.Errors) != 0 {
+		msg = "Terminated request"
+	} else {
+		msg = "Successful request"
+	}
+	jsonEvent, err := json.Marshal(logEvent)
+	if err != nil {
+		rs.log.AuditErr(fmt.Sprintf("%s - failed to marshal logEvent - %s", msg, err))
+		return
+	}
+	rs.log.Info(fmt.Sprintf("%s JSON=%s", msg, jsonEvent))
+}
+
+// Comma-separated list of HTTP clients involved in making this request,
+// starting with the original requestor and ending with the remote end of our
+// TCP connection (which is typically our own proxy).
+func getClientAddr(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		return xff + "," + r.RemoteAddr
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	cfocsp "github.com/cloudflare/cfssl/ocsp"
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/letsencrypt/boulder/core"
+	blog "github.com/letsencrypt/boulder/log"
+	"github.com/letsencrypt/boulder/test"
+)
+
+// errorSource fails every request with the same error
+type errorSource struct {
+	err error
+}
+
+func (es errorSource) Response(*ocsp.Request) ([]byte, error) {
+	return nil, es.err
+}
+
+func TestValidateResponse(t *testing.T) {
+	src, err := makeDBSource(mockSelector{}, []string{"./testdata/test-ca.der.pem"}, blog.NewMock())
+	test.AssertNotError(t, err, "makeDBSource failed")
+	fc := clock.NewFake()
+	fc.Set(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	src.clk = fc
+	src.validate = true
+
+	ocspReq, err := ocsp.ParseRequest(req)
+	test.AssertNotError(t, err, "Failed to parse OCSP request")
+	body, err := src.Response(ocspReq)
+	test.AssertNotError(t, err, "Valid response rejected")
+	test.AssertByteEquals(t, body, resp.OCSPResponse)
+
+	// The test response's NextUpdate is 2030-08-26
+	fc.Set(time.Date(2030, 8, 26, 0, 0, 0, 0, time.UTC))
+	_, err = src.Response(ocspReq)
+	test.AssertEquals(t, err, errStaleResponse)
+
+	fc.Set(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	ocspReq.SerialNumber = big.NewInt(1)
+	_, err = src.Response(ocspReq)
+	test.AssertEquals(t, err, errInvalidResponse)
+
+	// Without validation whatever is stored is served
+	src.validate = false
+	_, err = src.Response(ocspReq)
+	test.AssertNotError(t, err, "Unvalidated response rejected")
+}
+
+func TestResponderHeaders(t *testing.T) {
+	src, err := makeDBSource(mockSelector{}, []string{"./testdata/test-ca.der.pem"}, blog.NewMock())
+	test.AssertNotError(t, err, "makeDBSource failed")
+	fc := clock.NewFake()
+	fc.Set(time.Date(2030, 8, 25, 0, 0, 0, 0, time.UTC))
+	h := newResponder(src, fc, stats, blog.NewMock())
+
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("POST", "/", bytes.NewReader(req))
+	test.AssertNotError(t, err, "NewRequest failed")
+	h.ServeHTTP(w, r)
+	test.AssertEquals(t, w.Code, http.StatusOK)
+	test.AssertByteEquals(t, w.Body.Bytes(), resp.OCSPResponse)
+	test.AssertEquals(t, w.Header().Get("Cache-Control"), "max-age=86400, public, no-transform, must-revalidate")
+	test.AssertEquals(t, w.Header().Get("Expires"), "Mon, 26 Aug 2030 00:00:00 GMT")
+	test.AssertEquals(t, w.Header().Get("Last-Modified"), "Wed, 23 Sep 2015 00:00:00 GMT")
+	etag := fmt.Sprintf("\"%X\"", sha256.Sum256(resp.OCSPResponse))
+	test.AssertEquals(t, w.Header().Get("ETag"), etag)
+
+	w = httptest.NewRecorder()
+	r, err = http.NewRequest("POST", "/", bytes.NewReader(req))
+	test.AssertNotError(t, err, "NewRequest failed")
+	r.Header.Set("If-None-Match", etag)
+	h.ServeHTTP(w, r)
+	test.AssertEquals(t, w.Code, http.StatusNotModified)
+	test.AssertEquals(t, w.Header().Get("ETag"), etag)
+}
+
+func TestResponderErrors(t *testing.T) {
+	testCases := []struct {
+		err  error
+		body []byte
+	}{
+		{errNotFound, ocsp.UnauthorizedErrorResponse},
+		{errUnknownIssuer, ocsp.UnauthorizedErrorResponse},
+		{errStaleResponse, ocsp.TryLaterErrorResponse},
+		{errInvalidResponse, ocsp.InternalErrorErrorResponse},
+	}
+	for _, tc := range testCases {
+		h := newResponder(errorSource{tc.err}, clock.NewFake(), stats, blog.NewMock())
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("POST", "/", bytes.NewReader(req))
+		test.AssertNotError(t, err, "NewRequest failed")
+		h.ServeHTTP(w, r)
+		test.AssertEquals(t, w.Code, http.StatusOK)
+		test.AssertByteEquals(t, w.Body.Bytes(), tc.body)
+		test.AssertEquals(t, w.Header().Get("Cache-Control"), "max-age=0, no-cache")
+	}
+}
+
+func TestResponderGETForms(t *testing.T) {
+	b64 := base64.StdEncoding.EncodeToString(req)
+	urlSafe := base64.RawURLEncoding.EncodeToString(req)
+	paths := []string{
+		url.QueryEscape(b64),
+		b64,
+		strings.TrimRight(b64, "="),
+		urlSafe,
+	}
+	for _, path := range paths {
+		mockLog := blog.NewMock()
+		h := mux(stats, "/", cfsslSource{cfocsp.InMemorySource{}}, mockLog)
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", "/"+path, nil)
+		test.AssertNotError(t, err, "NewRequest failed")
+		h.ServeHTTP(w, r)
+		test.AssertEquals(t, w.Code, http.StatusOK)
+		test.AssertByteEquals(t, w.Body.Bytes(), ocsp.UnauthorizedErrorResponse)
+		test.AssertEquals(t, len(mockLog.GetAllMatching(`"Outcome":"NotFound"`)), 1)
+	}
+}
+
+func TestResponderMultipleCerts(t *testing.T) {
+	var parsed ocspRequestList
+	_, err := asn1.Unmarshal(req, &parsed)
+	test.AssertNotError(t, err, "Failed to unmarshal request")
+	test.AssertEquals(t, len(parsed.TBSRequest.RequestList), 1)
+	parsed.TBSRequest.RequestList = append(parsed.TBSRequest.RequestList, parsed.TBSRequest.RequestList[0])
+	multiple, err := asn1.Marshal(parsed)
+	test.AssertNotError(t, err, "Failed to marshal request")
+	count, err := requestCount(multiple)
+	test.AssertNotError(t, err, "Failed to count requests")
+	test.AssertEquals(t, count, 2)
+
+	mockLog := blog.NewMock()
+	h := newResponder(errorSource{errNotFound}, clock.NewFake(), stats, mockLog)
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("POST", "/", bytes.NewReader(multiple))
+	test.AssertNotError(t, err, "NewRequest failed")
+	h.ServeHTTP(w, r)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	test.AssertByteEquals(t, w.Body.Bytes(), ocsp.MalformedRequestErrorResponse)
+	test.AssertEquals(t, len(mockLog.GetAllMatching(`"Outcome":"Malformed"`)), 1)
+	test.AssertEquals(t, len(mockLog.GetAllMatching("request is for 2 certificates")), 1)
+}
+
+func TestResponderLogsRequests(t *testing.T) {
+	mockLog := blog.NewMock()
+	src, err := makeDBSource(mockSelector{}, []string{"./testdata/test-ca.der.pem"}, blog.NewMock())
+	test.AssertNotError(t, err, "makeDBSource failed")
+	h := newResponder(src, clock.NewFake(), stats, mockLog)
+
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("POST", "/", bytes.NewReader(req))
+	test.AssertNotError(t, err, "NewRequest failed")
+	r.Header.Set("User-Agent", "ocsp-client")
+	h.ServeHTTP(w, r)
+	test.AssertEquals(t, w.Code, http.StatusOK)
+
+	ocspReq, err := ocsp.ParseRequest(req)
+	test.AssertNotError(t, err, "Failed to parse OCSP request")
+	lines := mockLog.GetAllMatching("Successful request JSON=")
+	test.AssertEquals(t, len(lines), 1)
+	test.AssertContains(t, lines[0], `"Outcome":"Success"`)
+	test.AssertContains(t, lines[0], `"UserAgent":"ocsp-client"`)
+	test.AssertContains(t, lines[0], fmt.Sprintf(`"Serial":"%s"`, core.SerialToString(ocspReq.SerialNumber)))
+
+	w = httptest.NewRecorder()
+	r, err = http.NewRequest("POST", "/", bytes.NewReader([]byte("not a request")))
+	test.AssertNotError(t, err, "NewRequest failed")
+	h.ServeHTTP(w, r)
+	test.AssertEquals(t, w.Code, http.StatusBadRequest)
+	test.AssertEquals(t, len(mockLog.GetAllMatching(`Terminated request JSON=.*"Outcome":"Malformed"`)), 1)
+}
@@ -4,11 +4,14 @@ import (
 	"crypto/x509"
 	"database/sql"
 	"encoding/base64"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"net/url"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jmhodges/clock"
@@ -59,6 +62,12 @@ type OCSPUpdater struct {
 
 	loops []*looper
 
+	// Maximum number of OCSP responses to sign at once
+	parallelGenerateOCSPRequests int
+	// Serial prefixes of the certificates this updater is responsible for.
+	// Empty means all certificates.
+	serialPrefixes []string
+
 	ccu    *akamai.CachePurgeClient
 	issuer *x509.Certificate
 }
@@ -87,6 +96,24 @@ func newUpdater(
 		config.MissingSCTWindow.Duration == 0 {
 		return nil, fmt.Errorf("Loop window sizes must be non-zero")
 	}
+	for _, prefix := range config.SerialPrefixes {
+		if _, err := hex.DecodeString(prefix + strings.Repeat("0", len(prefix)%2)); err != nil || prefix == "" {
+			return nil, fmt.Errorf("Serial prefix %q is not a hex string", prefix)
+		}
+	}
+	// A prefix of another prefix would have both match the same serials, which
+	// is almost certainly a mistake in splitting up the serial space
+	for i, a := range config.SerialPrefixes {
+		for j, b := range config.SerialPrefixes {
+			if i != j && strings.HasPrefix(strings.ToLower(b), strings.ToLower(a)) {
+				return nil, fmt.Errorf("Serial prefixes %q and %q overlap", a, b)
+			}
+		}
+	}
+	parallelism := config.ParallelGenerateOCSPRequests
+	if parallelism == 0 {
+		parallelism = 1
+	}
 
 	updater := OCSPUpdater{
 		stats:               stats,
@@ -99,6 +126,9 @@ func newUpdater(
 		numLogs:             numLogs,
 		ocspMinTimeToExpiry: config.OCSPMinTimeToExpiry.Duration,
 		oldestIssuedSCT:     config.OldestIssuedSCT.Duration,
+
+		parallelGenerateOCSPRequests: parallelism,
+		serialPrefixes:               config.SerialPrefixes,
 	}
 
 	// Setup loops
@@ -203,27 +233,46 @@ func (updater *OCSPUpdater) sendPurge(der []byte) {
 	}
 }
 
+// shardClause returns a SQL condition, beginning with AND, that restricts
+// serialColumn to the serial prefixes this updater is responsible for, and
+// adds the condition's parameters to args. It returns the empty string if the
+// updater is responsible for all serials.
+func (updater *OCSPUpdater) shardClause(serialColumn string, args map[string]interface{}) string {
+	if len(updater.serialPrefixes) == 0 {
+		return ""
+	}
+	var conditions []string
+	for i, prefix := range updater.serialPrefixes {
+		name := fmt.Sprintf("serialPrefix%d", i)
+		conditions = append(conditions, fmt.Sprintf("%s LIKE :%s", serialColumn, name))
+		args[name] = prefix + "%"
+	}
+	return fmt.Sprintf(" AND (%s)", strings.Join(conditions, " OR "))
+}
+
 func (updater *OCSPUpdater) findStaleOCSPResponses(oldestLastUpdatedTime time.Time, batchSize int) ([]core.CertificateStatus, error) {
 	var statuses []core.CertificateStatus
+	args := map[string]interface{}{
+		"lastUpdate": oldestLastUpdatedTime,
+		"limit":      batchSize,
+	}
 	// TODO(@cpu): Once the notafter-backfill cmd has been run & completed then
 	// the query below can be rewritten to use `AND NOT cs.isExpired`.
 	_, err := updater.dbMap.Select(
 		&statuses,
-		`SELECT
+		fmt.Sprintf(`SELECT
 			 cs.serial,
 			 cs.status,
-			 cs.revokedDate
+			 cs.revokedDate,
+			 cs.ocspLastUpdated
 			 FROM certificateStatus AS cs
 			 JOIN certificates AS cert
 			 ON cs.serial = cert.serial
 			 WHERE cs.ocspLastUpdated < :lastUpdate
-			 AND cert.expires > now()
+			 AND cert.expires > now()%s
 			 ORDER BY cs.ocspLastUpdated ASC
-			 LIMIT :limit`,
-		map[string]interface{}{
-			"lastUpdate": oldestLastUpdatedTime,
-			"limit":      batchSize,
-		},
+			 LIMIT :limit`, updater.shardClause("cs.serial", args)),
+		args,
 	)
 	if err == sql.ErrNoRows {
 		return statuses, nil
@@ -239,14 +288,15 @@ func (updater *OCSPUpdater) getCertificatesWithMissingResponses(batchSize int) (
 	} else {
 		fields = sa.CertificateStatusFields
 	}
+	args := map[string]interface{}{
+		"limit": batchSize,
+	}
 	_, err := updater.dbMap.Select(
 		&statuses,
 		fmt.Sprintf(`SELECT %s FROM certificateStatus
-			 WHERE ocspLastUpdated = 0
-			 LIMIT :limit`, fields),
-		map[string]interface{}{
-			"limit": batchSize,
-		},
+			 WHERE ocspLastUpdated = 0%s
+			 LIMIT :limit`, fields, updater.shardClause("serial", args)),
+		args,
 	)
 	if err == sql.ErrNoRows {
 		return statuses, nil
@@ -369,16 +419,17 @@ func (updater *OCSPUpdater) findRevokedCertificatesToUpdate(batchSize int) ([]co
 	} else {
 		fields = sa.CertificateStatusFields
 	}
+	args := map[string]interface{}{
+		"revoked": string(core.OCSPStatusRevoked),
+		"limit":   batchSize,
+	}
 	_, err := updater.dbMap.Select(
 		&statuses,
 		fmt.Sprintf(`SELECT %s FROM certificateStatus
 		 WHERE status = :revoked
-		 AND ocspLastUpdated <= revokedDate
-		 LIMIT :limit`, fields),
-		map[string]interface{}{
-			"revoked": string(core.OCSPStatusRevoked),
-			"limit":   batchSize,
-		},
+		 AND ocspLastUpdated <= revokedDate%s
+		 LIMIT :limit`, fields, updater.shardClause("serial", args)),
+		args,
 	)
 	return statuses, err
 }
@@ -391,7 +442,7 @@ func (updater *OCSPUpdater) revokedCertificatesTick(ctx context.Context, batchSi
 		return err
 	}
 
-	for _, status := range statuses {
+	return updater.forEachStatus(statuses, func(status core.CertificateStatus) error {
 		meta, err := updater.generateRevokedResponse(ctx, status)
 		if err != nil {
 			updater.log.AuditErr(fmt.Sprintf("Failed to generate revoked OCSP response: %s", err))
@@ -402,14 +453,51 @@ func (updater *OCSPUpdater) revokedCertificatesTick(ctx context.Context, batchSi
 		if err != nil {
 			updater.stats.Inc("Errors.StoreRevokedResponse", 1)
 			updater.log.AuditErr(fmt.Sprintf("Failed to store OCSP response: %s", err))
-			continue
 		}
+		return nil
+	})
+}
+
+// forEachStatus calls f for each of statuses, running up to
+// parallelGenerateOCSPRequests calls at once. Once a call fails no more are
+// started, and the first error is returned after those in flight finish, so
+// that a failing CA causes the looper to back off just as it would if the
+// statuses were processed one at a time.
+func (updater *OCSPUpdater) forEachStatus(statuses []core.CertificateStatus, f func(core.CertificateStatus) error) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	sem := make(chan struct{}, updater.parallelGenerateOCSPRequests)
+	for _, status := range statuses {
+		sem <- struct{}{}
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(status core.CertificateStatus) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := f(status); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(status)
 	}
-	return nil
+	wg.Wait()
+	return firstErr
 }
 
 func (updater *OCSPUpdater) generateOCSPResponses(ctx context.Context, statuses []core.CertificateStatus) error {
-	for _, status := range statuses {
+	return updater.forEachStatus(statuses, func(status core.CertificateStatus) error {
 		meta, err := updater.generateResponse(ctx, status)
 		if err != nil {
 			updater.log.AuditErr(fmt.Sprintf("Failed to generate OCSP response: %s", err))
@@ -421,23 +509,32 @@ func (updater *OCSPUpdater) generateOCSPResponses(ctx context.Context, statuses
 		if err != nil {
 			updater.log.AuditErr(fmt.Sprintf("Failed to store OCSP response: %s", err))
 			updater.stats.Inc("Errors.StoreResponse", 1)
-			continue
+			return nil
 		}
 		updater.stats.Inc("StoredResponses", 1)
-	}
-	return nil
+		return nil
+	})
 }
 
 // oldOCSPResponsesTick looks for certificates with stale OCSP responses and
 // generates/stores new ones
 func (updater *OCSPUpdater) oldOCSPResponsesTick(ctx context.Context, batchSize int) error {
 	now := time.Now()
-	statuses, err := updater.findStaleOCSPResponses(now.Add(-updater.ocspMinTimeToExpiry), batchSize)
+	oldestLastUpdated := now.Add(-updater.ocspMinTimeToExpiry)
+	statuses, err := updater.findStaleOCSPResponses(oldestLastUpdated, batchSize)
 	if err != nil {
 		updater.stats.Inc("Errors.FindStaleResponses", 1)
 		updater.log.AuditErr(fmt.Sprintf("Failed to find stale OCSP responses: %s", err))
 		return err
 	}
+	// The statuses are ordered oldest first, so the first shows how far past
+	// due the most overdue response is. If the updater is keeping up this
+	// stays close to zero.
+	var lag time.Duration
+	if len(statuses) > 0 {
+		lag = oldestLastUpdated.Sub(statuses[0].OCSPLastUpdated)
+	}
+	updater.stats.TimingDuration("OldOCSPResponses.Lag", lag)
 
 	return updater.generateOCSPResponses(ctx, statuses)
 }
@@ -446,17 +543,18 @@ func (updater *OCSPUpdater) getSerialsIssuedSince(since time.Time, batchSize int
 	var allSerials []string
 	for {
 		serials := []string{}
+		args := map[string]interface{}{
+			"since":  since,
+			"limit":  batchSize,
+			"offset": len(allSerials),
+		}
 		_, err := updater.dbMap.Select(
 			&serials,
-			`SELECT serial FROM certificates
-			 WHERE issued > :since
+			fmt.Sprintf(`SELECT serial FROM certificates
+			 WHERE issued > :since%s
 			 ORDER BY issued ASC
-			 LIMIT :limit OFFSET :offset`,
-			map[string]interface{}{
-				"since":  since,
-				"limit":  batchSize,
-				"offset": len(allSerials),
-			},
+			 LIMIT :limit OFFSET :offset`, updater.shardClause("serial", args)),
+			args,
 		)
 		if err == sql.ErrNoRows || len(serials) == 0 {
 			break
@@ -533,9 +631,13 @@ func (l *looper) tick() {
 	l.stats.Inc("Ticks", 1)
 	tickEnd := tickStart.Add(time.Since(tickStart))
 	expectedTickEnd := tickStart.Add(l.tickDur)
+	var lag time.Duration
 	if tickEnd.After(expectedTickEnd) {
 		l.stats.Inc("LongTicks", 1)
+		lag = tickEnd.Sub(expectedTickEnd)
 	}
+	// Lag is how far the loop has fallen behind its schedule on this tick
+	l.stats.TimingDuration("Lag", lag)
 
 	// After we have all the stats stuff out of the way let's check if the tick
 	// function failed, if the reason is the HSM is dead increase the length of
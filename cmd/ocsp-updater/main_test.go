@@ -4,6 +4,8 @@ import (
 	"crypto/x509"
 	"database/sql"
 	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -437,3 +439,119 @@ func TestLoopTickBackoff(t *testing.T) {
 	test.AssertEquals(t, l.failures, 0)
 	test.AssertEquals(t, l.clk.Now(), start)
 }
+
+func TestFindStaleOCSPResponsesSharded(t *testing.T) {
+	updater, sa, _, fc, cleanUp := setup(t)
+	defer cleanUp()
+
+	reg := satest.CreateWorkingRegistration(t, sa)
+	parsedCert, err := core.LoadCert("test-cert.pem")
+	test.AssertNotError(t, err, "Couldn't read test certificate")
+	_, err = sa.AddCertificate(ctx, parsedCert.Raw, reg.ID)
+	test.AssertNotError(t, err, "Couldn't add test-cert.pem")
+	serial := core.SerialToString(parsedCert.SerialNumber)
+
+	earliest := fc.Now().Add(-time.Hour)
+	updater.serialPrefixes = []string{serial[:3]}
+	certs, err := updater.findStaleOCSPResponses(earliest, 10)
+	test.AssertNotError(t, err, "Couldn't find certificate")
+	test.AssertEquals(t, len(certs), 1)
+
+	// An updater responsible for other serials doesn't see the certificate
+	other := "0"
+	if serial[0] == '0' {
+		other = "1"
+	}
+	updater.serialPrefixes = []string{other}
+	certs, err = updater.findStaleOCSPResponses(earliest, 10)
+	test.AssertNotError(t, err, "Couldn't find certificate")
+	test.AssertEquals(t, len(certs), 0)
+	statuses, err := updater.getCertificatesWithMissingResponses(10)
+	test.AssertNotError(t, err, "Couldn't get missing responses")
+	test.AssertEquals(t, len(statuses), 0)
+}
+
+func TestShardClause(t *testing.T) {
+	updater := &OCSPUpdater{}
+	args := map[string]interface{}{}
+	test.AssertEquals(t, updater.shardClause("serial", args), "")
+	test.AssertEquals(t, len(args), 0)
+
+	updater.serialPrefixes = []string{"ff0", "ff1"}
+	test.AssertEquals(t, updater.shardClause("cs.serial", args),
+		" AND (cs.serial LIKE :serialPrefix0 OR cs.serial LIKE :serialPrefix1)")
+	test.AssertEquals(t, args["serialPrefix0"], "ff0%")
+	test.AssertEquals(t, args["serialPrefix1"], "ff1%")
+}
+
+func TestNewUpdaterSerialPrefixes(t *testing.T) {
+	config := cmd.OCSPUpdaterConfig{
+		NewCertificateBatchSize: 1,
+		OldOCSPBatchSize:        1,
+		MissingSCTBatchSize:     1,
+		NewCertificateWindow:    cmd.ConfigDuration{Duration: time.Second},
+		OldOCSPWindow:           cmd.ConfigDuration{Duration: time.Second},
+		MissingSCTWindow:        cmd.ConfigDuration{Duration: time.Second},
+		SerialPrefixes:          []string{"ff0", "ff1"},
+	}
+	updater, err := newUpdater(metrics.NewNoopScope(), clock.NewFake(), nil, nil, nil, nil, config, 0, "", blog.NewMock())
+	test.AssertNotError(t, err, "Failed to create updater")
+	test.AssertEquals(t, updater.parallelGenerateOCSPRequests, 1)
+
+	for _, prefix := range []string{"", "fg", "ff%"} {
+		config.SerialPrefixes = []string{prefix}
+		_, err = newUpdater(metrics.NewNoopScope(), clock.NewFake(), nil, nil, nil, nil, config, 0, "", blog.NewMock())
+		test.AssertError(t, err, fmt.Sprintf("Accepted serial prefix %q", prefix))
+	}
+
+	// Prefixes that match some of the same serials are rejected
+	for _, prefixes := range [][]string{{"ff", "ff0"}, {"ff0", "ff"}, {"ff0", "ff0"}, {"ff0", "FF01"}} {
+		config.SerialPrefixes = prefixes
+		_, err = newUpdater(metrics.NewNoopScope(), clock.NewFake(), nil, nil, nil, nil, config, 0, "", blog.NewMock())
+		test.AssertError(t, err, fmt.Sprintf("Accepted overlapping serial prefixes %q", prefixes))
+	}
+	config.SerialPrefixes = []string{"ff0", "ff10", "ff11"}
+	_, err = newUpdater(metrics.NewNoopScope(), clock.NewFake(), nil, nil, nil, nil, config, 0, "", blog.NewMock())
+	test.AssertNotError(t, err, "Rejected disjoint serial prefixes")
+}
+
+func TestForEachStatusParallel(t *testing.T) {
+	updater := &OCSPUpdater{parallelGenerateOCSPRequests: 4}
+	statuses := make([]core.CertificateStatus, 20)
+
+	var mu sync.Mutex
+	running, maxRunning, calls := 0, 0, 0
+	err := updater.forEachStatus(statuses, func(core.CertificateStatus) error {
+		mu.Lock()
+		running++
+		calls++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	test.AssertNotError(t, err, "forEachStatus failed")
+	test.AssertEquals(t, calls, 20)
+	test.Assert(t, maxRunning <= 4, fmt.Sprintf("%d calls ran at once", maxRunning))
+}
+
+func TestForEachStatusStopsOnError(t *testing.T) {
+	updater := &OCSPUpdater{parallelGenerateOCSPRequests: 1}
+	statuses := make([]core.CertificateStatus, 10)
+
+	calls := 0
+	err := updater.forEachStatus(statuses, func(core.CertificateStatus) error {
+		calls++
+		if calls == 3 {
+			return errors.New("CA is down")
+		}
+		return nil
+	})
+	test.AssertError(t, err, "forEachStatus didn't return the error")
+	test.AssertEquals(t, calls, 3)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/jmhodges/clock"
+
+	"github.com/letsencrypt/boulder/cmd"
+	bgrpc "github.com/letsencrypt/boulder/grpc"
+	"github.com/letsencrypt/boulder/metrics"
+	"github.com/letsencrypt/boulder/ratelimit"
+	rlPB "github.com/letsencrypt/boulder/ratelimit/proto"
+)
+
+const clientName = "RequestLimiter"
+
+// The request limiter holds the token buckets used by the WFEs to enforce
+// per-endpoint request limits, so that those limits apply across all WFEs
+// rather than to each one separately. The limits themselves are configured in
+// the WFEs.
+type config struct {
+	RequestLimiter struct {
+		cmd.ServiceConfig
+	}
+
+	Statsd cmd.StatsdConfig
+
+	Syslog cmd.SyslogConfig
+}
+
+func main() {
+	configFile := flag.String("config", "", "File path to the configuration file for this service")
+	flag.Parse()
+	if *configFile == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var c config
+	err := cmd.ReadConfigFile(*configFile, &c)
+	cmd.FailOnError(err, "Reading JSON config file into config structure")
+
+	go cmd.DebugServer(c.RequestLimiter.DebugAddr)
+
+	stats, logger := cmd.StatsAndLogging(c.Statsd, c.Syslog)
+	scope := metrics.NewStatsdScope(stats, "RequestLimiter")
+	defer logger.AuditPanic()
+	logger.Info(cmd.VersionString(clientName))
+
+	if c.RequestLimiter.GRPC == nil {
+		logger.AuditErr("No gRPC server configuration provided")
+		os.Exit(1)
+	}
+
+	go cmd.ProfileCmd(scope)
+
+	s, l, err := bgrpc.NewServer(c.RequestLimiter.GRPC, scope)
+	cmd.FailOnError(err, "Failed to setup gRPC server")
+	gw := bgrpc.NewBucketStoreServer(ratelimit.NewMemoryBucketStore(clock.Default()))
+	rlPB.RegisterBucketStoreServer(s, gw)
+	err = s.Serve(l)
+	cmd.FailOnError(err, "gRPC service failed")
+}
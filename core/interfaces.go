@@ -104,9 +104,15 @@ type StorageGetter interface {
 	CountCertificatesByNames(ctx context.Context, domains []string, earliest, latest time.Time) (countByDomain map[string]int, err error)
 	CountRegistrationsByIP(ctx context.Context, ip net.IP, earliest, latest time.Time) (int, error)
 	CountPendingAuthorizations(ctx context.Context, regID int64) (int, error)
+	CountInvalidAuthorizations(ctx context.Context, regID int64, hostname string, earliest, latest time.Time) (int, error)
 	GetSCTReceipt(ctx context.Context, serial, logID string) (SignedCertificateTimestamp, error)
 	CountFQDNSets(ctx context.Context, window time.Duration, domains []string) (count int64, err error)
 	FQDNSetExists(ctx context.Context, domains []string) (exists bool, err error)
+	GetSerialsByRegistration(ctx context.Context, regID int64, after string, limit int) (serials []string, err error)
+	GetAuthorizationIDsByRegistration(ctx context.Context, regID int64, after string, limit int) (ids []string, err error)
+	KeyBlocked(ctx context.Context, keyHash []byte) (bool, error)
+	EmailOptedOut(ctx context.Context, email string) (bool, error)
+	EmailUndeliverable(ctx context.Context, email string) (bool, error)
 }
 
 // StorageAdder are the Boulder SA's write/update methods
@@ -122,6 +128,11 @@ type StorageAdder interface {
 	RevokeAuthorizationsByDomain(ctx context.Context, domain AcmeIdentifier) (finalized, pending int64, err error)
 	DeactivateRegistration(ctx context.Context, id int64) error
 	DeactivateAuthorization(ctx context.Context, id string) error
+	AddBlockedKey(ctx context.Context, keyHash []byte, added time.Time, source string) error
+	AddEmailOptOut(ctx context.Context, regID int64, email string, added time.Time) error
+	AddUndeliverableEmail(ctx context.Context, email string, reason string, added time.Time) error
+	RemoveUndeliverableEmail(ctx context.Context, email string) error
+	AddWebhookDelivery(ctx context.Context, delivery WebhookDelivery) error
 }
 
 // StorageAuthority interface represents a simple key/value
@@ -136,3 +147,10 @@ type StorageAuthority interface {
 type Publisher interface {
 	SubmitToCT(ctx context.Context, der []byte) error
 }
+
+// NonceService defines the public interface for the Boulder nonce service,
+// which issues and redeems anti-replay nonces on behalf of the WFE
+type NonceService interface {
+	Nonce(ctx context.Context) (string, error)
+	Redeem(ctx context.Context, nonce string) (bool, error)
+}
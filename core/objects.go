@@ -574,3 +574,18 @@ type GPDNSResponse struct {
 	Answer  []GPDNSAnswer `json:"Answer"`
 	Comment string        `json:"Comment"`
 }
+
+// WebhookDelivery records the outcome of delivering an event to one of a
+// registration's webhook contacts
+type WebhookDelivery struct {
+	RegistrationID int64
+	URL            string
+	EventType      string
+	// Attempts is the number of times delivery was tried
+	Attempts int
+	// StatusCode is the HTTP status of the last attempt, or 0 if the last
+	// attempt got no response
+	StatusCode int
+	Delivered  bool
+	Created    time.Time
+}
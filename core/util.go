@@ -25,6 +25,7 @@ import (
 	blog "github.com/letsencrypt/boulder/log"
 	"github.com/letsencrypt/boulder/probs"
 	jose "github.com/square/go-jose"
+	"golang.org/x/crypto/ed25519"
 )
 
 // Package Variables Variables
@@ -187,7 +188,7 @@ func KeyDigest(key crypto.PublicKey) (string, error) {
 	case jose.JsonWebKey:
 		return KeyDigest(t.Key)
 	default:
-		keyDER, err := x509.MarshalPKIXPublicKey(key)
+		keyDER, err := MarshalPKIXPublicKey(key)
 		if err != nil {
 			logger := blog.Get()
 			logger.Debug(fmt.Sprintf("Problem marshaling public key: %s", err))
@@ -198,6 +199,22 @@ func KeyDigest(key crypto.PublicKey) (string, error) {
 	}
 }
 
+// ed25519SPKIPrefix is the DER encoding of an Ed25519 SubjectPublicKeyInfo
+// (RFC 8410) up to the key itself
+var ed25519SPKIPrefix = []byte{0x30, 0x2a, 0x30, 0x05, 0x06, 0x03, 0x2b, 0x65, 0x70, 0x03, 0x21, 0x00}
+
+// MarshalPKIXPublicKey is x509.MarshalPKIXPublicKey, extended to the Ed25519
+// keys that accounts may use, which crypto/x509 doesn't know about.
+func MarshalPKIXPublicKey(key crypto.PublicKey) ([]byte, error) {
+	if k, ok := key.(ed25519.PublicKey); ok {
+		if len(k) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Ed25519 key has wrong length: %d", len(k))
+		}
+		return append(append([]byte{}, ed25519SPKIPrefix...), k...), nil
+	}
+	return x509.MarshalPKIXPublicKey(key)
+}
+
 // KeyDigestEquals determines whether two public keys have the same digest.
 func KeyDigestEquals(j, k crypto.PublicKey) bool {
 	digestJ, errJ := KeyDigest(j)
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"crypto"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"math"
@@ -10,6 +12,7 @@ import (
 	"testing"
 
 	"github.com/square/go-jose"
+	"golang.org/x/crypto/ed25519"
 
 	"github.com/letsencrypt/boulder/probs"
 	"github.com/letsencrypt/boulder/test"
@@ -88,6 +91,51 @@ func TestKeyDigest(t *testing.T) {
 	test.Assert(t, err != nil, "Should have rejected unknown key type")
 }
 
+func TestKeyDigestEd25519(t *testing.T) {
+	// The example key of RFC 8410, section 10.1
+	spki, _ := base64.StdEncoding.DecodeString("MCowBQYDK2VwAyEAGb9ECWmEzf6FQbrBZ9w7lshQhqowtrbLDFw4rXAxZuE=")
+	key := ed25519.PublicKey(spki[len(spki)-ed25519.PublicKeySize:])
+	der, err := MarshalPKIXPublicKey(key)
+	test.AssertNotError(t, err, "Failed to marshal Ed25519 key")
+	test.AssertByteEquals(t, der, spki)
+	_, err = KeyDigest(key)
+	test.AssertNotError(t, err, "Failed to digest Ed25519 key")
+
+	_, err = MarshalPKIXPublicKey(key[:16])
+	test.AssertError(t, err, "Marshaled short Ed25519 key")
+}
+
+func TestEd25519JWS(t *testing.T) {
+	// The example key and signature of RFC 8037, appendix A
+	var jwk jose.JsonWebKey
+	err := json.Unmarshal([]byte(`{"kty":"OKP","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`), &jwk)
+	test.AssertNotError(t, err, "Failed to parse OKP JWK")
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	test.AssertNotError(t, err, "Failed to compute thumbprint")
+	test.AssertEquals(t, base64.RawURLEncoding.EncodeToString(thumbprint), "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k")
+
+	jws, err := jose.ParseSigned("eyJhbGciOiJFZERTQSJ9.RXhhbXBsZSBvZiBFZDI1NTE5IHNpZ25pbmc.hgyY0il_MGCjP0JzlnLWG1PPOt7-09PGcvMg3AIbQR6dWbhijcNR4ki4iylGjg5BhVsPt9g7sVvpAr_MuM0KAg")
+	test.AssertNotError(t, err, "Failed to parse JWS")
+	payload, err := jws.Verify(&jwk)
+	test.AssertNotError(t, err, "Failed to verify EdDSA signature")
+	test.AssertEquals(t, string(payload), "Example of Ed25519 signing")
+
+	// A private key round trips, and signs for its public key
+	_, private, err := ed25519.GenerateKey(nil)
+	test.AssertNotError(t, err, "Failed to generate key")
+	privateJSON, err := json.Marshal(jose.JsonWebKey{Key: private})
+	test.AssertNotError(t, err, "Failed to marshal private key")
+	var parsed jose.JsonWebKey
+	test.AssertNotError(t, json.Unmarshal(privateJSON, &parsed), "Failed to parse private key")
+	test.AssertByteEquals(t, parsed.Key.(ed25519.PrivateKey), private)
+	signer, err := jose.NewSigner(jose.EdDSA, private)
+	test.AssertNotError(t, err, "Failed to create signer")
+	signed, err := signer.Sign([]byte("payload"))
+	test.AssertNotError(t, err, "Failed to sign")
+	_, err = signed.Verify(private.Public())
+	test.AssertNotError(t, err, "Failed to verify own signature")
+}
+
 func TestKeyDigestEquals(t *testing.T) {
 	var jwk1, jwk2 jose.JsonWebKey
 	err := json.Unmarshal([]byte(JWK1JSON), &jwk1)
@@ -10,6 +10,8 @@ import (
 	"strings"
 	"testing"
 
+	"golang.org/x/net/context"
+
 	"github.com/letsencrypt/boulder/core"
 	"github.com/letsencrypt/boulder/goodkey"
 	"github.com/letsencrypt/boulder/test"
@@ -143,11 +145,34 @@ func TestVerifyCSR(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		err := VerifyCSR(c.csr, c.maxNames, c.keyPolicy, c.pa, false, c.regID)
+		err := VerifyCSR(context.Background(), c.csr, c.maxNames, c.keyPolicy, c.pa, false, c.regID)
 		test.AssertDeepEquals(t, c.expectedError, err)
 	}
 }
 
+func TestVerifyCSRBlockedKey(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.AssertNotError(t, err, "error generating test key")
+	signedReqBytes, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{PublicKey: private.PublicKey, SignatureAlgorithm: x509.SHA256WithRSA}, private)
+	test.AssertNotError(t, err, "error generating test CSR")
+	signedReq, err := x509.ParseCertificateRequest(signedReqBytes)
+	test.AssertNotError(t, err, "error parsing test CSR")
+
+	policy := *testingPolicy
+	policy.BlockedKeyCheck = func(context.Context, []byte) (bool, error) {
+		return true, nil
+	}
+	err = VerifyCSR(context.Background(), signedReq, 100, &policy, &mockPA{}, false, 0)
+	test.AssertEquals(t, err.Error(), "invalid public key in CSR: Key is blocked from use")
+
+	policy.BlockedKeyCheck = func(context.Context, []byte) (bool, error) {
+		return false, errors.New("database is down")
+	}
+	err = VerifyCSR(context.Background(), signedReq, 100, &policy, &mockPA{}, false, 0)
+	_, ok := err.(core.InternalServerError)
+	test.Assert(t, ok, "Failed blocked key check wasn't an internal error")
+}
+
 func TestNormalizeCSR(t *testing.T) {
 	cases := []struct {
 		csr           *x509.CertificateRequest
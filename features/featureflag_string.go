@@ -4,9 +4,9 @@ package features
 
 import "fmt"
 
-const _FeatureFlag_name = "unusedAllowAccountDeactivationCertStatusOptimizationsMigrated"
+const _FeatureFlag_name = "unusedAllowAccountDeactivationCertStatusOptimizationsMigratedStoreKeyHashesBlockedKeyTableUndeliverableEmailsWebhookContacts"
 
-var _FeatureFlag_index = [...]uint8{0, 6, 30, 61}
+var _FeatureFlag_index = [...]uint8{0, 6, 30, 61, 75, 90, 109, 124}
 
 func (i FeatureFlag) String() string {
 	if i < 0 || i >= FeatureFlag(len(_FeatureFlag_index)-1) {
@@ -14,6 +14,19 @@ const (
 	unused FeatureFlag = iota // unused is used for testing
 	AllowAccountDeactivation
 	CertStatusOptimizationsMigrated
+	// StoreKeyHashes enables storing the SPKI hash of every issued
+	// certificate in the keyHashToSerial table
+	StoreKeyHashes
+	// BlockedKeyTable enables rejecting keys listed in the blockedKeys table,
+	// and adding keys to it when certificates are revoked for keyCompromise
+	BlockedKeyTable
+	// UndeliverableEmails enables skipping contact addresses listed in the
+	// undeliverableEmails table in the mailers, and warning about them when
+	// registrations are created or updated
+	UndeliverableEmails
+	// WebhookContacts enables https: webhook contacts on registrations, which
+	// are sent signed notifications of account events
+	WebhookContacts
 )
 
 // List of features and their default value, protected by fMu
@@ -21,6 +34,10 @@ var features = map[FeatureFlag]bool{
 	unused: false,
 	AllowAccountDeactivation:        false,
 	CertStatusOptimizationsMigrated: false,
+	StoreKeyHashes:                  false,
+	BlockedKeyTable:                 false,
+	UndeliverableEmails:             false,
+	WebhookContacts:                 false,
 }
 
 var fMu = new(sync.RWMutex)
@@ -5,11 +5,15 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rsa"
+	"crypto/sha256"
 	"fmt"
 	"math/big"
 	"reflect"
 	"sync"
 
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/net/context"
+
 	"github.com/letsencrypt/boulder/core"
 )
 
@@ -35,12 +39,25 @@ var (
 	smallPrimes          []*big.Int
 )
 
+// BlockedKeyCheckFunc reports whether the key whose SubjectPublicKeyInfo has
+// the given SHA-256 hash has been blocked from use, for instance because it is
+// known to be compromised.
+type BlockedKeyCheckFunc func(ctx context.Context, keyHash []byte) (bool, error)
+
 // KeyPolicy determines which types of key may be used with various boulder
 // operations.
 type KeyPolicy struct {
 	AllowRSA           bool // Whether RSA keys should be allowed.
 	AllowECDSANISTP256 bool // Whether ECDSA NISTP256 keys should be allowed.
 	AllowECDSANISTP384 bool // Whether ECDSA NISTP384 keys should be allowed.
+	AllowECDSANISTP521 bool // Whether ECDSA NISTP521 keys should be allowed.
+	AllowEd25519       bool // Whether Ed25519 keys should be allowed.
+	// BlockedKeyCheck, if set, is consulted for keys that otherwise pass the
+	// policy, and keys it reports as blocked are rejected.
+	BlockedKeyCheck BlockedKeyCheckFunc
+	// WeakRSAKeys, if set, is a blocklist of Debian weak keys. RSA keys on it
+	// are rejected.
+	WeakRSAKeys *WeakRSAKeys
 }
 
 // NewKeyPolicy returns a KeyPolicy that allows RSA, ECDSA256 and ECDSA384.
@@ -55,20 +72,54 @@ func NewKeyPolicy() KeyPolicy {
 // GoodKey returns true if the key is acceptable for both TLS use and account
 // key use (our requirements are the same for either one), according to basic
 // strength and algorithm checking.
+// If the policy has a BlockedKeyCheck, a failure to consult it is returned as
+// a core.InternalServerError.
 // TODO: Support JsonWebKeys once go-jose migration is done.
-func (policy *KeyPolicy) GoodKey(key crypto.PublicKey) error {
+func (policy *KeyPolicy) GoodKey(ctx context.Context, key crypto.PublicKey) error {
+	var err error
 	switch t := key.(type) {
 	case rsa.PublicKey:
-		return policy.goodKeyRSA(t)
+		err = policy.goodKeyRSA(t)
+		key = &t
 	case *rsa.PublicKey:
-		return policy.goodKeyRSA(*t)
+		err = policy.goodKeyRSA(*t)
 	case ecdsa.PublicKey:
-		return policy.goodKeyECDSA(t)
+		err = policy.goodKeyECDSA(t)
+		key = &t
 	case *ecdsa.PublicKey:
-		return policy.goodKeyECDSA(*t)
+		err = policy.goodKeyECDSA(*t)
+	case ed25519.PublicKey:
+		err = policy.goodKeyEd25519(t)
 	default:
 		return core.MalformedRequestError(fmt.Sprintf("Unknown key type %s", reflect.TypeOf(key)))
 	}
+	if err != nil {
+		return err
+	}
+	return policy.CheckBlocked(ctx, key)
+}
+
+// CheckBlocked rejects keys that the policy's BlockedKeyCheck reports as
+// blocked. RSA and ECDSA keys must be pointer types, as
+// x509.MarshalPKIXPublicKey requires. Like GoodKey, a failure to consult the
+// BlockedKeyCheck is returned as a core.InternalServerError.
+func (policy *KeyPolicy) CheckBlocked(ctx context.Context, key crypto.PublicKey) error {
+	if policy.BlockedKeyCheck == nil {
+		return nil
+	}
+	der, err := core.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return core.MalformedRequestError(fmt.Sprintf("Unable to marshal key: %s", err))
+	}
+	keyHash := sha256.Sum256(der)
+	blocked, err := policy.BlockedKeyCheck(ctx, keyHash[:])
+	if err != nil {
+		return core.InternalServerError(fmt.Sprintf("Unable to check whether key is blocked: %s", err))
+	}
+	if blocked {
+		return core.MalformedRequestError("Key is blocked from use")
+	}
+	return nil
 }
 
 // GoodKeyECDSA determines if an ECDSA pubkey meets our requirements
@@ -177,11 +228,24 @@ func (policy *KeyPolicy) goodCurve(c elliptic.Curve) (err error) {
 		return nil
 	case policy.AllowECDSANISTP384 && params == elliptic.P384().Params():
 		return nil
+	case policy.AllowECDSANISTP521 && params == elliptic.P521().Params():
+		return nil
 	default:
 		return core.MalformedRequestError(fmt.Sprintf("ECDSA curve %v not allowed", params.Name))
 	}
 }
 
+// goodKeyEd25519 determines if an Ed25519 pubkey meets our requirements
+func (policy *KeyPolicy) goodKeyEd25519(key ed25519.PublicKey) error {
+	if !policy.AllowEd25519 {
+		return core.MalformedRequestError("Ed25519 keys are not allowed")
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return core.MalformedRequestError(fmt.Sprintf("Ed25519 key has wrong length: %d", len(key)))
+	}
+	return nil
+}
+
 // GoodKeyRSA determines if a RSA pubkey meets our requirements
 func (policy *KeyPolicy) goodKeyRSA(key rsa.PublicKey) (err error) {
 	if !policy.AllowRSA {
@@ -219,6 +283,12 @@ func (policy *KeyPolicy) goodKeyRSA(key rsa.PublicKey) (err error) {
 	if checkSmallPrimes(modulus) {
 		return core.MalformedRequestError("Key divisible by small prime")
 	}
+	if hasROCAFingerprint(modulus) {
+		return core.MalformedRequestError("Key generated by vulnerable Infineon-based hardware")
+	}
+	if policy.WeakRSAKeys != nil && policy.WeakRSAKeys.Known(&key) {
+		return core.MalformedRequestError("Key is on the Debian weak key blocklist")
+	}
 
 	return nil
 }
@@ -5,9 +5,16 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
 	"math/big"
 	"testing"
 
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/net/context"
+
+	"github.com/letsencrypt/boulder/core"
 	"github.com/letsencrypt/boulder/test"
 )
 
@@ -19,21 +26,21 @@ var testingPolicy = &KeyPolicy{
 
 func TestUnknownKeyType(t *testing.T) {
 	notAKey := struct{}{}
-	test.AssertError(t, testingPolicy.GoodKey(notAKey), "Should have rejected a key of unknown type")
+	test.AssertError(t, testingPolicy.GoodKey(context.Background(), notAKey), "Should have rejected a key of unknown type")
 }
 
 func TestSmallModulus(t *testing.T) {
 	private, err := rsa.GenerateKey(rand.Reader, 2040)
 	test.AssertNotError(t, err, "Error generating key")
-	test.AssertError(t, testingPolicy.GoodKey(&private.PublicKey), "Should have rejected too-short key.")
-	test.AssertError(t, testingPolicy.GoodKey(private.PublicKey), "Should have rejected too-short key.")
+	test.AssertError(t, testingPolicy.GoodKey(context.Background(), &private.PublicKey), "Should have rejected too-short key.")
+	test.AssertError(t, testingPolicy.GoodKey(context.Background(), private.PublicKey), "Should have rejected too-short key.")
 }
 
 func TestLargeModulus(t *testing.T) {
 	private, err := rsa.GenerateKey(rand.Reader, 4097)
 	test.AssertNotError(t, err, "Error generating key")
-	test.AssertError(t, testingPolicy.GoodKey(&private.PublicKey), "Should have rejected too-long key.")
-	test.AssertError(t, testingPolicy.GoodKey(private.PublicKey), "Should have rejected too-long key.")
+	test.AssertError(t, testingPolicy.GoodKey(context.Background(), &private.PublicKey), "Should have rejected too-long key.")
+	test.AssertError(t, testingPolicy.GoodKey(context.Background(), private.PublicKey), "Should have rejected too-long key.")
 }
 
 func TestModulusModulo8(t *testing.T) {
@@ -42,7 +49,7 @@ func TestModulusModulo8(t *testing.T) {
 		N: bigOne.Lsh(bigOne, 2049),
 		E: 5,
 	}
-	test.AssertError(t, testingPolicy.GoodKey(&key), "Should have rejected modulus with length not divisible by 8.")
+	test.AssertError(t, testingPolicy.GoodKey(context.Background(), &key), "Should have rejected modulus with length not divisible by 8.")
 }
 
 func TestSmallExponent(t *testing.T) {
@@ -51,7 +58,7 @@ func TestSmallExponent(t *testing.T) {
 		N: bigOne.Lsh(bigOne, 2048),
 		E: 5,
 	}
-	test.AssertError(t, testingPolicy.GoodKey(&key), "Should have rejected small exponent.")
+	test.AssertError(t, testingPolicy.GoodKey(context.Background(), &key), "Should have rejected small exponent.")
 }
 
 func TestEvenExponent(t *testing.T) {
@@ -60,7 +67,7 @@ func TestEvenExponent(t *testing.T) {
 		N: bigOne.Lsh(bigOne, 2048),
 		E: 1 << 17,
 	}
-	test.AssertError(t, testingPolicy.GoodKey(&key), "Should have rejected even exponent.")
+	test.AssertError(t, testingPolicy.GoodKey(context.Background(), &key), "Should have rejected even exponent.")
 }
 
 func TestEvenModulus(t *testing.T) {
@@ -69,7 +76,7 @@ func TestEvenModulus(t *testing.T) {
 		N: bigOne.Lsh(bigOne, 2048),
 		E: (1 << 17) + 1,
 	}
-	test.AssertError(t, testingPolicy.GoodKey(&key), "Should have rejected even modulus.")
+	test.AssertError(t, testingPolicy.GoodKey(context.Background(), &key), "Should have rejected even modulus.")
 }
 
 func TestModulusDivisibleBy752(t *testing.T) {
@@ -81,22 +88,48 @@ func TestModulusDivisibleBy752(t *testing.T) {
 		N: N,
 		E: (1 << 17) + 1,
 	}
-	test.AssertError(t, testingPolicy.GoodKey(&key), "Should have rejected modulus divisible by 751.")
+	test.AssertError(t, testingPolicy.GoodKey(context.Background(), &key), "Should have rejected modulus divisible by 751.")
 }
 
 func TestGoodKey(t *testing.T) {
 	private, err := rsa.GenerateKey(rand.Reader, 2048)
 	test.AssertNotError(t, err, "Error generating key")
-	test.AssertNotError(t, testingPolicy.GoodKey(&private.PublicKey), "Should have accepted good key.")
+	test.AssertNotError(t, testingPolicy.GoodKey(context.Background(), &private.PublicKey), "Should have accepted good key.")
 }
 
 func TestECDSABadCurve(t *testing.T) {
 	for _, curve := range invalidCurves {
 		private, err := ecdsa.GenerateKey(curve, rand.Reader)
 		test.AssertNotError(t, err, "Error generating key")
-		test.AssertError(t, testingPolicy.GoodKey(&private.PublicKey), "Should have rejected key with unsupported curve.")
-		test.AssertError(t, testingPolicy.GoodKey(private.PublicKey), "Should have rejected key with unsupported curve.")
+		test.AssertError(t, testingPolicy.GoodKey(context.Background(), &private.PublicKey), "Should have rejected key with unsupported curve.")
+		test.AssertError(t, testingPolicy.GoodKey(context.Background(), private.PublicKey), "Should have rejected key with unsupported curve.")
+	}
+}
+
+func TestECDSAP521(t *testing.T) {
+	private, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	test.AssertNotError(t, err, "Error generating key")
+	policy := *testingPolicy
+	policy.AllowECDSANISTP521 = true
+	test.AssertNotError(t, policy.GoodKey(context.Background(), &private.PublicKey), "Should have accepted P-521 key.")
+	test.AssertNotError(t, policy.GoodKey(context.Background(), private.PublicKey), "Should have accepted P-521 key.")
+}
+
+func TestEd25519(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	test.AssertNotError(t, err, "Error generating key")
+	test.AssertError(t, testingPolicy.GoodKey(context.Background(), public), "Should have rejected Ed25519 key.")
+
+	policy := *testingPolicy
+	policy.AllowEd25519 = true
+	test.AssertNotError(t, policy.GoodKey(context.Background(), public), "Should have accepted Ed25519 key.")
+	test.AssertError(t, policy.GoodKey(context.Background(), public[:16]), "Should have rejected short Ed25519 key.")
+
+	// Blocked key checks apply to Ed25519 keys too
+	policy.BlockedKeyCheck = func(context.Context, []byte) (bool, error) {
+		return true, nil
 	}
+	test.AssertError(t, policy.GoodKey(context.Background(), public), "Should have rejected blocked Ed25519 key.")
 }
 
 var invalidCurves = []elliptic.Curve{
@@ -113,8 +146,8 @@ func TestECDSAGoodKey(t *testing.T) {
 	for _, curve := range validCurves {
 		private, err := ecdsa.GenerateKey(curve, rand.Reader)
 		test.AssertNotError(t, err, "Error generating key")
-		test.AssertNotError(t, testingPolicy.GoodKey(&private.PublicKey), "Should have accepted good key.")
-		test.AssertNotError(t, testingPolicy.GoodKey(private.PublicKey), "Should have accepted good key.")
+		test.AssertNotError(t, testingPolicy.GoodKey(context.Background(), &private.PublicKey), "Should have accepted good key.")
+		test.AssertNotError(t, testingPolicy.GoodKey(context.Background(), private.PublicKey), "Should have accepted good key.")
 	}
 }
 
@@ -125,8 +158,8 @@ func TestECDSANotOnCurveX(t *testing.T) {
 		test.AssertNotError(t, err, "Error generating key")
 
 		private.X.Add(private.X, big.NewInt(1))
-		test.AssertError(t, testingPolicy.GoodKey(&private.PublicKey), "Should not have accepted key not on the curve.")
-		test.AssertError(t, testingPolicy.GoodKey(private.PublicKey), "Should not have accepted key not on the curve.")
+		test.AssertError(t, testingPolicy.GoodKey(context.Background(), &private.PublicKey), "Should not have accepted key not on the curve.")
+		test.AssertError(t, testingPolicy.GoodKey(context.Background(), private.PublicKey), "Should not have accepted key not on the curve.")
 	}
 }
 
@@ -138,8 +171,8 @@ func TestECDSANotOnCurveY(t *testing.T) {
 
 		// Change the public key so that it is no longer on the curve.
 		private.Y.Add(private.Y, big.NewInt(1))
-		test.AssertError(t, testingPolicy.GoodKey(&private.PublicKey), "Should not have accepted key not on the curve.")
-		test.AssertError(t, testingPolicy.GoodKey(private.PublicKey), "Should not have accepted key not on the curve.")
+		test.AssertError(t, testingPolicy.GoodKey(context.Background(), &private.PublicKey), "Should not have accepted key not on the curve.")
+		test.AssertError(t, testingPolicy.GoodKey(context.Background(), private.PublicKey), "Should not have accepted key not on the curve.")
 	}
 }
 
@@ -150,14 +183,14 @@ func TestECDSANegative(t *testing.T) {
 		test.AssertNotError(t, err, "Error generating key")
 
 		private.X.Neg(private.X)
-		test.AssertError(t, testingPolicy.GoodKey(&private.PublicKey), "Should not have accepted key with negative X.")
-		test.AssertError(t, testingPolicy.GoodKey(private.PublicKey), "Should not have accepted key with negative X.")
+		test.AssertError(t, testingPolicy.GoodKey(context.Background(), &private.PublicKey), "Should not have accepted key with negative X.")
+		test.AssertError(t, testingPolicy.GoodKey(context.Background(), private.PublicKey), "Should not have accepted key with negative X.")
 
 		// Check that negative Y is not accepted.
 		private.X.Neg(private.X)
 		private.Y.Neg(private.Y)
-		test.AssertError(t, testingPolicy.GoodKey(&private.PublicKey), "Should not have accepted key with negative Y.")
-		test.AssertError(t, testingPolicy.GoodKey(private.PublicKey), "Should not have accepted key with negative Y.")
+		test.AssertError(t, testingPolicy.GoodKey(context.Background(), &private.PublicKey), "Should not have accepted key with negative Y.")
+		test.AssertError(t, testingPolicy.GoodKey(context.Background(), private.PublicKey), "Should not have accepted key with negative Y.")
 	}
 }
 
@@ -168,8 +201,8 @@ func TestECDSANegativeUnmodulatedX(t *testing.T) {
 		test.AssertNotError(t, err, "Error generating key")
 
 		private.X.Mul(private.X, private.Curve.Params().P)
-		test.AssertError(t, testingPolicy.GoodKey(&private.PublicKey), "Should not have accepted key with unmodulated X.")
-		test.AssertError(t, testingPolicy.GoodKey(private.PublicKey), "Should not have accepted key with unmodulated X.")
+		test.AssertError(t, testingPolicy.GoodKey(context.Background(), &private.PublicKey), "Should not have accepted key with unmodulated X.")
+		test.AssertError(t, testingPolicy.GoodKey(context.Background(), private.PublicKey), "Should not have accepted key with unmodulated X.")
 	}
 }
 
@@ -180,8 +213,8 @@ func TestECDSANegativeUnmodulatedY(t *testing.T) {
 		test.AssertNotError(t, err, "Error generating key")
 
 		private.X.Mul(private.Y, private.Curve.Params().P)
-		test.AssertError(t, testingPolicy.GoodKey(&private.PublicKey), "Should not have accepted key with unmodulated Y.")
-		test.AssertError(t, testingPolicy.GoodKey(private.PublicKey), "Should not have accepted key with unmodulated Y.")
+		test.AssertError(t, testingPolicy.GoodKey(context.Background(), &private.PublicKey), "Should not have accepted key with unmodulated Y.")
+		test.AssertError(t, testingPolicy.GoodKey(context.Background(), private.PublicKey), "Should not have accepted key with unmodulated Y.")
 	}
 }
 
@@ -194,7 +227,37 @@ func TestECDSAIdentity(t *testing.T) {
 			Y:     big.NewInt(0),
 		}
 
-		test.AssertError(t, testingPolicy.GoodKey(&public), "Should not have accepted key with point at infinity.")
-		test.AssertError(t, testingPolicy.GoodKey(public), "Should not have accepted key with point at infinity.")
+		test.AssertError(t, testingPolicy.GoodKey(context.Background(), &public), "Should not have accepted key with point at infinity.")
+		test.AssertError(t, testingPolicy.GoodKey(context.Background(), public), "Should not have accepted key with point at infinity.")
+	}
+}
+
+func TestBlockedKey(t *testing.T) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "Error generating key")
+	der, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	test.AssertNotError(t, err, "Error marshalling key")
+	blockedHash := sha256.Sum256(der)
+
+	policy := *testingPolicy
+	policy.BlockedKeyCheck = func(_ context.Context, keyHash []byte) (bool, error) {
+		return string(keyHash) == string(blockedHash[:]), nil
+	}
+	err = policy.GoodKey(context.Background(), &private.PublicKey)
+	test.AssertError(t, err, "Should have rejected blocked key.")
+	_, ok := err.(core.MalformedRequestError)
+	test.Assert(t, ok, "Blocked key wasn't rejected as malformed")
+	test.AssertError(t, policy.GoodKey(context.Background(), private.PublicKey), "Should have rejected blocked key.")
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "Error generating key")
+	test.AssertNotError(t, policy.GoodKey(context.Background(), &other.PublicKey), "Should have accepted unblocked key.")
+
+	policy.BlockedKeyCheck = func(context.Context, []byte) (bool, error) {
+		return false, errors.New("database is down")
 	}
+	err = policy.GoodKey(context.Background(), &other.PublicKey)
+	test.AssertError(t, err, "Should have failed when the check failed.")
+	_, ok = err.(core.InternalServerError)
+	test.Assert(t, ok, "Failed check wasn't an internal error")
 }
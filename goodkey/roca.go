@@ -0,0 +1,56 @@
+package goodkey
+
+import (
+	"math/big"
+	"sync"
+)
+
+// rocaPrimes are the primes whose product divides the modulus M used to
+// generate primes by the vulnerable Infineon RSA library (CVE-2017-15361,
+// "ROCA"). Every prime it generates is of the form k*M + (65537^a mod M), so
+// modulo each of these primes the modulus of a vulnerable key is a power of
+// 65537.
+var rocaPrimeInts = []int64{
+	3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53, 59, 61,
+	67, 71, 73, 79, 83, 89, 97, 101, 103, 107, 109, 113, 127, 131, 137,
+	139, 149, 151, 157, 163, 167,
+}
+
+var (
+	rocaSingleton sync.Once
+	rocaPrimes    []*big.Int
+	// rocaResidues[i] holds the powers of 65537 modulo rocaPrimes[i]
+	rocaResidues []map[int64]bool
+)
+
+func initROCA() {
+	generator := big.NewInt(65537)
+	for _, p := range rocaPrimeInts {
+		prime := big.NewInt(p)
+		powers := make(map[int64]bool)
+		power := big.NewInt(1)
+		for !powers[power.Int64()] {
+			powers[power.Int64()] = true
+			power.Mul(power, generator)
+			power.Mod(power, prime)
+		}
+		rocaPrimes = append(rocaPrimes, prime)
+		rocaResidues = append(rocaResidues, powers)
+	}
+}
+
+// hasROCAFingerprint returns true if modulus has the structure of one
+// generated by the vulnerable Infineon library. A modulus not generated by it
+// has a negligible chance of passing the check.
+func hasROCAFingerprint(modulus *big.Int) bool {
+	rocaSingleton.Do(initROCA)
+
+	var residue big.Int
+	for i, prime := range rocaPrimes {
+		residue.Mod(modulus, prime)
+		if !rocaResidues[i][residue.Int64()] {
+			return false
+		}
+	}
+	return true
+}
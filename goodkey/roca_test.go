@@ -0,0 +1,54 @@
+package goodkey
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"math/big"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+// rocaModulus returns a 2048-bit number with the residues of a modulus
+// generated by the vulnerable Infineon library, and no small prime factors.
+func rocaModulus(t *testing.T) *big.Int {
+	m := big.NewInt(1)
+	for _, p := range rocaPrimeInts {
+		m.Mul(m, big.NewInt(p))
+	}
+	exponent, err := rand.Int(rand.Reader, m)
+	test.AssertNotError(t, err, "Error generating exponent")
+	residue := new(big.Int).Exp(big.NewInt(65537), exponent, m)
+
+	// Start k so that k*M is just over 2^2047, then step through multiples
+	// of M, which preserves the residues.
+	k := new(big.Int).Lsh(big.NewInt(1), 2047)
+	k.Div(k, m)
+	k.Add(k, big.NewInt(1))
+	n := new(big.Int)
+	for {
+		n.Mul(k, m)
+		n.Add(n, residue)
+		if !checkSmallPrimes(n) {
+			break
+		}
+		k.Add(k, big.NewInt(1))
+	}
+	test.AssertEquals(t, n.BitLen(), 2048)
+	return n
+}
+
+func TestROCAFingerprint(t *testing.T) {
+	n := rocaModulus(t)
+	test.Assert(t, hasROCAFingerprint(n), "Constructed modulus not detected")
+
+	err := testingPolicy.GoodKey(context.Background(), &rsa.PublicKey{N: n, E: 65537})
+	test.AssertError(t, err, "Should have rejected ROCA key.")
+	test.AssertEquals(t, err.Error(), "Key generated by vulnerable Infineon-based hardware")
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.AssertNotError(t, err, "Error generating key")
+	test.Assert(t, !hasROCAFingerprint(private.N), "Random modulus detected")
+}
@@ -0,0 +1,66 @@
+package goodkey
+
+import (
+	"bufio"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// truncatedHashLen is the number of bytes of each hash kept in a Debian weak
+// key blocklist. The openssl-blacklist package lists the last 20 hex digits of
+// each SHA-1 hash.
+const truncatedHashLen = 10
+
+type truncatedHash [truncatedHashLen]byte
+
+// WeakRSAKeys is a blocklist of RSA keys generated by Debian's OpenSSL
+// between 2006 and 2008, whose only source of entropy was the process ID
+// (CVE-2008-0166).
+type WeakRSAKeys struct {
+	suffixes map[truncatedHash]bool
+}
+
+// LoadWeakRSAKeys reads a blocklist in the format of the openssl-blacklist
+// package's blacklist.RSA-* files: one hex-encoded truncated hash per line,
+// with lines starting with # ignored. Each hash is the last 10 bytes of the
+// SHA-1 hash of "Modulus=<upper-case hex modulus>\n", the output of `openssl
+// rsa -modulus`.
+func LoadWeakRSAKeys(filename string) (*WeakRSAKeys, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	wk := &WeakRSAKeys{suffixes: make(map[truncatedHash]bool)}
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		suffix, err := hex.DecodeString(line)
+		if err != nil || len(suffix) != truncatedHashLen {
+			return nil, fmt.Errorf("%s:%d: invalid truncated hash %q", filename, lineNum, line)
+		}
+		var trunc truncatedHash
+		copy(trunc[:], suffix)
+		wk.suffixes[trunc] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return wk, nil
+}
+
+// Known returns true if key is on the blocklist.
+func (wk *WeakRSAKeys) Known(key *rsa.PublicKey) bool {
+	hash := sha1.Sum([]byte(fmt.Sprintf("Modulus=%X\n", key.N.Bytes())))
+	var trunc truncatedHash
+	copy(trunc[:], hash[len(hash)-truncatedHashLen:])
+	return wk.suffixes[trunc]
+}
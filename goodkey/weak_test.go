@@ -0,0 +1,59 @@
+package goodkey
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func writeBlocklist(t *testing.T, contents string) string {
+	f, err := ioutil.TempFile("", "weak-keys")
+	test.AssertNotError(t, err, "Failed to create blocklist")
+	defer f.Close()
+	_, err = f.WriteString(contents)
+	test.AssertNotError(t, err, "Failed to write blocklist")
+	return f.Name()
+}
+
+func TestWeakRSAKeys(t *testing.T) {
+	weak, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.AssertNotError(t, err, "Error generating key")
+	hash := sha1.Sum([]byte(fmt.Sprintf("Modulus=%X\n", weak.N.Bytes())))
+
+	filename := writeBlocklist(t, "# RSA 2048\n\n"+hex.EncodeToString(hash[10:])+"\n")
+	defer os.Remove(filename)
+	wk, err := LoadWeakRSAKeys(filename)
+	test.AssertNotError(t, err, "Failed to load blocklist")
+
+	test.Assert(t, wk.Known(&weak.PublicKey), "Listed key not known")
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.AssertNotError(t, err, "Error generating key")
+	test.Assert(t, !wk.Known(&other.PublicKey), "Unlisted key known")
+
+	policy := *testingPolicy
+	policy.WeakRSAKeys = wk
+	err = policy.GoodKey(context.Background(), &weak.PublicKey)
+	test.AssertError(t, err, "Should have rejected weak key.")
+	test.AssertEquals(t, err.Error(), "Key is on the Debian weak key blocklist")
+	test.AssertNotError(t, policy.GoodKey(context.Background(), &other.PublicKey), "Should have accepted unlisted key.")
+}
+
+func TestLoadWeakRSAKeysInvalid(t *testing.T) {
+	filename := writeBlocklist(t, "0123456789abcdef0123\nnot hex\n")
+	defer os.Remove(filename)
+	_, err := LoadWeakRSAKeys(filename)
+	test.AssertError(t, err, "Loaded an invalid blocklist")
+	test.AssertContains(t, err.Error(), ":2: invalid truncated hash")
+
+	_, err = LoadWeakRSAKeys("does-not-exist")
+	test.AssertError(t, err, "Loaded a missing blocklist")
+}
@@ -16,9 +16,13 @@ import (
 
 	caPB "github.com/letsencrypt/boulder/ca/proto"
 	"github.com/letsencrypt/boulder/core"
+	"github.com/letsencrypt/boulder/nonce"
+	noncePB "github.com/letsencrypt/boulder/nonce/proto"
 	"github.com/letsencrypt/boulder/probs"
 	"github.com/letsencrypt/boulder/publisher"
 	pubPB "github.com/letsencrypt/boulder/publisher/proto"
+	"github.com/letsencrypt/boulder/ratelimit"
+	rlPB "github.com/letsencrypt/boulder/ratelimit/proto"
 	"github.com/letsencrypt/boulder/revocation"
 	vaPB "github.com/letsencrypt/boulder/va/proto"
 )
@@ -217,3 +221,129 @@ func (cas *CertificateAuthorityServerWrapper) GenerateOCSP(ctx context.Context,
 	}
 	return &caPB.OCSPResponse{Response: res}, nil
 }
+
+// NonceServiceClientWrapper is the gRPC version of a core.NonceService client.
+// New nonces are requested from any of the nonce services behind the getter,
+// while redemptions are routed to the nonce service that issued the nonce
+// based on its prefix.
+type NonceServiceClientWrapper struct {
+	getter    noncePB.NonceServiceClient
+	redeemers map[string]noncePB.NonceServiceClient
+	timeout   time.Duration
+}
+
+// NewNonceServiceClient returns an initialized NonceServiceClientWrapper.
+// redeemers maps each nonce prefix to the client for the nonce service using
+// that prefix.
+func NewNonceServiceClient(getter noncePB.NonceServiceClient, redeemers map[string]noncePB.NonceServiceClient, timeout time.Duration) *NonceServiceClientWrapper {
+	return &NonceServiceClientWrapper{getter, redeemers, timeout}
+}
+
+func (nsc *NonceServiceClientWrapper) Nonce(ctx context.Context) (string, error) {
+	localCtx, cancel := context.WithTimeout(ctx, nsc.timeout)
+	defer cancel()
+	res, err := nsc.getter.Nonce(localCtx, &noncePB.NonceRequest{})
+	if err != nil {
+		return "", err
+	}
+	if res.Nonce == nil {
+		return "", errors.New("incomplete Nonce gRPC message")
+	}
+	return *res.Nonce, nil
+}
+
+// Redeem sends the nonce to the nonce service identified by its prefix. A
+// nonce with a prefix that doesn't belong to any known nonce service is
+// invalid.
+func (nsc *NonceServiceClientWrapper) Redeem(ctx context.Context, n string) (bool, error) {
+	redeemer, ok := nsc.redeemers[nonce.Prefix(n)]
+	if !ok {
+		return false, nil
+	}
+	localCtx, cancel := context.WithTimeout(ctx, nsc.timeout)
+	defer cancel()
+	res, err := redeemer.Redeem(localCtx, &noncePB.NonceMessage{Nonce: &n})
+	if err != nil {
+		return false, err
+	}
+	return res.GetValid(), nil
+}
+
+// NonceServiceServerWrapper is the gRPC version of a core.NonceService server
+type NonceServiceServerWrapper struct {
+	inner core.NonceService
+}
+
+func NewNonceServiceServer(inner core.NonceService) *NonceServiceServerWrapper {
+	return &NonceServiceServerWrapper{inner}
+}
+
+func (nss *NonceServiceServerWrapper) Nonce(ctx context.Context, _ *noncePB.NonceRequest) (*noncePB.NonceMessage, error) {
+	n, err := nss.inner.Nonce(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &noncePB.NonceMessage{Nonce: &n}, nil
+}
+
+func (nss *NonceServiceServerWrapper) Redeem(ctx context.Context, request *noncePB.NonceMessage) (*noncePB.ValidMessage, error) {
+	if request == nil || request.Nonce == nil {
+		return nil, errors.New("incomplete Redeem gRPC message")
+	}
+	valid, err := nss.inner.Redeem(ctx, *request.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	return &noncePB.ValidMessage{Valid: &valid}, nil
+}
+
+// BucketStoreClientWrapper is the gRPC version of a ratelimit.BucketStore
+// client
+type BucketStoreClientWrapper struct {
+	inner   rlPB.BucketStoreClient
+	timeout time.Duration
+}
+
+func NewBucketStoreClient(inner rlPB.BucketStoreClient, timeout time.Duration) *BucketStoreClientWrapper {
+	return &BucketStoreClientWrapper{inner, timeout}
+}
+
+func (bsc *BucketStoreClientWrapper) Take(ctx context.Context, key string, burst int, period time.Duration) (bool, time.Duration, error) {
+	localCtx, cancel := context.WithTimeout(ctx, bsc.timeout)
+	defer cancel()
+	burst64, periodNS := int64(burst), int64(period)
+	res, err := bsc.inner.Take(localCtx, &rlPB.TakeRequest{
+		Key:    &key,
+		Burst:  &burst64,
+		Period: &periodNS,
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	if res.Allowed == nil {
+		return false, 0, errors.New("incomplete Take gRPC message")
+	}
+	return *res.Allowed, time.Duration(res.GetRetryAfter()), nil
+}
+
+// BucketStoreServerWrapper is the gRPC version of a ratelimit.BucketStore
+// server
+type BucketStoreServerWrapper struct {
+	inner ratelimit.BucketStore
+}
+
+func NewBucketStoreServer(inner ratelimit.BucketStore) *BucketStoreServerWrapper {
+	return &BucketStoreServerWrapper{inner}
+}
+
+func (bss *BucketStoreServerWrapper) Take(ctx context.Context, request *rlPB.TakeRequest) (*rlPB.TakeResponse, error) {
+	if request == nil || request.Key == nil || request.Burst == nil || request.Period == nil {
+		return nil, errors.New("incomplete Take gRPC message")
+	}
+	allowed, retryAfter, err := bss.inner.Take(ctx, *request.Key, int(*request.Burst), time.Duration(*request.Period))
+	if err != nil {
+		return nil, err
+	}
+	retryAfterNS := int64(retryAfter)
+	return &rlPB.TakeResponse{Allowed: &allowed, RetryAfter: &retryAfterNS}, nil
+}
@@ -0,0 +1,90 @@
+package grpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/net/context"
+	ggrpc "google.golang.org/grpc"
+
+	"github.com/letsencrypt/boulder/metrics"
+	"github.com/letsencrypt/boulder/nonce"
+	noncePB "github.com/letsencrypt/boulder/nonce/proto"
+	"github.com/letsencrypt/boulder/ratelimit"
+	rlPB "github.com/letsencrypt/boulder/ratelimit/proto"
+	"github.com/letsencrypt/boulder/test"
+)
+
+// localNonceClient implements noncePB.NonceServiceClient by calling straight
+// into a NonceServiceServerWrapper, skipping the network.
+type localNonceClient struct {
+	srv *NonceServiceServerWrapper
+}
+
+func (lnc localNonceClient) Nonce(ctx context.Context, in *noncePB.NonceRequest, _ ...ggrpc.CallOption) (*noncePB.NonceMessage, error) {
+	return lnc.srv.Nonce(ctx, in)
+}
+
+func (lnc localNonceClient) Redeem(ctx context.Context, in *noncePB.NonceMessage, _ ...ggrpc.CallOption) (*noncePB.ValidMessage, error) {
+	return lnc.srv.Redeem(ctx, in)
+}
+
+func newLocalNonceClient(t *testing.T, prefix string) localNonceClient {
+	ns, err := nonce.NewNonceService(metrics.NewNoopScope(), prefix)
+	test.AssertNotError(t, err, "Could not create nonce service")
+	return localNonceClient{NewNonceServiceServer(nonce.NewLocal(ns))}
+}
+
+func TestNonceServiceRedeemRouting(t *testing.T) {
+	zinc := newLocalNonceClient(t, "zinc")
+	tinc := newLocalNonceClient(t, "tinc")
+	redeemers := map[string]noncePB.NonceServiceClient{
+		"zinc": zinc,
+		"tinc": tinc,
+	}
+	wfeA := NewNonceServiceClient(zinc, redeemers, time.Second)
+	wfeB := NewNonceServiceClient(tinc, redeemers, time.Second)
+
+	// A nonce issued through one WFE can be redeemed through another, once.
+	n, err := wfeA.Nonce(context.Background())
+	test.AssertNotError(t, err, "Could not get nonce")
+	valid, err := wfeB.Redeem(context.Background(), n)
+	test.AssertNotError(t, err, "Could not redeem nonce")
+	test.Assert(t, valid, "Nonce issued by another WFE was rejected")
+	valid, err = wfeA.Redeem(context.Background(), n)
+	test.AssertNotError(t, err, "Could not redeem nonce")
+	test.Assert(t, !valid, "Nonce was redeemed twice")
+
+	// A nonce with an unknown prefix is invalid.
+	valid, err = wfeA.Redeem(context.Background(), "hmm?"+n[nonce.PrefixLen:])
+	test.AssertNotError(t, err, "Could not redeem nonce")
+	test.Assert(t, !valid, "Accepted nonce with unknown prefix")
+}
+
+// localBucketStoreClient implements rlPB.BucketStoreClient by calling straight
+// into a BucketStoreServerWrapper, skipping the network.
+type localBucketStoreClient struct {
+	srv *BucketStoreServerWrapper
+}
+
+func (lbc localBucketStoreClient) Take(ctx context.Context, in *rlPB.TakeRequest, _ ...ggrpc.CallOption) (*rlPB.TakeResponse, error) {
+	return lbc.srv.Take(ctx, in)
+}
+
+func TestBucketStoreWrappers(t *testing.T) {
+	fc := clock.NewFake()
+	srv := NewBucketStoreServer(ratelimit.NewMemoryBucketStore(fc))
+	client := NewBucketStoreClient(localBucketStoreClient{srv}, time.Second)
+
+	ok, _, err := client.Take(context.Background(), "key", 1, time.Minute)
+	test.AssertNotError(t, err, "Take failed")
+	test.Assert(t, ok, "Rejected a request from a full bucket")
+	ok, retryAfter, err := client.Take(context.Background(), "key", 1, time.Minute)
+	test.AssertNotError(t, err, "Take failed")
+	test.Assert(t, !ok, "Allowed a request from an empty bucket")
+	test.AssertEquals(t, retryAfter, time.Minute)
+
+	_, err = srv.Take(context.Background(), &rlPB.TakeRequest{})
+	test.AssertError(t, err, "Accepted an incomplete request")
+}
@@ -0,0 +1,126 @@
+package mail
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// ErrNotDSN is returned by ParseDSN for messages that aren't RFC 3464 delivery
+// status notifications
+var ErrNotDSN = errors.New("message is not a delivery status notification")
+
+// Bounce is a recipient that a delivery status notification reports as
+// permanently undeliverable
+type Bounce struct {
+	Recipient string
+	// Status is the RFC 3463 status code, e.g. 5.1.1
+	Status string
+	// ListUnsubscribe is the List-Unsubscribe header of the returned message,
+	// if the DSN includes its headers. Since the unsubscribe link is signed
+	// for the recipient, it shows that the bounced mail was really sent by
+	// us rather than a forgery.
+	ListUnsubscribe string
+}
+
+// ParseDSN reads an RFC 3464 delivery status notification and returns the
+// recipients it reports as permanently failed. Delayed deliveries and
+// temporary failures are not returned.
+func ParseDSN(r io.Reader) ([]Bounce, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || params["report-type"] != "delivery-status" {
+		return nil, ErrNotDSN
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	var bounces []Bounce
+	var found bool
+	var listUnsubscribe string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		partType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			continue
+		}
+		switch partType {
+		case "message/delivery-status":
+			bounces, err = parseDeliveryStatus(part)
+			if err != nil {
+				return nil, err
+			}
+			found = true
+		case "text/rfc822-headers", "message/rfc822":
+			// The returned message, or just its headers
+			headers, err := textproto.NewReader(bufio.NewReader(part)).ReadMIMEHeader()
+			if err != nil && err != io.EOF {
+				continue
+			}
+			listUnsubscribe = headers.Get("List-Unsubscribe")
+		}
+	}
+	if !found {
+		return nil, ErrNotDSN
+	}
+	for i := range bounces {
+		bounces[i].ListUnsubscribe = listUnsubscribe
+	}
+	return bounces, nil
+}
+
+// parseDeliveryStatus reads the per-message fields and then each block of
+// per-recipient fields from a message/delivery-status body
+func parseDeliveryStatus(r io.Reader) ([]Bounce, error) {
+	tr := textproto.NewReader(bufio.NewReader(r))
+	// The first block describes the message rather than a recipient
+	if _, err := tr.ReadMIMEHeader(); err != nil {
+		if err == io.EOF {
+			return nil, ErrNotDSN
+		}
+		return nil, err
+	}
+	var bounces []Bounce
+	for {
+		fields, err := tr.ReadMIMEHeader()
+		if len(fields) > 0 && strings.EqualFold(fields.Get("Action"), "failed") {
+			status := strings.TrimSpace(fields.Get("Status"))
+			recipient := addressField(fields.Get("Final-Recipient"))
+			if recipient == "" {
+				recipient = addressField(fields.Get("Original-Recipient"))
+			}
+			if strings.HasPrefix(status, "5.") && recipient != "" {
+				bounces = append(bounces, Bounce{Recipient: recipient, Status: status})
+			}
+		}
+		if err == io.EOF {
+			return bounces, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// addressField returns the address from a recipient field of the form
+// "address-type; address", e.g. "rfc822; user@example.com"
+func addressField(value string) string {
+	fields := strings.SplitN(value, ";", 2)
+	if len(fields) != 2 || !strings.EqualFold(strings.TrimSpace(fields[0]), "rfc822") {
+		return ""
+	}
+	return strings.TrimSpace(fields[1])
+}
@@ -0,0 +1,75 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+const testDSN = "From: Mail Delivery System <MAILER-DAEMON@mx.example.com>\r\n" +
+	"To: expiry@example.org\r\n" +
+	"Subject: Undelivered Mail Returned to Sender\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=delivery-status; boundary=\"BOUNDARY\"\r\n" +
+	"\r\n" +
+	"--BOUNDARY\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Your message could not be delivered.\r\n" +
+	"--BOUNDARY\r\n" +
+	"Content-Type: message/delivery-status\r\n" +
+	"\r\n" +
+	"Reporting-MTA: dns; mx.example.com\r\n" +
+	"Arrival-Date: Tue, 18 Oct 2016 10:00:00 +0000\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; gone@example.com\r\n" +
+	"Action: failed\r\n" +
+	"Status: 5.1.1\r\n" +
+	"Diagnostic-Code: smtp; 550 5.1.1 User unknown\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; full@example.com\r\n" +
+	"Action: failed\r\n" +
+	"Status: 4.2.2\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; slow@example.com\r\n" +
+	"Action: delayed\r\n" +
+	"Status: 4.4.1\r\n" +
+	"\r\n" +
+	"Original-Recipient: rfc822; Also-Gone@example.com\r\n" +
+	"Action: Failed\r\n" +
+	"Status: 5.1.2\r\n" +
+	"--BOUNDARY\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"\r\n" +
+	"Subject: Certificate expiration notice\r\n" +
+	"List-Unsubscribe: <https://example.com/unsubscribe?token=abc>\r\n" +
+	"\r\n" +
+	"Your certificate will expire soon.\r\n" +
+	"--BOUNDARY--\r\n"
+
+func TestParseDSN(t *testing.T) {
+	bounces, err := ParseDSN(strings.NewReader(testDSN))
+	test.AssertNotError(t, err, "Failed to parse DSN")
+	// Temporary failures and delays aren't bounces
+	test.AssertDeepEquals(t, bounces, []Bounce{
+		{Recipient: "gone@example.com", Status: "5.1.1", ListUnsubscribe: "<https://example.com/unsubscribe?token=abc>"},
+		{Recipient: "Also-Gone@example.com", Status: "5.1.2", ListUnsubscribe: "<https://example.com/unsubscribe?token=abc>"},
+	})
+}
+
+func TestParseDSNNotDSN(t *testing.T) {
+	_, err := ParseDSN(strings.NewReader("Subject: hi\r\nContent-Type: text/plain\r\n\r\nhello\r\n"))
+	test.AssertEquals(t, err, ErrNotDSN)
+
+	// A report without a delivery-status part
+	noStatus := "Content-Type: multipart/report; report-type=delivery-status; boundary=\"B\"\r\n" +
+		"\r\n" +
+		"--B\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"hello\r\n" +
+		"--B--\r\n"
+	_, err = ParseDSN(strings.NewReader(noStatus))
+	test.AssertEquals(t, err, ErrNotDSN)
+}
@@ -9,10 +9,12 @@ import (
 	"io"
 	"math"
 	"math/big"
+	"mime/multipart"
 	"mime/quotedprintable"
 	"net"
 	"net/mail"
 	"net/smtp"
+	"net/textproto"
 	"strconv"
 	"strings"
 	"time"
@@ -43,12 +45,18 @@ func (s realSource) generate() *big.Int {
 // Mailer provides the interface for a mailer
 type Mailer interface {
 	SendMail([]string, string, string) error
+	// SendMultipartMail sends a multipart/alternative message with a plain
+	// text and an HTML version of the body
+	SendMultipartMail(to []string, subject, text, html string) error
+	// SendUnsubscribableMail sends a message to a single recipient with
+	// List-Unsubscribe headers pointing at unsubscribeURL. html may be empty.
+	SendUnsubscribableMail(to, subject, text, html, unsubscribeURL string) error
 	Connect() error
 	Close() error
 }
 
 // MailerImpl defines a mail transfer agent to use for sending mail. It is not
-// safe for concurrent access.
+// safe for concurrent access; use a Pool to send in parallel.
 type MailerImpl struct {
 	log           blog.Logger
 	dialer        dialer
@@ -103,24 +111,48 @@ func (d dryRunClient) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+// TLSMode controls how the connection to the mail server is secured
+type TLSMode string
+
+const (
+	// TLSImplicit uses TLS from the start of the connection, conventionally
+	// on port 465
+	TLSImplicit = TLSMode("implicit")
+	// TLSStartTLS connects in plaintext and requires upgrading the connection
+	// with STARTTLS before authenticating
+	TLSStartTLS = TLSMode("starttls")
+	// TLSNone never uses TLS. net/smtp refuses to send credentials over it
+	// unless the server is localhost.
+	TLSNone = TLSMode("none")
+)
+
 // New constructs a Mailer to represent an account on a particular mail
-// transfer agent.
+// transfer agent. tlsConfig is used to verify the server's certificate when
+// tlsMode is TLSImplicit or TLSStartTLS; if it is nil the system roots are
+// used and the certificate must be valid for server.
 func New(
 	server,
 	port,
 	username,
 	password string,
+	tlsMode TLSMode,
+	tlsConfig *tls.Config,
 	from mail.Address,
 	logger blog.Logger,
 	stats metrics.Scope,
 	reconnectBase time.Duration,
 	reconnectMax time.Duration) *MailerImpl {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: server}
+	}
 	return &MailerImpl{
 		dialer: &dialerImpl{
-			username: username,
-			password: password,
-			server:   server,
-			port:     port,
+			username:  username,
+			password:  password,
+			server:    server,
+			port:      port,
+			tlsMode:   tlsMode,
+			tlsConfig: tlsConfig,
 		},
 		log:           logger,
 		from:          from,
@@ -145,7 +177,11 @@ func NewDryRun(from mail.Address, logger blog.Logger) *MailerImpl {
 	}
 }
 
-func (m *MailerImpl) generateMessage(to []string, subject, body string) ([]byte, error) {
+// generateMessage builds a message with the given plain text body. If html
+// isn't empty the message is multipart/alternative, with html as the
+// preferred alternative. If unsubscribeURL isn't empty the message carries
+// RFC 8058 one-click List-Unsubscribe headers.
+func (m *MailerImpl) generateMessage(to []string, subject, body, html, unsubscribeURL string) ([]byte, error) {
 	mid := m.csprgSource.generate()
 	now := m.clk.Now().UTC()
 	addrs := []string{}
@@ -162,22 +198,49 @@ func (m *MailerImpl) generateMessage(to []string, subject, body string) ([]byte,
 		fmt.Sprintf("Date: %s", now.Format(time.RFC822)),
 		fmt.Sprintf("Message-Id: <%s.%s.%s>", now.Format("20060102T150405"), mid.String(), m.from.Address),
 		"MIME-Version: 1.0",
-		"Content-Type: text/plain; charset=UTF-8",
-		"Content-Transfer-Encoding: quoted-printable",
+	}
+	if unsubscribeURL != "" {
+		headers = append(headers,
+			fmt.Sprintf("List-Unsubscribe: <%s>", unsubscribeURL),
+			"List-Unsubscribe-Post: List-Unsubscribe=One-Click",
+		)
 	}
 	for i := range headers[1:] {
 		// strip LFs
 		headers[i] = strings.Replace(headers[i], "\n", "", -1)
 	}
 	bodyBuf := new(bytes.Buffer)
-	mimeWriter := quotedprintable.NewWriter(bodyBuf)
-	_, err := mimeWriter.Write([]byte(body))
-	if err != nil {
-		return nil, err
-	}
-	err = mimeWriter.Close()
-	if err != nil {
-		return nil, err
+	if html == "" {
+		headers = append(headers,
+			"Content-Type: text/plain; charset=UTF-8",
+			"Content-Transfer-Encoding: quoted-printable",
+		)
+		if err := writeQuotedPrintable(bodyBuf, body); err != nil {
+			return nil, err
+		}
+	} else {
+		mpWriter := multipart.NewWriter(bodyBuf)
+		headers = append(headers, fmt.Sprintf("Content-Type: multipart/alternative; boundary=%q", mpWriter.Boundary()))
+		// RFC 2046 section 5.1.4: alternatives are in increasing order of
+		// preference
+		for _, part := range []struct{ contentType, content string }{
+			{"text/plain; charset=UTF-8", body},
+			{"text/html; charset=UTF-8", html},
+		} {
+			w, err := mpWriter.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {part.contentType},
+				"Content-Transfer-Encoding": {"quoted-printable"},
+			})
+			if err != nil {
+				return nil, err
+			}
+			if err := writeQuotedPrintable(w, part.content); err != nil {
+				return nil, err
+			}
+		}
+		if err := mpWriter.Close(); err != nil {
+			return nil, err
+		}
 	}
 	return []byte(fmt.Sprintf(
 		"%s\r\n\r\n%s\r\n",
@@ -186,6 +249,14 @@ func (m *MailerImpl) generateMessage(to []string, subject, body string) ([]byte,
 	)), nil
 }
 
+func writeQuotedPrintable(w io.Writer, content string) error {
+	mimeWriter := quotedprintable.NewWriter(w)
+	if _, err := mimeWriter.Write([]byte(content)); err != nil {
+		return err
+	}
+	return mimeWriter.Close()
+}
+
 func (m *MailerImpl) reconnect() {
 	for i := 0; ; i++ {
 		sleepDuration := core.RetryBackoff(i, m.reconnectBase, m.reconnectMax, 2)
@@ -215,38 +286,55 @@ func (m *MailerImpl) Connect() error {
 
 type dialerImpl struct {
 	username, password, server, port string
+	tlsMode                          TLSMode
+	tlsConfig                        *tls.Config
 }
 
 func (di *dialerImpl) Dial() (smtpClient, error) {
 	hostport := net.JoinHostPort(di.server, di.port)
 	var conn net.Conn
 	var err error
-	// By convention, port 465 is TLS-wrapped SMTP, while 587 is plaintext SMTP
-	// (with STARTTLS as best-effort).
-	if di.port == "465" {
-		conn, err = tls.Dial("tcp", hostport, nil)
-	} else {
+	switch di.tlsMode {
+	case TLSImplicit:
+		conn, err = tls.Dial("tcp", hostport, di.tlsConfig)
+	case TLSStartTLS, TLSNone:
 		conn, err = net.Dial("tcp", hostport)
+	default:
+		return nil, fmt.Errorf("unknown TLS mode %q", di.tlsMode)
 	}
 	if err != nil {
 		return nil, err
 	}
 	client, err := smtp.NewClient(conn, di.server)
 	if err != nil {
+		_ = conn.Close()
 		return nil, err
 	}
+	if di.tlsMode == TLSStartTLS {
+		// Never fall back to plaintext: the credentials below would be sent
+		// in the clear
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			_ = client.Close()
+			return nil, errors.New("mail server does not support STARTTLS")
+		}
+		if err = client.StartTLS(di.tlsConfig); err != nil {
+			_ = client.Close()
+			return nil, err
+		}
+	}
 	auth := smtp.PlainAuth("", di.username, di.password, di.server)
 	if err = client.Auth(auth); err != nil {
+		_ = client.Close()
 		return nil, err
 	}
 	return client, nil
 }
 
-func (m *MailerImpl) sendOne(to []string, subject, msg string) error {
+func (m *MailerImpl) sendOne(to []string, subject, msg, html, unsubscribeURL string) error {
 	if m.client == nil {
 		return errors.New("call Connect before SendMail")
 	}
-	body, err := m.generateMessage(to, subject, msg)
+	body, err := m.generateMessage(to, subject, msg, html, unsubscribeURL)
 	if err != nil {
 		return err
 	}
@@ -276,10 +364,27 @@ func (m *MailerImpl) sendOne(to []string, subject, msg string) error {
 // SendMail sends an email to the provided list of recipients. The email body
 // is simple text.
 func (m *MailerImpl) SendMail(to []string, subject, msg string) error {
+	return m.send(to, subject, msg, "", "")
+}
+
+// SendMultipartMail sends an email to the provided list of recipients, with
+// both a plain text and an HTML body.
+func (m *MailerImpl) SendMultipartMail(to []string, subject, text, html string) error {
+	return m.send(to, subject, text, html, "")
+}
+
+// SendUnsubscribableMail sends an email to a single recipient, with headers
+// that let their mail client unsubscribe them using unsubscribeURL. If html is
+// empty the email body is simple text.
+func (m *MailerImpl) SendUnsubscribableMail(to, subject, text, html, unsubscribeURL string) error {
+	return m.send([]string{to}, subject, text, html, unsubscribeURL)
+}
+
+func (m *MailerImpl) send(to []string, subject, msg, html, unsubscribeURL string) error {
 	m.stats.Inc("SendMail.Attempts", 1)
 
 	for {
-		err := m.sendOne(to, subject, msg)
+		err := m.sendOne(to, subject, msg, html, unsubscribeURL)
 		if err == nil {
 			// If the error is nil, we sent the mail without issue. nice!
 			break
@@ -2,9 +2,19 @@ package mail
 
 import (
 	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/big"
+	"mime"
+	"mime/multipart"
 	"net"
 	"net/mail"
 	"strings"
@@ -29,10 +39,10 @@ func TestGenerateMessage(t *testing.T) {
 	stats := metrics.NewNoopScope()
 	fromAddress, _ := mail.ParseAddress("happy sender <send@email.com>")
 	log := blog.UseMock()
-	m := New("", "", "", "", *fromAddress, log, stats, 0, 0)
+	m := New("", "", "", "", TLSNone, nil, *fromAddress, log, stats, 0, 0)
 	m.clk = fc
 	m.csprgSource = fakeSource{}
-	messageBytes, err := m.generateMessage([]string{"recv@email.com"}, "test subject", "this is the body\n")
+	messageBytes, err := m.generateMessage([]string{"recv@email.com"}, "test subject", "this is the body\n", "", "")
 	test.AssertNotError(t, err, "Failed to generate email body")
 	message := string(messageBytes)
 	fields := strings.Split(message, "\r\n")
@@ -50,12 +60,65 @@ func TestGenerateMessage(t *testing.T) {
 	test.AssertEquals(t, fields[9], "this is the body")
 }
 
+func TestGenerateMultipartMessage(t *testing.T) {
+	stats := metrics.NewNoopScope()
+	fromAddress, _ := mail.ParseAddress("send@email.com")
+	log := blog.UseMock()
+	m := New("", "", "", "", TLSNone, nil, *fromAddress, log, stats, 0, 0)
+	m.clk = clock.NewFake()
+	m.csprgSource = fakeSource{}
+	messageBytes, err := m.generateMessage([]string{"recv@email.com"}, "test subject", "this is the body\n", "<p>this is the body</p>\n", "")
+	test.AssertNotError(t, err, "Failed to generate email body")
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(messageBytes)))
+	test.AssertNotError(t, err, "Failed to parse generated message")
+	test.AssertEquals(t, msg.Header.Get("Content-Transfer-Encoding"), "")
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	test.AssertNotError(t, err, "Failed to parse Content-Type")
+	test.AssertEquals(t, mediaType, "multipart/alternative")
+
+	expected := []struct{ contentType, body string }{
+		{"text/plain; charset=UTF-8", "this is the body\r\n"},
+		{"text/html; charset=UTF-8", "<p>this is the body</p>\r\n"},
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for _, e := range expected {
+		part, err := mr.NextPart()
+		test.AssertNotError(t, err, "Failed to read message part")
+		test.AssertEquals(t, part.Header.Get("Content-Type"), e.contentType)
+		// NextPart transparently decodes quoted-printable parts. The encoder
+		// writes line breaks as CRLF.
+		body, err := ioutil.ReadAll(part)
+		test.AssertNotError(t, err, "Failed to read message part body")
+		test.AssertEquals(t, string(body), e.body)
+	}
+	_, err = mr.NextPart()
+	test.AssertEquals(t, err, io.EOF)
+}
+
+func TestGenerateMessageUnsubscribe(t *testing.T) {
+	stats := metrics.NewNoopScope()
+	fromAddress, _ := mail.ParseAddress("send@email.com")
+	log := blog.UseMock()
+	m := New("", "", "", "", TLSNone, nil, *fromAddress, log, stats, 0, 0)
+	m.clk = clock.NewFake()
+	m.csprgSource = fakeSource{}
+	messageBytes, err := m.generateMessage([]string{"recv@email.com"}, "test subject", "this is the body\n", "", "https://example.com/unsubscribe?token=abc")
+	test.AssertNotError(t, err, "Failed to generate email body")
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(messageBytes)))
+	test.AssertNotError(t, err, "Failed to parse generated message")
+	test.AssertEquals(t, msg.Header.Get("List-Unsubscribe"), "<https://example.com/unsubscribe?token=abc>")
+	test.AssertEquals(t, msg.Header.Get("List-Unsubscribe-Post"), "List-Unsubscribe=One-Click")
+	test.AssertEquals(t, msg.Header.Get("Content-Type"), "text/plain; charset=UTF-8")
+}
+
 func TestFailNonASCIIAddress(t *testing.T) {
 	log := blog.UseMock()
 	stats := metrics.NewNoopScope()
 	fromAddress, _ := mail.ParseAddress("send@email.com")
-	m := New("", "", "", "", *fromAddress, log, stats, 0, 0)
-	_, err := m.generateMessage([]string{"遗憾@email.com"}, "test subject", "this is the body\n")
+	m := New("", "", "", "", TLSNone, nil, *fromAddress, log, stats, 0, 0)
+	_, err := m.generateMessage([]string{"遗憾@email.com"}, "test subject", "this is the body\n", "", "")
 	test.AssertError(t, err, "Allowed a non-ASCII to address incorrectly")
 }
 
@@ -185,6 +248,8 @@ func setup(t *testing.T) (*MailerImpl, net.Listener, func()) {
 		fmt.Sprintf("%d", port),
 		"user@example.com",
 		"paswd",
+		TLSNone,
+		nil,
 		*fromAddress,
 		log,
 		stats,
@@ -229,3 +294,160 @@ func TestReconnectSuccess(t *testing.T) {
 		t.Errorf("Expected SendMail() to not fail. Got err: %s", err)
 	}
 }
+
+// makeServerTLS returns a TLS config for a test mail server with a self-signed
+// certificate for localhost, and a pool trusting that certificate
+func makeServerTLS(t *testing.T) (*tls.Config, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "Failed to generate key")
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	test.AssertNotError(t, err, "Failed to create certificate")
+	cert, err := x509.ParseCertificate(der)
+	test.AssertNotError(t, err, "Failed to parse certificate")
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}, roots
+}
+
+// startTLSHandler offers STARTTLS and then authenticates the client over the
+// upgraded connection. Clients that reject the server certificate just hang
+// up, so handshake failures aren't test failures.
+func startTLSHandler(serverTLS *tls.Config) connHandler {
+	return func(connID int, t *testing.T, conn net.Conn) {
+		defer func() {
+			_ = conn.Close()
+		}()
+		buf := bufio.NewReader(conn)
+		_, _ = conn.Write([]byte("220 smtp.example.com ESMTP\r\n"))
+		if err := expect(t, buf, "EHLO localhost"); err != nil {
+			return
+		}
+		// The first line of the EHLO response is a greeting, not an extension
+		_, _ = conn.Write([]byte("250-smtp.example.com\r\n"))
+		_, _ = conn.Write([]byte("250-STARTTLS\r\n"))
+		_, _ = conn.Write([]byte("250 8BITMIME\r\n"))
+		if err := expect(t, buf, "STARTTLS"); err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte("220 2.0.0 Ready to start TLS\r\n"))
+
+		tlsConn := tls.Server(conn, serverTLS)
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		buf = bufio.NewReader(tlsConn)
+		if err := expect(t, buf, "EHLO localhost"); err != nil {
+			return
+		}
+		_, _ = tlsConn.Write([]byte("250-smtp.example.com\r\n"))
+		_, _ = tlsConn.Write([]byte("250-AUTH PLAIN LOGIN\r\n"))
+		_, _ = tlsConn.Write([]byte("250 8BITMIME\r\n"))
+		if err := expect(t, buf, "AUTH PLAIN AHVzZXJAZXhhbXBsZS5jb20AcGFzd2Q="); err != nil {
+			return
+		}
+		_, _ = tlsConn.Write([]byte("235 2.7.0 Authentication successful\r\n"))
+		_, _ = buf.ReadString('\n')
+	}
+}
+
+// noStartTLSHandler greets the client without offering STARTTLS and then
+// waits for it to hang up
+func noStartTLSHandler(connID int, t *testing.T, conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	buf := bufio.NewReader(conn)
+	_, _ = conn.Write([]byte("220 smtp.example.com ESMTP\r\n"))
+	if err := expect(t, buf, "EHLO localhost"); err != nil {
+		return
+	}
+	_, _ = conn.Write([]byte("250-smtp.example.com\r\n"))
+	_, _ = conn.Write([]byte("250-AUTH PLAIN LOGIN\r\n"))
+	_, _ = conn.Write([]byte("250 8BITMIME\r\n"))
+	_, _ = ioutil.ReadAll(buf)
+}
+
+func setupTLS(t *testing.T, l net.Listener, mode TLSMode, clientTLS *tls.Config) *MailerImpl {
+	fromAddress, _ := mail.ParseAddress("you-are-a-winner@example.com")
+	return New(
+		"localhost",
+		fmt.Sprintf("%d", l.Addr().(*net.TCPAddr).Port),
+		"user@example.com",
+		"paswd",
+		mode,
+		clientTLS,
+		*fromAddress,
+		blog.UseMock(),
+		metrics.NewNoopScope(),
+		time.Second*2, time.Second*10)
+}
+
+func TestStartTLS(t *testing.T) {
+	serverTLS, roots := makeServerTLS(t)
+	_, l, cleanUp := setup(t)
+	defer cleanUp()
+	go listenForever(l, t, startTLSHandler(serverTLS))
+
+	m := setupTLS(t, l, TLSStartTLS, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	err := m.Connect()
+	test.AssertNotError(t, err, "Failed to connect with STARTTLS")
+	test.AssertNotError(t, m.Close(), "Failed to clean up")
+
+	// The server's certificate isn't trusted by the system roots
+	m = setupTLS(t, l, TLSStartTLS, nil)
+	err = m.Connect()
+	test.AssertError(t, err, "Connected to a server with an untrusted certificate")
+
+	// Nor is it valid for another name
+	m = setupTLS(t, l, TLSStartTLS, &tls.Config{RootCAs: roots, ServerName: "mail.example.com"})
+	err = m.Connect()
+	test.AssertError(t, err, "Connected to a server with a certificate for the wrong name")
+}
+
+func TestStartTLSUnsupported(t *testing.T) {
+	_, l, cleanUp := setup(t)
+	defer cleanUp()
+	go listenForever(l, t, noStartTLSHandler)
+
+	m := setupTLS(t, l, TLSStartTLS, nil)
+	err := m.Connect()
+	test.AssertError(t, err, "Connected without STARTTLS")
+	test.AssertEquals(t, err.Error(), "mail server does not support STARTTLS")
+}
+
+func TestImplicitTLS(t *testing.T) {
+	serverTLS, roots := makeServerTLS(t)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	test.AssertNotError(t, err, "Failed to listen")
+	defer func() {
+		_ = l.Close()
+	}()
+	go listenForever(l, t, normalHandler)
+
+	m := setupTLS(t, l, TLSImplicit, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	err = m.Connect()
+	test.AssertNotError(t, err, "Failed to connect with implicit TLS")
+	test.AssertNotError(t, m.Close(), "Failed to clean up")
+}
+
+func TestUnknownTLSMode(t *testing.T) {
+	_, l, cleanUp := setup(t)
+	defer cleanUp()
+
+	m := setupTLS(t, l, TLSMode("opportunistic"), nil)
+	err := m.Connect()
+	test.AssertError(t, err, "Connected with an unknown TLS mode")
+}
@@ -0,0 +1,153 @@
+package mail
+
+import (
+	"crypto/tls"
+	"net/mail"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+
+	blog "github.com/letsencrypt/boulder/log"
+	"github.com/letsencrypt/boulder/metrics"
+)
+
+// Pool is a Mailer that sends over several connections in parallel. Unlike
+// MailerImpl it is safe for concurrent access: each send borrows an idle
+// connection, waiting for one if they are all busy. Each connection
+// reconnects independently with its own backoff when its server hangs up.
+type Pool struct {
+	conns []Mailer
+	idle  chan Mailer
+	clk   clock.Clock
+
+	// interval is the minimum time between the start of two sends across
+	// all connections. Zero means sends aren't rate limited.
+	interval time.Duration
+	rateMu   sync.Mutex
+	nextSend time.Time
+}
+
+// NewPool constructs a Pool that sends over conns, which should be separate
+// Mailers that each own a connection. If sendsPerSecond is greater than zero
+// it limits the rate of sends across all connections.
+func NewPool(conns []Mailer, sendsPerSecond float64) *Pool {
+	var interval time.Duration
+	if sendsPerSecond > 0 {
+		interval = time.Duration(float64(time.Second) / sendsPerSecond)
+	}
+	idle := make(chan Mailer, len(conns))
+	for _, conn := range conns {
+		idle <- conn
+	}
+	return &Pool{
+		conns:    conns,
+		idle:     idle,
+		clk:      clock.Default(),
+		interval: interval,
+	}
+}
+
+// NewPooled constructs a Pool of size connections to a mail transfer agent,
+// each configured as by New.
+func NewPooled(
+	size int,
+	sendsPerSecond float64,
+	server,
+	port,
+	username,
+	password string,
+	tlsMode TLSMode,
+	tlsConfig *tls.Config,
+	from mail.Address,
+	logger blog.Logger,
+	stats metrics.Scope,
+	reconnectBase time.Duration,
+	reconnectMax time.Duration) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	conns := make([]Mailer, size)
+	for i := range conns {
+		conns[i] = New(server, port, username, password, tlsMode, tlsConfig, from, logger, stats, reconnectBase, reconnectMax)
+	}
+	return NewPool(conns, sendsPerSecond)
+}
+
+// Connect connects every connection in the pool. It must be called before
+// sending. If a connection fails, the ones already made are closed.
+func (p *Pool) Connect() error {
+	for i, conn := range p.conns {
+		if err := conn.Connect(); err != nil {
+			for _, connected := range p.conns[:i] {
+				_ = connected.Close()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every connection in the pool, returning the first error
+// encountered.
+func (p *Pool) Close() error {
+	var firstErr error
+	for _, conn := range p.conns {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// SendMail sends an email to the provided list of recipients. The email body
+// is simple text.
+func (p *Pool) SendMail(to []string, subject, msg string) error {
+	return p.send(func(m Mailer) error {
+		return m.SendMail(to, subject, msg)
+	})
+}
+
+// SendMultipartMail sends an email to the provided list of recipients, with
+// both a plain text and an HTML body.
+func (p *Pool) SendMultipartMail(to []string, subject, text, html string) error {
+	return p.send(func(m Mailer) error {
+		return m.SendMultipartMail(to, subject, text, html)
+	})
+}
+
+// SendUnsubscribableMail sends an email to a single recipient, with headers
+// that let their mail client unsubscribe them using unsubscribeURL.
+func (p *Pool) SendUnsubscribableMail(to, subject, text, html, unsubscribeURL string) error {
+	return p.send(func(m Mailer) error {
+		return m.SendUnsubscribableMail(to, subject, text, html, unsubscribeURL)
+	})
+}
+
+func (p *Pool) send(f func(Mailer) error) error {
+	p.wait()
+	conn := <-p.idle
+	defer func() {
+		p.idle <- conn
+	}()
+	return f(conn)
+}
+
+// wait reserves the next send slot allowed by the rate limit and sleeps until
+// it arrives
+func (p *Pool) wait() {
+	if p.interval == 0 {
+		return
+	}
+	p.rateMu.Lock()
+	now := p.clk.Now()
+	sendAt := p.nextSend
+	if sendAt.Before(now) {
+		sendAt = now
+	}
+	p.nextSend = sendAt.Add(p.interval)
+	p.rateMu.Unlock()
+	if delay := sendAt.Sub(now); delay > 0 {
+		p.clk.Sleep(delay)
+	}
+}
@@ -0,0 +1,187 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+
+	blog "github.com/letsencrypt/boulder/log"
+	"github.com/letsencrypt/boulder/metrics"
+	"github.com/letsencrypt/boulder/test"
+)
+
+// countingMailer records how many sends are in flight across all of the
+// countingMailers sharing its counter
+type countingMailer struct {
+	counter *sendCounter
+	release chan struct{}
+	err     error
+	closed  bool
+}
+
+type sendCounter struct {
+	sync.Mutex
+	inFlight    int
+	maxInFlight int
+	sent        int
+}
+
+func (c *countingMailer) send() error {
+	c.counter.Lock()
+	c.counter.inFlight++
+	if c.counter.inFlight > c.counter.maxInFlight {
+		c.counter.maxInFlight = c.counter.inFlight
+	}
+	c.counter.Unlock()
+	if c.release != nil {
+		<-c.release
+	}
+	c.counter.Lock()
+	c.counter.inFlight--
+	c.counter.sent++
+	c.counter.Unlock()
+	return c.err
+}
+
+func (c *countingMailer) SendMail([]string, string, string) error {
+	return c.send()
+}
+
+func (c *countingMailer) SendMultipartMail(to []string, subject, text, html string) error {
+	return c.send()
+}
+
+func (c *countingMailer) SendUnsubscribableMail(to, subject, text, html, unsubscribeURL string) error {
+	return c.send()
+}
+
+func (c *countingMailer) Connect() error {
+	return c.err
+}
+
+func (c *countingMailer) Close() error {
+	c.closed = true
+	return c.err
+}
+
+func TestPoolParallelism(t *testing.T) {
+	counter := &sendCounter{}
+	release := make(chan struct{})
+	var conns []Mailer
+	for i := 0; i < 3; i++ {
+		conns = append(conns, &countingMailer{counter: counter, release: release})
+	}
+	p := NewPool(conns, 0)
+	test.AssertNotError(t, p.Connect(), "Failed to connect pool")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.SendMail([]string{"hi@bye.com"}, "subject", "body"); err != nil {
+				t.Errorf("SendMail failed: %s", err)
+			}
+		}()
+	}
+	// Let every send through once they've had a chance to pile up on the
+	// connections
+	for i := 0; i < 10; i++ {
+		release <- struct{}{}
+	}
+	wg.Wait()
+
+	test.AssertEquals(t, counter.sent, 10)
+	if counter.maxInFlight > 3 {
+		t.Errorf("Expected at most 3 concurrent sends, got %d", counter.maxInFlight)
+	}
+	test.AssertNotError(t, p.Close(), "Failed to close pool")
+}
+
+func TestPoolRateLimit(t *testing.T) {
+	counter := &sendCounter{}
+	p := NewPool([]Mailer{&countingMailer{counter: counter}, &countingMailer{counter: counter}}, 4)
+	fc := clock.NewFake()
+	p.clk = fc
+	start := fc.Now()
+
+	for i := 0; i < 5; i++ {
+		err := p.SendUnsubscribableMail("hi@bye.com", "subject", "text", "", "https://example.com/unsubscribe")
+		test.AssertNotError(t, err, "SendUnsubscribableMail failed")
+	}
+	test.AssertEquals(t, counter.sent, 5)
+	// At four sends per second the fifth send starts a second after the first
+	test.AssertEquals(t, fc.Now().Sub(start), time.Second)
+
+	// Time spent idle isn't banked for later bursts
+	fc.Add(time.Hour)
+	start = fc.Now()
+	for i := 0; i < 2; i++ {
+		err := p.SendMultipartMail([]string{"hi@bye.com"}, "subject", "text", "<p>html</p>")
+		test.AssertNotError(t, err, "SendMultipartMail failed")
+	}
+	test.AssertEquals(t, fc.Now().Sub(start), 250*time.Millisecond)
+}
+
+func TestPoolErrors(t *testing.T) {
+	counter := &sendCounter{}
+	p := NewPool([]Mailer{&countingMailer{counter: counter, err: errors.New("oops")}}, 0)
+	test.AssertError(t, p.Connect(), "Connect should have failed")
+	test.AssertError(t, p.SendMail([]string{"hi@bye.com"}, "subject", "body"), "SendMail should have failed")
+	// The connection is returned to the pool after a failed send
+	test.AssertError(t, p.SendMail([]string{"hi@bye.com"}, "subject", "body"), "SendMail should have failed")
+	test.AssertError(t, p.Close(), "Close should have failed")
+}
+
+func TestPoolConnectFailure(t *testing.T) {
+	counter := &sendCounter{}
+	first := &countingMailer{counter: counter}
+	second := &countingMailer{counter: counter}
+	failing := &countingMailer{counter: counter, err: errors.New("oops")}
+	p := NewPool([]Mailer{first, second, failing}, 0)
+	test.AssertError(t, p.Connect(), "Connect should have failed")
+	// The connections made before the failure aren't leaked
+	test.Assert(t, first.closed && second.closed, "Connections weren't closed after a failed Connect")
+	test.Assert(t, !failing.closed, "Failed connection was closed")
+}
+
+func TestPooledReconnect(t *testing.T) {
+	_, l, cleanUp := setup(t)
+	defer cleanUp()
+	// The first connection made by each member of the pool is cut off, and
+	// each reconnects on its own
+	go listenForever(l, t, disconnectHandler(2))
+
+	fromAddress, _ := mail.ParseAddress("you-are-a-winner@example.com")
+	p := NewPooled(2, 0,
+		"localhost",
+		fmt.Sprintf("%d", l.Addr().(*net.TCPAddr).Port),
+		"user@example.com",
+		"paswd",
+		TLSNone,
+		nil,
+		*fromAddress,
+		blog.UseMock(),
+		metrics.NewNoopScope(),
+		time.Second*2, time.Second*10)
+	test.AssertNotError(t, p.Connect(), "Failed to connect pool")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.SendMail([]string{"hi@bye.com"}, "You are already a winner!", "Just kidding")
+			if err != nil {
+				t.Errorf("Expected SendMail() to not fail. Got err: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
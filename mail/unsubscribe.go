@@ -0,0 +1,104 @@
+package mail
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrBadUnsubscribeToken is returned when an unsubscribe token is malformed or
+// its signature doesn't verify
+var ErrBadUnsubscribeToken = errors.New("invalid unsubscribe token")
+
+// UnsubscribeSigner creates and verifies the HMAC-signed tokens in unsubscribe
+// links. A token identifies a registration ID and one of its contact email
+// addresses, so that each recipient can unsubscribe independently.
+type UnsubscribeSigner struct {
+	key     []byte
+	baseURL string
+}
+
+// NewUnsubscribeSigner returns an UnsubscribeSigner that signs tokens with key
+// and builds links to the unsubscribe endpoint at baseURL
+func NewUnsubscribeSigner(key []byte, baseURL string) (*UnsubscribeSigner, error) {
+	if len(key) < sha256.Size {
+		return nil, fmt.Errorf("unsubscribe key must be at least %d bytes", sha256.Size)
+	}
+	return &UnsubscribeSigner{key: key, baseURL: baseURL}, nil
+}
+
+func (us *UnsubscribeSigner) mac(payload []byte) []byte {
+	h := hmac.New(sha256.New, us.key)
+	_, _ = h.Write(payload)
+	return h.Sum(nil)
+}
+
+// Token returns a signed token for the given registration ID and email address
+func (us *UnsubscribeSigner) Token(regID int64, email string) string {
+	payload := []byte(fmt.Sprintf("%d:%s", regID, email))
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(us.mac(payload))
+}
+
+// URL returns the unsubscribe link for the given registration ID and email
+// address
+func (us *UnsubscribeSigner) URL(regID int64, email string) string {
+	return us.baseURL + "?token=" + url.QueryEscape(us.Token(regID, email))
+}
+
+// Verify checks the signature on token and returns the registration ID and
+// email address it carries
+func (us *UnsubscribeSigner) Verify(token string) (int64, string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return 0, "", ErrBadUnsubscribeToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return 0, "", ErrBadUnsubscribeToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, "", ErrBadUnsubscribeToken
+	}
+	if !hmac.Equal(sig, us.mac(payload)) {
+		return 0, "", ErrBadUnsubscribeToken
+	}
+	fields := strings.SplitN(string(payload), ":", 2)
+	if len(fields) != 2 || fields[1] == "" {
+		return 0, "", ErrBadUnsubscribeToken
+	}
+	regID, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, "", ErrBadUnsubscribeToken
+	}
+	return regID, fields[1], nil
+}
+
+// VerifyListUnsubscribe checks the signature on the token in the unsubscribe
+// link of a List-Unsubscribe header, as sent by SendUnsubscribableMail, and
+// returns the registration ID and email address it carries
+func (us *UnsubscribeSigner) VerifyListUnsubscribe(header string) (int64, string, error) {
+	// The header is a comma separated list of <URL>s
+	for _, link := range strings.Split(header, ",") {
+		link = strings.TrimSpace(link)
+		if !strings.HasPrefix(link, "<") || !strings.HasSuffix(link, ">") {
+			continue
+		}
+		parsed, err := url.Parse(link[1 : len(link)-1])
+		if err != nil {
+			continue
+		}
+		token := parsed.Query().Get("token")
+		if token == "" {
+			continue
+		}
+		return us.Verify(token)
+	}
+	return 0, "", ErrBadUnsubscribeToken
+}
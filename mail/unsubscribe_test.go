@@ -0,0 +1,60 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+var unsubscribeKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestUnsubscribeToken(t *testing.T) {
+	_, err := NewUnsubscribeSigner([]byte("short"), "")
+	test.AssertError(t, err, "Accepted a short unsubscribe key")
+
+	us, err := NewUnsubscribeSigner(unsubscribeKey, "https://example.com/unsubscribe")
+	test.AssertNotError(t, err, "Failed to create UnsubscribeSigner")
+
+	token := us.Token(1337, "a:b@example.com")
+	regID, email, err := us.Verify(token)
+	test.AssertNotError(t, err, "Failed to verify token")
+	test.AssertEquals(t, regID, int64(1337))
+	test.AssertEquals(t, email, "a:b@example.com")
+
+	test.Assert(t, strings.HasPrefix(us.URL(1337, "a:b@example.com"), "https://example.com/unsubscribe?token="), "Wrong unsubscribe URL")
+
+	other, err := NewUnsubscribeSigner([]byte("fedcba9876543210fedcba9876543210"), "")
+	test.AssertNotError(t, err, "Failed to create UnsubscribeSigner")
+	_, _, err = other.Verify(token)
+	test.AssertEquals(t, err, ErrBadUnsubscribeToken)
+
+	// Swap in another payload but keep the signature
+	otherToken := us.Token(1, "a:b@example.com")
+	forged := otherToken[:strings.Index(otherToken, ".")] + token[strings.Index(token, "."):]
+	_, _, err = us.Verify(forged)
+	test.AssertEquals(t, err, ErrBadUnsubscribeToken)
+
+	for _, bad := range []string{"", "abc", "a.b.c", "!!!.abc", token + "x"} {
+		_, _, err = us.Verify(bad)
+		test.AssertEquals(t, err, ErrBadUnsubscribeToken)
+	}
+}
+
+func TestVerifyListUnsubscribe(t *testing.T) {
+	us, err := NewUnsubscribeSigner(unsubscribeKey, "https://example.com/unsubscribe")
+	test.AssertNotError(t, err, "Failed to create UnsubscribeSigner")
+
+	header := "<mailto:unsubscribe@example.com>, <" + us.URL(1337, "a@example.com") + ">"
+	regID, email, err := us.VerifyListUnsubscribe(header)
+	test.AssertNotError(t, err, "Failed to verify List-Unsubscribe header")
+	test.AssertEquals(t, regID, int64(1337))
+	test.AssertEquals(t, email, "a@example.com")
+
+	other, err := NewUnsubscribeSigner([]byte("fedcba9876543210fedcba9876543210"), "https://example.com/unsubscribe")
+	test.AssertNotError(t, err, "Failed to create UnsubscribeSigner")
+	for _, bad := range []string{"", us.URL(1337, "a@example.com"), "<" + other.URL(1337, "a@example.com") + ">", "<https://example.com/unsubscribe>"} {
+		_, _, err = us.VerifyListUnsubscribe(bad)
+		test.AssertEquals(t, err, ErrBadUnsubscribeToken)
+	}
+}
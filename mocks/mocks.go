@@ -330,6 +330,11 @@ func (sa *StorageAuthority) CountRegistrationsByIP(_ context.Context, _ net.IP,
 	return 0, nil
 }
 
+// CountInvalidAuthorizations is a mock
+func (sa *StorageAuthority) CountInvalidAuthorizations(_ context.Context, _ int64, _ string, _, _ time.Time) (int, error) {
+	return 0, nil
+}
+
 // CountPendingAuthorizations is a mock
 func (sa *StorageAuthority) CountPendingAuthorizations(_ context.Context, _ int64) (int, error) {
 	return 0, nil
@@ -345,6 +350,77 @@ func (sa *StorageAuthority) DeactivateRegistration(_ context.Context, _ int64) e
 	return nil
 }
 
+// pageAfter returns up to limit entries of the sorted slice all that are
+// greater than after.
+func pageAfter(all []string, after string, limit int) []string {
+	var page []string
+	for _, v := range all {
+		if v > after && len(page) < limit {
+			page = append(page, v)
+		}
+	}
+	return page
+}
+
+// GetSerialsByRegistration is a mock
+func (sa *StorageAuthority) GetSerialsByRegistration(_ context.Context, regID int64, after string, limit int) ([]string, error) {
+	if regID != 1 {
+		return nil, nil
+	}
+	return pageAfter([]string{
+		"0000000000000000000000000000000000b2",
+		"0000000000000000000000000000000000ee",
+	}, after, limit), nil
+}
+
+// GetAuthorizationIDsByRegistration is a mock
+func (sa *StorageAuthority) GetAuthorizationIDsByRegistration(_ context.Context, regID int64, after string, limit int) ([]string, error) {
+	if regID != 1 {
+		return nil, nil
+	}
+	return pageAfter([]string{"valid"}, after, limit), nil
+}
+
+// AddBlockedKey is a mock
+func (sa *StorageAuthority) AddBlockedKey(_ context.Context, keyHash []byte, added time.Time, source string) error {
+	return nil
+}
+
+// KeyBlocked is a mock
+func (sa *StorageAuthority) KeyBlocked(_ context.Context, keyHash []byte) (bool, error) {
+	return false, nil
+}
+
+// AddEmailOptOut is a mock
+func (sa *StorageAuthority) AddEmailOptOut(_ context.Context, regID int64, email string, added time.Time) error {
+	return nil
+}
+
+// EmailOptedOut is a mock
+func (sa *StorageAuthority) EmailOptedOut(_ context.Context, email string) (bool, error) {
+	return email == "opted-out@example.com", nil
+}
+
+// AddUndeliverableEmail is a mock
+func (sa *StorageAuthority) AddUndeliverableEmail(_ context.Context, email string, reason string, added time.Time) error {
+	return nil
+}
+
+// RemoveUndeliverableEmail is a mock
+func (sa *StorageAuthority) RemoveUndeliverableEmail(_ context.Context, email string) error {
+	return nil
+}
+
+// EmailUndeliverable is a mock
+func (sa *StorageAuthority) EmailUndeliverable(_ context.Context, email string) (bool, error) {
+	return email == "bounced@example.com", nil
+}
+
+// AddWebhookDelivery is a mock
+func (sa *StorageAuthority) AddWebhookDelivery(_ context.Context, delivery core.WebhookDelivery) error {
+	return nil
+}
+
 // Publisher is a mock
 type Publisher struct {
 	// empty
@@ -403,6 +479,12 @@ type MailerMessage struct {
 	To      string
 	Subject string
 	Body    string
+	// HTML is empty unless the message was sent with SendMultipartMail or
+	// SendUnsubscribableMail
+	HTML string
+	// UnsubscribeURL is empty unless the message was sent with
+	// SendUnsubscribableMail
+	UnsubscribeURL string
 }
 
 // Clear removes any previously recorded messages
@@ -422,6 +504,31 @@ func (m *Mailer) SendMail(to []string, subject, msg string) error {
 	return nil
 }
 
+// SendMultipartMail is a mock
+func (m *Mailer) SendMultipartMail(to []string, subject, text, html string) error {
+	for _, rcpt := range to {
+		m.Messages = append(m.Messages, MailerMessage{
+			To:      rcpt,
+			Subject: subject,
+			Body:    text,
+			HTML:    html,
+		})
+	}
+	return nil
+}
+
+// SendUnsubscribableMail is a mock
+func (m *Mailer) SendUnsubscribableMail(to, subject, text, html, unsubscribeURL string) error {
+	m.Messages = append(m.Messages, MailerMessage{
+		To:             to,
+		Subject:        subject,
+		Body:           text,
+		HTML:           html,
+		UnsubscribeURL: unsubscribeURL,
+	})
+	return nil
+}
+
 // Close is a mock
 func (m *Mailer) Close() error {
 	return nil
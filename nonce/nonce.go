@@ -1,14 +1,18 @@
 package nonce
 
 import (
+	"container/heap"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"math/big"
+	"strings"
 	"sync"
-	"time"
+
+	"golang.org/x/net/context"
 
 	"github.com/letsencrypt/boulder/metrics"
 )
@@ -18,21 +22,61 @@ import (
 const MaxUsed = 65536
 const nonceLen = 32
 
+// PrefixLen is the length of the routing prefix that a NonceService
+// configured with a prefix places in front of every nonce it generates. The
+// prefix identifies the issuing instance so that a nonce handed out by one
+// nonce service can be redeemed at the same service later on.
+const PrefixLen = 4
+
 var errInvalidNonceLength = errors.New("invalid nonce length")
 
+// int64Heap is a min-heap of nonce counters, implementing heap.Interface.
+type int64Heap []int64
+
+func (h int64Heap) Len() int           { return len(h) }
+func (h int64Heap) Less(i, j int) bool { return h[i] < h[j] }
+func (h int64Heap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *int64Heap) Push(x interface{}) {
+	*h = append(*h, x.(int64))
+}
+
+func (h *int64Heap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
 // NonceService generates, cancels, and tracks Nonces.
 type NonceService struct {
 	mu       sync.Mutex
 	latest   int64
 	earliest int64
 	used     map[int64]bool
+	// usedHeap holds the same counters as used, ordered so that the lowest
+	// one can be evicted in O(log n) once more than maxUsed are held.
+	usedHeap *int64Heap
 	gcm      cipher.AEAD
 	maxUsed  int
+	prefix   string
 	stats    metrics.Scope
 }
 
-// NewNonceService constructs a NonceService with defaults
-func NewNonceService(scope metrics.Scope) (*NonceService, error) {
+// NewNonceService constructs a NonceService with defaults. If prefix is not
+// empty it must be PrefixLen characters of the base64url alphabet, and it is
+// prepended to every nonce generated by the service.
+func NewNonceService(scope metrics.Scope, prefix string) (*NonceService, error) {
+	if prefix != "" {
+		if len(prefix) != PrefixLen {
+			return nil, fmt.Errorf("nonce prefix must be %d characters, got %q", PrefixLen, prefix)
+		}
+		if _, err := base64.RawURLEncoding.DecodeString(prefix); err != nil {
+			return nil, fmt.Errorf("nonce prefix must be base64url encoded, got %q", prefix)
+		}
+	}
+
 	scope = scope.NewScope("NonceService")
 	key := make([]byte, 16)
 	if _, err := rand.Read(key); err != nil {
@@ -52,12 +96,24 @@ func NewNonceService(scope metrics.Scope) (*NonceService, error) {
 		earliest: 0,
 		latest:   0,
 		used:     make(map[int64]bool, MaxUsed),
+		usedHeap: &int64Heap{},
 		gcm:      gcm,
 		maxUsed:  MaxUsed,
+		prefix:   prefix,
 		stats:    scope,
 	}, nil
 }
 
+// Prefix returns the routing prefix of a nonce generated by a NonceService
+// configured with a prefix, or the empty string if the nonce is too short to
+// carry one.
+func Prefix(nonce string) string {
+	if len(nonce) < PrefixLen {
+		return ""
+	}
+	return nonce[:PrefixLen]
+}
+
 func (ns *NonceService) encrypt(counter int64) (string, error) {
 	// Generate a nonce with upper 4 bytes zero
 	nonce := make([]byte, 12)
@@ -114,56 +170,82 @@ func (ns *NonceService) Nonce() (string, error) {
 	latest := ns.latest
 	ns.mu.Unlock()
 	defer ns.stats.Inc("Generated", 1)
-	return ns.encrypt(latest)
-}
-
-// minUsed returns the lowest key in the used map. Requires that a lock be held
-// by caller.
-func (ns *NonceService) minUsed() int64 {
-	s := time.Now()
-	min := ns.latest
-	for t := range ns.used {
-		if t < min {
-			min = t
-		}
+	nonce, err := ns.encrypt(latest)
+	if err != nil {
+		return "", err
 	}
-	ns.stats.TimingDuration("LinearScan.Latency", time.Since(s))
-	return min
+	return ns.prefix + nonce, nil
 }
 
 // Valid determines whether the provided Nonce string is valid, returning
 // true if so.
 func (ns *NonceService) Valid(nonce string) bool {
-	c, err := ns.decrypt(nonce)
+	if !strings.HasPrefix(nonce, ns.prefix) {
+		ns.stats.Inc("Invalid.Prefix", 1)
+		return false
+	}
+	c, err := ns.decrypt(strings.TrimPrefix(nonce, ns.prefix))
 	if err != nil {
 		ns.stats.Inc("Invalid.Decrypt", 1)
 		return false
 	}
 
+	result, evicted := ns.redeem(c)
+	if evicted {
+		ns.stats.Inc("Used.Full", 1)
+	}
+	ns.stats.Inc(result, 1)
+	return result == "Valid"
+}
+
+// redeem marks the counter c as used if it is within the window of
+// outstanding nonces and hasn't been used before. It returns the name of the
+// stat describing the outcome, and whether the lowest used counter had to be
+// evicted to make room. Stats are left to the caller so that no time is spent
+// on them while holding the lock.
+func (ns *NonceService) redeem(c int64) (string, bool) {
 	ns.mu.Lock()
 	defer ns.mu.Unlock()
 	if c > ns.latest {
-		ns.stats.Inc("Invalid.TooHigh", 1)
-		return false
+		return "Invalid.TooHigh", false
 	}
 
 	if c <= ns.earliest {
-		ns.stats.Inc("Invalid.TooLow", 1)
-		return false
+		return "Invalid.TooLow", false
 	}
 
 	if ns.used[c] {
-		ns.stats.Inc("Invalid.AlreadyUsed", 1)
-		return false
+		return "Invalid.AlreadyUsed", false
 	}
 
 	ns.used[c] = true
-	if len(ns.used) > ns.maxUsed {
-		ns.stats.Inc("LinearScan.Full", 1)
-		ns.earliest = ns.minUsed()
-		delete(ns.used, ns.earliest)
+	heap.Push(ns.usedHeap, c)
+	if len(ns.used) <= ns.maxUsed {
+		return "Valid", false
 	}
+	ns.earliest = heap.Pop(ns.usedHeap).(int64)
+	delete(ns.used, ns.earliest)
+	return "Valid", true
+}
+
+// Local wraps a NonceService in the context-aware interface that the WFE uses
+// to talk to a nonce service, so that an in-process NonceService can be used
+// interchangeably with a remote one.
+type Local struct {
+	ns *NonceService
+}
+
+// NewLocal returns a Local backed by the provided NonceService.
+func NewLocal(ns *NonceService) *Local {
+	return &Local{ns}
+}
+
+// Nonce provides a new Nonce from the wrapped NonceService.
+func (l *Local) Nonce(_ context.Context) (string, error) {
+	return l.ns.Nonce()
+}
 
-	ns.stats.Inc("Valid", 1)
-	return true
+// Redeem marks the provided Nonce as used, returning true if it was valid.
+func (l *Local) Redeem(_ context.Context, nonce string) (bool, error) {
+	return l.ns.Valid(nonce), nil
 }
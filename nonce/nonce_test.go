@@ -4,12 +4,14 @@ import (
 	"fmt"
 	"testing"
 
+	"golang.org/x/net/context"
+
 	"github.com/letsencrypt/boulder/metrics"
 	"github.com/letsencrypt/boulder/test"
 )
 
 func TestValidNonce(t *testing.T) {
-	ns, err := NewNonceService(metrics.NewNoopScope())
+	ns, err := NewNonceService(metrics.NewNoopScope(), "")
 	test.AssertNotError(t, err, "Could not create nonce service")
 	n, err := ns.Nonce()
 	test.AssertNotError(t, err, "Could not create nonce")
@@ -17,7 +19,7 @@ func TestValidNonce(t *testing.T) {
 }
 
 func TestAlreadyUsed(t *testing.T) {
-	ns, err := NewNonceService(metrics.NewNoopScope())
+	ns, err := NewNonceService(metrics.NewNoopScope(), "")
 	test.AssertNotError(t, err, "Could not create nonce service")
 	n, err := ns.Nonce()
 	test.AssertNotError(t, err, "Could not create nonce")
@@ -26,7 +28,7 @@ func TestAlreadyUsed(t *testing.T) {
 }
 
 func TestRejectMalformed(t *testing.T) {
-	ns, err := NewNonceService(metrics.NewNoopScope())
+	ns, err := NewNonceService(metrics.NewNoopScope(), "")
 	test.AssertNotError(t, err, "Could not create nonce service")
 	n, err := ns.Nonce()
 	test.AssertNotError(t, err, "Could not create nonce")
@@ -34,15 +36,15 @@ func TestRejectMalformed(t *testing.T) {
 }
 
 func TestRejectShort(t *testing.T) {
-	ns, err := NewNonceService(metrics.NewNoopScope())
+	ns, err := NewNonceService(metrics.NewNoopScope(), "")
 	test.AssertNotError(t, err, "Could not create nonce service")
 	test.Assert(t, !ns.Valid("aGkK"), "Accepted an invalid nonce")
 }
 
 func TestRejectUnknown(t *testing.T) {
-	ns1, err := NewNonceService(metrics.NewNoopScope())
+	ns1, err := NewNonceService(metrics.NewNoopScope(), "")
 	test.AssertNotError(t, err, "Could not create nonce service")
-	ns2, err := NewNonceService(metrics.NewNoopScope())
+	ns2, err := NewNonceService(metrics.NewNoopScope(), "")
 	test.AssertNotError(t, err, "Could not create nonce service")
 
 	n, err := ns1.Nonce()
@@ -51,7 +53,7 @@ func TestRejectUnknown(t *testing.T) {
 }
 
 func TestRejectTooLate(t *testing.T) {
-	ns, err := NewNonceService(metrics.NewNoopScope())
+	ns, err := NewNonceService(metrics.NewNoopScope(), "")
 	test.AssertNotError(t, err, "Could not create nonce service")
 
 	ns.latest = 2
@@ -62,7 +64,7 @@ func TestRejectTooLate(t *testing.T) {
 }
 
 func TestRejectTooEarly(t *testing.T) {
-	ns, err := NewNonceService(metrics.NewNoopScope())
+	ns, err := NewNonceService(metrics.NewNoopScope(), "")
 	test.AssertNotError(t, err, "Could not create nonce service")
 	ns.maxUsed = 2
 
@@ -80,3 +82,138 @@ func TestRejectTooEarly(t *testing.T) {
 	test.Assert(t, ns.Valid(n1), "Rejected a valid nonce")
 	test.Assert(t, !ns.Valid(n0), "Accepted a nonce that we should have forgotten")
 }
+
+func TestInvalidPrefix(t *testing.T) {
+	_, err := NewNonceService(metrics.NewNoopScope(), "zinc")
+	test.AssertNotError(t, err, "Rejected a valid prefix")
+	_, err = NewNonceService(metrics.NewNoopScope(), "zin")
+	test.AssertError(t, err, "Accepted a prefix that was too short")
+	_, err = NewNonceService(metrics.NewNoopScope(), "zinc!")
+	test.AssertError(t, err, "Accepted a prefix that was too long")
+	_, err = NewNonceService(metrics.NewNoopScope(), "zi+c")
+	test.AssertError(t, err, "Accepted a prefix that wasn't base64url")
+}
+
+func TestPrefixedNonce(t *testing.T) {
+	ns, err := NewNonceService(metrics.NewNoopScope(), "zinc")
+	test.AssertNotError(t, err, "Could not create nonce service")
+	n, err := ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	test.AssertEquals(t, Prefix(n), "zinc")
+	test.Assert(t, ns.Valid(n), "Did not recognize fresh prefixed nonce")
+}
+
+func TestRejectWrongPrefix(t *testing.T) {
+	ns, err := NewNonceService(metrics.NewNoopScope(), "zinc")
+	test.AssertNotError(t, err, "Could not create nonce service")
+	n, err := ns.Nonce()
+	test.AssertNotError(t, err, "Could not create nonce")
+	test.Assert(t, !ns.Valid("tinc"+n[PrefixLen:]), "Accepted a nonce with the wrong prefix")
+	test.Assert(t, !ns.Valid(n[PrefixLen:]), "Accepted a nonce without a prefix")
+	test.AssertEquals(t, Prefix("ab"), "")
+}
+
+func TestLocal(t *testing.T) {
+	ns, err := NewNonceService(metrics.NewNoopScope(), "")
+	test.AssertNotError(t, err, "Could not create nonce service")
+	l := NewLocal(ns)
+	n, err := l.Nonce(context.Background())
+	test.AssertNotError(t, err, "Could not create nonce")
+	valid, err := l.Redeem(context.Background(), n)
+	test.AssertNotError(t, err, "Could not redeem nonce")
+	test.Assert(t, valid, "Did not recognize fresh nonce")
+	valid, err = l.Redeem(context.Background(), n)
+	test.AssertNotError(t, err, "Could not redeem nonce")
+	test.Assert(t, !valid, "Redeemed the same nonce twice")
+}
+
+func TestEvictsLowestUsed(t *testing.T) {
+	ns, err := NewNonceService(metrics.NewNoopScope(), "")
+	test.AssertNotError(t, err, "Could not create nonce service")
+	ns.maxUsed = 3
+
+	nonces := make([]string, 6)
+	for i := range nonces {
+		nonces[i], err = ns.Nonce()
+		test.AssertNotError(t, err, "Could not create nonce")
+	}
+
+	// Redeem out of order so the lowest used counter isn't the oldest
+	// redeemed one.
+	for _, i := range []int{4, 1, 5, 2} {
+		test.Assert(t, ns.Valid(nonces[i]), "Rejected a valid nonce")
+	}
+	test.AssertEquals(t, len(ns.used), ns.maxUsed)
+	test.AssertEquals(t, ns.usedHeap.Len(), ns.maxUsed)
+	// Counters are 1-based, so nonces[1] was counter 2.
+	test.AssertEquals(t, ns.earliest, int64(2))
+	test.Assert(t, !ns.Valid(nonces[0]), "Accepted a nonce below the evicted counter")
+	test.Assert(t, !ns.Valid(nonces[1]), "Accepted an evicted nonce")
+	test.Assert(t, ns.Valid(nonces[3]), "Rejected a valid nonce")
+	test.AssertEquals(t, ns.earliest, int64(3))
+}
+
+func BenchmarkNonce(b *testing.B) {
+	ns, err := NewNonceService(metrics.NewNoopScope(), "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ns.Nonce(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkValid measures redeeming nonces once the used set holds maxUsed
+// counters, so that every redemption also evicts the lowest one.
+func benchmarkValid(b *testing.B, maxUsed int) {
+	ns, err := NewNonceService(metrics.NewNoopScope(), "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	ns.maxUsed = maxUsed
+	for i := 0; i < maxUsed; i++ {
+		n, err := ns.Nonce()
+		if err != nil {
+			b.Fatal(err)
+		}
+		ns.Valid(n)
+	}
+	nonces := make([]string, b.N)
+	for i := range nonces {
+		nonces[i], err = ns.Nonce()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !ns.Valid(nonces[i]) {
+			b.Fatal("Rejected a valid nonce")
+		}
+	}
+}
+
+func BenchmarkValid1K(b *testing.B)  { benchmarkValid(b, 1024) }
+func BenchmarkValid64K(b *testing.B) { benchmarkValid(b, MaxUsed) }
+func BenchmarkValid1M(b *testing.B)  { benchmarkValid(b, 1<<20) }
+
+func BenchmarkNonceValidParallel(b *testing.B) {
+	ns, err := NewNonceService(metrics.NewNoopScope(), "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n, err := ns.Nonce()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if !ns.Valid(n) {
+				b.Fatal("Rejected a valid nonce")
+			}
+		}
+	})
+}
@@ -0,0 +1,3 @@
+package proto
+
+//go:generate sh -c "protoc --go_out=plugins=grpc:. nonce.proto"
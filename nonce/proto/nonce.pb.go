@@ -0,0 +1,205 @@
+// Code generated by protoc-gen-go.
+// source: nonce.proto
+// DO NOT EDIT!
+
+/*
+Package proto is a generated protocol buffer package.
+
+It is generated from these files:
+	nonce.proto
+
+It has these top-level messages:
+	NonceRequest
+	NonceMessage
+	ValidMessage
+*/
+package proto
+
+import proto1 "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto1.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto1.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type NonceRequest struct {
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *NonceRequest) Reset()                    { *m = NonceRequest{} }
+func (m *NonceRequest) String() string            { return proto1.CompactTextString(m) }
+func (*NonceRequest) ProtoMessage()               {}
+func (*NonceRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+type NonceMessage struct {
+	Nonce            *string `protobuf:"bytes,1,opt,name=nonce" json:"nonce,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *NonceMessage) Reset()                    { *m = NonceMessage{} }
+func (m *NonceMessage) String() string            { return proto1.CompactTextString(m) }
+func (*NonceMessage) ProtoMessage()               {}
+func (*NonceMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *NonceMessage) GetNonce() string {
+	if m != nil && m.Nonce != nil {
+		return *m.Nonce
+	}
+	return ""
+}
+
+type ValidMessage struct {
+	Valid            *bool  `protobuf:"varint,1,opt,name=valid" json:"valid,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *ValidMessage) Reset()                    { *m = ValidMessage{} }
+func (m *ValidMessage) String() string            { return proto1.CompactTextString(m) }
+func (*ValidMessage) ProtoMessage()               {}
+func (*ValidMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *ValidMessage) GetValid() bool {
+	if m != nil && m.Valid != nil {
+		return *m.Valid
+	}
+	return false
+}
+
+func init() {
+	proto1.RegisterType((*NonceRequest)(nil), "nonce.NonceRequest")
+	proto1.RegisterType((*NonceMessage)(nil), "nonce.NonceMessage")
+	proto1.RegisterType((*ValidMessage)(nil), "nonce.ValidMessage")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion3
+
+// Client API for NonceService service
+
+type NonceServiceClient interface {
+	Nonce(ctx context.Context, in *NonceRequest, opts ...grpc.CallOption) (*NonceMessage, error)
+	Redeem(ctx context.Context, in *NonceMessage, opts ...grpc.CallOption) (*ValidMessage, error)
+}
+
+type nonceServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewNonceServiceClient(cc *grpc.ClientConn) NonceServiceClient {
+	return &nonceServiceClient{cc}
+}
+
+func (c *nonceServiceClient) Nonce(ctx context.Context, in *NonceRequest, opts ...grpc.CallOption) (*NonceMessage, error) {
+	out := new(NonceMessage)
+	err := grpc.Invoke(ctx, "/nonce.NonceService/Nonce", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nonceServiceClient) Redeem(ctx context.Context, in *NonceMessage, opts ...grpc.CallOption) (*ValidMessage, error) {
+	out := new(ValidMessage)
+	err := grpc.Invoke(ctx, "/nonce.NonceService/Redeem", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for NonceService service
+
+type NonceServiceServer interface {
+	Nonce(context.Context, *NonceRequest) (*NonceMessage, error)
+	Redeem(context.Context, *NonceMessage) (*ValidMessage, error)
+}
+
+func RegisterNonceServiceServer(s *grpc.Server, srv NonceServiceServer) {
+	s.RegisterService(&_NonceService_serviceDesc, srv)
+}
+
+func _NonceService_Nonce_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NonceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NonceServiceServer).Nonce(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nonce.NonceService/Nonce",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NonceServiceServer).Nonce(ctx, req.(*NonceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NonceService_Redeem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NonceMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NonceServiceServer).Redeem(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/nonce.NonceService/Redeem",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NonceServiceServer).Redeem(ctx, req.(*NonceMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _NonceService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "nonce.NonceService",
+	HandlerType: (*NonceServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Nonce",
+			Handler:    _NonceService_Nonce_Handler,
+		},
+		{
+			MethodName: "Redeem",
+			Handler:    _NonceService_Redeem_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: fileDescriptor0,
+}
+
+func init() { proto1.RegisterFile("nonce.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 144 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xe3, 0xe2, 0xce, 0xcb, 0xcf, 0x4b,
+	0x4e, 0xd5, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x05, 0x73, 0x94, 0xf8, 0xb8, 0x78, 0xfc,
+	0x40, 0x8c, 0xa0, 0xd4, 0xc2, 0xd2, 0xd4, 0xe2, 0x12, 0x25, 0x59, 0x28, 0xdf, 0x37, 0xb5, 0xb8,
+	0x38, 0x31, 0x3d, 0x55, 0x88, 0x97, 0x0b, 0xa2, 0x50, 0x82, 0x51, 0x81, 0x51, 0x83, 0x13, 0x24,
+	0x1d, 0x96, 0x98, 0x93, 0x99, 0x82, 0x24, 0x5d, 0x06, 0xe2, 0x83, 0xa5, 0x39, 0x8c, 0x2a, 0xa1,
+	0xba, 0x83, 0x53, 0x8b, 0xca, 0x32, 0x93, 0x53, 0x85, 0x8c, 0xb9, 0x58, 0xc1, 0x7c, 0x21, 0x61,
+	0x3d, 0x88, 0xdd, 0xc8, 0x76, 0x49, 0xa1, 0x08, 0x42, 0x4d, 0x54, 0x62, 0x10, 0x32, 0xe1, 0x62,
+	0x0b, 0x4a, 0x4d, 0x49, 0x4d, 0xcd, 0x15, 0xc2, 0xa6, 0x00, 0xae, 0x0b, 0xd9, 0x1d, 0x4a, 0x0c,
+	0x4e, 0xec, 0x51, 0xac, 0x60, 0x8f, 0x01, 0x00, 0xe0, 0xae, 0x08, 0xdf, 0xe6, 0x00, 0x00, 0x00,
+}
@@ -0,0 +1,98 @@
+package ra
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+
+	"github.com/letsencrypt/boulder/metrics"
+	"github.com/letsencrypt/boulder/probs"
+)
+
+// emailDomainCache holds the results of contact email domain validation, so
+// that registrations using a popular mail domain don't each wait on its MX
+// and A lookups. Only definitive results are cached; DNS errors such as
+// timeouts are not.
+type emailDomainCache struct {
+	clk   clock.Clock
+	stats metrics.Scope
+
+	// ttl is how long a valid domain is cached for, and negativeTTL how long
+	// an invalid one is
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+
+	mu      sync.Mutex
+	entries map[string]emailDomainResult
+}
+
+type emailDomainResult struct {
+	prob    *probs.ProblemDetails
+	expires time.Time
+}
+
+func newEmailDomainCache(ttl, negativeTTL time.Duration, maxEntries int, clk clock.Clock, stats metrics.Scope) *emailDomainCache {
+	return &emailDomainCache{
+		clk:         clk,
+		stats:       stats,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		maxEntries:  maxEntries,
+		entries:     make(map[string]emailDomainResult),
+	}
+}
+
+// get returns the cached result for domain, and whether there was one
+func (c *emailDomainCache) get(domain string) (*probs.ProblemDetails, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result, present := c.entries[domain]
+	if !present || !c.clk.Now().Before(result.expires) {
+		c.stats.Inc("Misses", 1)
+		return nil, false
+	}
+	c.stats.Inc("Hits", 1)
+	return result.prob, true
+}
+
+// set caches the result of validating domain. prob is nil if the domain is
+// valid.
+func (c *emailDomainCache) set(domain string, prob *probs.ProblemDetails) {
+	ttl := c.ttl
+	if prob != nil {
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clk.Now()
+	if _, present := c.entries[domain]; !present && c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[domain] = emailDomainResult{prob: prob, expires: now.Add(ttl)}
+	_ = c.stats.Gauge("Size", int64(len(c.entries)))
+}
+
+// evict makes room for a new entry by removing every expired entry or, if
+// none have expired, an arbitrary one. c.mu must be held.
+func (c *emailDomainCache) evict(now time.Time) {
+	evicted := 0
+	for domain, result := range c.entries {
+		if !now.Before(result.expires) {
+			delete(c.entries, domain)
+			evicted++
+		}
+	}
+	if evicted == 0 {
+		for domain := range c.entries {
+			delete(c.entries, domain)
+			evicted++
+			break
+		}
+	}
+	c.stats.Inc("Evictions", int64(evicted))
+}
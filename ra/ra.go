@@ -1,6 +1,7 @@
 package ra
 
 import (
+	"crypto/sha256"
 	"crypto/x509"
 	"errors"
 	"expvar"
@@ -21,6 +22,7 @@ import (
 	"github.com/letsencrypt/boulder/bdns"
 	"github.com/letsencrypt/boulder/core"
 	csrlib "github.com/letsencrypt/boulder/csr"
+	"github.com/letsencrypt/boulder/features"
 	"github.com/letsencrypt/boulder/goodkey"
 	blog "github.com/letsencrypt/boulder/log"
 	"github.com/letsencrypt/boulder/metrics"
@@ -29,6 +31,7 @@ import (
 	"github.com/letsencrypt/boulder/reloader"
 	"github.com/letsencrypt/boulder/revocation"
 	vaPB "github.com/letsencrypt/boulder/va/proto"
+	"github.com/letsencrypt/boulder/webhook"
 )
 
 // Note: the issuanceExpvar must be a global. If it is a member of the RA, or
@@ -63,12 +66,32 @@ type RegistrationAuthorityImpl struct {
 	forceCNFromSAN               bool
 	reuseValidAuthz              bool
 
+	// Webhooks, if set, is sent notifications of account events for the
+	// https: contacts of registrations
+	Webhooks webhookNotifier
+
+	// emailCache, if set, caches the results of contact email domain
+	// validation. When asyncEmailValidation is true, contacts whose domain
+	// isn't cached are accepted straight away and their domain is validated
+	// in the background; emailRechecks maps the domains being validated, at
+	// most maxEmailRechecks of them, to the addresses accepted with them.
+	emailCache           *emailDomainCache
+	asyncEmailValidation bool
+	maxEmailRechecks     int
+	emailRechecksMu      sync.Mutex
+	emailRechecks        map[string][]string
+
 	regByIPStats         metrics.Scope
 	pendAuthByRegIDStats metrics.Scope
+	failAuthByRegIDStats metrics.Scope
 	certsForDomainStats  metrics.Scope
 	totalCertsStats      metrics.Scope
 }
 
+type webhookNotifier interface {
+	Notify(ctx context.Context, hooks []string, event webhook.Event)
+}
+
 // NewRegistrationAuthorityImpl constructs a new RA object.
 func NewRegistrationAuthorityImpl(
 	clk clock.Clock,
@@ -97,6 +120,7 @@ func NewRegistrationAuthorityImpl(
 		reuseValidAuthz:              reuseValidAuthz,
 		regByIPStats:                 stats.NewScope("RA", "RateLimit", "RegistrationsByIP"),
 		pendAuthByRegIDStats:         stats.NewScope("RA", "RateLimit", "PendingAuthorizationsByRegID"),
+		failAuthByRegIDStats:         stats.NewScope("RA", "RateLimit", "FailedAuthorizationsByRegID"),
 		certsForDomainStats:          stats.NewScope("RA", "RateLimit", "CertificatesForDomain"),
 		totalCertsStats:              stats.NewScope("RA", "RateLimit", "TotalCertificates"),
 	}
@@ -123,15 +147,32 @@ const (
 )
 
 func validateEmail(ctx context.Context, address string, resolver bdns.DNSResolver) (prob *probs.ProblemDetails) {
+	domain, prob := emailDomain(address)
+	if prob != nil {
+		return prob
+	}
+	prob, _ = validateEmailDomain(ctx, domain, resolver)
+	return prob
+}
+
+// emailDomain checks that address is a single email address and returns its
+// lowercased domain
+func emailDomain(address string) (string, *probs.ProblemDetails) {
 	emails, err := mail.ParseAddressList(address)
 	if err != nil {
-		return probs.InvalidEmail(unparseableEmailDetail)
+		return "", probs.InvalidEmail(unparseableEmailDetail)
 	}
 	if len(emails) > 1 {
-		return probs.InvalidEmail(multipleAddressDetail)
+		return "", probs.InvalidEmail(multipleAddressDetail)
 	}
 	splitEmail := strings.SplitN(emails[0].Address, "@", -1)
-	domain := strings.ToLower(splitEmail[len(splitEmail)-1])
+	return strings.ToLower(splitEmail[len(splitEmail)-1]), nil
+}
+
+// validateEmailDomain checks that domain has an MX or A record, so that mail
+// can be delivered to it. The returned bool is false when the result was
+// caused by a DNS error, such as a timeout, and so shouldn't be cached.
+func validateEmailDomain(ctx context.Context, domain string, resolver bdns.DNSResolver) (*probs.ProblemDetails, bool) {
 	var resultMX []string
 	var resultA []net.IP
 	var errMX, errA error
@@ -150,21 +191,154 @@ func validateEmail(ctx context.Context, address string, resolver bdns.DNSResolve
 	if errMX != nil {
 		prob := bdns.ProblemDetailsFromDNSError(errMX)
 		prob.Type = probs.InvalidEmailProblem
-		return prob
+		return prob, false
 	} else if len(resultMX) > 0 {
-		return nil
+		return nil, true
 	}
 	if errA != nil {
 		prob := bdns.ProblemDetailsFromDNSError(errA)
 		prob.Type = probs.InvalidEmailProblem
-		return prob
+		return prob, false
 	} else if len(resultA) > 0 {
+		return nil, true
+	}
+
+	return probs.InvalidEmail(emptyDNSResponseDetail), true
+}
+
+// emailRecheckTimeout bounds the background validation of a contact email
+// domain in async mode
+const emailRecheckTimeout = time.Minute
+
+// defaultMaxEmailRechecks is the number of domains that may be validated in
+// the background at once in async mode, if SetEmailValidation isn't given one
+const defaultMaxEmailRechecks = 100
+
+// SetEmailValidation configures how contact email domains are validated.
+// Results are cached for cacheTTL if the domain is valid and negativeCacheTTL
+// if it isn't, in a cache of at most cacheSize domains; a zero TTL disables
+// caching of that kind of result. When async is true, contacts whose domain
+// isn't cached are accepted and the domain is validated in the background,
+// for up to maxRechecks domains at once; see recheckEmailDomain.
+func (ra *RegistrationAuthorityImpl) SetEmailValidation(cacheTTL, negativeCacheTTL time.Duration, cacheSize int, async bool, maxRechecks int) {
+	ra.emailCache = nil
+	if cacheTTL > 0 || negativeCacheTTL > 0 {
+		ra.emailCache = newEmailDomainCache(cacheTTL, negativeCacheTTL, cacheSize, ra.clk,
+			ra.stats.NewScope("ValidateEmail", "Cache"))
+	}
+	ra.asyncEmailValidation = async
+	if maxRechecks <= 0 {
+		maxRechecks = defaultMaxEmailRechecks
+	}
+	ra.maxEmailRechecks = maxRechecks
+	ra.emailRechecks = make(map[string][]string)
+}
+
+// checkEmail validates the contact email address, using cached results for
+// its domain where possible
+func (ra *RegistrationAuthorityImpl) checkEmail(ctx context.Context, address string) *probs.ProblemDetails {
+	domain, prob := emailDomain(address)
+	if prob != nil {
+		return prob
+	}
+	if ra.emailCache != nil {
+		if prob, present := ra.emailCache.get(domain); present {
+			return prob
+		}
+	}
+	if ra.asyncEmailValidation && ra.recheckEmailDomain(address, domain) {
+		ra.stats.Inc("ValidateEmail.Async.Deferred", 1)
 		return nil
 	}
+	return ra.lookupEmailDomain(ctx, domain)
+}
 
-	return probs.InvalidEmail(emptyDNSResponseDetail)
+// lookupEmailDomain validates domain and caches the result
+func (ra *RegistrationAuthorityImpl) lookupEmailDomain(ctx context.Context, domain string) *probs.ProblemDetails {
+	prob, definitive := validateEmailDomain(ctx, domain, ra.DNSResolver)
+	if definitive && ra.emailCache != nil {
+		ra.emailCache.set(domain, prob)
+	}
+	return prob
 }
 
+// recheckEmailDomain validates the domain of a contact that is accepted
+// without validation, in the background, and returns true. Contacts with the
+// same domain share a single validation. If maxEmailRechecks domains are
+// already being validated it returns false, and the caller should validate
+// the domain itself.
+//
+// A failure doesn't affect the registrations. When the UndeliverableEmails
+// feature is enabled, each contact whose domain is definitely invalid is
+// recorded as undeliverable, so that the mailers skip it and operators can
+// follow it up, and a contact whose domain is valid has any such record
+// cleared. Failures that may be transient, such as DNS timeouts, are only
+// logged.
+//
+// Pending validations are only held in memory, so contacts accepted shortly
+// before the RA stops are never validated.
+func (ra *RegistrationAuthorityImpl) recheckEmailDomain(address, domain string) bool {
+	ra.emailRechecksMu.Lock()
+	defer ra.emailRechecksMu.Unlock()
+	addresses, pending := ra.emailRechecks[domain]
+	if !pending && len(ra.emailRechecks) >= ra.maxEmailRechecks {
+		ra.stats.Inc("ValidateEmail.Async.Full", 1)
+		return false
+	}
+	ra.emailRechecks[domain] = append(addresses, address)
+	if pending {
+		return true
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), emailRecheckTimeout)
+		defer cancel()
+		prob, definitive := validateEmailDomain(ctx, domain, ra.DNSResolver)
+		if definitive && ra.emailCache != nil {
+			ra.emailCache.set(domain, prob)
+		}
+
+		ra.emailRechecksMu.Lock()
+		addresses := ra.emailRechecks[domain]
+		delete(ra.emailRechecks, domain)
+		ra.emailRechecksMu.Unlock()
+
+		if prob == nil {
+			if !features.Enabled(features.UndeliverableEmails) {
+				return
+			}
+			// A contact that failed an earlier validation has been
+			// submitted again with a domain that is now valid
+			for _, address := range addresses {
+				if err := ra.SA.RemoveUndeliverableEmail(ctx, address); err != nil {
+					ra.stats.Inc("ValidateEmail.Async.RecordErrors", 1)
+					ra.log.AuditErr(fmt.Sprintf("Failed to clear undeliverable contact email %s: %s", address, err))
+				}
+			}
+			return
+		}
+		for _, address := range addresses {
+			ra.stats.Inc("ValidateEmail.Async.Failures", 1)
+			ra.log.Warning(fmt.Sprintf("Contact email %s was accepted but failed validation: %s", address, prob.Detail))
+			if !definitive || !features.Enabled(features.UndeliverableEmails) {
+				continue
+			}
+			reason := "failed validation: " + prob.Detail
+			if len(reason) > maxUndeliverableReason {
+				reason = reason[:maxUndeliverableReason]
+			}
+			if err := ra.SA.AddUndeliverableEmail(ctx, address, reason, ra.clk.Now()); err != nil {
+				ra.stats.Inc("ValidateEmail.Async.RecordErrors", 1)
+				ra.log.AuditErr(fmt.Sprintf("Failed to record contact email %s as undeliverable: %s", address, err))
+			}
+		}
+	}()
+	return true
+}
+
+// maxUndeliverableReason is the length of the undeliverableEmails reason
+// column
+const maxUndeliverableReason = 255
+
 type certificateRequestEvent struct {
 	ID                  string    `json:",omitempty"`
 	Requester           int64     `json:",omitempty"`
@@ -248,7 +422,10 @@ func (ra *RegistrationAuthorityImpl) checkRegistrationLimit(ctx context.Context,
 
 // NewRegistration constructs a new Registration from a request.
 func (ra *RegistrationAuthorityImpl) NewRegistration(ctx context.Context, init core.Registration) (reg core.Registration, err error) {
-	if err = ra.keyPolicy.GoodKey(init.Key.Key); err != nil {
+	if err = ra.keyPolicy.GoodKey(ctx, init.Key.Key); err != nil {
+		if _, ok := err.(core.InternalServerError); ok {
+			return core.Registration{}, err
+		}
 		return core.Registration{}, core.MalformedRequestError(fmt.Sprintf("Invalid public key: %s", err.Error()))
 	}
 	if err = ra.checkRegistrationLimit(ctx, init.InitialIP); err != nil {
@@ -299,6 +476,12 @@ func (ra *RegistrationAuthorityImpl) validateContacts(ctx context.Context, conta
 		if err != nil {
 			return core.MalformedRequestError("Invalid contact")
 		}
+		if parsed.Scheme == "https" && features.Enabled(features.WebhookContacts) {
+			if err := ra.validateWebhook(contact, parsed); err != nil {
+				return err
+			}
+			continue
+		}
 		if parsed.Scheme != "mailto" {
 			return core.MalformedRequestError(fmt.Sprintf("Contact method %s is not supported", parsed.Scheme))
 		}
@@ -309,18 +492,64 @@ func (ra *RegistrationAuthorityImpl) validateContacts(ctx context.Context, conta
 
 		start := ra.clk.Now()
 		ra.stats.Inc("ValidateEmail.Calls", 1)
-		problem := validateEmail(ctx, parsed.Opaque, ra.DNSResolver)
+		problem := ra.checkEmail(ctx, parsed.Opaque)
 		ra.stats.TimingDuration("ValidateEmail.Latency", ra.clk.Now().Sub(start))
 		if problem != nil {
 			ra.stats.Inc("ValidateEmail.Errors", 1)
 			return problem
 		}
 		ra.stats.Inc("ValidateEmail.Successes", 1)
+
+		// Addresses that have bounced are still accepted, since the subscriber
+		// may have fixed their mailbox. Submitting one again is taken as a
+		// sign that it works, so mailers start sending to it again.
+		if features.Enabled(features.UndeliverableEmails) {
+			undeliverable, err := ra.SA.EmailUndeliverable(ctx, parsed.Opaque)
+			if err != nil {
+				return err
+			}
+			if undeliverable {
+				ra.stats.Inc("ValidateEmail.Undeliverable", 1)
+				ra.log.Warning(fmt.Sprintf("Contact email %s has previously bounced", parsed.Opaque))
+				if err := ra.SA.RemoveUndeliverableEmail(ctx, parsed.Opaque); err != nil {
+					return err
+				}
+			}
+		}
 	}
 
 	return nil
 }
 
+// validateWebhook checks that an https: contact is an absolute URL on the
+// default port, without credentials, whose host is a name the PA would issue
+// for. This keeps webhooks from being pointed at IP addresses or internal
+// names.
+func (ra *RegistrationAuthorityImpl) validateWebhook(contact string, parsed *url.URL) error {
+	if !core.IsASCII(contact) {
+		return core.MalformedRequestError(
+			fmt.Sprintf("Contact webhook [%s] contains non-ASCII characters", contact))
+	}
+	if parsed.Opaque != "" || parsed.User != nil || parsed.Host == "" {
+		return core.MalformedRequestError(
+			fmt.Sprintf("Contact webhook [%s] must be an absolute URL without credentials", contact))
+	}
+	host := parsed.Host
+	if h, port, err := net.SplitHostPort(parsed.Host); err == nil {
+		if port != "443" {
+			return core.MalformedRequestError(
+				fmt.Sprintf("Contact webhook [%s] must use the default HTTPS port", contact))
+		}
+		host = h
+	}
+	identifier := core.AcmeIdentifier{Type: core.IdentifierDNS, Value: strings.ToLower(host)}
+	if err := ra.PA.WillingToIssue(identifier); err != nil {
+		return core.MalformedRequestError(
+			fmt.Sprintf("Contact webhook [%s] has an invalid host: %s", contact, err))
+	}
+	return nil
+}
+
 func (ra *RegistrationAuthorityImpl) checkPendingAuthorizationLimit(ctx context.Context, regID int64) error {
 	limit := ra.rlPolicies.PendingAuthorizationsPerAccount()
 	if limit.Enabled() {
@@ -341,6 +570,30 @@ func (ra *RegistrationAuthorityImpl) checkPendingAuthorizationLimit(ctx context.
 	return nil
 }
 
+// checkFailedAuthorizationLimit checks whether the registration has failed too
+// many authorizations for the hostname recently. Invalid authorizations are
+// counted by their expiry: an authorization created now expires after the
+// pending authorization lifetime, so the window ends there.
+func (ra *RegistrationAuthorityImpl) checkFailedAuthorizationLimit(ctx context.Context, regID int64, hostname string) error {
+	limit := ra.rlPolicies.FailedAuthorizationsPerAccount()
+	if !limit.Enabled() {
+		return nil
+	}
+	latest := ra.clk.Now().Add(ra.pendingAuthorizationLifetime)
+	earliest := latest.Add(-limit.Window.Duration)
+	count, err := ra.SA.CountInvalidAuthorizations(ctx, regID, hostname, earliest, latest)
+	if err != nil {
+		return err
+	}
+	if count >= limit.GetThreshold(hostname, regID) {
+		ra.failAuthByRegIDStats.Inc("Exceeded", 1)
+		ra.log.Info(fmt.Sprintf("Rate limit exceeded, FailedAuthorizationsByRegID, regID: %d, hostname: %s", regID, hostname))
+		return core.RateLimitedError(fmt.Sprintf("Too many failed authorizations recently for %s.", hostname))
+	}
+	ra.failAuthByRegIDStats.Inc("Pass", 1)
+	return nil
+}
+
 // NewAuthorization constructs a new Authz from a request. Values (domains) in
 // request.Identifier will be lowercased before storage.
 func (ra *RegistrationAuthorityImpl) NewAuthorization(ctx context.Context, request core.Authorization, regID int64) (authz core.Authorization, err error) {
@@ -357,6 +610,10 @@ func (ra *RegistrationAuthorityImpl) NewAuthorization(ctx context.Context, reque
 	}
 
 	if identifier.Type == core.IdentifierDNS {
+		if err = ra.checkFailedAuthorizationLimit(ctx, regID, identifier.Value); err != nil {
+			return authz, err
+		}
+
 		isSafeResp, err := ra.VA.IsSafeDomain(ctx, &vaPB.IsSafeDomainRequest{Domain: &identifier.Value})
 		if err != nil {
 			outErr := core.InternalServerError("unable to determine if domain was safe")
@@ -577,8 +834,10 @@ func (ra *RegistrationAuthorityImpl) NewCertificate(ctx context.Context, req cor
 
 	// Verify the CSR
 	csr := req.CSR
-	if err := csrlib.VerifyCSR(csr, ra.maxNames, &ra.keyPolicy, ra.PA, ra.forceCNFromSAN, regID); err != nil {
-		err = core.MalformedRequestError(err.Error())
+	if err := csrlib.VerifyCSR(ctx, csr, ra.maxNames, &ra.keyPolicy, ra.PA, ra.forceCNFromSAN, regID); err != nil {
+		if _, ok := err.(core.InternalServerError); !ok {
+			err = core.MalformedRequestError(err.Error())
+		}
 		return emptyCert, err
 	}
 
@@ -995,7 +1254,14 @@ func (ra *RegistrationAuthorityImpl) RevokeCertificateWithReg(ctx context.Contex
 		return err
 	}
 
+	err = ra.blockCompromisedKey(ctx, cert, revocationCode, fmt.Sprintf("registration %d", regID))
+	if err != nil {
+		state = fmt.Sprintf("Failure -- %s", err)
+		return err
+	}
+
 	state = "Success"
+	ra.notifyRevocation(cert, revocationCode)
 	return nil
 }
 
@@ -1027,11 +1293,83 @@ func (ra *RegistrationAuthorityImpl) AdministrativelyRevokeCertificate(ctx conte
 		return err
 	}
 
+	err = ra.blockCompromisedKey(ctx, cert, revocationCode, fmt.Sprintf("admin-revoker user %s", user))
+	if err != nil {
+		state = fmt.Sprintf("Failure -- %s", err)
+		return err
+	}
+
 	state = "Success"
 	ra.stats.Inc("RevokedCertificates", 1)
+	ra.notifyRevocation(cert, revocationCode)
 	return nil
 }
 
+// blockCompromisedKey adds the key of a certificate revoked for keyCompromise
+// to the SA's blocked keys, so that it can't be used for a registration or
+// certificate again. source is recorded as the reason the key was blocked.
+func (ra *RegistrationAuthorityImpl) blockCompromisedKey(ctx context.Context, cert x509.Certificate, revocationCode revocation.Reason, source string) error {
+	if revocationCode != revocation.KeyCompromise || !features.Enabled(features.BlockedKeyTable) {
+		return nil
+	}
+	keyHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	err := ra.SA.AddBlockedKey(ctx, keyHash[:], ra.clk.Now(), fmt.Sprintf("keyCompromise revocation of %s by %s", core.SerialToString(cert.SerialNumber), source))
+	if err != nil {
+		return fmt.Errorf("certificate revoked, but its key could not be blocked: %s", err)
+	}
+	ra.stats.Inc("BlockedKeys", 1)
+	return nil
+}
+
+// notifyRevocation tells the webhooks of the registration that owns a revoked
+// certificate about the revocation. That may not be the registration that
+// requested it, since certificates can also be revoked using their key.
+func (ra *RegistrationAuthorityImpl) notifyRevocation(cert x509.Certificate, revocationCode revocation.Reason) {
+	if ra.Webhooks == nil || !features.Enabled(features.WebhookContacts) {
+		return
+	}
+	serial := core.SerialToString(cert.SerialNumber)
+	event := webhook.Event{
+		Type: webhook.CertificateRevoked,
+		Certificates: []webhook.Certificate{{
+			Serial:   serial,
+			Expires:  cert.NotAfter,
+			DNSNames: cert.DNSNames,
+		}},
+		RevocationReason: revocation.ReasonToString[revocationCode],
+	}
+	go func() {
+		ctx := context.Background()
+		stored, err := ra.SA.GetCertificate(ctx, serial)
+		if err != nil {
+			ra.log.AuditErr(fmt.Sprintf("Failed to look up owner of revoked certificate %s for webhooks: %s", serial, err))
+			return
+		}
+		ra.sendWebhooks(ctx, stored.RegistrationID, event)
+	}()
+}
+
+// sendWebhooks delivers event to the webhook contacts of registration regID.
+// Callers run it in the background, so that slow webhooks don't hold up the
+// request that caused the event.
+func (ra *RegistrationAuthorityImpl) sendWebhooks(ctx context.Context, regID int64, event webhook.Event) {
+	reg, err := ra.SA.GetRegistration(ctx, regID)
+	if err != nil {
+		ra.log.AuditErr(fmt.Sprintf("Failed to look up registration %d for webhooks: %s", regID, err))
+		return
+	}
+	if reg.Contact == nil {
+		return
+	}
+	hooks := webhook.Contacts(*reg.Contact)
+	if len(hooks) == 0 {
+		return
+	}
+	event.Registration = regID
+	event.Created = ra.clk.Now()
+	ra.Webhooks.Notify(ctx, hooks, event)
+}
+
 // onValidationUpdate saves a validation's new status after receiving an
 // authorization back from the VA.
 func (ra *RegistrationAuthorityImpl) onValidationUpdate(ctx context.Context, authz core.Authorization) error {
@@ -1096,5 +1434,13 @@ func (ra *RegistrationAuthorityImpl) DeactivateAuthorization(ctx context.Context
 	if err != nil {
 		return core.InternalServerError(err.Error())
 	}
+	if ra.Webhooks != nil && features.Enabled(features.WebhookContacts) {
+		event := webhook.Event{
+			Type:          webhook.AuthorizationDeactivated,
+			Authorization: auth.ID,
+			Identifier:    auth.Identifier.Value,
+		}
+		go ra.sendWebhooks(context.Background(), auth.RegistrationID, event)
+	}
 	return nil
 }
@@ -0,0 +1,188 @@
+// Code generated by protoc-gen-go.
+// source: ratelimit.proto
+// DO NOT EDIT!
+
+/*
+Package proto is a generated protocol buffer package.
+
+It is generated from these files:
+	ratelimit.proto
+
+It has these top-level messages:
+	TakeRequest
+	TakeResponse
+*/
+package proto
+
+import proto1 "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto1.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto1.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type TakeRequest struct {
+	Key              *string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Burst            *int64  `protobuf:"varint,2,opt,name=burst" json:"burst,omitempty"`
+	Period           *int64  `protobuf:"varint,3,opt,name=period" json:"period,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *TakeRequest) Reset()                    { *m = TakeRequest{} }
+func (m *TakeRequest) String() string            { return proto1.CompactTextString(m) }
+func (*TakeRequest) ProtoMessage()               {}
+func (*TakeRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+func (m *TakeRequest) GetKey() string {
+	if m != nil && m.Key != nil {
+		return *m.Key
+	}
+	return ""
+}
+
+func (m *TakeRequest) GetBurst() int64 {
+	if m != nil && m.Burst != nil {
+		return *m.Burst
+	}
+	return 0
+}
+
+func (m *TakeRequest) GetPeriod() int64 {
+	if m != nil && m.Period != nil {
+		return *m.Period
+	}
+	return 0
+}
+
+type TakeResponse struct {
+	Allowed          *bool  `protobuf:"varint,1,opt,name=allowed" json:"allowed,omitempty"`
+	RetryAfter       *int64 `protobuf:"varint,2,opt,name=retryAfter" json:"retryAfter,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *TakeResponse) Reset()                    { *m = TakeResponse{} }
+func (m *TakeResponse) String() string            { return proto1.CompactTextString(m) }
+func (*TakeResponse) ProtoMessage()               {}
+func (*TakeResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *TakeResponse) GetAllowed() bool {
+	if m != nil && m.Allowed != nil {
+		return *m.Allowed
+	}
+	return false
+}
+
+func (m *TakeResponse) GetRetryAfter() int64 {
+	if m != nil && m.RetryAfter != nil {
+		return *m.RetryAfter
+	}
+	return 0
+}
+
+func init() {
+	proto1.RegisterType((*TakeRequest)(nil), "ratelimit.TakeRequest")
+	proto1.RegisterType((*TakeResponse)(nil), "ratelimit.TakeResponse")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion3
+
+// Client API for BucketStore service
+
+type BucketStoreClient interface {
+	Take(ctx context.Context, in *TakeRequest, opts ...grpc.CallOption) (*TakeResponse, error)
+}
+
+type bucketStoreClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewBucketStoreClient(cc *grpc.ClientConn) BucketStoreClient {
+	return &bucketStoreClient{cc}
+}
+
+func (c *bucketStoreClient) Take(ctx context.Context, in *TakeRequest, opts ...grpc.CallOption) (*TakeResponse, error) {
+	out := new(TakeResponse)
+	err := grpc.Invoke(ctx, "/ratelimit.BucketStore/Take", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for BucketStore service
+
+type BucketStoreServer interface {
+	Take(context.Context, *TakeRequest) (*TakeResponse, error)
+}
+
+func RegisterBucketStoreServer(s *grpc.Server, srv BucketStoreServer) {
+	s.RegisterService(&_BucketStore_serviceDesc, srv)
+}
+
+func _BucketStore_Take_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TakeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BucketStoreServer).Take(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ratelimit.BucketStore/Take",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BucketStoreServer).Take(ctx, req.(*TakeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _BucketStore_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "ratelimit.BucketStore",
+	HandlerType: (*BucketStoreServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Take",
+			Handler:    _BucketStore_Take_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: fileDescriptor0,
+}
+
+func init() { proto1.RegisterFile("ratelimit.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 178 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x65, 0x8f, 0x31, 0x0f, 0x82, 0x50,
+	0x10, 0x83, 0x45, 0x44, 0xe4, 0x50, 0x49, 0x6e, 0x50, 0xe2, 0x64, 0x98, 0x9c, 0x18, 0x74, 0x62,
+	0x94, 0xc9, 0x59, 0x9d, 0xdc, 0x50, 0xce, 0x84, 0x80, 0x3e, 0xbc, 0x77, 0xc4, 0xf0, 0xef, 0x15,
+	0x48, 0xd4, 0xc4, 0xb1, 0x4d, 0xfa, 0xb5, 0x05, 0x8f, 0x13, 0xa1, 0x22, 0xbb, 0x65, 0x12, 0x96,
+	0xac, 0x44, 0xa1, 0xf3, 0x31, 0x82, 0x08, 0xdc, 0x63, 0x92, 0xd3, 0x9e, 0x1e, 0x15, 0x69, 0x41,
+	0x17, 0xcc, 0x9c, 0x6a, 0xdf, 0x58, 0x1a, 0x2b, 0x07, 0x27, 0x60, 0x9d, 0x2b, 0xd6, 0xe2, 0xf7,
+	0xdf, 0xd2, 0xc4, 0x29, 0x0c, 0x4b, 0xe2, 0x4c, 0xa5, 0xbe, 0xd9, 0xe8, 0x60, 0x03, 0xe3, 0x2e,
+	0xaa, 0x4b, 0x75, 0xd7, 0x84, 0x1e, 0xd8, 0x49, 0x51, 0xa8, 0x27, 0xa5, 0x6d, 0x7e, 0x84, 0x08,
+	0xc0, 0x24, 0x5c, 0x6f, 0xaf, 0x42, 0xdc, 0x41, 0xd6, 0x3b, 0x70, 0xe3, 0xea, 0x92, 0x93, 0x1c,
+	0x44, 0x31, 0x61, 0x04, 0x83, 0x86, 0x81, 0xb3, 0xf0, 0xbb, 0xf1, 0x67, 0xcf, 0x62, 0xfe, 0xe7,
+	0x77, 0x65, 0x41, 0x2f, 0xb6, 0x4f, 0x56, 0xfb, 0xe6, 0x05, 0x05, 0x94, 0x88, 0xc8, 0xdf, 0x00,
+	0x00, 0x00,
+}
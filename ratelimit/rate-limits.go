@@ -17,6 +17,7 @@ type Limits interface {
 	RegistrationsPerIP() RateLimitPolicy
 	PendingAuthorizationsPerAccount() RateLimitPolicy
 	CertificatesPerFQDNSet() RateLimitPolicy
+	FailedAuthorizationsPerAccount() RateLimitPolicy
 	LoadPolicies(contents []byte) error
 }
 
@@ -74,6 +75,15 @@ func (r *limitsImpl) CertificatesPerFQDNSet() RateLimitPolicy {
 	return r.rlPolicy.CertificatesPerFQDNSet
 }
 
+func (r *limitsImpl) FailedAuthorizationsPerAccount() RateLimitPolicy {
+	r.RLock()
+	defer r.RUnlock()
+	if r.rlPolicy == nil {
+		return RateLimitPolicy{}
+	}
+	return r.rlPolicy.FailedAuthorizationsPerAccount
+}
+
 // LoadPolicies loads various rate limiting policies from a byte array of
 // YAML configuration (typically read from disk by a reloader)
 func (r *limitsImpl) LoadPolicies(contents []byte) error {
@@ -115,6 +125,11 @@ type rateLimitConfig struct {
 	// Number of certificates that can be extant containing a specific set
 	// of DNS names.
 	CertificatesPerFQDNSet RateLimitPolicy `yaml:"certificatesPerFQDNSet"`
+	// Number of failed (invalid) authorizations that can exist per account for
+	// a given hostname. Authorizations are counted by their expiry, so the
+	// window should be at least the pending authorization lifetime. Overrides
+	// by key use the hostname.
+	FailedAuthorizationsPerAccount RateLimitPolicy `yaml:"failedAuthorizationsPerAccount"`
 }
 
 // RateLimitPolicy describes a general limiting policy
@@ -129,6 +129,15 @@ func TestLoadPolicies(t *testing.T) {
 	})
 	test.AssertEquals(t, len(certsPerFQDN.RegistrationOverrides), 0)
 
+	// Test that the FailedAuthorizationsPerAccount section parsed correctly
+	failedAuthsPerAcct := policy.FailedAuthorizationsPerAccount()
+	test.AssertEquals(t, failedAuthsPerAcct.Threshold, 100)
+	test.AssertEquals(t, failedAuthsPerAcct.Window.Duration, 336*time.Hour)
+	test.AssertDeepEquals(t, failedAuthsPerAcct.Overrides, map[string]int{
+		"le.wtf": 10000,
+	})
+	test.AssertEquals(t, len(failedAuthsPerAcct.RegistrationOverrides), 0)
+
 	// Test that loading invalid YAML generates an error
 	err = policy.LoadPolicies([]byte("err"))
 	test.AssertError(t, err, "Failed to generate error loading invalid yaml policy file")
@@ -148,4 +157,5 @@ func TestLoadPolicies(t *testing.T) {
 	test.AssertEquals(t, emptyPolicy.RegistrationsPerIP().Threshold, 0)
 	test.AssertEquals(t, emptyPolicy.PendingAuthorizationsPerAccount().Threshold, 0)
 	test.AssertEquals(t, emptyPolicy.CertificatesPerFQDNSet().Threshold, 0)
+	test.AssertEquals(t, emptyPolicy.FailedAuthorizationsPerAccount().Threshold, 0)
 }
@@ -0,0 +1,175 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/net/context"
+	"gopkg.in/yaml.v2"
+
+	"github.com/letsencrypt/boulder/cmd"
+)
+
+// RequestLimits is defined to allow mock implementations be provided during
+// unit testing
+type RequestLimits interface {
+	// Endpoint returns the request rate policies for the WFE endpoint
+	// registered at the given path, e.g. "/acme/new-authz".
+	Endpoint(path string) EndpointRequestPolicy
+	LoadPolicies(contents []byte) error
+}
+
+// requestLimitsImpl is an unexported implementation of the RequestLimits
+// interface. Like limitsImpl it guards a pointer to the current policy so that
+// it can be swapped out by a reloader.
+type requestLimitsImpl struct {
+	sync.RWMutex
+	policies map[string]EndpointRequestPolicy
+}
+
+func (r *requestLimitsImpl) Endpoint(path string) EndpointRequestPolicy {
+	r.RLock()
+	defer r.RUnlock()
+	return r.policies[path]
+}
+
+// LoadPolicies loads request rate policies from a byte array of YAML
+// configuration (typically read from disk by a reloader). The YAML is a map
+// from WFE endpoint path to an EndpointRequestPolicy.
+func (r *requestLimitsImpl) LoadPolicies(contents []byte) error {
+	var newPolicies map[string]EndpointRequestPolicy
+	err := yaml.Unmarshal(contents, &newPolicies)
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	r.policies = newPolicies
+	r.Unlock()
+	return nil
+}
+
+func NewRequestLimits() RequestLimits {
+	return &requestLimitsImpl{}
+}
+
+// EndpointRequestPolicy holds the request rate policies for a single WFE
+// endpoint.
+type EndpointRequestPolicy struct {
+	// Requests per registration. Only checked for requests authenticated by a
+	// registered account key. Overrides are by registration.
+	PerAccount RequestRatePolicy `yaml:"perAccount"`
+	// Requests per client IP. Overrides are by IP.
+	PerIP RequestRatePolicy `yaml:"perIP"`
+}
+
+// RequestRatePolicy describes a token bucket: a client may make up to Burst
+// requests at once, and the bucket refills at a rate of Burst requests per
+// Period.
+type RequestRatePolicy struct {
+	// How long it takes for an empty bucket to refill completely
+	Period cmd.ConfigDuration `yaml:"period"`
+	// The size of the bucket. Zero means "no limit."
+	Burst int `yaml:"burst"`
+	// A per-key override of Burst. For per-IP policies the key is the client
+	// IP. Note that a zero entry means a limit of zero, not "no limit."
+	Overrides map[string]int `yaml:"overrides"`
+	// A per-registration override of Burst, which takes priority over
+	// Overrides.
+	RegistrationOverrides map[int64]int `yaml:"registrationOverrides"`
+}
+
+// Enabled returns true iff the RequestRatePolicy is enabled.
+func (rrp *RequestRatePolicy) Enabled() bool {
+	return rrp.Burst != 0 && rrp.Period.Duration > 0
+}
+
+// GetBurst returns the bucket size for this policy, taking into account any
+// overrides for `key` or `regID`.
+func (rrp *RequestRatePolicy) GetBurst(key string, regID int64) int {
+	if override, ok := rrp.RegistrationOverrides[regID]; ok {
+		return override
+	}
+	if override, ok := rrp.Overrides[key]; ok {
+		return override
+	}
+	return rrp.Burst
+}
+
+// BucketStore holds token bucket state. Sharing a BucketStore between WFEs
+// (e.g. over gRPC) makes request limits apply across all of them.
+type BucketStore interface {
+	// Take removes a token from the bucket named by key, which holds at most
+	// burst tokens and refills completely over period. It returns false, and
+	// how long the caller must wait for a token, if the bucket is empty.
+	Take(ctx context.Context, key string, burst int, period time.Duration) (bool, time.Duration, error)
+}
+
+// bucketSweepInterval is how often a memoryBucketStore drops buckets that have
+// refilled completely, which are indistinguishable from absent ones.
+const bucketSweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full is the time at which the bucket will have refilled completely
+	full time.Time
+}
+
+// memoryBucketStore is an in-process BucketStore.
+type memoryBucketStore struct {
+	mu        sync.Mutex
+	clk       clock.Clock
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewMemoryBucketStore returns a BucketStore that keeps its state in memory.
+func NewMemoryBucketStore(clk clock.Clock) BucketStore {
+	return &memoryBucketStore{
+		clk:       clk,
+		buckets:   make(map[string]*bucket),
+		lastSweep: clk.Now(),
+	}
+}
+
+func (m *memoryBucketStore) Take(_ context.Context, key string, burst int, period time.Duration) (bool, time.Duration, error) {
+	if burst <= 0 {
+		return false, period, nil
+	}
+	now := m.clk.Now()
+	rate := float64(burst) / float64(period)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.lastSweep) > bucketSweepInterval {
+		for k, b := range m.buckets {
+			if !now.Before(b.full) {
+				delete(m.buckets, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		m.buckets[key] = b
+	}
+	b.tokens += float64(now.Sub(b.last)) * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		// Round up so that a client retrying after exactly this long will
+		// find a whole token.
+		return false, time.Duration(math.Ceil((1 - b.tokens) / rate)), nil
+	}
+	b.tokens--
+	b.full = now.Add(time.Duration((float64(burst) - b.tokens) / rate))
+	return true, 0, nil
+}
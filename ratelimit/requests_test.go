@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"golang.org/x/net/context"
+
+	"github.com/letsencrypt/boulder/cmd"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestRequestRatePolicy(t *testing.T) {
+	policy := RequestRatePolicy{
+		Period: cmd.ConfigDuration{Duration: time.Second},
+		Burst:  1,
+		Overrides: map[string]int{
+			"key": 2,
+		},
+		RegistrationOverrides: map[int64]int{
+			101: 3,
+		},
+	}
+	test.Assert(t, policy.Enabled(), "Policy should have been enabled")
+	test.AssertEquals(t, policy.GetBurst("foo", 11), 1)
+	test.AssertEquals(t, policy.GetBurst("key", 11), 2)
+	test.AssertEquals(t, policy.GetBurst("key", 101), 3)
+
+	test.Assert(t, !(&RequestRatePolicy{}).Enabled(), "Empty policy should not have been enabled")
+	test.Assert(t, !(&RequestRatePolicy{Burst: 1}).Enabled(), "Policy without a period should not have been enabled")
+}
+
+func TestLoadRequestPolicies(t *testing.T) {
+	limits := NewRequestLimits()
+	unconfigured := limits.Endpoint("/acme/new-authz")
+	test.AssertEquals(t, unconfigured.PerIP.Enabled(), false)
+
+	policyContent, err := ioutil.ReadFile("../test/request-limit-policies.yml")
+	test.AssertNotError(t, err, "Failed to load request-limit-policies.yml")
+	err = limits.LoadPolicies(policyContent)
+	test.AssertNotError(t, err, "Failed to parse request-limit-policies.yml")
+
+	newAuthz := limits.Endpoint("/acme/new-authz")
+	test.AssertEquals(t, newAuthz.PerIP.Burst, 100)
+	test.AssertEquals(t, newAuthz.PerIP.Period.Duration, time.Second)
+	test.AssertEquals(t, newAuthz.PerAccount.Burst, 50)
+	test.AssertEquals(t, newAuthz.PerAccount.GetBurst("", 101), 1000)
+	newReg := limits.Endpoint("/acme/new-reg")
+	test.AssertEquals(t, newReg.PerIP.GetBurst("10.77.77.77", 0), 0)
+	challenge := limits.Endpoint("/acme/challenge/")
+	test.AssertEquals(t, challenge.PerIP.Enabled(), false)
+	unknown := limits.Endpoint("/acme/unknown")
+	test.AssertEquals(t, unknown.PerAccount.Enabled(), false)
+
+	err = limits.LoadPolicies([]byte("err"))
+	test.AssertError(t, err, "Loaded a malformed policy")
+}
+
+func TestMemoryBucketStore(t *testing.T) {
+	fc := clock.NewFake()
+	store := NewMemoryBucketStore(fc)
+	ctx := context.Background()
+
+	// A new bucket starts full.
+	for i := 0; i < 4; i++ {
+		ok, _, err := store.Take(ctx, "a", 4, time.Minute)
+		test.AssertNotError(t, err, "Take failed")
+		test.Assert(t, ok, "Rejected a request with tokens left")
+	}
+	ok, retryAfter, err := store.Take(ctx, "a", 4, time.Minute)
+	test.AssertNotError(t, err, "Take failed")
+	test.Assert(t, !ok, "Allowed a request from an empty bucket")
+	test.AssertEquals(t, retryAfter, 15*time.Second)
+
+	// Buckets are independent.
+	ok, _, err = store.Take(ctx, "b", 4, time.Minute)
+	test.AssertNotError(t, err, "Take failed")
+	test.Assert(t, ok, "Rejected a request to a different bucket")
+
+	// Tokens are refilled at burst per period.
+	fc.Add(10 * time.Second)
+	ok, retryAfter, _ = store.Take(ctx, "a", 4, time.Minute)
+	test.Assert(t, !ok, "Allowed a request before a token was refilled")
+	test.AssertEquals(t, retryAfter, 5*time.Second)
+	fc.Add(5 * time.Second)
+	ok, _, _ = store.Take(ctx, "a", 4, time.Minute)
+	test.Assert(t, ok, "Rejected a request after a token was refilled")
+
+	// A zero burst never allows anything.
+	ok, _, _ = store.Take(ctx, "c", 0, time.Minute)
+	test.Assert(t, !ok, "Allowed a request with a zero burst")
+
+	// Buckets that have refilled are swept.
+	fc.Add(2 * time.Minute)
+	store.Take(ctx, "b", 4, time.Minute)
+	test.AssertEquals(t, len(store.(*memoryBucketStore).buckets), 1)
+}
@@ -63,6 +63,7 @@ const (
 	MethodCountCertificatesByNames          = "CountCertificatesByNames"          // SA
 	MethodCountRegistrationsByIP            = "CountRegistrationsByIP"            // SA
 	MethodCountPendingAuthorizations        = "CountPendingAuthorizations"        // SA
+	MethodCountInvalidAuthorizations        = "CountInvalidAuthorizations"        // SA
 	MethodGetSCTReceipt                     = "GetSCTReceipt"                     // SA
 	MethodAddSCTReceipt                     = "AddSCTReceipt"                     // SA
 	MethodSubmitToCT                        = "SubmitToCT"                        // Pub
@@ -73,6 +74,16 @@ const (
 	MethodDeactivateAuthorization           = "DeactivateAuthorization"           // RA
 	MethodDeactivateRegistrationSA          = "DeactivateRegistrationSA"          // SA
 	MethodDeactivateRegistration            = "DeactivateRegistration"            // RA
+	MethodGetSerialsByRegistration          = "GetSerialsByRegistration"          // SA
+	MethodGetAuthorizationIDsByRegistration = "GetAuthorizationIDsByRegistration" // SA
+	MethodAddBlockedKey                     = "AddBlockedKey"                     // SA
+	MethodKeyBlocked                        = "KeyBlocked"                        // SA
+	MethodAddEmailOptOut                    = "AddEmailOptOut"                    // SA
+	MethodEmailOptedOut                     = "EmailOptedOut"                     // SA
+	MethodAddUndeliverableEmail             = "AddUndeliverableEmail"             // SA
+	MethodEmailUndeliverable                = "EmailUndeliverable"                // SA
+	MethodRemoveUndeliverableEmail          = "RemoveUndeliverableEmail"          // SA
+	MethodAddWebhookDelivery                = "AddWebhookDelivery"                // SA
 )
 
 // Request structs
@@ -181,6 +192,13 @@ type countPendingAuthorizationsRequest struct {
 	RegID int64
 }
 
+type countInvalidAuthorizationsRequest struct {
+	RegID    int64
+	Hostname string
+	Earliest time.Time
+	Latest   time.Time
+}
+
 type revokeAuthsRequest struct {
 	Ident core.AcmeIdentifier
 }
@@ -194,6 +212,30 @@ type fqdnSetExistsRequest struct {
 	Names []string
 }
 
+type listByRegistrationRequest struct {
+	RegID int64
+	After string
+	Limit int
+}
+
+type addBlockedKeyRequest struct {
+	KeyHash []byte
+	Added   time.Time
+	Source  string
+}
+
+type addEmailOptOutRequest struct {
+	RegID int64
+	Email string
+	Added time.Time
+}
+
+type addUndeliverableEmailRequest struct {
+	Email  string
+	Reason string
+	Added  time.Time
+}
+
 // Response structs
 type caaResponse struct {
 	Present bool
@@ -214,6 +256,18 @@ type fqdnSetExistsResponse struct {
 	Exists bool
 }
 
+type keyBlockedResponse struct {
+	Blocked bool
+}
+
+type emailOptedOutResponse struct {
+	OptedOut bool
+}
+
+type emailUndeliverableResponse struct {
+	Undeliverable bool
+}
+
 func improperMessage(method string, err error, obj interface{}) {
 	log := blog.Get()
 	log.AuditErr(fmt.Sprintf("Improper message. method: %s err: %s data: %+v", method, err, obj))
@@ -1058,6 +1112,20 @@ func NewStorageAuthorityServer(rpc Server, impl core.StorageAuthority) error {
 		return json.Marshal(count)
 	})
 
+	rpc.Handle(MethodCountInvalidAuthorizations, func(ctx context.Context, req []byte) (response []byte, err error) {
+		var cReq countInvalidAuthorizationsRequest
+		err = json.Unmarshal(req, &cReq)
+		if err != nil {
+			return
+		}
+
+		count, err := impl.CountInvalidAuthorizations(ctx, cReq.RegID, cReq.Hostname, cReq.Earliest, cReq.Latest)
+		if err != nil {
+			return
+		}
+		return json.Marshal(count)
+	})
+
 	rpc.Handle(MethodGetSCTReceipt, func(ctx context.Context, req []byte) (response []byte, err error) {
 		var gsctReq struct {
 			Serial string
@@ -1134,6 +1202,157 @@ func NewStorageAuthorityServer(rpc Server, impl core.StorageAuthority) error {
 		return
 	})
 
+	rpc.Handle(MethodGetSerialsByRegistration, func(ctx context.Context, req []byte) (response []byte, err error) {
+		var r listByRegistrationRequest
+		err = json.Unmarshal(req, &r)
+		if err != nil {
+			improperMessage(MethodGetSerialsByRegistration, err, req)
+			return
+		}
+		serials, err := impl.GetSerialsByRegistration(ctx, r.RegID, r.After, r.Limit)
+		if err != nil {
+			errorCondition(MethodGetSerialsByRegistration, err, req)
+			return
+		}
+		response, err = json.Marshal(serials)
+		if err != nil {
+			errorCondition(MethodGetSerialsByRegistration, err, req)
+			return
+		}
+		return
+	})
+
+	rpc.Handle(MethodGetAuthorizationIDsByRegistration, func(ctx context.Context, req []byte) (response []byte, err error) {
+		var r listByRegistrationRequest
+		err = json.Unmarshal(req, &r)
+		if err != nil {
+			improperMessage(MethodGetAuthorizationIDsByRegistration, err, req)
+			return
+		}
+		ids, err := impl.GetAuthorizationIDsByRegistration(ctx, r.RegID, r.After, r.Limit)
+		if err != nil {
+			errorCondition(MethodGetAuthorizationIDsByRegistration, err, req)
+			return
+		}
+		response, err = json.Marshal(ids)
+		if err != nil {
+			errorCondition(MethodGetAuthorizationIDsByRegistration, err, req)
+			return
+		}
+		return
+	})
+
+	rpc.Handle(MethodAddBlockedKey, func(ctx context.Context, req []byte) (response []byte, err error) {
+		var r addBlockedKeyRequest
+		err = json.Unmarshal(req, &r)
+		if err != nil {
+			improperMessage(MethodAddBlockedKey, err, req)
+			return
+		}
+		err = impl.AddBlockedKey(ctx, r.KeyHash, r.Added, r.Source)
+		if err != nil {
+			errorCondition(MethodAddBlockedKey, err, req)
+			return
+		}
+		return
+	})
+
+	rpc.Handle(MethodKeyBlocked, func(ctx context.Context, req []byte) (response []byte, err error) {
+		blocked, err := impl.KeyBlocked(ctx, req)
+		if err != nil {
+			errorCondition(MethodKeyBlocked, err, req)
+			return
+		}
+		response, err = json.Marshal(keyBlockedResponse{blocked})
+		if err != nil {
+			errorCondition(MethodKeyBlocked, err, req)
+			return
+		}
+		return
+	})
+
+	rpc.Handle(MethodAddEmailOptOut, func(ctx context.Context, req []byte) (response []byte, err error) {
+		var r addEmailOptOutRequest
+		err = json.Unmarshal(req, &r)
+		if err != nil {
+			improperMessage(MethodAddEmailOptOut, err, req)
+			return
+		}
+		err = impl.AddEmailOptOut(ctx, r.RegID, r.Email, r.Added)
+		if err != nil {
+			errorCondition(MethodAddEmailOptOut, err, req)
+			return
+		}
+		return
+	})
+
+	rpc.Handle(MethodEmailOptedOut, func(ctx context.Context, req []byte) (response []byte, err error) {
+		optedOut, err := impl.EmailOptedOut(ctx, string(req))
+		if err != nil {
+			errorCondition(MethodEmailOptedOut, err, req)
+			return
+		}
+		response, err = json.Marshal(emailOptedOutResponse{optedOut})
+		if err != nil {
+			errorCondition(MethodEmailOptedOut, err, req)
+			return
+		}
+		return
+	})
+
+	rpc.Handle(MethodAddUndeliverableEmail, func(ctx context.Context, req []byte) (response []byte, err error) {
+		var r addUndeliverableEmailRequest
+		err = json.Unmarshal(req, &r)
+		if err != nil {
+			improperMessage(MethodAddUndeliverableEmail, err, req)
+			return
+		}
+		err = impl.AddUndeliverableEmail(ctx, r.Email, r.Reason, r.Added)
+		if err != nil {
+			errorCondition(MethodAddUndeliverableEmail, err, req)
+			return
+		}
+		return
+	})
+
+	rpc.Handle(MethodRemoveUndeliverableEmail, func(ctx context.Context, req []byte) (response []byte, err error) {
+		err = impl.RemoveUndeliverableEmail(ctx, string(req))
+		if err != nil {
+			errorCondition(MethodRemoveUndeliverableEmail, err, req)
+			return
+		}
+		return
+	})
+
+	rpc.Handle(MethodEmailUndeliverable, func(ctx context.Context, req []byte) (response []byte, err error) {
+		undeliverable, err := impl.EmailUndeliverable(ctx, string(req))
+		if err != nil {
+			errorCondition(MethodEmailUndeliverable, err, req)
+			return
+		}
+		response, err = json.Marshal(emailUndeliverableResponse{undeliverable})
+		if err != nil {
+			errorCondition(MethodEmailUndeliverable, err, req)
+			return
+		}
+		return
+	})
+
+	rpc.Handle(MethodAddWebhookDelivery, func(ctx context.Context, req []byte) (response []byte, err error) {
+		var delivery core.WebhookDelivery
+		err = json.Unmarshal(req, &delivery)
+		if err != nil {
+			improperMessage(MethodAddWebhookDelivery, err, req)
+			return
+		}
+		err = impl.AddWebhookDelivery(ctx, delivery)
+		if err != nil {
+			errorCondition(MethodAddWebhookDelivery, err, req)
+			return
+		}
+		return
+	})
+
 	rpc.Handle(MethodDeactivateAuthorizationSA, func(ctx context.Context, req []byte) (response []byte, err error) {
 		err = impl.DeactivateAuthorization(ctx, string(req))
 		if err != nil {
@@ -1457,6 +1676,27 @@ func (cac StorageAuthorityClient) CountPendingAuthorizations(ctx context.Context
 	return
 }
 
+// CountInvalidAuthorizations calls CountInvalidAuthorizations on the remote
+// StorageAuthority.
+func (cac StorageAuthorityClient) CountInvalidAuthorizations(ctx context.Context, regID int64, hostname string, earliest, latest time.Time) (count int, err error) {
+	cReq := countInvalidAuthorizationsRequest{
+		RegID:    regID,
+		Hostname: hostname,
+		Earliest: earliest,
+		Latest:   latest,
+	}
+	data, err := json.Marshal(cReq)
+	if err != nil {
+		return
+	}
+	response, err := cac.rpc.DispatchSync(MethodCountInvalidAuthorizations, data)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(response, &count)
+	return
+}
+
 // GetSCTReceipt retrieves an SCT according to the serial number of a certificate
 // and the logID of the log to which it was submitted.
 func (cac StorageAuthorityClient) GetSCTReceipt(ctx context.Context, serial string, logID string) (receipt core.SignedCertificateTimestamp, err error) {
@@ -1538,3 +1778,118 @@ func (cac StorageAuthorityClient) DeactivateRegistration(ctx context.Context, id
 	_, err = cac.rpc.DispatchSync(MethodDeactivateRegistrationSA, data)
 	return err
 }
+
+// GetSerialsByRegistration returns a page of serials of certificates issued to
+// the given registration
+func (cac StorageAuthorityClient) GetSerialsByRegistration(ctx context.Context, regID int64, after string, limit int) ([]string, error) {
+	data, err := json.Marshal(listByRegistrationRequest{regID, after, limit})
+	if err != nil {
+		return nil, err
+	}
+	response, err := cac.rpc.DispatchSync(MethodGetSerialsByRegistration, data)
+	if err != nil {
+		return nil, err
+	}
+	var serials []string
+	err = json.Unmarshal(response, &serials)
+	return serials, err
+}
+
+// GetAuthorizationIDsByRegistration returns a page of IDs of unexpired,
+// finalized authorizations belonging to the given registration
+func (cac StorageAuthorityClient) GetAuthorizationIDsByRegistration(ctx context.Context, regID int64, after string, limit int) ([]string, error) {
+	data, err := json.Marshal(listByRegistrationRequest{regID, after, limit})
+	if err != nil {
+		return nil, err
+	}
+	response, err := cac.rpc.DispatchSync(MethodGetAuthorizationIDsByRegistration, data)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	err = json.Unmarshal(response, &ids)
+	return ids, err
+}
+
+// AddBlockedKey adds the SHA-256 hash of a public key's SubjectPublicKeyInfo to
+// the set of keys that may not be used
+func (cac StorageAuthorityClient) AddBlockedKey(ctx context.Context, keyHash []byte, added time.Time, source string) error {
+	data, err := json.Marshal(addBlockedKeyRequest{keyHash, added, source})
+	if err != nil {
+		return err
+	}
+	_, err = cac.rpc.DispatchSync(MethodAddBlockedKey, data)
+	return err
+}
+
+// KeyBlocked returns whether the public key whose SubjectPublicKeyInfo has the
+// given SHA-256 hash may not be used
+func (cac StorageAuthorityClient) KeyBlocked(ctx context.Context, keyHash []byte) (bool, error) {
+	response, err := cac.rpc.DispatchSync(MethodKeyBlocked, keyHash)
+	if err != nil {
+		return false, err
+	}
+	var blocked keyBlockedResponse
+	err = json.Unmarshal(response, &blocked)
+	return blocked.Blocked, err
+}
+
+// AddEmailOptOut records that email has unsubscribed from notification emails
+func (cac StorageAuthorityClient) AddEmailOptOut(ctx context.Context, regID int64, email string, added time.Time) error {
+	data, err := json.Marshal(addEmailOptOutRequest{regID, email, added})
+	if err != nil {
+		return err
+	}
+	_, err = cac.rpc.DispatchSync(MethodAddEmailOptOut, data)
+	return err
+}
+
+// EmailOptedOut returns whether email has unsubscribed from notification
+// emails
+func (cac StorageAuthorityClient) EmailOptedOut(ctx context.Context, email string) (bool, error) {
+	response, err := cac.rpc.DispatchSync(MethodEmailOptedOut, []byte(email))
+	if err != nil {
+		return false, err
+	}
+	var optedOut emailOptedOutResponse
+	err = json.Unmarshal(response, &optedOut)
+	return optedOut.OptedOut, err
+}
+
+// AddUndeliverableEmail records that mail to email bounced permanently
+func (cac StorageAuthorityClient) AddUndeliverableEmail(ctx context.Context, email string, reason string, added time.Time) error {
+	data, err := json.Marshal(addUndeliverableEmailRequest{email, reason, added})
+	if err != nil {
+		return err
+	}
+	_, err = cac.rpc.DispatchSync(MethodAddUndeliverableEmail, data)
+	return err
+}
+
+// RemoveUndeliverableEmail clears any record that email is undeliverable
+func (cac StorageAuthorityClient) RemoveUndeliverableEmail(ctx context.Context, email string) error {
+	_, err := cac.rpc.DispatchSync(MethodRemoveUndeliverableEmail, []byte(email))
+	return err
+}
+
+// EmailUndeliverable returns whether mail to email has bounced permanently
+func (cac StorageAuthorityClient) EmailUndeliverable(ctx context.Context, email string) (bool, error) {
+	response, err := cac.rpc.DispatchSync(MethodEmailUndeliverable, []byte(email))
+	if err != nil {
+		return false, err
+	}
+	var undeliverable emailUndeliverableResponse
+	err = json.Unmarshal(response, &undeliverable)
+	return undeliverable.Undeliverable, err
+}
+
+// AddWebhookDelivery records the outcome of delivering an event to a
+// registration's webhook contact
+func (cac StorageAuthorityClient) AddWebhookDelivery(ctx context.Context, delivery core.WebhookDelivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	_, err = cac.rpc.DispatchSync(MethodAddWebhookDelivery, data)
+	return err
+}
@@ -0,0 +1,18 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE `keyHashToSerial` (
+  `id` BIGINT(20) NOT NULL AUTO_INCREMENT,
+  `keyHash` BINARY(32) NOT NULL,
+  `certNotAfter` DATETIME NOT NULL,
+  `certSerial` VARCHAR(255) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `keyHash_certNotAfter` (`keyHash`, `certNotAfter`),
+  UNIQUE KEY `unique_keyHash_certSerial` (`keyHash`, `certSerial`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `keyHashToSerial`;
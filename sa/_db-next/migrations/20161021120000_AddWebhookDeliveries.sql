@@ -0,0 +1,21 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE `webhookDeliveries` (
+  `id` BIGINT(20) NOT NULL AUTO_INCREMENT,
+  `registrationID` BIGINT(20) NOT NULL,
+  `url` VARCHAR(255) NOT NULL,
+  `eventType` VARCHAR(64) NOT NULL,
+  `attempts` INT(11) NOT NULL,
+  `statusCode` INT(11) NOT NULL,
+  `delivered` TINYINT(1) NOT NULL,
+  `created` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `registrationID_created_idx` (`registrationID`, `created`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `webhookDeliveries`;
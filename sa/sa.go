@@ -914,6 +914,14 @@ func (ssa *SQLStorageAuthority) AddCertificate(ctx context.Context, certDER []by
 		return
 	}
 
+	if features.Enabled(features.StoreKeyHashes) {
+		err = addKeyHash(tx, parsedCertificate)
+		if err != nil {
+			err = Rollback(tx, err)
+			return
+		}
+	}
+
 	err = addFQDNSet(
 		tx,
 		parsedCertificate.DNSNames,
@@ -960,6 +968,35 @@ func (ssa *SQLStorageAuthority) CountPendingAuthorizations(ctx context.Context,
 	return
 }
 
+// CountInvalidAuthorizations returns the number of invalid authorizations for
+// the given registration and hostname which expire between earliest and
+// latest.
+func (ssa *SQLStorageAuthority) CountInvalidAuthorizations(ctx context.Context, regID int64, hostname string, earliest, latest time.Time) (count int, err error) {
+	identifier := core.AcmeIdentifier{
+		Type:  core.IdentifierDNS,
+		Value: hostname,
+	}
+	identifierJSON, err := json.Marshal(identifier)
+	if err != nil {
+		return 0, err
+	}
+	err = ssa.dbMap.SelectOne(&count,
+		`SELECT count(1) FROM authz
+		 WHERE registrationID = :regID AND
+				identifier = :identifier AND
+				status = :status AND
+				expires > :earliest AND
+				expires <= :latest`,
+		map[string]interface{}{
+			"regID":      regID,
+			"identifier": string(identifierJSON),
+			"status":     string(core.StatusInvalid),
+			"earliest":   earliest,
+			"latest":     latest,
+		})
+	return
+}
+
 // ErrNoReceipt is an error type for non-existent SCT receipt
 type ErrNoReceipt string
 
@@ -1000,6 +1037,123 @@ func (ssa *SQLStorageAuthority) AddSCTReceipt(ctx context.Context, sct core.Sign
 	return err
 }
 
+// AddBlockedKey adds the SHA-256 hash of a public key's SubjectPublicKeyInfo to
+// the blockedKeys table. Adding a key that is already blocked is not an error.
+// source records why the key was blocked.
+func (ssa *SQLStorageAuthority) AddBlockedKey(ctx context.Context, keyHash []byte, added time.Time, source string) error {
+	_, err := ssa.dbMap.Exec(
+		`INSERT INTO blockedKeys (keyHash, added, source) VALUES (?, ?, ?)`,
+		keyHash,
+		added,
+		source,
+	)
+	if err != nil && strings.HasPrefix(err.Error(), "Error 1062: Duplicate entry") {
+		return nil
+	}
+	return err
+}
+
+// KeyBlocked returns whether the public key whose SubjectPublicKeyInfo has the
+// given SHA-256 hash is in the blockedKeys table
+func (ssa *SQLStorageAuthority) KeyBlocked(ctx context.Context, keyHash []byte) (bool, error) {
+	var count int64
+	err := ssa.dbMap.SelectOne(
+		&count,
+		`SELECT COUNT(1) FROM blockedKeys
+		WHERE keyHash = ?
+		LIMIT 1`,
+		keyHash,
+	)
+	return count > 0, err
+}
+
+// AddEmailOptOut records that email has unsubscribed from notification emails,
+// using a link sent to registration regID. Adding an address that has already
+// opted out is not an error.
+func (ssa *SQLStorageAuthority) AddEmailOptOut(ctx context.Context, regID int64, email string, added time.Time) error {
+	_, err := ssa.dbMap.Exec(
+		`INSERT INTO emailOptOuts (email, registrationID, added) VALUES (?, ?, ?)`,
+		email,
+		regID,
+		added,
+	)
+	if err != nil && strings.HasPrefix(err.Error(), "Error 1062: Duplicate entry") {
+		return nil
+	}
+	return err
+}
+
+// EmailOptedOut returns whether email has unsubscribed from notification
+// emails
+func (ssa *SQLStorageAuthority) EmailOptedOut(ctx context.Context, email string) (bool, error) {
+	var count int64
+	err := ssa.dbMap.SelectOne(
+		&count,
+		`SELECT COUNT(1) FROM emailOptOuts
+		WHERE email = ?
+		LIMIT 1`,
+		email,
+	)
+	return count > 0, err
+}
+
+// AddUndeliverableEmail records that mail to email bounced permanently. reason
+// is the delivery status from the bounce. Adding an address that is already
+// undeliverable is not an error.
+func (ssa *SQLStorageAuthority) AddUndeliverableEmail(ctx context.Context, email string, reason string, added time.Time) error {
+	_, err := ssa.dbMap.Exec(
+		`INSERT INTO undeliverableEmails (email, added, reason) VALUES (?, ?, ?)`,
+		email,
+		added,
+		reason,
+	)
+	if err != nil && strings.HasPrefix(err.Error(), "Error 1062: Duplicate entry") {
+		return nil
+	}
+	return err
+}
+
+// RemoveUndeliverableEmail clears any record that email is undeliverable.
+// Removing an address that isn't undeliverable is not an error.
+func (ssa *SQLStorageAuthority) RemoveUndeliverableEmail(ctx context.Context, email string) error {
+	_, err := ssa.dbMap.Exec(
+		`DELETE FROM undeliverableEmails WHERE email = ?`,
+		email,
+	)
+	return err
+}
+
+// EmailUndeliverable returns whether mail to email has bounced permanently
+func (ssa *SQLStorageAuthority) EmailUndeliverable(ctx context.Context, email string) (bool, error) {
+	var count int64
+	err := ssa.dbMap.SelectOne(
+		&count,
+		`SELECT COUNT(1) FROM undeliverableEmails
+		WHERE email = ?
+		LIMIT 1`,
+		email,
+	)
+	return count > 0, err
+}
+
+// AddWebhookDelivery records the outcome of delivering an event to a
+// registration's webhook contact
+func (ssa *SQLStorageAuthority) AddWebhookDelivery(ctx context.Context, delivery core.WebhookDelivery) error {
+	_, err := ssa.dbMap.Exec(
+		`INSERT INTO webhookDeliveries
+		(registrationID, url, eventType, attempts, statusCode, delivered, created)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		delivery.RegistrationID,
+		delivery.URL,
+		delivery.EventType,
+		delivery.Attempts,
+		delivery.StatusCode,
+		delivery.Delivered,
+		delivery.Created,
+	)
+	return err
+}
+
 func hashNames(names []string) []byte {
 	names = core.UniqueLowerNames(names)
 	hash := sha256.Sum256([]byte(strings.Join(names, ",")))
@@ -1015,6 +1169,20 @@ func addFQDNSet(tx *gorp.Transaction, names []string, serial string, issued time
 	})
 }
 
+// addKeyHash records the SHA-256 hash of the certificate's SubjectPublicKeyInfo
+// so that all certificates for a key can be found, e.g. to revoke them if the
+// key is compromised.
+func addKeyHash(tx execable, cert *x509.Certificate) error {
+	keyHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	_, err := tx.Exec(
+		`INSERT INTO keyHashToSerial (keyHash, certNotAfter, certSerial) VALUES (?, ?, ?)`,
+		keyHash[:],
+		cert.NotAfter,
+		core.SerialToString(cert.SerialNumber),
+	)
+	return err
+}
+
 type execable interface {
 	Exec(string, ...interface{}) (sql.Result, error)
 }
@@ -1063,6 +1231,58 @@ func (ssa *SQLStorageAuthority) FQDNSetExists(ctx context.Context, names []strin
 	return count > 0, err
 }
 
+// GetSerialsByRegistration returns up to |limit| serials of certificates
+// issued to the given registration, ordered by serial. Only serials greater
+// than |after| are returned, so the last serial of one page can be passed back
+// in to fetch the next page.
+func (ssa *SQLStorageAuthority) GetSerialsByRegistration(ctx context.Context, regID int64, after string, limit int) ([]string, error) {
+	var serials []string
+	_, err := ssa.dbMap.Select(
+		&serials,
+		`SELECT serial FROM certificates
+		WHERE registrationID = :regID
+		AND serial > :after
+		ORDER BY serial
+		LIMIT :limit`,
+		map[string]interface{}{
+			"regID": regID,
+			"after": after,
+			"limit": limit,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return serials, nil
+}
+
+// GetAuthorizationIDsByRegistration returns up to |limit| IDs of unexpired,
+// finalized authorizations belonging to the given registration, ordered by ID.
+// Only IDs greater than |after| are returned, so the last ID of one page can be
+// passed back in to fetch the next page.
+func (ssa *SQLStorageAuthority) GetAuthorizationIDsByRegistration(ctx context.Context, regID int64, after string, limit int) ([]string, error) {
+	var ids []string
+	_, err := ssa.dbMap.Select(
+		&ids,
+		`SELECT id FROM authz
+		WHERE registrationID = :regID
+		AND expires > :now
+		AND id > :after
+		ORDER BY id
+		LIMIT :limit`,
+		map[string]interface{}{
+			"regID": regID,
+			"now":   ssa.clk.Now(),
+			"after": after,
+			"limit": limit,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
 // DeactivateRegistration deactivates a currently valid registration
 func (ssa *SQLStorageAuthority) DeactivateRegistration(ctx context.Context, id int64) error {
 	_, err := ssa.dbMap.Exec(
@@ -3,6 +3,7 @@ package sa
 import (
 	"bytes"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"database/sql"
 	"encoding/base64"
@@ -12,6 +13,7 @@ import (
 	"math/big"
 	"net"
 	"reflect"
+	"sort"
 	"testing"
 	"time"
 
@@ -160,6 +162,39 @@ func TestCountPendingAuthorizations(t *testing.T) {
 	test.AssertEquals(t, count, 0)
 }
 
+func TestCountInvalidAuthorizations(t *testing.T) {
+	sa, fc, cleanUp := initSA(t)
+	defer cleanUp()
+
+	reg := satest.CreateWorkingRegistration(t, sa)
+	ident := core.AcmeIdentifier{Type: core.IdentifierDNS, Value: "example.com"}
+	for _, status := range []core.AcmeStatus{core.StatusInvalid, core.StatusInvalid, core.StatusValid} {
+		expires := fc.Now().Add(time.Hour)
+		authz, err := sa.NewPendingAuthorization(ctx, core.Authorization{
+			RegistrationID: reg.ID,
+			Identifier:     ident,
+			Expires:        &expires,
+		})
+		test.AssertNotError(t, err, "Couldn't create new pending authorization")
+		authz.Status = status
+		err = sa.FinalizeAuthorization(ctx, authz)
+		test.AssertNotError(t, err, "Couldn't finalize pending authorization")
+	}
+
+	earliest, latest := fc.Now(), fc.Now().Add(2*time.Hour)
+	count, err := sa.CountInvalidAuthorizations(ctx, reg.ID, "example.com", earliest, latest)
+	test.AssertNotError(t, err, "Couldn't count invalid authorizations")
+	test.AssertEquals(t, count, 2)
+
+	count, err = sa.CountInvalidAuthorizations(ctx, reg.ID, "other.com", earliest, latest)
+	test.AssertNotError(t, err, "Couldn't count invalid authorizations")
+	test.AssertEquals(t, count, 0)
+
+	count, err = sa.CountInvalidAuthorizations(ctx, reg.ID, "example.com", latest, latest.Add(time.Hour))
+	test.AssertNotError(t, err, "Couldn't count invalid authorizations")
+	test.AssertEquals(t, count, 0)
+}
+
 func TestAddAuthorization(t *testing.T) {
 	sa, _, cleanUp := initSA(t)
 	defer cleanUp()
@@ -195,6 +230,63 @@ func TestAddAuthorization(t *testing.T) {
 	test.AssertNotError(t, err, "Couldn't get authorization with ID "+PA.ID)
 }
 
+func TestGetSerialsByRegistration(t *testing.T) {
+	sa, _, cleanUp := initSA(t)
+	defer cleanUp()
+
+	reg := satest.CreateWorkingRegistration(t, sa)
+	for _, filename := range []string{"www.eff.org.der", "test-cert.der"} {
+		certDER, err := ioutil.ReadFile(filename)
+		test.AssertNotError(t, err, "Couldn't read example cert DER")
+		_, err = sa.AddCertificate(ctx, certDER, reg.ID)
+		test.AssertNotError(t, err, "Couldn't add certificate")
+	}
+
+	serials, err := sa.GetSerialsByRegistration(ctx, reg.ID, "", 1)
+	test.AssertNotError(t, err, "Couldn't list serials")
+	test.AssertDeepEquals(t, serials, []string{"000000000000000000000000000000021bd4"})
+
+	serials, err = sa.GetSerialsByRegistration(ctx, reg.ID, serials[0], 10)
+	test.AssertNotError(t, err, "Couldn't list serials")
+	test.AssertDeepEquals(t, serials, []string{"ffdd9b8a82126d96f61d378d5ba99a0474f0"})
+
+	serials, err = sa.GetSerialsByRegistration(ctx, reg.ID+1, "", 10)
+	test.AssertNotError(t, err, "Couldn't list serials")
+	test.AssertEquals(t, len(serials), 0)
+}
+
+func TestGetAuthorizationIDsByRegistration(t *testing.T) {
+	sa, fc, cleanUp := initSA(t)
+	defer cleanUp()
+
+	reg := satest.CreateWorkingRegistration(t, sa)
+	var ids []string
+	for _, name := range []string{"a.example.com", "b.example.com"} {
+		authz := CreateDomainAuthWithRegID(t, name, sa, reg.ID)
+		authz.Status = core.StatusValid
+		err := sa.FinalizeAuthorization(ctx, authz)
+		test.AssertNotError(t, err, "Couldn't finalize authorization")
+		ids = append(ids, authz.ID)
+	}
+	// Pending authorizations are not listed
+	CreateDomainAuthWithRegID(t, "c.example.com", sa, reg.ID)
+	sort.Strings(ids)
+
+	page, err := sa.GetAuthorizationIDsByRegistration(ctx, reg.ID, "", 1)
+	test.AssertNotError(t, err, "Couldn't list authorization IDs")
+	test.AssertDeepEquals(t, page, ids[:1])
+
+	page, err = sa.GetAuthorizationIDsByRegistration(ctx, reg.ID, page[0], 10)
+	test.AssertNotError(t, err, "Couldn't list authorization IDs")
+	test.AssertDeepEquals(t, page, ids[1:])
+
+	// Expired authorizations are not listed
+	fc.Set(time.Now().AddDate(0, 0, 2))
+	page, err = sa.GetAuthorizationIDsByRegistration(ctx, reg.ID, "", 10)
+	test.AssertNotError(t, err, "Couldn't list authorization IDs")
+	test.AssertEquals(t, len(page), 0)
+}
+
 func CreateDomainAuth(t *testing.T, domainName string, sa *SQLStorageAuthority) (authz core.Authorization) {
 	return CreateDomainAuthWithRegID(t, domainName, sa, 42)
 }
@@ -360,6 +452,136 @@ func TestGetValidAuthorizationsMultiple(t *testing.T) {
 	test.AssertEquals(t, wwwResult.ID, wwwAuthz.ID)
 }
 
+func TestAddCertificateKeyHash(t *testing.T) {
+	_ = features.Set(map[string]bool{"StoreKeyHashes": true})
+	defer features.Reset()
+
+	sa, _, cleanUp := initSA(t)
+	defer cleanUp()
+
+	reg := satest.CreateWorkingRegistration(t, sa)
+	certDER, err := ioutil.ReadFile("www.eff.org.der")
+	test.AssertNotError(t, err, "Couldn't read example cert DER")
+	cert, err := x509.ParseCertificate(certDER)
+	test.AssertNotError(t, err, "Couldn't parse example cert")
+	_, err = sa.AddCertificate(ctx, certDER, reg.ID)
+	test.AssertNotError(t, err, "Couldn't add www.eff.org.der")
+
+	keyHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	var serials []string
+	_, err = sa.dbMap.Select(
+		&serials,
+		"SELECT certSerial FROM keyHashToSerial WHERE keyHash = ? AND certNotAfter = ?",
+		keyHash[:],
+		cert.NotAfter,
+	)
+	test.AssertNotError(t, err, "Couldn't select key hashes")
+	test.AssertEquals(t, len(serials), 1)
+	test.AssertEquals(t, serials[0], "000000000000000000000000000000021bd4")
+}
+
+func TestBlockedKeys(t *testing.T) {
+	sa, fc, cleanUp := initSA(t)
+	defer cleanUp()
+
+	keyHash := sha256.Sum256([]byte("a public key"))
+	blocked, err := sa.KeyBlocked(ctx, keyHash[:])
+	test.AssertNotError(t, err, "KeyBlocked failed")
+	test.Assert(t, !blocked, "Key blocked before being added")
+
+	err = sa.AddBlockedKey(ctx, keyHash[:], fc.Now(), "test")
+	test.AssertNotError(t, err, "AddBlockedKey failed")
+	blocked, err = sa.KeyBlocked(ctx, keyHash[:])
+	test.AssertNotError(t, err, "KeyBlocked failed")
+	test.Assert(t, blocked, "Added key not blocked")
+
+	// Blocking a key twice is fine
+	err = sa.AddBlockedKey(ctx, keyHash[:], fc.Now(), "test")
+	test.AssertNotError(t, err, "AddBlockedKey failed for an already blocked key")
+
+	otherHash := sha256.Sum256([]byte("another public key"))
+	blocked, err = sa.KeyBlocked(ctx, otherHash[:])
+	test.AssertNotError(t, err, "KeyBlocked failed")
+	test.Assert(t, !blocked, "Unrelated key blocked")
+}
+
+func TestEmailOptOuts(t *testing.T) {
+	sa, fc, cleanUp := initSA(t)
+	defer cleanUp()
+
+	optedOut, err := sa.EmailOptedOut(ctx, "a@example.com")
+	test.AssertNotError(t, err, "EmailOptedOut failed")
+	test.Assert(t, !optedOut, "Email opted out before being added")
+
+	err = sa.AddEmailOptOut(ctx, 1, "a@example.com", fc.Now())
+	test.AssertNotError(t, err, "AddEmailOptOut failed")
+	optedOut, err = sa.EmailOptedOut(ctx, "a@example.com")
+	test.AssertNotError(t, err, "EmailOptedOut failed")
+	test.Assert(t, optedOut, "Added email not opted out")
+
+	// Opting out twice, even from another registration, is fine
+	err = sa.AddEmailOptOut(ctx, 2, "a@example.com", fc.Now())
+	test.AssertNotError(t, err, "AddEmailOptOut failed for an already opted out email")
+
+	optedOut, err = sa.EmailOptedOut(ctx, "b@example.com")
+	test.AssertNotError(t, err, "EmailOptedOut failed")
+	test.Assert(t, !optedOut, "Unrelated email opted out")
+}
+
+func TestUndeliverableEmails(t *testing.T) {
+	sa, fc, cleanUp := initSA(t)
+	defer cleanUp()
+
+	undeliverable, err := sa.EmailUndeliverable(ctx, "a@example.com")
+	test.AssertNotError(t, err, "EmailUndeliverable failed")
+	test.Assert(t, !undeliverable, "Email undeliverable before being added")
+
+	err = sa.AddUndeliverableEmail(ctx, "a@example.com", "5.1.1", fc.Now())
+	test.AssertNotError(t, err, "AddUndeliverableEmail failed")
+	undeliverable, err = sa.EmailUndeliverable(ctx, "a@example.com")
+	test.AssertNotError(t, err, "EmailUndeliverable failed")
+	test.Assert(t, undeliverable, "Added email not undeliverable")
+
+	// Bouncing twice is fine
+	err = sa.AddUndeliverableEmail(ctx, "a@example.com", "5.1.1", fc.Now())
+	test.AssertNotError(t, err, "AddUndeliverableEmail failed for an already undeliverable email")
+
+	undeliverable, err = sa.EmailUndeliverable(ctx, "b@example.com")
+	test.AssertNotError(t, err, "EmailUndeliverable failed")
+	test.Assert(t, !undeliverable, "Unrelated email undeliverable")
+
+	err = sa.RemoveUndeliverableEmail(ctx, "a@example.com")
+	test.AssertNotError(t, err, "RemoveUndeliverableEmail failed")
+	undeliverable, err = sa.EmailUndeliverable(ctx, "a@example.com")
+	test.AssertNotError(t, err, "EmailUndeliverable failed")
+	test.Assert(t, !undeliverable, "Removed email still undeliverable")
+	err = sa.RemoveUndeliverableEmail(ctx, "a@example.com")
+	test.AssertNotError(t, err, "RemoveUndeliverableEmail failed for an email that isn't undeliverable")
+}
+
+func TestAddWebhookDelivery(t *testing.T) {
+	sa, fc, cleanUp := initSA(t)
+	defer cleanUp()
+
+	reg := satest.CreateWorkingRegistration(t, sa)
+	err := sa.AddWebhookDelivery(ctx, core.WebhookDelivery{
+		RegistrationID: reg.ID,
+		URL:            "https://hooks.example.com/acme",
+		EventType:      "certificate.revoked",
+		Attempts:       3,
+		StatusCode:     503,
+		Delivered:      false,
+		Created:        fc.Now(),
+	})
+	test.AssertNotError(t, err, "AddWebhookDelivery failed")
+
+	count, err := sa.dbMap.SelectInt(
+		"SELECT COUNT(1) FROM webhookDeliveries WHERE registrationID = ? AND attempts = 3 AND delivered = 0",
+		reg.ID)
+	test.AssertNotError(t, err, "Failed to count webhook deliveries")
+	test.AssertEquals(t, count, int64(1))
+}
+
 func TestAddCertificate(t *testing.T) {
 	// Enable the feature for the `CertStatusOptimizationsMigrated` flag so that
 	// adding a new certificate will populate the `certificateStatus.NotAfter`
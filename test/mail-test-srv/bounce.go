@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// bounces returns whether mail to rcpt should bounce. Recipients whose local
+// part starts with "bounce" stand in for mailboxes that don't exist.
+func bounces(rcpt string) bool {
+	return strings.HasPrefix(strings.ToLower(rcpt), "bounce")
+}
+
+// writeBounce delivers a delivery status notification reporting that the mail
+// msg from from to rcpt failed into the new/ directory of srv.bounceMaildir,
+// the way an MTA would return it to the sender, with the headers of msg
+func (srv *mailSrv) writeBounce(from, rcpt, msg string) error {
+	headers := msg
+	if end := strings.Index(msg, "\r\n\r\n"); end >= 0 {
+		headers = msg[:end+2]
+	}
+	dsn := fmt.Sprintf("From: Mail Delivery System <MAILER-DAEMON@mail-test-srv>\r\n"+
+		"To: %s\r\n"+
+		"Subject: Undelivered Mail Returned to Sender\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"Content-Type: multipart/report; report-type=delivery-status; boundary=\"BOUNDARY\"\r\n"+
+		"\r\n"+
+		"--BOUNDARY\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"\r\n"+
+		"Your message to %s could not be delivered.\r\n"+
+		"--BOUNDARY\r\n"+
+		"Content-Type: message/delivery-status\r\n"+
+		"\r\n"+
+		"Reporting-MTA: dns; mail-test-srv\r\n"+
+		"\r\n"+
+		"Final-Recipient: rfc822; %s\r\n"+
+		"Action: failed\r\n"+
+		"Status: 5.1.1\r\n"+
+		"Diagnostic-Code: smtp; 550 5.1.1 User unknown\r\n"+
+		"--BOUNDARY\r\n"+
+		"Content-Type: text/rfc822-headers\r\n"+
+		"\r\n"+
+		"%s"+
+		"--BOUNDARY--\r\n", from, rcpt, rcpt, headers)
+
+	srv.bounceMutex.Lock()
+	srv.bounceNumber++
+	name := fmt.Sprintf("%d.%d.mail-test-srv", time.Now().UnixNano(), srv.bounceNumber)
+	srv.bounceMutex.Unlock()
+
+	// Maildir readers expect messages to appear in new/ atomically
+	tmpPath := filepath.Join(srv.bounceMaildir, "tmp", name)
+	if err := ioutil.WriteFile(tmpPath, []byte(dsn), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(srv.bounceMaildir, "new", name))
+}
+
+// makeMaildir creates the subdirectories of a maildir at dir
+func makeMaildir(dir string) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
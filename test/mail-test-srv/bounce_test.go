@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bmail "github.com/letsencrypt/boulder/mail"
+)
+
+func TestWriteBounce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail-test-srv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := makeMaildir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bounces("bounce-me@example.com") || bounces("happy@example.com") {
+		t.Error("bounces() picked the wrong recipients")
+	}
+
+	srv := mailSrv{bounceMaildir: dir}
+	msg := "Subject: Hello\r\nList-Unsubscribe: <https://example.com/unsubscribe?token=abc>\r\n\r\nHi\r\n.\r\n"
+	if err := srv.writeBounce("sender@example.com", "bounce-me@example.com", msg); err != nil {
+		t.Fatalf("writeBounce failed: %s", err)
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 bounce, got %d", len(entries))
+	}
+	f, err := os.Open(filepath.Join(dir, "new", entries[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	parsed, err := bmail.ParseDSN(f)
+	if err != nil {
+		t.Fatalf("bounce isn't a valid DSN: %s", err)
+	}
+	if len(parsed) != 1 || parsed[0].Recipient != "bounce-me@example.com" || parsed[0].Status != "5.1.1" ||
+		parsed[0].ListUnsubscribe != "<https://example.com/unsubscribe?token=abc>" {
+		t.Errorf("unexpected bounces %+v", parsed)
+	}
+}
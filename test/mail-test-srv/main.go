@@ -24,6 +24,11 @@ type mailSrv struct {
 	allMailMutex    sync.Mutex
 	connNumber      uint
 	connNumberMutex sync.RWMutex
+	// bounceMaildir, if set, is a maildir that delivery status notifications
+	// are written to for recipients that bounce
+	bounceMaildir string
+	bounceNumber  uint
+	bounceMutex   sync.Mutex
 }
 
 type rcvdMail struct {
@@ -157,6 +162,11 @@ func (srv *mailSrv) handleConn(conn net.Conn) {
 				mailResult.To = rcpt
 				srv.allReceivedMail = append(srv.allReceivedMail, mailResult)
 				log.Printf("mail-test-srv: Got mail: %s -> %s\n", fromAddr, rcpt)
+				if srv.bounceMaildir != "" && bounces(rcpt) {
+					if err := srv.writeBounce(fromAddr, rcpt, mailResult.Mail); err != nil {
+						log.Printf("mail-test-srv: writing bounce for %s: %v\n", rcpt, err)
+					}
+				}
 			}
 			srv.allMailMutex.Unlock()
 			conn.Write([]byte("250 Got mail \r\n"))
@@ -182,6 +192,7 @@ func main() {
 	var listenAPI = flag.String("http", "0.0.0.0:9381", "http port to listen on")
 	var listenSMTP = flag.String("smtp", "0.0.0.0:9380", "smtp port to listen on")
 	var closeFirst = flag.Uint("closeFirst", 0, "close first n connections after MAIL for reconnection tests")
+	var bounceMaildir = flag.String("bounceMaildir", "", "maildir to write bounces to for recipients starting with \"bounce\"")
 
 	flag.Parse()
 	l, err := net.Listen("tcp", *listenSMTP)
@@ -191,7 +202,13 @@ func main() {
 	defer l.Close()
 
 	srv := mailSrv{
-		closeFirst: *closeFirst,
+		closeFirst:    *closeFirst,
+		bounceMaildir: *bounceMaildir,
+	}
+	if srv.bounceMaildir != "" {
+		if err := makeMaildir(srv.bounceMaildir); err != nil {
+			log.Fatalln("Couldn't create bounce maildir", err)
+		}
 	}
 
 	srv.setupHTTP(http.DefaultServeMux)
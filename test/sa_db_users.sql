@@ -39,6 +39,11 @@ GRANT INSERT ON ocspResponses TO 'sa'@'localhost';
 GRANT SELECT,INSERT,UPDATE ON registrations TO 'sa'@'localhost';
 GRANT SELECT,INSERT,UPDATE ON challenges TO 'sa'@'localhost';
 GRANT SELECT,INSERT on fqdnSets TO 'sa'@'localhost';
+GRANT SELECT,INSERT ON keyHashToSerial TO 'sa'@'localhost';
+GRANT SELECT,INSERT ON blockedKeys TO 'sa'@'localhost';
+GRANT SELECT,INSERT ON emailOptOuts TO 'sa'@'localhost';
+GRANT SELECT,INSERT,DELETE ON undeliverableEmails TO 'sa'@'localhost';
+GRANT SELECT,INSERT ON webhookDeliveries TO 'sa'@'localhost';
 
 -- OCSP Responder
 GRANT SELECT ON certificateStatus TO 'ocsp_resp'@'localhost';
@@ -53,6 +58,7 @@ GRANT SELECT ON sctReceipts TO 'ocsp_update'@'localhost';
 -- Revoker Tool
 GRANT SELECT ON registrations TO 'revoker'@'localhost';
 GRANT SELECT ON certificates TO 'revoker'@'localhost';
+GRANT SELECT,INSERT ON keyHashToSerial TO 'revoker'@'localhost';
 
 -- External Cert Importer
 GRANT SELECT,INSERT,UPDATE,DELETE ON identifierData TO 'importer'@'localhost';
@@ -63,6 +69,9 @@ GRANT SELECT ON certificates TO 'mailer'@'localhost';
 GRANT SELECT ON registrations TO 'mailer'@'localhost';
 GRANT SELECT,UPDATE ON certificateStatus TO 'mailer'@'localhost';
 GRANT SELECT ON fqdnSets TO 'mailer'@'localhost';
+GRANT SELECT ON emailOptOuts TO 'mailer'@'localhost';
+GRANT SELECT ON undeliverableEmails TO 'mailer'@'localhost';
+GRANT SELECT ON issuedNames TO 'mailer'@'localhost';
 
 -- Cert checker
 GRANT SELECT ON certificates TO 'cert_checker'@'localhost';
@@ -46,6 +46,8 @@ def start(race_detection):
     global processes
     forward()
     progs = [
+        'nonce-service --config %s' % os.path.join(default_config_dir, "nonce.json"),
+        'request-limiter --config %s' % os.path.join(default_config_dir, "request-limiter.json"),
         'boulder-wfe --config %s' % os.path.join(default_config_dir, "wfe.json"),
         'boulder-ra --config %s' % os.path.join(default_config_dir, "ra.json"),
         'boulder-sa --config %s' % os.path.join(default_config_dir, "sa.json"),
@@ -56,9 +58,13 @@ def start(race_detection):
         'ocsp-responder --config %s' % os.path.join(default_config_dir, "ocsp-responder.json"),
         'ct-test-srv',
         'dns-test-srv',
-        'mail-test-srv --closeFirst 5',
+        'mail-test-srv --closeFirst 5 --bounceMaildir /tmp/boulder-bounces',
         'caa-checker --config cmd/caa-checker/test-config.yml'
     ]
+    # The bounce processor depends on tables that only exist in the next schema
+    bounce_config = os.path.join(default_config_dir, "bounce-processor.json")
+    if os.path.exists(bounce_config):
+        progs.append('bounce-processor --config %s' % bounce_config)
     if not install(race_detection):
         return False
     for prog in progs:
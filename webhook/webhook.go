@@ -0,0 +1,336 @@
+// Package webhook delivers signed notifications of account events to the
+// https: webhook contacts of registrations.
+package webhook
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/square/go-jose"
+	"golang.org/x/net/context"
+
+	"github.com/letsencrypt/boulder/core"
+	blog "github.com/letsencrypt/boulder/log"
+	"github.com/letsencrypt/boulder/metrics"
+)
+
+// Event types
+const (
+	CertificateExpiring      = "certificate.expiring"
+	CertificateRevoked       = "certificate.revoked"
+	AuthorizationDeactivated = "authorization.deactivated"
+)
+
+// SignatureHeader carries a JWS (RFC 7515) over the request body, in compact
+// serialization with the payload detached as described in RFC 7515 Appendix
+// F. Receivers verify it with the CA's published webhook key.
+const SignatureHeader = "Boulder-Signature"
+
+// Event is the JSON body POSTed to a registration's webhook contacts
+type Event struct {
+	Type         string    `json:"type"`
+	Registration int64     `json:"registration"`
+	Created      time.Time `json:"created"`
+
+	// Certificates is set for certificate events
+	Certificates []Certificate `json:"certificates,omitempty"`
+	// RevocationReason is set for certificate.revoked events
+	RevocationReason string `json:"revocationReason,omitempty"`
+
+	// Authorization and Identifier are set for authorization events
+	Authorization string `json:"authorization,omitempty"`
+	Identifier    string `json:"identifier,omitempty"`
+}
+
+// Certificate describes a certificate in an Event
+type Certificate struct {
+	Serial   string    `json:"serial"`
+	Expires  time.Time `json:"expires"`
+	DNSNames []string  `json:"dnsNames"`
+}
+
+// DeliveryLog records the outcome of each webhook delivery
+type DeliveryLog interface {
+	AddWebhookDelivery(ctx context.Context, delivery core.WebhookDelivery) error
+}
+
+// Contacts returns the webhook URLs among a registration's contacts
+func Contacts(contacts []string) []string {
+	var hooks []string
+	for _, contact := range contacts {
+		if strings.HasPrefix(contact, "https:") {
+			hooks = append(hooks, contact)
+		}
+	}
+	return hooks
+}
+
+var errRedirect = errors.New("webhook redirects are not followed")
+
+// Notifier POSTs signed events to webhooks, retrying failed deliveries with
+// exponential backoff. It is safe for concurrent use.
+type Notifier struct {
+	client     *http.Client
+	signer     jose.Signer
+	deliveries DeliveryLog
+	log        blog.Logger
+	stats      metrics.Scope
+	clk        clock.Clock
+
+	maxAttempts int
+	retryBase   time.Duration
+	retryMax    time.Duration
+}
+
+// defaultTimeout bounds each delivery attempt when New is given no timeout
+const defaultTimeout = 10 * time.Second
+
+// LoadKey reads the PEM encoded ECDSA (P-256 or P-384) or RSA private key in
+// keyFile and returns it with the algorithm that events are signed with
+func LoadKey(keyFile string) (jose.SignatureAlgorithm, interface{}, error) {
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return "", nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return "", nil, fmt.Errorf("no PEM data found in %q", keyFile)
+	}
+	var key interface{}
+	if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+		if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+				return "", nil, fmt.Errorf("unable to parse webhook key in %q", keyFile)
+			}
+		}
+	}
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jose.ES256, key, nil
+		case elliptic.P384():
+			return jose.ES384, key, nil
+		}
+		return "", nil, errors.New("webhook ECDSA key must use P-256 or P-384")
+	case *rsa.PrivateKey:
+		return jose.RS256, key, nil
+	}
+	return "", nil, fmt.Errorf("unsupported webhook key type %T", key)
+}
+
+// New constructs a Notifier that signs events with signingKey using alg, and
+// records deliveries in deliveries. Each request times out after timeout, or
+// 10 seconds if it is zero, and each delivery is tried at most maxAttempts
+// times.
+func New(
+	alg jose.SignatureAlgorithm,
+	signingKey interface{},
+	deliveries DeliveryLog,
+	timeout time.Duration,
+	maxAttempts int,
+	retryBase time.Duration,
+	retryMax time.Duration,
+	logger blog.Logger,
+	stats metrics.Scope) (*Notifier, error) {
+	signer, err := jose.NewSigner(alg, signingKey)
+	if err != nil {
+		return nil, err
+	}
+	signer.SetEmbedJwk(false)
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	return &Notifier{
+		client: &http.Client{
+			Transport: &http.Transport{
+				Dial: func(network, addr string) (net.Conn, error) {
+					return dialPublic(dialer, network, addr)
+				},
+				TLSHandshakeTimeout: timeout,
+			},
+			Timeout: timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return errRedirect
+			},
+		},
+		signer:      signer,
+		deliveries:  deliveries,
+		log:         logger,
+		stats:       stats.NewScope("Webhooks"),
+		clk:         clock.Default(),
+		maxAttempts: maxAttempts,
+		retryBase:   retryBase,
+		retryMax:    retryMax,
+	}, nil
+}
+
+// dialPublic refuses connections to loopback, private and link-local
+// addresses, so that webhook contacts can't be used to reach internal
+// services. The address actually connected to is checked, rather than the
+// result of a separate lookup, so DNS changes can't get around it.
+func dialPublic(dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	conn, err := dialer.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); !ok || !publicIP(tcpAddr.IP) {
+		_ = conn.Close()
+		return nil, fmt.Errorf("webhook address %s is not public", conn.RemoteAddr())
+	}
+	return conn, nil
+}
+
+var nonPublicNetworks = []*net.IPNet{
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("fc00::/7"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func publicIP(ip net.IP) bool {
+	if !ip.IsGlobalUnicast() {
+		return false
+	}
+	for _, n := range nonPublicNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Notify delivers event to each of hooks and records the outcome. It returns
+// once every delivery has succeeded or run out of attempts; failures are
+// logged rather than returned, since there is nothing more for the caller to
+// do about them.
+func (n *Notifier) Notify(ctx context.Context, hooks []string, event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		n.log.AuditErr(fmt.Sprintf("Failed to marshal webhook event: %s", err))
+		return
+	}
+	signature, err := n.sign(body)
+	if err != nil {
+		n.log.AuditErr(fmt.Sprintf("Failed to sign webhook event: %s", err))
+		n.stats.Inc("Errors.Sign", 1)
+		return
+	}
+	for _, hook := range hooks {
+		attempts, status, err := n.deliver(ctx, hook, body, signature)
+		delivery := core.WebhookDelivery{
+			RegistrationID: event.Registration,
+			URL:            hook,
+			EventType:      event.Type,
+			Attempts:       attempts,
+			StatusCode:     status,
+			Delivered:      err == nil,
+			Created:        n.clk.Now(),
+		}
+		if err != nil {
+			n.stats.Inc("Failures", 1)
+			n.log.Warning(fmt.Sprintf("Failed to deliver %s event for registration %d to %s after %d attempts: %s",
+				event.Type, event.Registration, hook, attempts, err))
+		} else {
+			n.stats.Inc("Deliveries", 1)
+		}
+		if err := n.deliveries.AddWebhookDelivery(ctx, delivery); err != nil {
+			n.stats.Inc("Errors.AddWebhookDelivery", 1)
+			n.log.AuditErr(fmt.Sprintf("Failed to record webhook delivery to %s: %s", hook, err))
+		}
+	}
+}
+
+// sign returns the detached compact JWS of body
+func (n *Notifier) sign(body []byte) (string, error) {
+	jws, err := n.signer.Sign(body)
+	if err != nil {
+		return "", err
+	}
+	compact, err := jws.CompactSerialize()
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(compact, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed compact JWS")
+	}
+	return parts[0] + ".." + parts[2], nil
+}
+
+// deliver POSTs body to hook until it succeeds, fails permanently or runs
+// out of attempts. It returns the number of attempts made and the HTTP status
+// of the last one.
+func (n *Notifier) deliver(ctx context.Context, hook string, body []byte, signature string) (int, int, error) {
+	var status int
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		status, retry, err = n.post(hook, body, signature)
+		if err == nil || !retry || attempt >= n.maxAttempts {
+			return attempt, status, err
+		}
+		n.stats.Inc("Retries", 1)
+		n.clk.Sleep(core.RetryBackoff(attempt, n.retryBase, n.retryMax, 2))
+		if ctx.Err() != nil {
+			return attempt, status, ctx.Err()
+		}
+	}
+}
+
+// post makes a single delivery attempt, returning the HTTP status and whether
+// a failure is worth retrying
+func (n *Notifier) post(hook string, body []byte, signature string) (int, bool, error) {
+	req, err := http.NewRequest("POST", hook, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, signature)
+	req.Header.Set("User-Agent", "Boulder-Webhooks")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok && urlErr.Err == errRedirect {
+			return 0, false, err
+		}
+		return 0, true, err
+	}
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	_ = resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return resp.StatusCode, false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return resp.StatusCode, true, fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return resp.StatusCode, false, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+}
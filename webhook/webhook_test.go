@@ -0,0 +1,190 @@
+package webhook
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/square/go-jose"
+	"golang.org/x/net/context"
+
+	"github.com/letsencrypt/boulder/core"
+	blog "github.com/letsencrypt/boulder/log"
+	"github.com/letsencrypt/boulder/metrics"
+	"github.com/letsencrypt/boulder/test"
+)
+
+type fakeDeliveryLog struct {
+	sync.Mutex
+	deliveries []core.WebhookDelivery
+}
+
+func (f *fakeDeliveryLog) AddWebhookDelivery(ctx context.Context, delivery core.WebhookDelivery) error {
+	f.Lock()
+	defer f.Unlock()
+	f.deliveries = append(f.deliveries, delivery)
+	return nil
+}
+
+func setup(t *testing.T) (*Notifier, *ecdsa.PrivateKey, *fakeDeliveryLog) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "Failed to generate key")
+	log := &fakeDeliveryLog{}
+	n, err := New(jose.ES256, key, log, time.Second, 3, time.Second, time.Minute, blog.NewMock(), metrics.NewNoopScope())
+	test.AssertNotError(t, err, "Failed to create notifier")
+	n.clk = clock.NewFake()
+	// The test server listens on loopback, which the default dialer refuses
+	n.client = &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		CheckRedirect: n.client.CheckRedirect,
+	}
+	return n, key, log
+}
+
+func TestContacts(t *testing.T) {
+	hooks := Contacts([]string{"mailto:me@example.com", "https://example.com/hook", "tel:+15555555555"})
+	test.AssertDeepEquals(t, hooks, []string{"https://example.com/hook"})
+}
+
+func TestNotify(t *testing.T) {
+	n, key, log := setup(t)
+
+	var body []byte
+	var signature string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+	}))
+	defer server.Close()
+
+	event := Event{Type: AuthorizationDeactivated, Registration: 1, Authorization: "abc", Identifier: "example.com"}
+	n.Notify(context.Background(), []string{server.URL}, event)
+
+	var received Event
+	err := json.Unmarshal(body, &received)
+	test.AssertNotError(t, err, "Failed to unmarshal event")
+	test.AssertEquals(t, received.Type, AuthorizationDeactivated)
+	test.AssertEquals(t, received.Authorization, "abc")
+
+	// Reattaching the payload gives a JWS that verifies with the notifier's key
+	parts := strings.Split(signature, ".")
+	test.AssertEquals(t, len(parts), 3)
+	test.AssertEquals(t, parts[1], "")
+	jws, err := jose.ParseSigned(parts[0] + "." + base64.RawURLEncoding.EncodeToString(body) + "." + parts[2])
+	test.AssertNotError(t, err, "Failed to parse signature")
+	payload, err := jws.Verify(&key.PublicKey)
+	test.AssertNotError(t, err, "Signature didn't verify")
+	test.AssertEquals(t, string(payload), string(body))
+
+	test.AssertEquals(t, len(log.deliveries), 1)
+	test.AssertEquals(t, log.deliveries[0].Delivered, true)
+	test.AssertEquals(t, log.deliveries[0].Attempts, 1)
+	test.AssertEquals(t, log.deliveries[0].StatusCode, http.StatusOK)
+	test.AssertEquals(t, log.deliveries[0].URL, server.URL)
+}
+
+func TestNotifyRetries(t *testing.T) {
+	n, _, log := setup(t)
+
+	attempts := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	event := Event{Type: CertificateRevoked, Registration: 1}
+	n.Notify(context.Background(), []string{server.URL}, event)
+	test.AssertEquals(t, attempts, 2)
+	test.AssertEquals(t, log.deliveries[0].Delivered, true)
+	test.AssertEquals(t, log.deliveries[0].Attempts, 2)
+
+	// Client errors aren't retried
+	attempts = 0
+	forbidden := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer forbidden.Close()
+	n.Notify(context.Background(), []string{forbidden.URL}, event)
+	test.AssertEquals(t, attempts, 1)
+	test.AssertEquals(t, log.deliveries[1].Delivered, false)
+	test.AssertEquals(t, log.deliveries[1].StatusCode, http.StatusForbidden)
+
+	// Server errors are retried until attempts run out
+	attempts = 0
+	broken := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	n.Notify(context.Background(), []string{broken.URL}, event)
+	test.AssertEquals(t, attempts, 3)
+	test.AssertEquals(t, log.deliveries[2].Delivered, false)
+	test.AssertEquals(t, log.deliveries[2].Attempts, 3)
+}
+
+func TestNotifyRedirect(t *testing.T) {
+	n, _, log := setup(t)
+
+	attempts := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Redirect(w, r, "https://example.com/", http.StatusFound)
+	}))
+	defer server.Close()
+
+	n.Notify(context.Background(), []string{server.URL}, Event{Type: CertificateRevoked, Registration: 1})
+	test.AssertEquals(t, attempts, 1)
+	test.AssertEquals(t, log.deliveries[0].Delivered, false)
+}
+
+func TestPublicIP(t *testing.T) {
+	for _, tc := range []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1::1", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+	} {
+		test.AssertEquals(t, publicIP(net.ParseIP(tc.ip)), tc.public)
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	alg, key, err := LoadKey("../test/secrets/webhook_key.pem")
+	test.AssertNotError(t, err, "Failed to load key")
+	test.AssertEquals(t, alg, jose.ES256)
+	_, err = New(alg, key, nil, 0, 1, 0, 0, blog.NewMock(), metrics.NewNoopScope())
+	test.AssertNotError(t, err, "Failed to create notifier with loaded key")
+
+	_, _, err = LoadKey("../test/secrets/smtp_password")
+	test.AssertError(t, err, "Accepted a non-PEM key file")
+
+	_, _, err = LoadKey("../test/secrets/does-not-exist.pem")
+	test.AssertError(t, err, "Accepted a missing key file")
+}
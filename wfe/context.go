@@ -28,6 +28,13 @@ type requestEvent struct {
 	ResponseNonce string                 `json:",omitempty"`
 	UserAgent     string                 `json:",omitempty"`
 	Extra         map[string]interface{} `json:",omitempty"`
+
+	// pattern is the path the handling endpoint was registered at, used to
+	// look up per-endpoint request limits.
+	pattern string
+	// retryAfter is sent to the client in a Retry-After header along with a
+	// rateLimited problem.
+	retryAfter time.Duration
 }
 
 func (e *requestEvent) AddError(msg string, args ...interface{}) {
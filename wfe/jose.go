@@ -7,6 +7,7 @@ import (
 
 	"github.com/letsencrypt/boulder/core"
 	"github.com/square/go-jose"
+	"golang.org/x/crypto/ed25519"
 )
 
 func algorithmForKey(key *jose.JsonWebKey) (string, error) {
@@ -22,6 +23,8 @@ func algorithmForKey(key *jose.JsonWebKey) (string, error) {
 		case "P-521":
 			return string(jose.ES512), nil
 		}
+	case ed25519.PublicKey:
+		return string(jose.EdDSA), nil
 	}
 	return "", core.SignatureValidationError("no signature algorithms suitable for given key type")
 }
@@ -46,7 +49,7 @@ func checkAlgorithm(key *jose.JsonWebKey, parsedJws *jose.JsonWebSignature) (str
 	if jwsAlgorithm != algorithm {
 		return invalidJWSAlgorithm,
 			core.SignatureValidationError(fmt.Sprintf(
-				"signature type '%s' in JWS header is not supported, expected one of RS256, ES256, ES384 or ES512",
+				"signature type '%s' in JWS header is not supported, expected one of RS256, ES256, ES384, ES512 or EdDSA",
 				jwsAlgorithm))
 	}
 	if key.Algorithm != "" && key.Algorithm != algorithm {
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/square/go-jose"
+	"golang.org/x/crypto/ed25519"
 )
 
 func TestRejectsNone(t *testing.T) {
@@ -28,7 +29,7 @@ func TestRejectsNone(t *testing.T) {
 	if prob == nil {
 		t.Fatalf("verifyPOST did not reject JWS with alg: 'none'")
 	}
-	if prob.Detail != "signature type 'none' in JWS header is not supported, expected one of RS256, ES256, ES384 or ES512" {
+	if prob.Detail != "signature type 'none' in JWS header is not supported, expected one of RS256, ES256, ES384, ES512 or EdDSA" {
 		t.Fatalf("verifyPOST rejected JWS with alg: 'none', but for wrong reason: %#v", prob)
 	}
 }
@@ -52,7 +53,7 @@ func TestRejectsHS256(t *testing.T) {
 	if prob == nil {
 		t.Fatalf("verifyPOST did not reject JWS with alg: 'HS256'")
 	}
-	expected := "signature type 'HS256' in JWS header is not supported, expected one of RS256, ES256, ES384 or ES512"
+	expected := "signature type 'HS256' in JWS header is not supported, expected one of RS256, ES256, ES384, ES512 or EdDSA"
 	if prob.Detail != expected {
 		t.Fatalf("verifyPOST rejected JWS with alg: 'none', but for wrong reason: got '%s', wanted %s", prob, expected)
 	}
@@ -86,7 +87,7 @@ func TestCheckAlgorithm(t *testing.T) {
 					},
 				},
 			},
-			"signature type 'HS256' in JWS header is not supported, expected one of RS256, ES256, ES384 or ES512",
+			"signature type 'HS256' in JWS header is not supported, expected one of RS256, ES256, ES384, ES512 or EdDSA",
 			"WFE.Errors.InvalidJWSAlgorithm",
 		},
 		{
@@ -103,7 +104,7 @@ func TestCheckAlgorithm(t *testing.T) {
 					},
 				},
 			},
-			"signature type 'HS256' in JWS header is not supported, expected one of RS256, ES256, ES384 or ES512",
+			"signature type 'HS256' in JWS header is not supported, expected one of RS256, ES256, ES384, ES512 or EdDSA",
 			"WFE.Errors.InvalidJWSAlgorithm",
 		},
 		{
@@ -200,4 +201,34 @@ func TestCheckAlgorithmSuccess(t *testing.T) {
 	if err != nil {
 		t.Errorf("ES256 key: Expected nil error, got '%s'", err)
 	}
+	_, err = checkAlgorithm(&jose.JsonWebKey{
+		Key: &ecdsa.PublicKey{
+			Curve: elliptic.P521(),
+		},
+	}, &jose.JsonWebSignature{
+		Signatures: []jose.Signature{
+			{
+				Header: jose.JoseHeader{
+					Algorithm: "ES512",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("ES512 key: Expected nil error, got '%s'", err)
+	}
+	_, err = checkAlgorithm(&jose.JsonWebKey{
+		Key: ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)),
+	}, &jose.JsonWebSignature{
+		Signatures: []jose.Signature{
+			{
+				Header: jose.JoseHeader{
+					Algorithm: "EdDSA",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Errorf("Ed25519 key: Expected nil error, got '%s'", err)
+	}
 }
@@ -6,7 +6,9 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"html/template"
 	"io/ioutil"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
@@ -23,9 +25,12 @@ import (
 	"github.com/letsencrypt/boulder/features"
 	"github.com/letsencrypt/boulder/goodkey"
 	blog "github.com/letsencrypt/boulder/log"
+	"github.com/letsencrypt/boulder/mail"
 	"github.com/letsencrypt/boulder/metrics"
 	"github.com/letsencrypt/boulder/nonce"
 	"github.com/letsencrypt/boulder/probs"
+	"github.com/letsencrypt/boulder/ratelimit"
+	"github.com/letsencrypt/boulder/reloader"
 	"github.com/letsencrypt/boulder/revocation"
 )
 
@@ -43,8 +48,20 @@ const (
 	termsPath      = "/terms"
 	issuerPath     = "/acme/issuer-cert"
 	buildIDPath    = "/build"
+
+	// Paths for listing a registration's certificates and authorizations, as
+	// linked to from the registration object.
+	regCertsPath  = "/acme/reg-certs/"
+	regAuthzsPath = "/acme/reg-authzs/"
+
+	// Path of the unsubscribe links in notification emails
+	unsubscribePath = "/unsubscribe"
 )
 
+// defaultListPageSize is the number of entries returned per page by the
+// certificates and authorizations listing endpoints.
+const defaultListPageSize = 100
+
 // WebFrontEndImpl provides all the logic for Boulder's web-facing interface,
 // i.e., ACME.  Its members configure the paths for various ACME functions,
 // plus a few other data items used in ACME.  Its methods are primarily handlers
@@ -65,8 +82,15 @@ type WebFrontEndImpl struct {
 	// URL to the current subscriber agreement (should contain some version identifier)
 	SubscriberAgreementURL string
 
-	// Register of anti-replay nonces
-	nonceService *nonce.NonceService
+	// Request rate limiting. Limits are looked up per endpoint in
+	// requestLimits, and their token buckets are kept in RequestBuckets, which
+	// may be shared between WFEs.
+	requestLimits  ratelimit.RequestLimits
+	RequestBuckets ratelimit.BucketStore
+
+	// Register of anti-replay nonces. Defaults to an in-process nonce
+	// service, which only recognizes nonces issued by this WFE.
+	NonceService core.NonceService
 
 	// Key policy.
 	keyPolicy goodkey.KeyPolicy
@@ -83,10 +107,24 @@ type WebFrontEndImpl struct {
 	// Maximum duration of a request
 	RequestTimeout time.Duration
 
+	// Number of entries per page for the certificates and authorizations
+	// listing endpoints
+	listPageSize int
+
 	// Feature gates
 	CheckMalformedCSR      bool
 	AcceptRevocationReason bool
 	AllowAuthzDeactivation bool
+
+	// UnsubscribeSigner verifies the tokens in the unsubscribe links sent by
+	// the mailers, and EmailOptOuts records the addresses that unsubscribe.
+	// The unsubscribe endpoint is only served when both are set.
+	UnsubscribeSigner *mail.UnsubscribeSigner
+	EmailOptOuts      emailOptOutAdder
+}
+
+type emailOptOutAdder interface {
+	AddEmailOptOut(ctx context.Context, regID int64, email string, added time.Time) error
 }
 
 // NewWebFrontEndImpl constructs a web service for Boulder
@@ -96,20 +134,61 @@ func NewWebFrontEndImpl(
 	keyPolicy goodkey.KeyPolicy,
 	logger blog.Logger,
 ) (WebFrontEndImpl, error) {
-	nonceService, err := nonce.NewNonceService(stats)
+	nonceService, err := nonce.NewNonceService(stats, "")
 	if err != nil {
 		return WebFrontEndImpl{}, err
 	}
 
 	return WebFrontEndImpl{
-		log:          logger,
-		clk:          clk,
-		nonceService: nonceService,
-		stats:        stats,
-		keyPolicy:    keyPolicy,
+		log:            logger,
+		clk:            clk,
+		NonceService:   nonce.NewLocal(nonceService),
+		stats:          stats,
+		keyPolicy:      keyPolicy,
+		listPageSize:   defaultListPageSize,
+		requestLimits:  ratelimit.NewRequestLimits(),
+		RequestBuckets: ratelimit.NewMemoryBucketStore(clk),
 	}, nil
 }
 
+// SetRequestLimitPoliciesFile loads the per-endpoint request rate limit
+// policies from filename, and reloads them whenever the file changes.
+func (wfe *WebFrontEndImpl) SetRequestLimitPoliciesFile(filename string) error {
+	_, err := reloader.New(filename, wfe.requestLimits.LoadPolicies, wfe.requestLimitPoliciesLoadError)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (wfe *WebFrontEndImpl) requestLimitPoliciesLoadError(err error) {
+	wfe.log.Err(fmt.Sprintf("error reloading request limit policy: %s", err))
+}
+
+// checkRequestRate takes a token from the bucket for key under the given
+// policy, returning a rateLimited problem if there was none left. A failure
+// to reach the bucket store is logged and the request allowed, so that an
+// outage of a shared store doesn't take the API down with it.
+func (wfe *WebFrontEndImpl) checkRequestRate(ctx context.Context, logEvent *requestEvent, policy ratelimit.RequestRatePolicy, limitName, key string, regID int64) *probs.ProblemDetails {
+	if !policy.Enabled() || wfe.RequestBuckets == nil {
+		return nil
+	}
+	bucketKey := fmt.Sprintf("%s:%s:%s", logEvent.pattern, limitName, key)
+	ok, retryAfter, err := wfe.RequestBuckets.Take(ctx, bucketKey, policy.GetBurst(key, regID), policy.Period.Duration)
+	if err != nil {
+		wfe.stats.Inc("RequestLimits.Errors", 1)
+		logEvent.AddError("unable to check %s request limit: %s", limitName, err)
+		return nil
+	}
+	if ok {
+		return nil
+	}
+	wfe.stats.Inc(fmt.Sprintf("RequestLimits.%s.Exceeded", limitName), 1)
+	logEvent.retryAfter = retryAfter
+	return probs.RateLimited(fmt.Sprintf("Too many requests to %s, retry after %s", logEvent.pattern, retryAfter))
+}
+
 // HandleFunc registers a handler at the given path. It's
 // http.HandleFunc(), but with a wrapper around the handler that
 // provides some generic per-request functionality:
@@ -145,7 +224,7 @@ func (wfe *WebFrontEndImpl) HandleFunc(mux *http.ServeMux, pattern string, h wfe
 		wfe: wfeHandlerFunc(func(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
 			// We do not propagate errors here, because (1) they should be
 			// transient, and (2) they fail closed.
-			nonce, err := wfe.nonceService.Nonce()
+			nonce, err := wfe.NonceService.Nonce(ctx)
 			if err == nil {
 				response.Header().Set("Replay-Nonce", nonce)
 				logEvent.ResponseNonce = nonce
@@ -174,6 +253,15 @@ func (wfe *WebFrontEndImpl) HandleFunc(mux *http.ServeMux, pattern string, h wfe
 
 			wfe.setCORSHeaders(response, request, "")
 
+			logEvent.pattern = pattern
+			if logEvent.RealIP != "" {
+				policy := wfe.requestLimits.Endpoint(pattern).PerIP
+				if prob := wfe.checkRequestRate(ctx, logEvent, policy, "PerIP", logEvent.RealIP, 0); prob != nil {
+					wfe.sendError(response, logEvent, prob, nil)
+					return
+				}
+			}
+
 			timeout := wfe.RequestTimeout
 			if timeout == 0 {
 				timeout = 5 * time.Minute
@@ -259,10 +347,15 @@ func (wfe *WebFrontEndImpl) Handler() (http.Handler, error) {
 	wfe.HandleFunc(m, authzPath, wfe.Authorization, "GET", "POST")
 	wfe.HandleFunc(m, challengePath, wfe.Challenge, "GET", "POST")
 	wfe.HandleFunc(m, certPath, wfe.Certificate, "GET")
+	wfe.HandleFunc(m, regCertsPath, wfe.RegistrationCertificates, "GET", "POST")
+	wfe.HandleFunc(m, regAuthzsPath, wfe.RegistrationAuthorizations, "GET", "POST")
 	wfe.HandleFunc(m, revokeCertPath, wfe.RevokeCertificate, "POST")
 	wfe.HandleFunc(m, termsPath, wfe.Terms, "GET")
 	wfe.HandleFunc(m, issuerPath, wfe.Issuer, "GET")
 	wfe.HandleFunc(m, buildIDPath, wfe.BuildID, "GET")
+	if wfe.UnsubscribeSigner != nil && wfe.EmailOptOuts != nil {
+		wfe.HandleFunc(m, unsubscribePath, wfe.Unsubscribe, "GET", "POST")
+	}
 	// We don't use our special HandleFunc for "/" because it matches everything,
 	// meaning we can wind up returning 405 when we mean to return 404. See
 	// https://github.com/letsencrypt/boulder/issues/717
@@ -430,7 +523,11 @@ func (wfe *WebFrontEndImpl) verifyPOST(ctx context.Context, logEvent *requestEve
 		// When looking up keys from the registrations DB, we can be confident they
 		// are "good". But when we are verifying against any submitted key, we want
 		// to check its quality before doing the verify.
-		if err = wfe.keyPolicy.GoodKey(submittedKey.Key); err != nil {
+		if err = wfe.keyPolicy.GoodKey(ctx, submittedKey.Key); err != nil {
+			if _, ok := err.(core.InternalServerError); ok {
+				logEvent.AddError("unable to check JWK with GoodKey: %s", err)
+				return nil, nil, reg, probs.ServerInternal("Unable to check JWK")
+			}
 			wfe.stats.Inc("Errors.JWKRejectedByGoodKey", 1)
 			logEvent.AddError("JWK in request was rejected by GoodKey: %s", err)
 			return nil, nil, reg, probs.Malformed(err.Error())
@@ -450,6 +547,19 @@ func (wfe *WebFrontEndImpl) verifyPOST(ctx context.Context, logEvent *requestEve
 		key = &reg.Key
 		logEvent.Requester = reg.ID
 		logEvent.Contacts = reg.Contact
+
+		// Registrations keep their key when it is blocked, e.g. after a
+		// certificate for it is revoked for keyCompromise, so it must be
+		// checked here as well as when it is first submitted
+		if err = wfe.keyPolicy.CheckBlocked(ctx, key.Key); err != nil {
+			if _, ok := err.(core.InternalServerError); ok {
+				logEvent.AddError("unable to check whether registration key is blocked: %s", err)
+				return nil, nil, reg, probs.ServerInternal("Unable to check JWK")
+			}
+			wfe.stats.Inc("Errors.BlockedRegistrationKey", 1)
+			logEvent.AddError("registration key is blocked: %s", err)
+			return nil, nil, reg, probs.Unauthorized(err.Error())
+		}
 	}
 
 	if features.Enabled(features.AllowAccountDeactivation) && reg.Status != core.StatusValid {
@@ -479,12 +589,28 @@ func (wfe *WebFrontEndImpl) verifyPOST(ctx context.Context, logEvent *requestEve
 		wfe.stats.Inc("Errors.JWSMissingNonce", 1)
 		logEvent.AddError("JWS is missing an anti-replay nonce")
 		return nil, nil, reg, probs.BadNonce("JWS has no anti-replay nonce")
-	} else if !wfe.nonceService.Valid(nonce) {
+	}
+	valid, err := wfe.NonceService.Redeem(ctx, nonce)
+	if err != nil {
+		wfe.stats.Inc("Errors.NonceRedeemFailed", 1)
+		logEvent.AddError("unable to redeem anti-replay nonce %s: %s", nonce, err)
+		return nil, nil, reg, probs.ServerInternal("Unable to check anti-replay nonce")
+	}
+	if !valid {
 		wfe.stats.Inc("Errors.JWSInvalidNonce", 1)
 		logEvent.AddError("JWS has an invalid anti-replay nonce: %s", nonce)
 		return nil, nil, reg, probs.BadNonce(fmt.Sprintf("JWS has invalid anti-replay nonce %v", nonce))
 	}
 
+	// Only charge a registration's request limit for requests it really
+	// signed, so that someone who knows its public key can't use it up
+	if key == &reg.Key {
+		policy := wfe.requestLimits.Endpoint(logEvent.pattern).PerAccount
+		if prob := wfe.checkRequestRate(ctx, logEvent, policy, "PerAccount", strconv.FormatInt(reg.ID, 10), reg.ID); prob != nil {
+			return nil, nil, reg, prob
+		}
+	}
+
 	// Check that the "resource" field is present and has the correct value
 	var parsedRequest struct {
 		Resource string `json:"resource"`
@@ -529,6 +655,10 @@ func (wfe *WebFrontEndImpl) sendError(response http.ResponseWriter, logEvent *re
 		problemDoc = []byte("{\"detail\": \"Problem marshalling error message.\"}")
 	}
 
+	if prob.Type == probs.RateLimitedProblem && logEvent.retryAfter > 0 {
+		response.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(logEvent.retryAfter.Seconds()))))
+	}
+
 	// Paraphrased from
 	// https://golang.org/src/net/http/server.go#L1272
 	response.Header().Set("Content-Type", "application/problem+json")
@@ -546,6 +676,25 @@ func link(url, relation string) string {
 	return fmt.Sprintf("<%s>;rel=\"%s\"", url, relation)
 }
 
+// registrationResponse is a core.Registration as displayed to the client,
+// including the URLs from which the registration's authorizations and
+// certificates can be listed.
+type registrationResponse struct {
+	core.Registration
+	Authorizations string `json:"authorizations"`
+	Certificates   string `json:"certificates"`
+}
+
+// prepRegistrationForDisplay takes a core.Registration and wraps it in
+// a registrationResponse with its listing URLs filled in.
+func (wfe *WebFrontEndImpl) prepRegistrationForDisplay(request *http.Request, reg core.Registration) registrationResponse {
+	return registrationResponse{
+		Registration:   reg,
+		Authorizations: wfe.relativeEndpoint(request, fmt.Sprintf("%s%d", regAuthzsPath, reg.ID)),
+		Certificates:   wfe.relativeEndpoint(request, fmt.Sprintf("%s%d", regCertsPath, reg.ID)),
+	}
+}
+
 // NewRegistration is used by clients to submit a new registration/account
 func (wfe *WebFrontEndImpl) NewRegistration(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
 
@@ -601,7 +750,7 @@ func (wfe *WebFrontEndImpl) NewRegistration(ctx context.Context, logEvent *reque
 	// Use an explicitly typed variable. Otherwise `go vet' incorrectly complains
 	// that reg.ID is a string being passed to %d.
 	regURL := wfe.relativeEndpoint(request, fmt.Sprintf("%s%d", regPath, reg.ID))
-	responseBody, err := marshalIndent(reg)
+	responseBody, err := marshalIndent(wfe.prepRegistrationForDisplay(request, reg))
 	if err != nil {
 		// ServerInternal because we just created this registration, and it
 		// should be OK.
@@ -841,9 +990,9 @@ func (wfe *WebFrontEndImpl) NewCertificate(ctx context.Context, logEvent *reques
 	// bytes on the wire, and (b) the CA logs all rejections as audit events, but
 	// a bad key from the client is just a malformed request and doesn't need to
 	// be audited.
-	if err := wfe.keyPolicy.GoodKey(certificateRequest.CSR.PublicKey); err != nil {
+	if err := wfe.keyPolicy.GoodKey(ctx, certificateRequest.CSR.PublicKey); err != nil {
 		logEvent.AddError("CSR public key failed GoodKey: %s", err)
-		wfe.sendError(response, logEvent, probs.Malformed("Invalid key in certificate request :: %s", err), err)
+		wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Invalid key in certificate request"), err)
 		return
 	}
 	logEvent.Extra["CSRDNSNames"] = certificateRequest.CSR.DNSNames
@@ -1162,7 +1311,7 @@ func (wfe *WebFrontEndImpl) Registration(ctx context.Context, logEvent *requestE
 		return
 	}
 
-	jsonReply, err := marshalIndent(updatedReg)
+	jsonReply, err := marshalIndent(wfe.prepRegistrationForDisplay(request, updatedReg))
 	if err != nil {
 		// ServerInternal because we just generated the reg, it should be OK
 		logEvent.AddError("unable to marshal updated registration: %s", err)
@@ -1308,6 +1457,124 @@ func (wfe *WebFrontEndImpl) Certificate(ctx context.Context, logEvent *requestEv
 	return
 }
 
+// RegistrationCertificates lists the certificates issued to a registration, one
+// page at a time. The request must be a POST signed by the registration's key.
+func (wfe *WebFrontEndImpl) RegistrationCertificates(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
+	wfe.listByRegistration(ctx, logEvent, response, request, registrationListing{
+		field:       "certificates",
+		listPath:    regCertsPath,
+		entryPath:   certPath,
+		validCursor: core.ValidSerial,
+		fetch:       wfe.SA.GetSerialsByRegistration,
+	})
+}
+
+// RegistrationAuthorizations lists the unexpired, finalized authorizations
+// belonging to a registration, one page at a time. The request must be a POST
+// signed by the registration's key.
+func (wfe *WebFrontEndImpl) RegistrationAuthorizations(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
+	wfe.listByRegistration(ctx, logEvent, response, request, registrationListing{
+		field:       "authorizations",
+		listPath:    regAuthzsPath,
+		entryPath:   authzPath,
+		validCursor: core.LooksLikeAToken,
+		fetch:       wfe.SA.GetAuthorizationIDsByRegistration,
+	})
+}
+
+// registrationListing describes one of the per-registration listing
+// endpoints.
+type registrationListing struct {
+	// Name of the JSON field holding the list of URLs
+	field string
+	// Path prefix of the listing endpoint itself
+	listPath string
+	// Path prefix under which each listed entry can be fetched
+	entryPath string
+	// Reports whether a client-provided cursor is well formed
+	validCursor func(string) bool
+	// Returns up to limit entries for the registration following after
+	fetch func(ctx context.Context, regID int64, after string, limit int) ([]string, error)
+}
+
+// listByRegistration serves one page of a registration listing. Entries are
+// returned in a stable order, and when more entries are available a Link
+// header with rel="next" points at the following page.
+//
+// A registration's certificates and authorizations are private to it, and
+// registration IDs are easy to guess, so only a request signed by the
+// registration's own key is answered. An unsigned GET is refused outright,
+// rather than being told whether the registration exists.
+func (wfe *WebFrontEndImpl) listByRegistration(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request, listing registrationListing) {
+	if request.Method != "POST" {
+		logEvent.AddError("unsigned request for %s listing", listing.field)
+		wfe.sendError(response, logEvent, probs.Unauthorized(fmt.Sprintf(
+			"Listing a registration's %s requires a POST signed by the registration's key", listing.field)), nil)
+		return
+	}
+	_, _, currReg, prob := wfe.verifyPOST(ctx, logEvent, request, true, core.ResourceRegistration)
+	addRequesterHeader(response, logEvent.Requester)
+	if prob != nil {
+		// verifyPOST handles its own setting of logEvent.Errors
+		wfe.sendError(response, logEvent, prob, nil)
+		return
+	}
+
+	idStr := request.URL.Path
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 {
+		logEvent.AddError("registration ID must be a positive integer, was %#v", idStr)
+		wfe.sendError(response, logEvent, probs.NotFound("Registration not found"), nil)
+		return
+	}
+	logEvent.Extra["RegistrationID"] = id
+	if id != currReg.ID {
+		logEvent.AddError("Request signing key did not match registration key: %d != %d", id, currReg.ID)
+		wfe.sendError(response, logEvent, probs.Unauthorized("Request signing key did not match registration key"), nil)
+		return
+	}
+
+	cursor := request.URL.Query().Get("cursor")
+	if cursor != "" && !listing.validCursor(cursor) {
+		logEvent.AddError("invalid listing cursor: %#v", cursor)
+		wfe.sendError(response, logEvent, probs.Malformed("Invalid cursor"), nil)
+		return
+	}
+	logEvent.Extra["Cursor"] = cursor
+
+	// Ask for one more entry than fits in a page so we know whether there is a
+	// next page to link to.
+	entries, err := listing.fetch(ctx, id, cursor, wfe.listPageSize+1)
+	if err != nil {
+		logEvent.AddError("unable to list %s for registration %d: %s", listing.field, id, err)
+		wfe.sendError(response, logEvent, core.ProblemDetailsForError(err, "Unable to list "+listing.field), err)
+		return
+	}
+	if len(entries) > wfe.listPageSize {
+		entries = entries[:wfe.listPageSize]
+		nextURL := wfe.relativeEndpoint(request, fmt.Sprintf("%s%d", listing.listPath, id)) +
+			"?cursor=" + url.QueryEscape(entries[len(entries)-1])
+		response.Header().Add("Link", link(nextURL, "next"))
+	}
+
+	urls := make([]string, len(entries))
+	for i, entry := range entries {
+		urls[i] = wfe.relativeEndpoint(request, listing.entryPath+entry)
+	}
+	jsonReply, err := marshalIndent(map[string][]string{listing.field: urls})
+	if err != nil {
+		logEvent.AddError("unable to marshal %s listing: %s", listing.field, err)
+		wfe.sendError(response, logEvent, probs.ServerInternal("Failed to marshal listing"), err)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	if _, err = response.Write(jsonReply); err != nil {
+		logEvent.AddError(err.Error())
+		wfe.log.Warning(fmt.Sprintf("Could not write response: %s", err))
+	}
+}
+
 // Terms is used by the client to obtain the current Terms of Service /
 // Subscriber Agreement to which the subscriber must agree.
 func (wfe *WebFrontEndImpl) Terms(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
@@ -1336,6 +1603,55 @@ func (wfe *WebFrontEndImpl) BuildID(ctx context.Context, logEvent *requestEvent,
 	}
 }
 
+var unsubscribeForm = template.Must(template.New("unsubscribe").Parse(`<html>
+<body>
+<form method="POST" action="?token={{.Token}}">
+<p>Stop sending notification emails to {{.Email}}?</p>
+<input type="submit" value="Unsubscribe">
+</form>
+</body>
+</html>
+`))
+
+// Unsubscribe opts the recipient of a notification email out of further ones,
+// using the signed link from the email. A GET serves a confirmation form, so
+// that mail scanners which follow links don't unsubscribe anyone. A POST,
+// which is also what RFC 8058 one-click unsubscribe sends, records the opt
+// out.
+func (wfe *WebFrontEndImpl) Unsubscribe(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
+	token := request.URL.Query().Get("token")
+	regID, email, err := wfe.UnsubscribeSigner.Verify(token)
+	if err != nil {
+		wfe.sendError(response, logEvent, probs.Malformed("Invalid unsubscribe link"), err)
+		return
+	}
+	logEvent.Requester = regID
+
+	if request.Method != "POST" {
+		response.Header().Set("Content-Type", "text/html; charset=utf-8")
+		response.WriteHeader(http.StatusOK)
+		err = unsubscribeForm.Execute(response, struct{ Token, Email string }{token, email})
+		if err != nil {
+			logEvent.AddError("unable to write unsubscribe form: %s", err)
+			wfe.log.Warning(fmt.Sprintf("Could not write response: %s", err))
+		}
+		return
+	}
+
+	err = wfe.EmailOptOuts.AddEmailOptOut(ctx, regID, email, wfe.clk.Now())
+	if err != nil {
+		logEvent.AddError("unable to record email opt out: %s", err)
+		wfe.sendError(response, logEvent, probs.ServerInternal("Failed to unsubscribe"), err)
+		return
+	}
+	response.Header().Set("Content-Type", "text/plain")
+	response.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(response, "%s will no longer receive notification emails\n", email); err != nil {
+		logEvent.AddError("unable to write unsubscribe response: %s", err)
+		wfe.log.Warning(fmt.Sprintf("Could not write response: %s", err))
+	}
+}
+
 // Options responds to an HTTP OPTIONS request.
 func (wfe *WebFrontEndImpl) Options(response http.ResponseWriter, request *http.Request, methodsStr string, methodsMap map[string]bool) {
 	// Every OPTIONS request gets an Allow header with a list of supported methods.
@@ -1399,7 +1715,7 @@ func (wfe *WebFrontEndImpl) deactivateRegistration(ctx context.Context, reg core
 		return
 	}
 	reg.Status = core.StatusDeactivated
-	jsonReply, err := marshalIndent(reg)
+	jsonReply, err := marshalIndent(wfe.prepRegistrationForDisplay(request, reg))
 	if err != nil {
 		// ServerInternal because registration is from DB and should be fine
 		logEvent.AddError("unable to marshal updated registration: %s", err)
@@ -5,8 +5,10 @@ import (
 	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -22,15 +24,16 @@ import (
 
 	"github.com/jmhodges/clock"
 	"github.com/square/go-jose"
+	"golang.org/x/crypto/ed25519"
 	"golang.org/x/net/context"
 
 	"github.com/letsencrypt/boulder/core"
 	"github.com/letsencrypt/boulder/features"
 	"github.com/letsencrypt/boulder/goodkey"
 	blog "github.com/letsencrypt/boulder/log"
+	"github.com/letsencrypt/boulder/mail"
 	"github.com/letsencrypt/boulder/metrics"
 	"github.com/letsencrypt/boulder/mocks"
-	"github.com/letsencrypt/boulder/nonce"
 	"github.com/letsencrypt/boulder/probs"
 	"github.com/letsencrypt/boulder/ra"
 	"github.com/letsencrypt/boulder/revocation"
@@ -232,13 +235,23 @@ func makeBody(s string) io.ReadCloser {
 	return ioutil.NopCloser(strings.NewReader(s))
 }
 
-func signRequest(t *testing.T, req string, nonceService *nonce.NonceService) string {
+// nonceSource adapts a core.NonceService to the jose.NonceSource interface
+// used by signers in tests.
+type nonceSource struct {
+	ns core.NonceService
+}
+
+func (s nonceSource) Nonce() (string, error) {
+	return s.ns.Nonce(context.Background())
+}
+
+func signRequest(t *testing.T, req string, nonceService core.NonceService) string {
 	accountKey, err := jose.LoadPrivateKey([]byte(test1KeyPrivatePEM))
 	test.AssertNotError(t, err, "Failed to load key")
 
 	signer, err := jose.NewSigner("RS256", accountKey)
 	test.AssertNotError(t, err, "Failed to make signer")
-	signer.SetNonceSource(nonceService)
+	signer.SetNonceSource(nonceSource{nonceService})
 	result, err := signer.Sign([]byte(req))
 	test.AssertNotError(t, err, "Failed to sign req")
 	ret := result.FullSerialize()
@@ -707,7 +720,7 @@ func TestIssueCertificate(t *testing.T) {
 	// POST, Properly JWS-signed, but payload is "foo", not base64-encoded JSON.
 	responseWriter.Body.Reset()
 	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter,
-		makePostRequest(signRequest(t, "foo", wfe.nonceService)))
+		makePostRequest(signRequest(t, "foo", wfe.NonceService)))
 	assertJSONEquals(t,
 		responseWriter.Body.String(),
 		`{"type":"urn:acme:error:malformed","detail":"Request payload did not parse as JSON","status":400}`)
@@ -716,7 +729,7 @@ func TestIssueCertificate(t *testing.T) {
 	responseWriter.Body.Reset()
 	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter,
 		makePostRequest(
-			signRequest(t, "{}", wfe.nonceService)))
+			signRequest(t, "{}", wfe.NonceService)))
 	assertJSONEquals(t,
 		responseWriter.Body.String(),
 		`{"type":"urn:acme:error:malformed","detail":"Request payload does not specify a resource","status":400}`)
@@ -724,7 +737,7 @@ func TestIssueCertificate(t *testing.T) {
 	// Valid, signed JWS body, payload is '{"resource":"new-cert"}'
 	responseWriter.Body.Reset()
 	wfe.NewCertificate(ctx, newRequestEvent(), responseWriter,
-		makePostRequest(signRequest(t, `{"resource":"new-cert"}`, wfe.nonceService)))
+		makePostRequest(signRequest(t, `{"resource":"new-cert"}`, wfe.NonceService)))
 	assertJSONEquals(t,
 		responseWriter.Body.String(),
 		`{"type":"urn:acme:error:malformed","detail":"Error parsing certificate request. Extensions in the CSR marked critical can cause this error: https://github.com/letsencrypt/boulder/issues/565","status":400}`)
@@ -739,7 +752,7 @@ func TestIssueCertificate(t *testing.T) {
 		makePostRequest(signRequest(t, `{
       "resource":"new-cert",
       "csr": "MIICVzCCAT8CAQAwEjEQMA4GA1UEAwwHZm9iLmNvbTCCASIwDQYJKoZIhvcNAQEBBQADggEPADCCAQoCggEBAKzHhqcMSTVjBu61vufGVmIYM4mMbWXgndHOUWnIqSKcNtFtPQ465tcZRT5ITIZWXGjsmgDrj31qvG3t5qLwyaF5hsTvFHK72nLMAQhdgM6481Qe9yaoaulWpkGr_9LVz4jQ9pGAaLVamXGpSxV-ipTOo79Sev4aZE8ksD9atEfWtcOD9w8_zj74vpWjTAHN49Q88chlChVqakn0zSfHPfS-jF8g0UTddBuF0Ti3sZChjxzbo6LwZ4182xX7XPnOLav3AGj0Su7j5XMl3OpenOrlWulWJeZIHq5itGW321j306XiGdbrdWH4K7JygICFds6oolwQRGBY6yinAtCgkTcCAwEAAaAAMA0GCSqGSIb3DQEBCwUAA4IBAQBxPiHOtKuBxtvecMNtLkTSuTyEkusQGnjoFDaKe5oqwGYQgy0YBii2-BbaPmqS4ZaDc-vDz_RLeKH5ZiH-NliYR1V_CRtpFLQi18g_2pLQnZLVO3ENs-SM37nU_nBGn9O93t2bkssoM3fZmtgp3R2W7I_wvx7Z8oWKa4boTeBAg_q9Gmi6QskZBddK7A4S_vOR0frU6QSPK_ksPhvovp9fwb6CVKrlJWf556UwRPWgbkW39hvTxK2KHhrUEg3oawNkWde2jZtnZ9e-9zpw8-_5O0X7-YN0ucbFTfQybce_ReuLlGepiHT5bvVavBZoIvqw1XOgSMvGgZFU8tAWMBlj"
-    }`, wfe.nonceService)))
+    }`, wfe.NonceService)))
 	assertJSONEquals(t,
 		responseWriter.Body.String(),
 		`{"type":"urn:acme:error:malformed","detail":"Error creating new cert :: invalid signature on CSR","status":400}`)
@@ -752,7 +765,7 @@ func TestIssueCertificate(t *testing.T) {
 		makePostRequest(signRequest(t, `{
 			"resource":"new-cert",
 			"csr": "MIICWDCCAUACAQAwEzERMA8GA1UEAwwIbWVlcC5jb20wggEiMA0GCSqGSIb3DQEBAQUAA4IBDwAwggEKAoIBAQCaqzue57mgXEoGTZZoVkkCZraebWgXI8irX2BgQB1A3iZa9onxGPMcWQMxhSuUisbEJi4UkMcVST12HX01rUwhj41UuBxJvI1w4wvdstssTAaa9c9tsQ5-UED2bFRL1MsyBdbmCF_-pu3i-ZIYqWgiKbjVBe3nlAVbo77zizwp3Y4Tp1_TBOwTAuFkHePmkNT63uPm9My_hNzsSm1o-Q519Cf7ry-JQmOVgz_jIgFVGFYJ17EV3KUIpUuDShuyCFATBQspgJSN2DoXRUlQjXXkNTj23OxxdT_cVLcLJjytyG6e5izME2R2aCkDBWIc1a4_sRJ0R396auPXG6KhJ7o_AgMBAAGgADANBgkqhkiG9w0BAQsFAAOCAQEALu046p76aKgvoAEHFINkMTgKokPXf9mZ4IZx_BKz-qs1MPMxVtPIrQDVweBH6tYT7Hfj2naLry6SpZ3vUNP_FYeTFWgW1V03LiqacX-QQgbEYtn99Dt3ScGyzb7EH833ztb3vDJ_-ha_CJplIrg-kHBBrlLFWXhh-I9K1qLRTNpbhZ18ooFde4Sbhkw9o9fKivGhx9aYr7ZbjRsNtKit_DsG1nwEXz53TMJ2vB9IQY29coJv_n5NFLkvBfzbG5faRNiFcimPYBO2jFdaA2mWzfxltLtwMF_dBwzTXDpMo3TVT9zEdV8YpsWqr63igqGDZVpKenlkqvRTeGJVayVuMA"
-		}`, wfe.nonceService)))
+		}`, wfe.NonceService)))
 	assertJSONEquals(t,
 		responseWriter.Body.String(),
 		`{"type":"urn:acme:error:unauthorized","detail":"Error creating new cert :: Authorizations for these names not found or expired: meep.com","status":403}`)
@@ -765,7 +778,7 @@ func TestIssueCertificate(t *testing.T) {
 		makePostRequest(signRequest(t, `{
 			"resource":"new-cert",
 			"csr": "MIICYjCCAUoCAQAwHTEbMBkGA1UEAwwSbm90LWFuLWV4YW1wbGUuY29tMIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAmqs7nue5oFxKBk2WaFZJAma2nm1oFyPIq19gYEAdQN4mWvaJ8RjzHFkDMYUrlIrGxCYuFJDHFUk9dh19Na1MIY-NVLgcSbyNcOML3bLbLEwGmvXPbbEOflBA9mxUS9TLMgXW5ghf_qbt4vmSGKloIim41QXt55QFW6O-84s8Kd2OE6df0wTsEwLhZB3j5pDU-t7j5vTMv4Tc7EptaPkOdfQn-68viUJjlYM_4yIBVRhWCdexFdylCKVLg0obsghQEwULKYCUjdg6F0VJUI115DU49tzscXU_3FS3CyY8rchunuYszBNkdmgpAwViHNWuP7ESdEd_emrj1xuioSe6PwIDAQABoAAwDQYJKoZIhvcNAQELBQADggEBAE_T1nWU38XVYL28hNVSXU0rW5IBUKtbvr0qAkD4kda4HmQRTYkt-LNSuvxoZCC9lxijjgtJi-OJe_DCTdZZpYzewlVvcKToWSYHYQ6Wm1-fxxD_XzphvZOujpmBySchdiz7QSVWJmVZu34XD5RJbIcrmj_cjRt42J1hiTFjNMzQu9U6_HwIMmliDL-soFY2RTvvZf-dAFvOUQ-Wbxt97eM1PbbmxJNWRhbAmgEpe9PWDPTpqV5AK56VAa991cQ1P8ZVmPss5hvwGWhOtpnpTZVHN3toGNYFKqxWPboirqushQlfKiFqT9rpRgM3-mFjOHidGqsKEkTdmfSVlVEk3oo="
-		}`, wfe.nonceService)))
+		}`, wfe.NonceService)))
 	assertCsrLogged(t, mockLog)
 	cert, err := core.LoadCert("test/not-an-example.com.crt")
 	test.AssertNotError(t, err, "Could not load cert")
@@ -795,7 +808,7 @@ func TestIssueCertificate(t *testing.T) {
 		makePostRequest(signRequest(t, `{
       "resource": "new-cert",
       "csr": "MIICWjCCAUICADAWMRQwEgYDVQQDEwtleGFtcGxlLmNvbTCCASIwDQYJKoZIhvcNAQEBBQADggEPADCCAQoCggEBAMpwCSKfLhKC3SnvLNpVayAEyAHVixkusgProAPZRBH0VAog_r4JOfoJez7ABiZ2ZIXXA2gg65_05HkGNl9ww-sa0EY8eCty_8WcHxqzafUnyXOJZuLMPJjaJ2oiBv_3BM7PZgpFzyNZ0_0ZuRKdFGtEY-vX9GXZUV0A3sxZMOpce0lhHAiBk_vNARJyM2-O-cZ7WjzZ7R1T9myAyxtsFhWy3QYvIwiKVVF3lDp3KXlPZ_7wBhVIBcVSk0bzhseotyUnKg-aL5qZIeB1ci7IT5qA_6C1_bsCSJSbQ5gnQwIQ0iaUV_SgUBpKNqYbmnSdZmDxvvW8FzhuL6JSDLfBR2kCAwEAAaAAMA0GCSqGSIb3DQEBCwUAA4IBAQBxxkchTXfjv07aSWU9brHnRziNYOLvsSNiOWmWLNlZg9LKdBy6j1xwM8IQRCfTOVSkbuxVV-kU5p-Cg9UF_UGoerl3j8SiupurTovK9-L_PdX0wTKbK9xkh7OUq88jp32Rw0eAT87gODJRD-M1NXlTvm-j896e60hUmL-DIe3iPbFl8auUS-KROAWjci-LJZYVdomm9Iw47E-zr4Hg27EdZhvCZvSyPMK8ioys9mNg5TthHB6ExepKP1YW3HpQa1EdUVYWGEvyVL4upQZOxuEA1WJqHv6iVDzsQqkl5kkahK87NKTPS59k1TFetjw2GLnQ09-g_L7kT8dpq3Bk5Wo="
-    }`, wfe.nonceService)))
+    }`, wfe.NonceService)))
 	assertJSONEquals(t,
 		responseWriter.Body.String(),
 		`{"type":"urn:acme:error:malformed","detail":"CSR generated using a pre-1.0.2 OpenSSL with a client that doesn't properly specify the CSR version","status":400}`)
@@ -855,7 +868,7 @@ func TestChallenge(t *testing.T) {
 	path := "valid/23"
 	wfe.Challenge(ctx, newRequestEvent(), responseWriter,
 		makePostRequestWithPath(path,
-			signRequest(t, `{"resource":"challenge"}`, wfe.nonceService)))
+			signRequest(t, `{"resource":"challenge"}`, wfe.NonceService)))
 
 	test.AssertEquals(t, responseWriter.Code, 202)
 	test.AssertEquals(
@@ -873,7 +886,7 @@ func TestChallenge(t *testing.T) {
 	responseWriter = httptest.NewRecorder()
 	wfe.Challenge(ctx, newRequestEvent(), responseWriter,
 		makePostRequestWithPath(challengeURL,
-			signRequest(t, `{"resource":"challenge"}`, wfe.nonceService)))
+			signRequest(t, `{"resource":"challenge"}`, wfe.NonceService)))
 	test.AssertEquals(t, responseWriter.Code, http.StatusNotFound)
 	assertJSONEquals(t, responseWriter.Body.String(),
 		`{"type":"urn:acme:error:malformed","detail":"Expired authorization","status":404}`)
@@ -897,6 +910,64 @@ func TestBadNonce(t *testing.T) {
 	assertJSONEquals(t, responseWriter.Body.String(), `{"type":"urn:acme:error:badNonce","detail":"JWS has no anti-replay nonce","status":400}`)
 }
 
+// brokenNonceService issues nonces from a local nonce service but fails to
+// redeem them, as a remote nonce service might.
+type brokenNonceService struct {
+	core.NonceService
+}
+
+func (bns brokenNonceService) Redeem(_ context.Context, _ string) (bool, error) {
+	return false, errors.New("nonce service unavailable")
+}
+
+func TestNonceRedeemError(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.NonceService = brokenNonceService{wfe.NonceService}
+
+	responseWriter := httptest.NewRecorder()
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter,
+		makePostRequest(signRequest(t, `{"resource":"new-reg","contact":["mailto:person@mail.com"],"agreement":"`+agreementURL+`"}`, wfe.NonceService)))
+	assertJSONEquals(t, responseWriter.Body.String(), `{"type":"urn:acme:error:serverInternal","detail":"Unable to check anti-replay nonce","status":500}`)
+}
+
+// newKeysSA is a mock StorageAuthority that has no registration for any key
+type newKeysSA struct {
+	*mocks.StorageAuthority
+}
+
+func (sa newKeysSA) GetRegistrationByKey(_ context.Context, jwk jose.JsonWebKey) (core.Registration, error) {
+	return core.Registration{}, core.NoSuchRegistrationError("reg not found")
+}
+
+func TestNewEd25519Registration(t *testing.T) {
+	wfe, fc := setupWFE(t)
+	wfe.SA = newKeysSA{mocks.NewStorageAuthority(fc)}
+	_, private, err := ed25519.GenerateKey(nil)
+	test.AssertNotError(t, err, "Failed to generate key")
+	signer, err := jose.NewSigner(jose.EdDSA, private)
+	test.AssertNotError(t, err, "Failed to make signer")
+	signer.SetNonceSource(nonceSource{wfe.NonceService})
+	newReg := `{"resource":"new-reg","contact":["mailto:person@mail.com"],"agreement":"` + agreementURL + `"}`
+
+	// Ed25519 account keys are refused unless the key policy allows them
+	responseWriter := httptest.NewRecorder()
+	result, err := signer.Sign([]byte(newReg))
+	test.AssertNotError(t, err, "Failed to sign")
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter, makePostRequest(result.FullSerialize()))
+	assertJSONEquals(t, responseWriter.Body.String(), `{"type":"urn:acme:error:malformed","detail":"Ed25519 keys are not allowed","status":400}`)
+
+	wfe.keyPolicy.AllowEd25519 = true
+	responseWriter = httptest.NewRecorder()
+	result, err = signer.Sign([]byte(newReg))
+	test.AssertNotError(t, err, "Failed to sign")
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter, makePostRequest(result.FullSerialize()))
+	test.AssertEquals(t, responseWriter.Code, http.StatusCreated)
+	var reg core.Registration
+	err = json.Unmarshal([]byte(responseWriter.Body.String()), &reg)
+	test.AssertNotError(t, err, "Couldn't unmarshal returned registration object")
+	test.Assert(t, core.KeyDigestEquals(reg.Key, private.Public()), "Registration has the wrong key")
+}
+
 func TestNewECDSARegistration(t *testing.T) {
 	wfe, _ := setupWFE(t)
 
@@ -907,7 +978,7 @@ func TestNewECDSARegistration(t *testing.T) {
 	test.Assert(t, ok, "Couldn't load ECDSA key")
 	signer, err := jose.NewSigner("ES256", ecdsaKey)
 	test.AssertNotError(t, err, "Failed to make signer")
-	signer.SetNonceSource(wfe.nonceService)
+	signer.SetNonceSource(nonceSource{wfe.NonceService})
 
 	responseWriter := httptest.NewRecorder()
 	result, err := signer.Sign([]byte(`{"resource":"new-reg","contact":["mailto:person@mail.com"],"agreement":"` + agreementURL + `"}`))
@@ -922,6 +993,15 @@ func TestNewECDSARegistration(t *testing.T) {
 	test.AssertEquals(t, reg.Agreement, "http://example.invalid/terms")
 	test.AssertEquals(t, reg.InitialIP.String(), "1.1.1.1")
 
+	var regLinks struct {
+		Authorizations string
+		Certificates   string
+	}
+	err = json.Unmarshal([]byte(responseWriter.Body.String()), &regLinks)
+	test.AssertNotError(t, err, "Couldn't unmarshal returned registration object")
+	test.AssertEquals(t, regLinks.Authorizations, "http://localhost/acme/reg-authzs/0")
+	test.AssertEquals(t, regLinks.Certificates, "http://localhost/acme/reg-certs/0")
+
 	test.AssertEquals(t, responseWriter.Header().Get("Location"), "http://localhost/acme/reg/0")
 
 	key, err = jose.LoadPrivateKey([]byte(testE1KeyPrivatePEM))
@@ -930,7 +1010,7 @@ func TestNewECDSARegistration(t *testing.T) {
 	test.Assert(t, ok, "Couldn't load ECDSA key")
 	signer, err = jose.NewSigner("ES256", ecdsaKey)
 	test.AssertNotError(t, err, "Failed to make signer")
-	signer.SetNonceSource(wfe.nonceService)
+	signer.SetNonceSource(nonceSource{wfe.NonceService})
 
 	// Reset the body and status code
 	responseWriter = httptest.NewRecorder()
@@ -962,7 +1042,7 @@ func TestEmptyRegistration(t *testing.T) {
 	test.Assert(t, ok, "Couldn't load RSA key")
 	signer, err := jose.NewSigner("RS256", rsaKey)
 	test.AssertNotError(t, err, "Failed to make signer")
-	signer.SetNonceSource(wfe.nonceService)
+	signer.SetNonceSource(nonceSource{wfe.NonceService})
 
 	emptyReg := `{"resource":"reg"}`
 	emptyBody, err := signer.Sign([]byte(emptyReg))
@@ -988,6 +1068,62 @@ func TestEmptyRegistration(t *testing.T) {
 	responseWriter.Body.Reset()
 }
 
+func TestNewRegistrationBlockedKey(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	key, err := jose.LoadPrivateKey([]byte(test2KeyPrivatePEM))
+	test.AssertNotError(t, err, "Failed to load key")
+	signer, err := jose.NewSigner("RS256", key)
+	test.AssertNotError(t, err, "Failed to make signer")
+	signer.SetNonceSource(nonceSource{wfe.NonceService})
+
+	wfe.keyPolicy.BlockedKeyCheck = func(context.Context, []byte) (bool, error) {
+		return true, nil
+	}
+	result, err := signer.Sign([]byte(`{"resource":"new-reg","contact":["mailto:person@mail.com"],"agreement":"` + agreementURL + `"}`))
+	test.AssertNotError(t, err, "Unable to sign")
+	responseWriter := httptest.NewRecorder()
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter, makePostRequest(result.FullSerialize()))
+	assertJSONEquals(t, responseWriter.Body.String(), `{"type":"urn:acme:error:malformed","detail":"Key is blocked from use","status":400}`)
+
+	wfe.keyPolicy.BlockedKeyCheck = func(context.Context, []byte) (bool, error) {
+		return false, errors.New("database is down")
+	}
+	result, err = signer.Sign([]byte(`{"resource":"new-reg","contact":["mailto:person@mail.com"],"agreement":"` + agreementURL + `"}`))
+	test.AssertNotError(t, err, "Unable to sign")
+	responseWriter = httptest.NewRecorder()
+	wfe.NewRegistration(ctx, newRequestEvent(), responseWriter, makePostRequest(result.FullSerialize()))
+	assertJSONEquals(t, responseWriter.Body.String(), `{"type":"urn:acme:error:serverInternal","detail":"Unable to check JWK","status":500}`)
+}
+
+func TestRegistrationBlockedKey(t *testing.T) {
+	wfe, _ := setupWFE(t)
+
+	// The key of an existing registration can be blocked after it registers
+	wfe.keyPolicy.BlockedKeyCheck = func(context.Context, []byte) (bool, error) {
+		return true, nil
+	}
+	responseWriter := httptest.NewRecorder()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg"}`, wfe.NonceService)))
+	assertJSONEquals(t, responseWriter.Body.String(), `{"type":"urn:acme:error:unauthorized","detail":"Key is blocked from use","status":403}`)
+
+	wfe.keyPolicy.BlockedKeyCheck = func(context.Context, []byte) (bool, error) {
+		return false, errors.New("database is down")
+	}
+	responseWriter = httptest.NewRecorder()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg"}`, wfe.NonceService)))
+	assertJSONEquals(t, responseWriter.Body.String(), `{"type":"urn:acme:error:serverInternal","detail":"Unable to check JWK","status":500}`)
+
+	wfe.keyPolicy.BlockedKeyCheck = func(context.Context, []byte) (bool, error) {
+		return false, nil
+	}
+	responseWriter = httptest.NewRecorder()
+	wfe.Registration(ctx, newRequestEvent(), responseWriter,
+		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg"}`, wfe.NonceService)))
+	test.AssertNotContains(t, responseWriter.Body.String(), "urn:acme:error")
+}
+
 func TestNewRegistration(t *testing.T) {
 	wfe, _ := setupWFE(t)
 	mux, err := wfe.Handler()
@@ -999,7 +1135,7 @@ func TestNewRegistration(t *testing.T) {
 	test.Assert(t, ok, "Couldn't load RSA key")
 	signer, err := jose.NewSigner("RS256", rsaKey)
 	test.AssertNotError(t, err, "Failed to make signer")
-	signer.SetNonceSource(wfe.nonceService)
+	signer.SetNonceSource(nonceSource{wfe.NonceService})
 	fooBody, err := signer.Sign([]byte("foo"))
 	test.AssertNotError(t, err, "Unable to sign")
 
@@ -1087,6 +1223,15 @@ func TestNewRegistration(t *testing.T) {
 	test.AssertEquals(t, reg.Agreement, "http://example.invalid/terms")
 	test.AssertEquals(t, reg.InitialIP.String(), "1.1.1.1")
 
+	var regLinks struct {
+		Authorizations string
+		Certificates   string
+	}
+	err = json.Unmarshal([]byte(responseWriter.Body.String()), &regLinks)
+	test.AssertNotError(t, err, "Couldn't unmarshal returned registration object")
+	test.AssertEquals(t, regLinks.Authorizations, "http://localhost/acme/reg-authzs/0")
+	test.AssertEquals(t, regLinks.Certificates, "http://localhost/acme/reg-certs/0")
+
 	test.AssertEquals(
 		t, responseWriter.Header().Get("Location"),
 		"http://localhost/acme/reg/0")
@@ -1104,7 +1249,7 @@ func TestNewRegistration(t *testing.T) {
 	test.Assert(t, ok, "Couldn't load RSA key")
 	signer, err = jose.NewSigner("RS256", rsaKey)
 	test.AssertNotError(t, err, "Failed to make signer")
-	signer.SetNonceSource(wfe.nonceService)
+	signer.SetNonceSource(nonceSource{wfe.NonceService})
 
 	// Reset the body and status code
 	responseWriter = httptest.NewRecorder()
@@ -1179,7 +1324,7 @@ func TestRevokeCertificateCertKey(t *testing.T) {
 	wfe.SA = &mockSANoSuchRegistration{mocks.NewStorageAuthority(fc)}
 	responseWriter := httptest.NewRecorder()
 
-	signer.SetNonceSource(wfe.nonceService)
+	signer.SetNonceSource(nonceSource{wfe.NonceService})
 	result, _ := signer.Sign(revokeRequestJSON)
 	wfe.RevokeCertificate(ctx, newRequestEvent(), responseWriter,
 		makePostRequest(result.FullSerialize()))
@@ -1208,7 +1353,7 @@ func TestRevokeCertificateReasons(t *testing.T) {
 	wfe.SA = &mockSANoSuchRegistration{mocks.NewStorageAuthority(fc)}
 	responseWriter := httptest.NewRecorder()
 
-	signer.SetNonceSource(wfe.nonceService)
+	signer.SetNonceSource(nonceSource{wfe.NonceService})
 	result, _ := signer.Sign(revokeRequestJSON)
 	wfe.RevokeCertificate(ctx, newRequestEvent(), responseWriter,
 		makePostRequest(result.FullSerialize()))
@@ -1221,7 +1366,7 @@ func TestRevokeCertificateReasons(t *testing.T) {
 	revokeRequestJSON, err = makeRevokeRequestJSON(nil)
 	test.AssertNotError(t, err, "Failed to make revokeRequestJSON")
 
-	signer.SetNonceSource(wfe.nonceService)
+	signer.SetNonceSource(nonceSource{wfe.NonceService})
 	result, _ = signer.Sign(revokeRequestJSON)
 	wfe.RevokeCertificate(ctx, newRequestEvent(), responseWriter,
 		makePostRequest(result.FullSerialize()))
@@ -1235,7 +1380,7 @@ func TestRevokeCertificateReasons(t *testing.T) {
 	revokeRequestJSON, err = makeRevokeRequestJSON(&unsupported)
 	test.AssertNotError(t, err, "Failed to make revokeRequestJSON")
 
-	signer.SetNonceSource(wfe.nonceService)
+	signer.SetNonceSource(nonceSource{wfe.NonceService})
 	result, _ = signer.Sign(revokeRequestJSON)
 	wfe.RevokeCertificate(ctx, newRequestEvent(), responseWriter,
 		makePostRequest(result.FullSerialize()))
@@ -1247,7 +1392,7 @@ func TestRevokeCertificateReasons(t *testing.T) {
 	revokeRequestJSON, err = makeRevokeRequestJSON(&unsupported)
 	test.AssertNotError(t, err, "Failed to make revokeRequestJSON")
 
-	signer.SetNonceSource(wfe.nonceService)
+	signer.SetNonceSource(nonceSource{wfe.NonceService})
 	result, _ = signer.Sign(revokeRequestJSON)
 	wfe.RevokeCertificate(ctx, newRequestEvent(), responseWriter,
 		makePostRequest(result.FullSerialize()))
@@ -1270,7 +1415,7 @@ func TestRevokeCertificateAccountKey(t *testing.T) {
 	test.Assert(t, ok, "Couldn't load RSA key")
 	accountKeySigner, err := jose.NewSigner("RS256", test1Key)
 	test.AssertNotError(t, err, "Failed to make signer")
-	accountKeySigner.SetNonceSource(wfe.nonceService)
+	accountKeySigner.SetNonceSource(nonceSource{wfe.NonceService})
 	result, _ := accountKeySigner.Sign(revokeRequestJSON)
 	wfe.RevokeCertificate(ctx, newRequestEvent(), responseWriter,
 		makePostRequest(result.FullSerialize()))
@@ -1288,7 +1433,7 @@ func TestRevokeCertificateWrongKey(t *testing.T) {
 	test.Assert(t, ok, "Couldn't load RSA key")
 	accountKeySigner2, err := jose.NewSigner("RS256", test2Key)
 	test.AssertNotError(t, err, "Failed to make signer")
-	accountKeySigner2.SetNonceSource(wfe.nonceService)
+	accountKeySigner2.SetNonceSource(nonceSource{wfe.NonceService})
 	revokeRequestJSON, err := makeRevokeRequestJSON(nil)
 	test.AssertNotError(t, err, "Unable to create revoke request")
 
@@ -1331,7 +1476,7 @@ func TestRevokeCertificateAlreadyRevoked(t *testing.T) {
 	wfe.SA = &mockSANoSuchRegistration{mocks.NewStorageAuthority(fc)}
 	responseWriter := httptest.NewRecorder()
 	responseWriter.Body.Reset()
-	signer.SetNonceSource(wfe.nonceService)
+	signer.SetNonceSource(nonceSource{wfe.NonceService})
 	result, _ := signer.Sign(revokeRequestJSON)
 	wfe.RevokeCertificate(ctx, newRequestEvent(), responseWriter,
 		makePostRequest(result.FullSerialize()))
@@ -1372,7 +1517,7 @@ func TestAuthorization(t *testing.T) {
 	// POST, Properly JWS-signed, but payload is "foo", not base64-encoded JSON.
 	responseWriter.Body.Reset()
 	wfe.NewAuthorization(ctx, newRequestEvent(), responseWriter,
-		makePostRequest(signRequest(t, "foo", wfe.nonceService)))
+		makePostRequest(signRequest(t, "foo", wfe.NonceService)))
 	assertJSONEquals(t,
 		responseWriter.Body.String(),
 		`{"type":"urn:acme:error:malformed","detail":"Request payload did not parse as JSON","status":400}`)
@@ -1400,7 +1545,7 @@ func TestAuthorization(t *testing.T) {
 
 	responseWriter.Body.Reset()
 	wfe.NewAuthorization(ctx, newRequestEvent(), responseWriter,
-		makePostRequest(signRequest(t, `{"resource":"new-authz","identifier":{"type":"dns","value":"test.com"}}`, wfe.nonceService)))
+		makePostRequest(signRequest(t, `{"resource":"new-authz","identifier":{"type":"dns","value":"test.com"}}`, wfe.NonceService)))
 
 	test.AssertEquals(
 		t, responseWriter.Header().Get("Location"),
@@ -1487,7 +1632,7 @@ func TestRegistration(t *testing.T) {
 	test.AssertNotError(t, err, "Failed to make signer")
 
 	// Test POST valid JSON but key is not registered
-	signer.SetNonceSource(wfe.nonceService)
+	signer.SetNonceSource(nonceSource{wfe.NonceService})
 	result, err := signer.Sign([]byte(`{"resource":"reg","agreement":"` + agreementURL + `"}`))
 	test.AssertNotError(t, err, "Unable to sign")
 	wfe.Registration(ctx, newRequestEvent(), responseWriter,
@@ -1505,7 +1650,7 @@ func TestRegistration(t *testing.T) {
 	test.AssertNotError(t, err, "Failed to make signer")
 
 	// Test POST valid JSON with registration up in the mock (with incorrect agreement URL)
-	signer.SetNonceSource(wfe.nonceService)
+	signer.SetNonceSource(nonceSource{wfe.NonceService})
 	result, err = signer.Sign([]byte(`{"resource":"reg","agreement":"https://letsencrypt.org/im-bad"}`))
 
 	// Test POST valid JSON with registration up in the mock
@@ -1550,6 +1695,119 @@ func TestRegistration(t *testing.T) {
 	responseWriter.Body.Reset()
 }
 
+// signedListingRequest makes a request for a registration listing at path,
+// signed with the given private key
+func signedListingRequest(t *testing.T, nonceService core.NonceService, path, keyPEM, alg string) *http.Request {
+	key, err := jose.LoadPrivateKey([]byte(keyPEM))
+	test.AssertNotError(t, err, "Failed to load key")
+	signer, err := jose.NewSigner(jose.SignatureAlgorithm(alg), key)
+	test.AssertNotError(t, err, "Failed to make signer")
+	signer.SetNonceSource(nonceSource{nonceService})
+	result, err := signer.Sign([]byte(`{"resource":"reg"}`))
+	test.AssertNotError(t, err, "Failed to sign request")
+	return makePostRequestWithPath(path, result.FullSerialize())
+}
+
+func TestRegistrationCertificates(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	mux, err := wfe.Handler()
+	test.AssertNotError(t, err, "Problem setting up HTTP handlers")
+	wfe.listPageSize = 1
+
+	responseWriter := httptest.NewRecorder()
+	mux.ServeHTTP(responseWriter, signedListingRequest(t, wfe.NonceService, regCertsPath+"1", test1KeyPrivatePEM, "RS256"))
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	assertJSONEquals(t,
+		responseWriter.Body.String(),
+		`{"certificates":["http://localhost/acme/cert/0000000000000000000000000000000000b2"]}`)
+	test.AssertEquals(t,
+		responseWriter.Header().Get("Link"),
+		`<http://localhost/acme/reg-certs/1?cursor=0000000000000000000000000000000000b2>;rel="next"`)
+
+	// Following the next link returns the last page, which has no next link
+	responseWriter = httptest.NewRecorder()
+	mux.ServeHTTP(responseWriter, signedListingRequest(t, wfe.NonceService,
+		regCertsPath+"1?cursor=0000000000000000000000000000000000b2", test1KeyPrivatePEM, "RS256"))
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	assertJSONEquals(t,
+		responseWriter.Body.String(),
+		`{"certificates":["http://localhost/acme/cert/0000000000000000000000000000000000ee"]}`)
+	test.AssertEquals(t, responseWriter.Header().Get("Link"), "")
+
+	// A registration with no certificates gets an empty list
+	responseWriter = httptest.NewRecorder()
+	mux.ServeHTTP(responseWriter, signedListingRequest(t, wfe.NonceService, regCertsPath+"3", testE1KeyPrivatePEM, "ES256"))
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	assertJSONEquals(t, responseWriter.Body.String(), `{"certificates":[]}`)
+
+	// A malformed cursor is rejected
+	responseWriter = httptest.NewRecorder()
+	mux.ServeHTTP(responseWriter, signedListingRequest(t, wfe.NonceService, regCertsPath+"1?cursor=zz", test1KeyPrivatePEM, "RS256"))
+	assertJSONEquals(t,
+		responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"Invalid cursor","status":400}`)
+
+	// A non-numeric registration ID is not found
+	responseWriter = httptest.NewRecorder()
+	mux.ServeHTTP(responseWriter, signedListingRequest(t, wfe.NonceService, regCertsPath+"abc", test1KeyPrivatePEM, "RS256"))
+	assertJSONEquals(t,
+		responseWriter.Body.String(),
+		`{"type":"urn:acme:error:malformed","detail":"Registration not found","status":404}`)
+}
+
+func TestRegistrationAuthorizations(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	mux, err := wfe.Handler()
+	test.AssertNotError(t, err, "Problem setting up HTTP handlers")
+
+	responseWriter := httptest.NewRecorder()
+	mux.ServeHTTP(responseWriter, signedListingRequest(t, wfe.NonceService, regAuthzsPath+"1", test1KeyPrivatePEM, "RS256"))
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	assertJSONEquals(t,
+		responseWriter.Body.String(),
+		`{"authorizations":["http://localhost/acme/authz/valid"]}`)
+	test.AssertEquals(t, responseWriter.Header().Get("Link"), "")
+}
+
+func TestRegistrationListingsArePrivate(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	mux, err := wfe.Handler()
+	test.AssertNotError(t, err, "Problem setting up HTTP handlers")
+
+	for _, path := range []string{regCertsPath, regAuthzsPath} {
+		// An unsigned GET is refused
+		responseWriter := httptest.NewRecorder()
+		mux.ServeHTTP(responseWriter, &http.Request{
+			Method: "GET",
+			URL:    mustParseURL(path + "1"),
+		})
+		test.AssertEquals(t, responseWriter.Code, http.StatusForbidden)
+		test.AssertNotContains(t, responseWriter.Body.String(), "/acme/cert/")
+		test.AssertNotContains(t, responseWriter.Body.String(), "/acme/authz/")
+
+		// A POST without a JWS is refused
+		responseWriter = httptest.NewRecorder()
+		mux.ServeHTTP(responseWriter, makePostRequestWithPath(path+"1", "{}"))
+		test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+
+		// Another account can't list registration 1's entries
+		responseWriter = httptest.NewRecorder()
+		mux.ServeHTTP(responseWriter, signedListingRequest(t, wfe.NonceService, path+"1", testE1KeyPrivatePEM, "ES256"))
+		test.AssertEquals(t, responseWriter.Code, http.StatusForbidden)
+		assertJSONEquals(t,
+			responseWriter.Body.String(),
+			`{"type":"urn:acme:error:unauthorized","detail":"Request signing key did not match registration key","status":403}`)
+
+		// Nor can a key with no registration
+		responseWriter = httptest.NewRecorder()
+		mux.ServeHTTP(responseWriter, signedListingRequest(t, wfe.NonceService, path+"1", test2KeyPrivatePEM, "RS256"))
+		test.Assert(t, responseWriter.Code == http.StatusForbidden || responseWriter.Code == http.StatusNotFound,
+			fmt.Sprintf("unregistered key got status %d", responseWriter.Code))
+		test.AssertNotContains(t, responseWriter.Body.String(), "/acme/cert/")
+		test.AssertNotContains(t, responseWriter.Body.String(), "/acme/authz/")
+	}
+}
+
 func TestTermsRedirect(t *testing.T) {
 	wfe, _ := setupWFE(t)
 	responseWriter := httptest.NewRecorder()
@@ -1579,6 +1837,57 @@ func TestIssuer(t *testing.T) {
 	test.Assert(t, bytes.Compare(responseWriter.Body.Bytes(), wfe.IssuerCert) == 0, "Incorrect bytes returned")
 }
 
+type optOutRecorder struct {
+	regIDs []int64
+	emails []string
+}
+
+func (r *optOutRecorder) AddEmailOptOut(_ context.Context, regID int64, email string, _ time.Time) error {
+	r.regIDs = append(r.regIDs, regID)
+	r.emails = append(r.emails, email)
+	return nil
+}
+
+func TestUnsubscribe(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	signer, err := mail.NewUnsubscribeSigner([]byte("0123456789abcdef0123456789abcdef"), "http://localhost/unsubscribe")
+	test.AssertNotError(t, err, "Failed to create UnsubscribeSigner")
+	optOuts := &optOutRecorder{}
+	wfe.UnsubscribeSigner = signer
+	wfe.EmailOptOuts = optOuts
+	mux, err := wfe.Handler()
+	test.AssertNotError(t, err, "Problem setting up HTTP handlers")
+
+	link := signer.URL(1, "a@example.com")
+
+	// A GET only shows a confirmation form
+	responseWriter := httptest.NewRecorder()
+	mux.ServeHTTP(responseWriter, &http.Request{
+		Method: "GET",
+		URL:    mustParseURL(link),
+	})
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertContains(t, responseWriter.Body.String(), `<form method="POST"`)
+	test.AssertContains(t, responseWriter.Body.String(), "a@example.com")
+	test.AssertEquals(t, len(optOuts.emails), 0)
+
+	// A one-click POST records the opt out
+	responseWriter = httptest.NewRecorder()
+	request := makePostRequestWithPath(link, "List-Unsubscribe=One-Click")
+	mux.ServeHTTP(responseWriter, request)
+	test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	test.AssertDeepEquals(t, optOuts.regIDs, []int64{1})
+	test.AssertDeepEquals(t, optOuts.emails, []string{"a@example.com"})
+
+	// A token signed with another key is rejected
+	other, err := mail.NewUnsubscribeSigner([]byte("fedcba9876543210fedcba9876543210"), "http://localhost/unsubscribe")
+	test.AssertNotError(t, err, "Failed to create UnsubscribeSigner")
+	responseWriter = httptest.NewRecorder()
+	mux.ServeHTTP(responseWriter, makePostRequestWithPath(other.URL(2, "b@example.com"), ""))
+	test.AssertEquals(t, responseWriter.Code, http.StatusBadRequest)
+	test.AssertEquals(t, len(optOuts.emails), 1)
+}
+
 func TestGetCertificate(t *testing.T) {
 	wfe, _ := setupWFE(t)
 	mux, err := wfe.Handler()
@@ -1709,7 +2018,7 @@ func TestVerifyPOSTUsesStoredKey(t *testing.T) {
 	wfe.SA = &mockSADifferentStoredKey{mocks.NewStorageAuthority(fc)}
 	// signRequest signs with test1Key, but our special mock returns a
 	// registration with test2Key
-	_, _, _, err := wfe.verifyPOST(ctx, newRequestEvent(), makePostRequest(signRequest(t, `{"resource":"foo"}`, wfe.nonceService)), true, "foo")
+	_, _, _, err := wfe.verifyPOST(ctx, newRequestEvent(), makePostRequest(signRequest(t, `{"resource":"foo"}`, wfe.NonceService)), true, "foo")
 	test.AssertError(t, err, "No error returned when provided key differed from stored key.")
 }
 
@@ -1724,7 +2033,7 @@ func TestBadKeyCSR(t *testing.T) {
 		makePostRequest(signRequest(t, `{
 			"resource":"new-cert",
 			"csr": "MIHLMHcCAQAwEjEQMA4GA1UEAwwHZm9vLmNvbTBcMA0GCSqGSIb3DQEBAQUAA0sAMEgCQQDCZftp4x4owgjBnwOKfzihIPedT-BUmV2fuQPMqaUlc8yJUp13vcO5uxUlaBm8leM7Dj_sgTDP_JgykorlYo73AgMBAAGgADANBgkqhkiG9w0BAQsFAANBAEaQ2QBhweK-kp1ejQCedUhMit_wG-uTBtKnc3M82f6_fztLkhg1vWQ782nmhbEI5orXp6QtNHgJYnBpqA9Ut00"
-		}`, wfe.nonceService)))
+		}`, wfe.NonceService)))
 
 	assertJSONEquals(t,
 		responseWriter.Body.String(),
@@ -1806,14 +2115,14 @@ func TestHeaderBoulderRequester(t *testing.T) {
 	test.AssertNotError(t, err, "Failed to make signer")
 
 	// requests that do not call sendError() have the requester header
-	signer.SetNonceSource(wfe.nonceService)
+	signer.SetNonceSource(nonceSource{wfe.NonceService})
 	result, err := signer.Sign([]byte(`{"resource":"reg","agreement":"` + agreementURL + `"}`))
 	request := makePostRequestWithPath(regPath+"1", result.FullSerialize())
 	mux.ServeHTTP(responseWriter, request)
 	test.AssertEquals(t, responseWriter.Header().Get("Boulder-Requester"), "1")
 
 	// requests that do call sendError() also should have the requester header
-	signer.SetNonceSource(wfe.nonceService)
+	signer.SetNonceSource(nonceSource{wfe.NonceService})
 	result, err = signer.Sign([]byte(`{"resource":"reg","agreement":"https://letsencrypt.org/im-bad"}`))
 	request = makePostRequestWithPath(regPath+"1", result.FullSerialize())
 	mux.ServeHTTP(responseWriter, request)
@@ -1827,14 +2136,14 @@ func TestDeactivateAuthorization(t *testing.T) {
 
 	responseWriter.Body.Reset()
 	wfe.Authorization(ctx, newRequestEvent(), responseWriter,
-		makePostRequestWithPath("valid", signRequest(t, `{"resource":"authz","status":""}`, wfe.nonceService)))
+		makePostRequestWithPath("valid", signRequest(t, `{"resource":"authz","status":""}`, wfe.NonceService)))
 	assertJSONEquals(t,
 		responseWriter.Body.String(),
 		`{"type": "urn:acme:error:malformed","detail": "Invalid status value","status": 400}`)
 
 	responseWriter.Body.Reset()
 	wfe.Authorization(ctx, newRequestEvent(), responseWriter,
-		makePostRequestWithPath("valid", signRequest(t, `{"resource":"authz","status":"deactivated"}`, wfe.nonceService)))
+		makePostRequestWithPath("valid", signRequest(t, `{"resource":"authz","status":"deactivated"}`, wfe.NonceService)))
 	assertJSONEquals(t,
 		responseWriter.Body.String(),
 		`{
@@ -1861,14 +2170,14 @@ func TestDeactivateRegistration(t *testing.T) {
 
 	responseWriter.Body.Reset()
 	wfe.Registration(ctx, newRequestEvent(), responseWriter,
-		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg","status":"asd"}`, wfe.nonceService)))
+		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg","status":"asd"}`, wfe.NonceService)))
 	assertJSONEquals(t,
 		responseWriter.Body.String(),
 		`{"type": "urn:acme:error:malformed","detail": "Invalid value provided for status field","status": 400}`)
 
 	responseWriter.Body.Reset()
 	wfe.Registration(ctx, newRequestEvent(), responseWriter,
-		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg","status":"deactivated"}`, wfe.nonceService)))
+		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg","status":"deactivated"}`, wfe.NonceService)))
 	assertJSONEquals(t,
 		responseWriter.Body.String(),
 		`{
@@ -1884,12 +2193,14 @@ func TestDeactivateRegistration(t *testing.T) {
 		  "agreement": "http://example.invalid/terms",
 		  "initialIp": "",
 		  "createdAt": "0001-01-01T00:00:00Z",
-		  "Status": "deactivated"
+		  "Status": "deactivated",
+		  "authorizations": "http://localhost/acme/reg-authzs/1",
+		  "certificates": "http://localhost/acme/reg-certs/1"
 		}`)
 
 	responseWriter.Body.Reset()
 	wfe.Registration(ctx, newRequestEvent(), responseWriter,
-		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg","status":"deactivated","contact":[]}`, wfe.nonceService)))
+		makePostRequestWithPath("1", signRequest(t, `{"resource":"reg","status":"deactivated","contact":[]}`, wfe.NonceService)))
 	assertJSONEquals(t,
 		responseWriter.Body.String(),
 		`{
@@ -1905,7 +2216,9 @@ func TestDeactivateRegistration(t *testing.T) {
 		  "agreement": "http://example.invalid/terms",
 		  "initialIp": "",
 		  "createdAt": "0001-01-01T00:00:00Z",
-		  "Status": "deactivated"
+		  "Status": "deactivated",
+		  "authorizations": "http://localhost/acme/reg-authzs/1",
+		  "certificates": "http://localhost/acme/reg-certs/1"
 		}`)
 
 	key, err := jose.LoadPrivateKey([]byte(test3KeyPrivatePEM))
@@ -1914,7 +2227,7 @@ func TestDeactivateRegistration(t *testing.T) {
 	test.Assert(t, ok, "Couldn't load RSA key")
 	signer, err := jose.NewSigner("RS256", rsaKey)
 	test.AssertNotError(t, err, "Failed to make signer")
-	signer.SetNonceSource(wfe.nonceService)
+	signer.SetNonceSource(nonceSource{wfe.NonceService})
 
 	result, err := signer.Sign([]byte(`{"resource":"reg","status":"deactivated"}`))
 	test.AssertNotError(t, err, "Unable to sign")
@@ -1932,3 +2245,118 @@ func TestDeactivateRegistration(t *testing.T) {
 		  "status": 403
 		}`)
 }
+
+func TestRequestLimitPerIP(t *testing.T) {
+	wfe, fc := setupWFE(t)
+	err := wfe.requestLimits.LoadPolicies([]byte(`
+/directory:
+  perIP:
+    period: 1m
+    burst: 2
+    overrides:
+      10.0.0.2: 0
+`))
+	test.AssertNotError(t, err, "Failed to load request limit policies")
+	mux, err := wfe.Handler()
+	test.AssertNotError(t, err, "Problem setting up HTTP handlers")
+
+	get := func(ip string) *httptest.ResponseRecorder {
+		responseWriter := httptest.NewRecorder()
+		mux.ServeHTTP(responseWriter, &http.Request{
+			Method: "GET",
+			URL:    mustParseURL(directoryPath),
+			Header: map[string][]string{"X-Real-Ip": {ip}},
+		})
+		return responseWriter
+	}
+
+	test.AssertEquals(t, get("10.0.0.1").Code, http.StatusOK)
+	test.AssertEquals(t, get("10.0.0.1").Code, http.StatusOK)
+	responseWriter := get("10.0.0.1")
+	test.AssertEquals(t, responseWriter.Code, http.StatusTooManyRequests)
+	test.AssertEquals(t, responseWriter.Header().Get("Retry-After"), "30")
+	assertJSONEquals(t, responseWriter.Body.String(), `{"type":"urn:acme:error:rateLimited","detail":"Too many requests to /directory, retry after 30s","status":429}`)
+
+	// Other clients are unaffected, unless they have an override.
+	test.AssertEquals(t, get("10.0.0.3").Code, http.StatusOK)
+	test.AssertEquals(t, get("10.0.0.2").Code, http.StatusTooManyRequests)
+
+	// Once enough time has passed a request is allowed again.
+	fc.Add(30 * time.Second)
+	test.AssertEquals(t, get("10.0.0.1").Code, http.StatusOK)
+	test.AssertEquals(t, get("10.0.0.1").Code, http.StatusTooManyRequests)
+}
+
+func TestRequestLimitPerAccount(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	err := wfe.requestLimits.LoadPolicies([]byte(`
+/acme/new-authz:
+  perAccount:
+    period: 1h
+    burst: 1
+`))
+	test.AssertNotError(t, err, "Failed to load request limit policies")
+	mux, err := wfe.Handler()
+	test.AssertNotError(t, err, "Problem setting up HTTP handlers")
+
+	post := func(body string) *httptest.ResponseRecorder {
+		responseWriter := httptest.NewRecorder()
+		mux.ServeHTTP(responseWriter, makePostRequestWithPath(newAuthzPath, body))
+		return responseWriter
+	}
+	newAuthz := func() *httptest.ResponseRecorder {
+		return post(signRequest(t, `{"resource":"new-authz","identifier":{"type":"dns","value":"test.com"}}`, wfe.NonceService))
+	}
+
+	// Requests that merely carry the registration's key, without a valid
+	// signature or with a used nonce, don't count against its limit
+	signed := signRequest(t, `{"resource":"new-authz","identifier":{"type":"dns","value":"test.com"}}`, wfe.NonceService)
+	var forged map[string]interface{}
+	test.AssertNotError(t, json.Unmarshal([]byte(signed), &forged), "Failed to parse JWS")
+	forged["payload"] = base64.RawURLEncoding.EncodeToString([]byte(`{"resource":"new-authz","identifier":{"type":"dns","value":"forged.com"}}`))
+	forgedJSON, err := json.Marshal(forged)
+	test.AssertNotError(t, err, "Failed to marshal JWS")
+	for i := 0; i < 2; i++ {
+		test.AssertEquals(t, post(string(forgedJSON)).Code, http.StatusBadRequest)
+	}
+	test.AssertEquals(t, post(signed).Code, http.StatusCreated)
+	// The limit is used up, but a replay is refused for its nonce first
+	replayed := post(signed)
+	test.AssertEquals(t, replayed.Code, http.StatusBadRequest)
+	test.Assert(t, strings.Contains(replayed.Body.String(), "urn:acme:error:badNonce"), "Replay wasn't refused for its nonce")
+
+	responseWriter := newAuthz()
+	test.AssertEquals(t, responseWriter.Code, http.StatusTooManyRequests)
+	test.AssertEquals(t, responseWriter.Header().Get("Retry-After"), "3600")
+}
+
+// failingBucketStore is a ratelimit.BucketStore that can't be reached
+type failingBucketStore struct{}
+
+func (failingBucketStore) Take(_ context.Context, _ string, _ int, _ time.Duration) (bool, time.Duration, error) {
+	return false, 0, errors.New("bucket store unavailable")
+}
+
+func TestRequestLimitFailsOpen(t *testing.T) {
+	wfe, _ := setupWFE(t)
+	wfe.RequestBuckets = failingBucketStore{}
+	err := wfe.requestLimits.LoadPolicies([]byte(`
+/directory:
+  perIP:
+    period: 1m
+    burst: 1
+`))
+	test.AssertNotError(t, err, "Failed to load request limit policies")
+	mux, err := wfe.Handler()
+	test.AssertNotError(t, err, "Problem setting up HTTP handlers")
+
+	for i := 0; i < 3; i++ {
+		responseWriter := httptest.NewRecorder()
+		mux.ServeHTTP(responseWriter, &http.Request{
+			Method: "GET",
+			URL:    mustParseURL(directoryPath),
+			Header: map[string][]string{"X-Real-Ip": {"10.0.0.1"}},
+		})
+		test.AssertEquals(t, responseWriter.Code, http.StatusOK)
+	}
+}